@@ -50,18 +50,89 @@ type config struct {
 	AI struct {
 		// 深度求索 api
 		DeepSeek struct {
-			APIKey string `mapstructure:"api_key"`
+			APIKey  string `mapstructure:"api_key"`
 			BaseURL string `mapstructure:"base_url"`
 		}
 		// 月之暗面 api
 		Kimi struct {
-			APIKey string `mapstructure:"api_key"`
+			APIKey  string `mapstructure:"api_key"`
 			BaseURL string `mapstructure:"base_url"`
 		}
+		// 服务端主密钥，用于加密用户自带的API密钥，为空时不能保存用户密钥
+		MasterKey string `mapstructure:"master_key"`
+		// 其他兼容OpenAI协议的提供商
+		Providers []ProviderConfig `mapstructure:"providers"`
+		// 知识库嵌入模型
+		Embedding struct {
+			Provider  string `mapstructure:"provider"`  // 嵌入提供商，local表示本地哈希嵌入，其他值为已注册的提供商名称
+			Model     string `mapstructure:"model"`     // 嵌入模型名称，使用远程提供商时必填
+			Dimension int    `mapstructure:"dimension"` // 向量维度，0表示使用模型默认维度
+		}
+		// 提供商熔断器，提供商连续失败时暂停请求并快速失败
+		CircuitBreaker struct {
+			FailureThreshold int `mapstructure:"failure_threshold"` // 连续失败多少次后熔断，默认5
+			OpenSeconds      int `mapstructure:"open_seconds"`      // 熔断后多少秒允许探测请求，默认30
+		} `mapstructure:"circuit_breaker"`
+		// 回复缓存，需要配置Redis，开启工具调用的配置不使用缓存
+		Cache struct {
+			Enabled   bool    `mapstructure:"enabled"`   // 是否缓存回复，请求参数和消息完全相同时直接返回缓存
+			TTL       int     `mapstructure:"ttl"`       // 缓存有效期(秒)，默认3600
+			Semantic  bool    `mapstructure:"semantic"`  // 是否开启语义缓存，上下文相同且问题相似时也命中
+			Threshold float64 `mapstructure:"threshold"` // 语义缓存命中的最低余弦相似度，默认0.95
+		}
+		// 批量提示词任务
+		Batch struct {
+			Workers           int            `mapstructure:"workers"`             // 所有任务同时处理的提示词数，默认4
+			MaxItems          int            `mapstructure:"max_items"`           // 单个任务最多的提示词数，默认1000
+			MaxAttempts       int            `mapstructure:"max_attempts"`        // 每条提示词最多请求次数，默认3
+			RequestsPerMinute int            `mapstructure:"requests_per_minute"` // 每个提供商每分钟最多发送的请求数，0表示不限速
+			RateLimits        map[string]int `mapstructure:"rate_limits"`         // 按提供商名称覆盖requests_per_minute
+		}
+		// 模型价格表，用于计算每条回复的费用，没有配置价格的模型费用为0
+		Pricing struct {
+			Currency string       `mapstructure:"currency"` // 货币单位，仅用于显示，默认CNY
+			Models   []ModelPrice `mapstructure:"models"`
+		}
+		// 每个用户的用量配额
+		Quota QuotaConfig `mapstructure:"quota"`
+		// 本地模拟提供商，用于离线开发
+		Mock struct {
+			Enabled bool     `mapstructure:"enabled"`
+			Latency int      `mapstructure:"latency"` // 每个数据块的延迟(毫秒)
+			Replies []string `mapstructure:"replies"` // 脚本回复，用完后回显用户消息
+			Error   string   `mapstructure:"error"`   // 注入的错误类型，如 rate_limit, server, timeout
+		}
 	}
 }
 
-var Config *config
+// ProviderConfig 兼容OpenAI协议的AI提供商配置
+type ProviderConfig struct {
+	Name    string   `mapstructure:"name"`     // 提供商名称，即AIConfig中的provider
+	BaseURL string   `mapstructure:"base_url"` // API基础URL
+	APIKey  string   `mapstructure:"api_key"`  // API密钥
+	Models  []string `mapstructure:"models"`   // 可用模型列表
+}
+
+// ModelPrice 模型价格，单位为每百万token的价格
+type ModelPrice struct {
+	Provider    string  `mapstructure:"provider"`     // 提供商名称，为空时匹配所有提供商的同名模型
+	Model       string  `mapstructure:"model"`        // 模型名称
+	Input       float64 `mapstructure:"input"`        // 输入价格
+	CachedInput float64 `mapstructure:"cached_input"` // 命中缓存的输入价格，0表示与input相同
+	Output      float64 `mapstructure:"output"`       // 输出价格
+}
+
+// QuotaConfig 每个用户的用量配额，0表示不限制
+type QuotaConfig struct {
+	DailyTokens   int64   `mapstructure:"daily_tokens"`   // 每天最多使用的token数
+	MonthlyTokens int64   `mapstructure:"monthly_tokens"` // 每月最多使用的token数
+	DailyCost     float64 `mapstructure:"daily_cost"`     // 每天最多花费的金额
+	MonthlyCost   float64 `mapstructure:"monthly_cost"`   // 每月最多花费的金额
+	WarningRatio  float64 `mapstructure:"warning_ratio"`  // 用量达到配额的比例时发送提醒邮件，默认0.8
+}
+
+// Config 全局配置，默认为零值配置，InitConfig读取配置文件后替换
+var Config = &config{}
 
 func InitConfig() {
 	viper.SetConfigName("config")
@@ -46,9 +46,16 @@ func migrateDB() {
 		&models.EmailVerification{},
 		&models.ChatSession{},          // 聊天会话表
 		&models.ChatMessage{},          // 聊天消息表
+		&models.ChatAttachment{},       // 聊天图片附件表
 		&models.KnowledgeFile{},        // 知识库文件表
 		&models.KnowledgeVectorStore{}, // 知识库向量存储表
 		&models.AIConfig{},             // AI配置表
+		&models.ProviderCredential{},   // 用户API密钥表
+		&models.Persona{},              // 角色表
+		&models.BatchJob{},             // 批量任务表
+		&models.BatchItem{},            // 批量任务条目表
+		&models.AccessToken{},          // 用户访问令牌表
+		&models.UsageDaily{},           // 每日用量表
 	)
 	if err != nil {
 		log.Fatalf("数据库迁移失败: %v", err)
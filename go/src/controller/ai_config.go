@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"Deepseek-Go/models"
 	"Deepseek-Go/utils/ai"
 	"net/http"
 	"strconv"
@@ -18,10 +19,42 @@ type AIConfigController struct {
 // AI配置请求
 type AIConfigCreateRequest struct {
 	ModelName   string  `json:"model_name" binding:"required"`
-	Temperature float64 `json:"temperature" binding:"required,min=0,max=1"`
-	MaxTokens   int     `json:"max_tokens" binding:"required,min=1,max=4096"`
-	Provider    string  `json:"provider" binding:"required,oneof=deepseek kimi"`
+	Temperature float64 `json:"temperature"` // 取值范围由模型能力决定
+	MaxTokens   int     `json:"max_tokens" binding:"required"`
+	Provider    string  `json:"provider" binding:"required"`
 	IsDefault   bool    `json:"is_default"`
+	EnableTools bool    `json:"enable_tools"`
+	// 扩展采样参数
+	TopP             float64  `json:"top_p"`
+	PresencePenalty  float64  `json:"presence_penalty"`
+	FrequencyPenalty float64  `json:"frequency_penalty"`
+	Stop             []string `json:"stop"`
+	Seed             *int     `json:"seed"`
+	ResponseFormat   string   `json:"response_format"`
+	// 备用配置ID，按顺序在主配置不可用时使用
+	FallbackConfigIDs []uint `json:"fallback_config_ids"`
+	// 使用的用户API密钥ID，0表示使用服务端配置的密钥
+	CredentialID uint `json:"credential_id"`
+}
+
+// toModel 转换为AI配置模型
+func (req AIConfigCreateRequest) toModel() models.AIConfig {
+	return models.AIConfig{
+		ModelName:         req.ModelName,
+		Temperature:       req.Temperature,
+		MaxTokens:         req.MaxTokens,
+		Provider:          req.Provider,
+		IsDefault:         req.IsDefault,
+		EnableTools:       req.EnableTools,
+		TopP:              req.TopP,
+		PresencePenalty:   req.PresencePenalty,
+		FrequencyPenalty:  req.FrequencyPenalty,
+		Stop:              req.Stop,
+		Seed:              req.Seed,
+		ResponseFormat:    req.ResponseFormat,
+		FallbackConfigIDs: req.FallbackConfigIDs,
+		CredentialID:      req.CredentialID,
+	}
 }
 
 // 构造函数
@@ -48,15 +81,26 @@ func (ac *AIConfigController) CreateConfig(c *gin.Context) {
 		return
 	}
 
+	// 校验提供商是否已注册
+	if !ai.HasProvider(req.Provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的AI提供商: " + req.Provider})
+		return
+	}
+
+	// 校验模型是否属于提供商
+	if err := ai.ValidateModel(c.Request.Context(), req.Provider, req.ModelName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 按模型能力校验采样参数
+	if err := ai.ValidateSamplingParams(req.toModel()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的参数: " + err.Error()})
+		return
+	}
+
 	// 调用服务创建配置
-	config, err := ac.AIService.CreateAIConfig(
-		userID.(uint),
-		req.ModelName,
-		req.Temperature,
-		req.MaxTokens,
-		req.Provider,
-		req.IsDefault,
-	)
+	config, err := ac.AIService.CreateAIConfig(userID.(uint), req.toModel())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -142,16 +186,26 @@ func (ac *AIConfigController) UpdateConfig(c *gin.Context) {
 		return
 	}
 
+	// 校验提供商是否已注册
+	if !ai.HasProvider(req.Provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的AI提供商: " + req.Provider})
+		return
+	}
+
+	// 校验模型是否属于提供商
+	if err := ai.ValidateModel(c.Request.Context(), req.Provider, req.ModelName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 按模型能力校验采样参数
+	if err := ai.ValidateSamplingParams(req.toModel()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的参数: " + err.Error()})
+		return
+	}
+
 	// 调用服务更新配置
-	config, err := ac.AIService.UpdateAIConfig(
-		uint(configID),
-		userID.(uint),
-		req.ModelName,
-		req.Temperature,
-		req.MaxTokens,
-		req.Provider,
-		req.IsDefault,
-	)
+	config, err := ac.AIService.UpdateAIConfig(uint(configID), userID.(uint), req.toModel())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -212,27 +266,12 @@ func (ac *AIConfigController) GetDefaultConfig(c *gin.Context) {
 	})
 }
 
-// GetAvailableModels 获取可用的AI模型列表
+// GetAvailableModels 获取可用的AI模型列表，refresh=true时忽略缓存重新查询提供商
 func (ac *AIConfigController) GetAvailableModels(c *gin.Context) {
-	// DeepSeek模型列表
-	deepseekModels := []gin.H{
-		{"name": "deepseek-chat", "provider": "deepseek", "description": "基础模型"},
-		{"name": "deepseek-reasoner", "provider": "deepseek", "description": "深度思考模型"},
-	}
-
-	// Kimi模型列表
-	kimiModels := []gin.H{
-		{"name": "moonshot-v1-8k", "provider": "kimi", "description": "基础模型，支持8K上下文"},
-		{"name": "moonshot-v1-32k", "provider": "kimi", "description": "基础模型，支持32K上下文"},
-		{"name": "moonshot-v1-128k", "provider": "kimi", "description": "基础模型，支持128K上下文"},
-		{"name": "moonshot-v1-auto", "provider": "kimi", "description": "自动选择模型，根据上下文长度"},
-	}
+	refresh, _ := strconv.ParseBool(c.Query("refresh"))
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "获取可用模型列表成功",
-		"data": gin.H{
-			"deepseek": deepseekModels,
-			"kimi":     kimiModels,
-		},
+		"data":    ai.DiscoverModels(c.Request.Context(), refresh),
 	})
 }
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"Deepseek-Go/utils/ai"
+	"Deepseek-Go/utils/ai/aitest"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAIConfigTestRouter 创建使用内存数据库的AI配置路由并跳过JWT认证
+func newAIConfigTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	ac := NewAIConfigController(aitest.NewDB(t))
+	r := gin.New()
+	group := r.Group("/ai", func(c *gin.Context) { c.Set("userID", testUserID) })
+	group.POST("/configs", ac.CreateConfig)
+	group.PUT("/configs/:id", ac.UpdateConfig)
+	group.GET("/models", ac.GetAvailableModels)
+	return r
+}
+
+func TestCreateConfigValidatesModelName(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Models = []string{"qwen-max", "qwen-plus"}
+	ai.RegisterProvider(ai.Provider{Name: "qwen-test", BaseURL: server.URL, Models: []string{"qwen-max"}})
+	r := newAIConfigTestRouter(t)
+
+	w := doJSON(r, http.MethodPost, "/ai/configs", gin.H{"provider": "qwen-test", "model_name": "qwen-turbo", "max_tokens": 1024})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown model status = %d, want 400: %s", w.Code, w.Body.String())
+	}
+
+	// 只由提供商接口返回的模型也可以使用
+	w = doJSON(r, http.MethodPost, "/ai/configs", gin.H{"provider": "qwen-test", "model_name": "qwen-plus", "max_tokens": 1024})
+	if w.Code != http.StatusOK {
+		t.Fatalf("remote model status = %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID uint `json:"ID"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	w = doJSON(r, http.MethodPut, "/ai/configs/"+strconv.Itoa(int(created.Data.ID)), gin.H{"provider": "qwen-test", "model_name": "deepseek-v1-8k", "max_tokens": 1024})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("update with unknown model status = %d, want 400: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetAvailableModels(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Models = []string{"kimi-latest"}
+	ai.RegisterProvider(ai.Provider{Name: "kimi-test", BaseURL: server.URL, Models: []string{"moonshot-v1-8k"}})
+	r := newAIConfigTestRouter(t)
+
+	w := doJSON(r, http.MethodGet, "/ai/models?refresh=true", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Data map[string][]ai.ModelInfo `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	infos := response.Data["kimi-test"]
+	if len(infos) != 2 {
+		t.Fatalf("models = %+v, want 2", infos)
+	}
+	if latest := infos[1]; latest.Name != "kimi-latest" || latest.Source != "remote" || !latest.Vision || latest.ContextLength != 131072 {
+		t.Errorf("kimi-latest = %+v", latest)
+	}
+}
+
+func TestGetProvidersHealth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ai.RegisterProvider(ai.Provider{Name: "mock-health", Models: []string{"mock-echo"}, New: func(ai.Provider) ai.AIModel { return ai.NewMockModel() }})
+	pc := NewProviderController(aitest.NewDB(t))
+	r := gin.New()
+	r.GET("/providers/health", pc.GetProvidersHealth)
+
+	w := doJSON(r, http.MethodGet, "/providers/health", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data []ai.ProviderHealth `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, health := range resp.Data {
+		if health.Provider == "mock-health" {
+			if health.State != ai.BreakerClosed {
+				t.Errorf("state = %s", health.State)
+			}
+			return
+		}
+	}
+	t.Errorf("mock-health missing from %+v", resp.Data)
+}
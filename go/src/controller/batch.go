@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 批量任务控制器
+type BatchController struct {
+	DB        *gorm.DB
+	AIService *ai.AIService
+}
+
+// 构造函数
+func NewBatchController(db *gorm.DB) *BatchController {
+	return &BatchController{
+		DB:        db,
+		AIService: ai.NewAIService(db),
+	}
+}
+
+// CreateBatchJob 上传JSONL或CSV格式的提示词文件并创建批量任务
+// 表单字段：file为提示词文件，ai_config_id为使用的AI配置(0或不填表示默认配置)，
+// knowledge_ids为逗号分隔的知识库文件ID，name为任务名称(默认为文件名)
+func (bc *BatchController) CreateBatchJob(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 获取上传的文件
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "获取上传文件失败: " + err.Error()})
+		return
+	}
+	defer file.Close()
+	if header.Size > global.MaxFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小不能超过10MB"})
+		return
+	}
+
+	// 按扩展名确定文件格式
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+	if format != "jsonl" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的文件类型，仅支持jsonl和csv文件"})
+		return
+	}
+
+	// 解析AI配置和知识库文件
+	var configID uint64
+	if value := c.PostForm("ai_config_id"); value != "" {
+		configID, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的AI配置ID"})
+			return
+		}
+	}
+	knowledgeIDs, err := parseIDList(c.PostForm("knowledge_ids"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的知识库文件ID"})
+		return
+	}
+
+	var aiConfig *models.AIConfig
+	if configID > 0 {
+		aiConfig, err = bc.AIService.GetAIConfig(uint(configID), userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		aiConfig, err = bc.AIService.GetDefaultAIConfig(userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取默认AI配置失败: " + err.Error()})
+			return
+		}
+	}
+
+	// 解析提示词并创建任务
+	prompts, err := ai.ParseBatchPrompts(file, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = header.Filename
+	}
+
+	job, err := bc.AIService.CreateBatchJob(userID.(uint), name, *aiConfig, knowledgeIDs, prompts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "创建批量任务成功",
+		"data":    job,
+	})
+}
+
+// GetBatchJobs 获取批量任务列表
+func (bc *BatchController) GetBatchJobs(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 获取分页参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+
+	jobs, count, err := bc.AIService.GetBatchJobs(userID.(uint), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取批量任务列表失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取批量任务列表成功",
+		"data": gin.H{
+			"total":    count,
+			"page":     page,
+			"pageSize": pageSize,
+			"jobs":     jobs,
+		},
+	})
+}
+
+// GetBatchJob 获取批量任务的状态和进度
+func (bc *BatchController) GetBatchJob(c *gin.Context) {
+	jobID, userID, ok := batchJobParams(c)
+	if !ok {
+		return
+	}
+
+	job, err := bc.AIService.GetBatchJob(jobID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取批量任务成功",
+		"data":    job,
+	})
+}
+
+// GetBatchItems 分页获取批量任务的条目，可通过status筛选，如status=failed查看失败的条目及原因
+func (bc *BatchController) GetBatchItems(c *gin.Context) {
+	jobID, userID, ok := batchJobParams(c)
+	if !ok {
+		return
+	}
+
+	// 获取分页参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	items, count, err := bc.AIService.GetBatchItems(jobID, userID, c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取批量任务条目成功",
+		"data": gin.H{
+			"total":    count,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    items,
+		},
+	})
+}
+
+// DownloadBatchResults 以JSONL格式下载已结束任务的结果
+func (bc *BatchController) DownloadBatchResults(c *gin.Context) {
+	jobID, userID, ok := batchJobParams(c)
+	if !ok {
+		return
+	}
+
+	job, err := bc.AIService.GetBatchJob(jobID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if job.Status != ai.BatchCompleted && job.Status != ai.BatchCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": ai.ErrBatchNotFinished.Error(), "status": job.Status})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=batch-"+strconv.Itoa(int(job.ID))+"-results.jsonl")
+	c.Status(http.StatusOK)
+	if err := bc.AIService.WriteBatchResults(job.ID, userID, c.Writer); err != nil {
+		// 已开始输出文件内容，只能记录错误
+		c.Error(err)
+	}
+}
+
+// CancelBatchJob 取消未完成的批量任务
+func (bc *BatchController) CancelBatchJob(c *gin.Context) {
+	jobID, userID, ok := batchJobParams(c)
+	if !ok {
+		return
+	}
+
+	job, err := bc.AIService.CancelBatchJob(jobID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已取消批量任务",
+		"data":    job,
+	})
+}
+
+// DeleteBatchJob 删除批量任务及其结果
+func (bc *BatchController) DeleteBatchJob(c *gin.Context) {
+	jobID, userID, ok := batchJobParams(c)
+	if !ok {
+		return
+	}
+
+	if err := bc.AIService.DeleteBatchJob(jobID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除批量任务成功",
+	})
+}
+
+// batchJobParams 获取路径中的任务ID和当前用户ID，出错时写入错误响应并返回false
+func batchJobParams(c *gin.Context) (uint, uint, bool) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return 0, 0, false
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return 0, 0, false
+	}
+	return uint(jobID), userID.(uint), true
+}
+
+// parseIDList 解析逗号分隔的ID列表，空字符串返回nil
+func parseIDList(value string) ([]uint, error) {
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
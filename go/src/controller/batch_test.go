@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadBatch 以表单上传提示词文件创建批量任务
+func uploadBatch(r http.Handler, fileName string, data string, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	part, _ := writer.CreateFormFile("file", fileName)
+	part.Write([]byte(data))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/batch/jobs", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestBatchEndpoints(t *testing.T) {
+	r, cc, aiConfig := newTestRouter(t, "mock-controller-batch", ai.NewMockModel())
+	bc := &BatchController{DB: cc.DB, AIService: cc.AIService}
+	group := r.Group("/batch", func(c *gin.Context) { c.Set("userID", testUserID) })
+	group.POST("/jobs", bc.CreateBatchJob)
+	group.GET("/jobs", bc.GetBatchJobs)
+	group.GET("/jobs/:id", bc.GetBatchJob)
+	group.GET("/jobs/:id/items", bc.GetBatchItems)
+	group.GET("/jobs/:id/results", bc.DownloadBatchResults)
+	group.POST("/jobs/:id/cancel", bc.CancelBatchJob)
+	group.DELETE("/jobs/:id", bc.DeleteBatchJob)
+
+	configID := strconv.Itoa(int(aiConfig.ID))
+	if w := uploadBatch(r, "prompts.txt", "你好", map[string]string{"ai_config_id": configID}); w.Code != http.StatusBadRequest {
+		t.Errorf("txt upload status = %d", w.Code)
+	}
+	if w := uploadBatch(r, "prompts.csv", "question\n你好\n", map[string]string{"ai_config_id": configID}); w.Code != http.StatusBadRequest {
+		t.Errorf("csv without prompt column status = %d", w.Code)
+	}
+
+	w := uploadBatch(r, "prompts.csv", "custom_id,prompt\nq1,你好\nq2,再见\n", map[string]string{"ai_config_id": configID, "name": "评测"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data models.BatchJob `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Data.Name != "评测" || created.Data.Total != 2 || created.Data.AIConfigID != aiConfig.ID {
+		t.Fatalf("created job = %+v", created.Data)
+	}
+	jobPath := "/batch/jobs/" + strconv.Itoa(int(created.Data.ID))
+
+	// 轮询任务进度直到完成
+	var job models.BatchJob
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w = doJSON(r, http.MethodGet, jobPath, nil)
+		var resp struct {
+			Data models.BatchJob `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		job = resp.Data
+		if job.Status == ai.BatchCompleted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status != ai.BatchCompleted || job.Succeeded != 2 {
+		t.Fatalf("job = %+v", job)
+	}
+
+	w = doJSON(r, http.MethodGet, jobPath+"/items?status=succeeded", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"total":2`) {
+		t.Errorf("items status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, http.MethodGet, jobPath+"/results", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/x-ndjson") {
+		t.Fatalf("results status = %d, headers = %v", w.Code, w.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"custom_id":"q1"`) || !strings.Contains(lines[1], `"response":"echo: 再见"`) {
+		t.Errorf("results = %q", w.Body.String())
+	}
+
+	if w := doJSON(r, http.MethodPost, jobPath+"/cancel", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("cancel finished job status = %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodGet, "/batch/jobs/9999", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing job status = %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodDelete, jobPath, nil); w.Code != http.StatusOK {
+		t.Errorf("delete status = %d", w.Code)
+	}
+	w = doJSON(r, http.MethodGet, "/batch/jobs", nil)
+	if !strings.Contains(w.Body.String(), `"total":0`) {
+		t.Errorf("jobs after delete = %s", w.Body.String())
+	}
+}
@@ -1,11 +1,18 @@
 package controller
 
 import (
+	"Deepseek-Go/global"
 	"Deepseek-Go/models"
 	"Deepseek-Go/utils/ai"
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +31,19 @@ type ChatRequest struct {
 	Message      string `json:"message"`
 	AIConfigID   uint   `json:"ai_config_id"` // 0表示使用默认配置
 	KnowledgeIDs []uint `json:"knowledge_ids"`
+	// 通过/chat/attachments上传的图片附件ID，仅支持视觉模型
+	AttachmentIDs []uint `json:"attachment_ids"`
+	// 切换会话使用的角色，0表示沿用会话当前的角色
+	PersonaID uint `json:"persona_id"`
+}
+
+// 结构化输出请求结构体
+type StructuredChatRequest struct {
+	SessionID    uint            `json:"session_id"`
+	Message      string          `json:"message" binding:"required"`
+	Schema       json.RawMessage `json:"schema" binding:"required"` // 回复需要符合的JSON Schema
+	AIConfigID   uint            `json:"ai_config_id"`              // 0表示使用默认配置
+	KnowledgeIDs []uint          `json:"knowledge_ids"`
 }
 
 // AI配置请求结构体
@@ -36,10 +56,23 @@ type AIConfigRequest struct {
 
 // 聊天响应结构体
 type ChatResponse struct {
-	ID        uint   `json:"id"`
-	Role      string `json:"role"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
+	ID               uint            `json:"id"`
+	Role             string          `json:"role"`
+	Content          string          `json:"content"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	ToolCalls        json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID       string          `json:"tool_call_id,omitempty"`
+	Provider         string          `json:"provider,omitempty"`
+	ModelName        string          `json:"model_name,omitempty"`
+	AttachmentIDs    []uint          `json:"attachment_ids,omitempty"`
+	ComparisonID     string          `json:"comparison_id,omitempty"`     // 多模型对比的ID，同一对比的回答相同
+	Candidate        bool            `json:"candidate,omitempty"`         // 是否为尚未选出胜者的对比回答
+	AIConfigID       uint            `json:"ai_config_id,omitempty"`      // 生成对比回答的AI配置ID
+	PromptTokens     int             `json:"prompt_tokens,omitempty"`     // 生成回复的输入token数
+	CompletionTokens int             `json:"completion_tokens,omitempty"` // 生成回复的输出token数
+	CachedTokens     int             `json:"cached_tokens,omitempty"`     // 输入中命中提供商缓存的token数
+	Cost             float64         `json:"cost,omitempty"`              // 按模型价格表计算的费用
+	CreatedAt        string          `json:"created_at"`
 }
 
 // NewChatController 创建聊天控制器
@@ -66,30 +99,29 @@ func (cc *ChatController) Chat(c *gin.Context) {
 	}
 
 	// 获取AI配置
-	var aiConfig models.AIConfig
-	var err error
+	aiConfig, ok := cc.chatConfig(c, req, userID.(uint))
+	if !ok {
+		return
+	}
 
-	if req.AIConfigID > 0 {
-		// 使用指定的配置
-		aiConfig, err = cc.getAIConfig(req.AIConfigID, userID.(uint))
+	// 请求指定角色时先获取或创建会话并切换会话的角色
+	if req.PersonaID > 0 {
+		session, err := cc.AIService.GetOrCreateSession(userID.(uint), req.SessionID, req.Message)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-	} else {
-		// 使用默认配置
-		config, err := cc.AIService.GetDefaultAIConfig(userID.(uint))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取默认AI配置失败: " + err.Error()})
+		if err := cc.AIService.SetSessionPersona(session, req.PersonaID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		aiConfig = *config
+		req.SessionID = session.ID
 	}
 
 	// 调用AI服务处理聊天
-	assistantMessage, session, err := cc.AIService.Chat(userID.(uint), req.SessionID, req.Message, aiConfig, req.KnowledgeIDs)
+	assistantMessage, session, err := cc.AIService.Chat(c.Request.Context(), userID.(uint), req.SessionID, req.Message, aiConfig, req.KnowledgeIDs, req.AttachmentIDs)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "聊天处理失败: " + err.Error()})
+		writeAIError(c, "聊天处理失败: ", err)
 		return
 	}
 
@@ -97,10 +129,17 @@ func (cc *ChatController) Chat(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "聊天成功",
 		"data": ChatResponse{
-			ID:        assistantMessage.ID,
-			Role:      assistantMessage.Role,
-			Content:   assistantMessage.Content,
-			CreatedAt: assistantMessage.CreatedAt.Format(time.RFC3339),
+			ID:               assistantMessage.ID,
+			Role:             assistantMessage.Role,
+			Content:          assistantMessage.Content,
+			ReasoningContent: assistantMessage.ReasoningContent,
+			Provider:         assistantMessage.Provider,
+			ModelName:        assistantMessage.ModelName,
+			PromptTokens:     assistantMessage.PromptTokens,
+			CompletionTokens: assistantMessage.CompletionTokens,
+			CachedTokens:     assistantMessage.CachedTokens,
+			Cost:             assistantMessage.Cost,
+			CreatedAt:        assistantMessage.CreatedAt.Format(time.RFC3339),
 		},
 		"session_id": session.ID,
 	})
@@ -122,9 +161,252 @@ func (cc *ChatController) StreamChat(c *gin.Context) {
 	}
 
 	// 获取AI配置
-	var aiConfig models.AIConfig
-	var err error
+	aiConfig, ok := cc.chatConfig(c, req, userID.(uint))
+	if !ok {
+		return
+	}
+
+	// 配额用完时直接返回402/429，不创建会话
+	if err := cc.AIService.CheckQuota(userID.(uint)); err != nil {
+		writeAIError(c, "聊天处理失败: ", err)
+		return
+	}
+
+	// 先获取或创建会话，客户端可以据此在生成过程中停止生成
+	session, err := cc.AIService.GetOrCreateSession(userID.(uint), req.SessionID, req.Message)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PersonaID > 0 {
+		if err := cc.AIService.SetSessionPersona(session, req.PersonaID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// 缓存本次生成的事件，连接断开后可以通过续传接口从Last-Event-ID继续接收
+	stream, err := cc.AIService.OpenStream(c.Request.Context(), session.ID)
+	if errors.Is(err, ai.ErrStreamBusy) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "error_type": aiErrorType(err)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stream.Emit("session", gin.H{"session_id": session.ID})
+
+	// 生成不随本次请求结束，没有客户端接收流超过一定时间后才取消对提供商的请求
+	ctx, cancel := stream.Detach()
+	go func() {
+		defer cancel()
+		defer stream.Close()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("会话%d流式生成异常: %v", session.ID, r)
+				stream.Emit("", gin.H{"error": "AI服务调用失败", "error_type": "internal", "status": http.StatusInternalServerError, "done": true})
+			}
+		}()
+		cc.streamGeneration(ctx, stream, userID.(uint), session.ID, req, aiConfig)
+	}()
+
+	cc.followStream(c, session.ID, userID.(uint), 0)
+}
+
+// streamGeneration 执行流式生成，并将数据块转换为SSE事件写入流缓存
+func (cc *ChatController) streamGeneration(ctx context.Context, stream *ai.StreamWriter, userID, sessionID uint, req ChatRequest, aiConfig models.AIConfig) {
+	// 记录结束原因和用量
+	var finishReason string
+	var usage *ai.Usage
+
+	// 处理流式回复的回调函数
+	callback := func(chunk *ai.ChatCompletionChunk) {
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			return
+		}
+
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+
+		// 思维链内容以单独的reasoning事件发送
+		if choice.Delta.ReasoningContent != "" {
+			stream.Emit("reasoning", gin.H{
+				"id":      chunk.ID,
+				"content": choice.Delta.ReasoningContent,
+				"done":    false,
+			})
+		}
 
+		// 工具调用以单独的tool_call事件发送，仅在出现函数名时通知
+		for _, call := range choice.Delta.ToolCalls {
+			if call.Function.Name == "" {
+				continue
+			}
+			stream.Emit("tool_call", gin.H{
+				"id":   chunk.ID,
+				"name": call.Function.Name,
+				"done": false,
+			})
+		}
+
+		if choice.Delta.Content == "" && choice.FinishReason == "" {
+			return
+		}
+
+		// 发送数据到客户端
+		stream.Emit("", gin.H{
+			"id":            chunk.ID,
+			"content":       choice.Delta.Content,
+			"finish_reason": choice.FinishReason,
+			"done":          false,
+		})
+	}
+
+	// 调用AI服务处理流式聊天
+	assistantMessage, _, err := cc.AIService.StreamChat(ctx, userID, sessionID, req.Message, aiConfig, req.KnowledgeIDs, req.AttachmentIDs, callback)
+	if errors.Is(err, ai.ErrGenerationStopped) || (err != nil && assistantMessage != nil) {
+		// 生成中断，返回已保存的部分回复
+		done := gin.H{
+			"id":            "done",
+			"content":       "",
+			"done":          true,
+			"interrupted":   true,
+			"finish_reason": "stopped",
+			"usage":         usage,
+			"session_id":    sessionID,
+		}
+		if assistantMessage != nil {
+			done["message_id"] = assistantMessage.ID
+			done["provider"] = assistantMessage.Provider
+			done["model_name"] = assistantMessage.ModelName
+		}
+		stream.Emit("", done)
+		return
+	}
+	if err != nil {
+		// 发送错误信息
+		stream.Emit("", gin.H{
+			"error":      "AI服务调用失败: " + err.Error(),
+			"error_type": aiErrorType(err),
+			"status":     ai.HTTPStatus(err),
+			"done":       true,
+		})
+		return
+	}
+
+	// 发送完成消息
+	stream.Emit("", gin.H{
+		"id":            "done",
+		"content":       "",
+		"done":          true,
+		"finish_reason": finishReason,
+		"usage":         usage,
+		"session_id":    sessionID,
+		"message_id":    assistantMessage.ID,
+		"provider":      assistantMessage.Provider,
+		"model_name":    assistantMessage.ModelName,
+		"cached":        assistantMessage.Cached,
+	})
+}
+
+// ResumeStream 断线重连后从Last-Event-ID之后续传会话最近一次生成的事件，生成未结束时继续接收
+func (cc *ChatController) ResumeStream(c *gin.Context) {
+	// 获取会话ID
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 浏览器的EventSource重连时自动携带Last-Event-ID请求头，其他客户端也可以通过查询参数指定
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	afterID := 0
+	if lastEventID != "" {
+		afterID, err = strconv.Atoi(lastEventID)
+		if err != nil || afterID < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的Last-Event-ID"})
+			return
+		}
+	}
+
+	cc.followStream(c, uint(sessionID), userID.(uint), afterID)
+}
+
+// followStream 以SSE格式输出会话缓存的事件，在输出第一个事件前出错时返回JSON错误
+func (cc *ChatController) followStream(c *gin.Context, sessionID, userID uint, afterID int) {
+	started := false
+	err := cc.AIService.FollowStream(c.Request.Context(), sessionID, userID, afterID, func(event ai.StreamEvent) error {
+		if !started {
+			// 设置SSE响应头
+			c.Writer.Header().Set("Content-Type", "text/event-stream")
+			c.Writer.Header().Set("Cache-Control", "no-cache")
+			c.Writer.Header().Set("Connection", "keep-alive")
+			c.Writer.Header().Set("Transfer-Encoding", "chunked")
+			started = true
+		}
+
+		message := "id: " + strconv.Itoa(event.ID) + "\n"
+		if event.Event != "" {
+			message += "event: " + event.Event + "\n"
+		}
+		message += "data: " + event.Data + "\n\n"
+		if _, err := c.Writer.Write([]byte(message)); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil || started {
+		return
+	}
+
+	if errors.Is(err, ai.ErrStreamNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// StructuredChat 处理结构化输出请求，返回符合JSON Schema的解析结果
+func (cc *ChatController) StructuredChat(c *gin.Context) {
+	var req StructuredChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 解析JSON Schema
+	schema, err := ai.ParseJSONSchema(req.Schema)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的JSON Schema: " + err.Error()})
+		return
+	}
+
+	// 获取AI配置
+	var aiConfig models.AIConfig
 	if req.AIConfigID > 0 {
 		// 使用指定的配置
 		aiConfig, err = cc.getAIConfig(req.AIConfigID, userID.(uint))
@@ -141,54 +423,138 @@ func (cc *ChatController) StreamChat(c *gin.Context) {
 		}
 		aiConfig = *config
 	}
+	if !ai.Capabilities(aiConfig.ModelName).JSONMode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "模型" + aiConfig.ModelName + "不支持JSON输出"})
+		return
+	}
 
-	// 设置SSE响应头
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Transfer-Encoding", "chunked")
-
-	// 确保数据立即发送
-	c.Writer.Flush()
-
-	// 处理流式回复的回调函数
-	callback := func(response *ai.ChatCompletionResponse) {
-		if len(response.Choices) > 0 {
-			chunk := response.Choices[0].Message.Content
-
-			// 发送数据到客户端
-			data, _ := json.Marshal(gin.H{
-				"id":      response.ID,
-				"content": chunk,
-				"done":    false,
+	// 调用AI服务生成结构化输出
+	result, assistantMessage, session, err := cc.AIService.StructuredChat(c.Request.Context(), userID.(uint), req.SessionID, req.Message, schema, aiConfig, req.KnowledgeIDs)
+	if err != nil {
+		var outputErr *ai.StructuredOutputError
+		if errors.As(err, &outputErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":      "结构化输出失败: " + err.Error(),
+				"error_type": "schema_validation",
+				"details":    outputErr.Errors,
+				"content":    outputErr.Content,
 			})
-			c.Writer.Write([]byte("data: " + string(data) + "\n\n"))
-			c.Writer.Flush()
+			return
 		}
+		writeAIError(c, "结构化输出失败: ", err)
+		return
 	}
 
-	// 调用AI服务处理流式聊天
-	_, session, err := cc.AIService.StreamChat(userID.(uint), req.SessionID, req.Message, aiConfig, req.KnowledgeIDs, c.Writer, callback)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "结构化输出成功",
+		"data": gin.H{
+			"id":         assistantMessage.ID,
+			"result":     result,
+			"provider":   assistantMessage.Provider,
+			"model_name": assistantMessage.ModelName,
+			"created_at": assistantMessage.CreatedAt.Format(time.RFC3339),
+		},
+		"session_id": session.ID,
+	})
+}
+
+// StopGeneration 停止会话正在进行的生成，已生成的部分回复会被保存并标记为中断
+func (cc *ChatController) StopGeneration(c *gin.Context) {
+	// 获取会话ID
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		// 发送错误信息
-		data, _ := json.Marshal(gin.H{
-			"error": "AI服务调用失败: " + err.Error(),
-			"done":  true,
-		})
-		c.Writer.Write([]byte("data: " + string(data) + "\n\n"))
-		c.Writer.Flush()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
 		return
 	}
 
-	// 发送完成消息
-	data, _ := json.Marshal(gin.H{
-		"id":         "done",
-		"content":    "",
-		"done":       true,
-		"session_id": session.ID,
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	stopped, err := cc.AIService.StopGeneration(c.Request.Context(), uint(sessionID), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !stopped {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该会话没有正在进行的生成"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已停止生成",
 	})
-	c.Writer.Write([]byte("data: " + string(data) + "\n\n"))
-	c.Writer.Flush()
+}
+
+// UploadAttachment 上传聊天图片附件，返回的附件ID用于聊天请求的attachment_ids
+func (cc *ChatController) UploadAttachment(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 获取上传的文件
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "获取上传文件失败: " + err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// 校验文件类型
+	if _, ok := global.AllowedImageTypes[strings.ToLower(filepath.Ext(header.Filename))]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的图片类型，仅支持png, jpg, jpeg, webp, gif文件"})
+		return
+	}
+
+	// 校验文件大小
+	if header.Size > global.MaxImageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "图片大小不能超过5MB"})
+		return
+	}
+
+	// 保存附件
+	attachment, err := cc.AIService.SaveChatAttachment(userID.(uint), file, header.Filename)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上传图片失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "图片上传成功",
+		"data":    attachment,
+	})
+}
+
+// GetAttachment 获取聊天图片附件的内容
+func (cc *ChatController) GetAttachment(c *gin.Context) {
+	// 获取附件ID
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	attachment, err := cc.AIService.GetChatAttachment(uint(attachmentID), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", attachment.MimeType)
+	c.File(attachment.FilePath)
 }
 
 // GetSessions 获取用户的所有聊天会话
@@ -242,7 +608,12 @@ func (cc *ChatController) GetSessionMessages(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 
-	// 调用服务获取消息历史
+	// 调用服务获取会话和消息历史
+	session, err := cc.AIService.GetSession(uint(sessionID), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	messages, count, err := cc.AIService.GetSessionMessages(uint(sessionID), userID.(uint), page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -253,10 +624,23 @@ func (cc *ChatController) GetSessionMessages(c *gin.Context) {
 	var formattedMessages []ChatResponse
 	for _, msg := range messages {
 		formattedMessages = append(formattedMessages, ChatResponse{
-			ID:        msg.ID,
-			Role:      msg.Role,
-			Content:   msg.Content,
-			CreatedAt: msg.CreatedAt.Format(time.RFC3339),
+			ID:               msg.ID,
+			Role:             msg.Role,
+			Content:          msg.Content,
+			ReasoningContent: msg.ReasoningContent,
+			ToolCalls:        rawToolCalls(msg.ToolCalls),
+			ToolCallID:       msg.ToolCallID,
+			Provider:         msg.Provider,
+			ModelName:        msg.ModelName,
+			AttachmentIDs:    msg.AttachmentIDs,
+			ComparisonID:     msg.ComparisonID,
+			Candidate:        msg.Candidate,
+			AIConfigID:       msg.AIConfigID,
+			PromptTokens:     msg.PromptTokens,
+			CompletionTokens: msg.CompletionTokens,
+			CachedTokens:     msg.CachedTokens,
+			Cost:             msg.Cost,
+			CreatedAt:        msg.CreatedAt.Format(time.RFC3339),
 		})
 	}
 
@@ -267,6 +651,9 @@ func (cc *ChatController) GetSessionMessages(c *gin.Context) {
 			"page":     page,
 			"pageSize": pageSize,
 			"messages": formattedMessages,
+			// 较早消息的滚动摘要，summary_until_id及之前的消息不再发送给模型
+			"summary":          session.Summary,
+			"summary_until_id": session.SummaryUntilID,
 		},
 	})
 }
@@ -287,9 +674,11 @@ func (cc *ChatController) UpdateSession(c *gin.Context) {
 		return
 	}
 
-	// 解析请求体
+	// 解析请求体，未传入的字段保持不变
 	var updateData struct {
-		Title string `json:"title"`
+		Title     *string `json:"title"`
+		Summary   *string `json:"summary"`
+		PersonaID *uint   `json:"persona_id"` // 0表示恢复默认系统提示词
 	}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
@@ -297,7 +686,7 @@ func (cc *ChatController) UpdateSession(c *gin.Context) {
 	}
 
 	// 调用服务更新会话
-	session, err := cc.AIService.UpdateSession(uint(sessionID), userID.(uint), updateData.Title)
+	session, err := cc.AIService.UpdateSession(uint(sessionID), userID.(uint), updateData.Title, updateData.Summary, updateData.PersonaID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -336,6 +725,91 @@ func (cc *ChatController) DeleteSession(c *gin.Context) {
 	})
 }
 
+// UpdateMessage 编辑会话中的消息
+func (cc *ChatController) UpdateMessage(c *gin.Context) {
+	// 获取会话ID和消息ID
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的消息ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 解析请求体
+	var updateData struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	// 调用服务更新消息
+	message, err := cc.AIService.UpdateMessage(uint(sessionID), uint(messageID), userID.(uint), updateData.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "更新消息成功",
+		"data": ChatResponse{
+			ID:               message.ID,
+			Role:             message.Role,
+			Content:          message.Content,
+			ReasoningContent: message.ReasoningContent,
+			ToolCalls:        rawToolCalls(message.ToolCalls),
+			ToolCallID:       message.ToolCallID,
+			Provider:         message.Provider,
+			ModelName:        message.ModelName,
+			CreatedAt:        message.CreatedAt.Format(time.RFC3339),
+		},
+	})
+}
+
+// DeleteMessage 删除会话中的消息
+func (cc *ChatController) DeleteMessage(c *gin.Context) {
+	// 获取会话ID和消息ID
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的消息ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 调用服务删除消息
+	if err := cc.AIService.DeleteMessage(uint(sessionID), uint(messageID), userID.(uint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除消息成功",
+	})
+}
+
 // 辅助方法
 
 // getAIConfig 获取AI配置并验证所有权
@@ -346,3 +820,92 @@ func (cc *ChatController) getAIConfig(configID, userID uint) (models.AIConfig, e
 	}
 	return *config, nil
 }
+
+// chatConfig 确定本次对话使用的AI配置，出错时写入错误响应并返回false
+// 请求未指定配置时，优先使用角色的默认配置，其次使用用户的默认配置；共享角色的默认配置只对创建者生效
+func (cc *ChatController) chatConfig(c *gin.Context, req ChatRequest, userID uint) (models.AIConfig, bool) {
+	// 获取本次对话的角色，请求未指定时沿用会话的角色
+	var persona *models.Persona
+	if req.PersonaID > 0 {
+		var err error
+		persona, err = cc.AIService.GetPersona(req.PersonaID, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return models.AIConfig{}, false
+		}
+	} else if req.SessionID > 0 {
+		if session, err := cc.AIService.GetSession(req.SessionID, userID); err == nil {
+			persona = cc.AIService.SessionPersona(session)
+		}
+	}
+
+	configID := req.AIConfigID
+	if configID == 0 && persona != nil && persona.UserID == userID {
+		configID = persona.AIConfigID
+	}
+
+	var aiConfig models.AIConfig
+	if configID > 0 {
+		// 使用指定的配置
+		config, err := cc.getAIConfig(configID, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return models.AIConfig{}, false
+		}
+		aiConfig = config
+	} else {
+		// 使用默认配置
+		config, err := cc.AIService.GetDefaultAIConfig(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取默认AI配置失败: " + err.Error()})
+			return models.AIConfig{}, false
+		}
+		aiConfig = *config
+	}
+
+	// 附带图片时校验模型是否支持图片输入
+	if len(req.AttachmentIDs) > 0 && !ai.Capabilities(aiConfig.ModelName).Vision {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "模型" + aiConfig.ModelName + "不支持图片输入"})
+		return models.AIConfig{}, false
+	}
+	return aiConfig, true
+}
+
+// writeAIError 按AI错误类型返回对应的HTTP状态码
+func writeAIError(c *gin.Context, prefix string, err error) {
+	if providerErr, ok := ai.AsProviderError(err); ok && providerErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(providerErr.RetryAfter.Seconds()))))
+	}
+	if quotaErr, ok := ai.AsQuotaError(err); ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(quotaErr.ResetAt).Seconds()))))
+	}
+	c.JSON(ai.HTTPStatus(err), gin.H{
+		"error":      prefix + err.Error(),
+		"error_type": aiErrorType(err),
+	})
+}
+
+// aiErrorType 返回AI错误的类型，超出配额返回quota，其他非提供商错误返回internal
+func aiErrorType(err error) string {
+	if providerErr, ok := ai.AsProviderError(err); ok {
+		return string(providerErr.Kind)
+	}
+	if _, ok := ai.AsQuotaError(err); ok {
+		return "quota"
+	}
+	if errors.Is(err, ai.ErrGenerationStopped) {
+		return "stopped"
+	}
+	if errors.Is(err, ai.ErrStreamBusy) {
+		return "busy"
+	}
+	return "internal"
+}
+
+// rawToolCalls 将存储的工具调用JSON转换为原始JSON，为空时省略
+func rawToolCalls(toolCalls string) json.RawMessage {
+	if toolCalls == "" {
+		return nil
+	}
+	return json.RawMessage(toolCalls)
+}
@@ -0,0 +1,584 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"Deepseek-Go/utils/ai/aitest"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testUserID uint = 1
+
+// newTestRouter 创建使用内存数据库的聊天路由，注册给定的模拟提供商并跳过JWT认证
+func newTestRouter(t *testing.T, provider string, model *ai.MockModel) (*gin.Engine, *ChatController, models.AIConfig) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	ai.RegisterProvider(ai.Provider{Name: provider, Models: []string{"mock-echo"}, New: func(ai.Provider) ai.AIModel { return model }})
+	cc := NewChatController(aitest.NewDB(t))
+
+	aiConfig := models.AIConfig{UserID: testUserID, Provider: provider, ModelName: "mock-echo", MaxTokens: 256}
+	if err := cc.DB.Create(&aiConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	chat := r.Group("/chat", func(c *gin.Context) { c.Set("userID", testUserID) })
+	chat.POST("/completions", cc.Chat)
+	chat.POST("/stream", cc.StreamChat)
+	chat.POST("/structured", cc.StructuredChat)
+	chat.POST("/compare", cc.CompareChat)
+	chat.POST("/attachments", cc.UploadAttachment)
+	chat.GET("/attachments/:id", cc.GetAttachment)
+	chat.GET("/sessions/:id", cc.GetSessionMessages)
+	chat.GET("/sessions/:id/stream", cc.ResumeStream)
+	chat.POST("/sessions/:id/messages/:message_id/pick", cc.PickComparisonWinner)
+
+	return r, cc, aiConfig
+}
+
+// doJSON 发送JSON请求并返回响应
+func doJSON(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// sseEvent 表示一个解析后的SSE事件
+type sseEvent struct {
+	Event string
+	Data  map[string]interface{}
+}
+
+// parseSSE 解析SSE响应体
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.Data); err != nil {
+				t.Fatalf("invalid SSE data %q: %v", line, err)
+			}
+		case line == "" && current.Data != nil:
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestChatControllerChat(t *testing.T) {
+	r, _, aiConfig := newTestRouter(t, "mock-controller-chat", ai.NewMockModel())
+
+	w := doJSON(r, http.MethodPost, "/chat/completions", ChatRequest{Message: "你好", AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data      ChatResponse `json:"data"`
+		SessionID uint         `json:"session_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Content != "echo: 你好" || resp.Data.Role != "assistant" || resp.SessionID == 0 {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.Data.Provider != "mock-controller-chat" || resp.Data.ModelName != "mock-echo" {
+		t.Errorf("Provider/ModelName = %q/%q", resp.Data.Provider, resp.Data.ModelName)
+	}
+
+	// 会话详情应包含用户消息和AI回复
+	w = doJSON(r, http.MethodGet, "/chat/sessions/1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var session struct {
+		Data struct {
+			Messages []ChatResponse `json:"messages"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Data.Messages) != 2 {
+		t.Errorf("len(messages) = %d, want 2", len(session.Data.Messages))
+	}
+}
+
+func TestChatControllerChatProviderError(t *testing.T) {
+	model := ai.NewMockModel()
+	model.Err = &ai.ProviderError{Provider: "mock-controller-error", Kind: ai.ErrKindRateLimit, StatusCode: 429, RetryAfter: 1500 * time.Millisecond}
+	r, _, aiConfig := newTestRouter(t, "mock-controller-error", model)
+
+	w := doJSON(r, http.MethodPost, "/chat/completions", ChatRequest{Message: "你好", AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["error_type"] != string(ai.ErrKindRateLimit) {
+		t.Errorf("error_type = %v", resp["error_type"])
+	}
+}
+
+func TestChatControllerStreamChat(t *testing.T) {
+	model := ai.NewMockModel(ai.ChatMessage{ReasoningContent: "思考中", Content: "流式输出的回复"})
+	model.ChunkSize = 3
+	r, _, aiConfig := newTestRouter(t, "mock-controller-stream", model)
+
+	w := doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{Message: "你好", AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	var content, reasoning string
+	var session, done map[string]interface{}
+	for _, event := range parseSSE(t, w.Body.String()) {
+		switch {
+		case event.Event == "session":
+			session = event.Data
+		case event.Event == "reasoning":
+			reasoning += event.Data["content"].(string)
+		case event.Data["done"] == true:
+			done = event.Data
+		default:
+			content += event.Data["content"].(string)
+		}
+	}
+
+	if content != "流式输出的回复" || reasoning != "思考中" {
+		t.Errorf("content = %q, reasoning = %q", content, reasoning)
+	}
+	if done == nil {
+		t.Fatal("missing done event")
+	}
+	if done["finish_reason"] != "stop" || done["session_id"] == nil || done["provider"] != "mock-controller-stream" {
+		t.Errorf("done event = %v", done)
+	}
+	if session == nil || session["session_id"] != done["session_id"] {
+		t.Errorf("session event = %v, done event = %v", session, done)
+	}
+	if done["usage"] == nil {
+		t.Error("done event has no usage")
+	}
+	if done["cached"] != false {
+		t.Errorf("done cached = %v", done["cached"])
+	}
+}
+
+func TestChatControllerStreamChatError(t *testing.T) {
+	model := ai.NewMockModel()
+	model.Err = &ai.ProviderError{Provider: "mock-controller-stream-error", Kind: ai.ErrKindAuth, StatusCode: 401}
+	r, _, aiConfig := newTestRouter(t, "mock-controller-stream-error", model)
+
+	w := doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{Message: "你好", AIConfigID: aiConfig.ID})
+	events := parseSSE(t, w.Body.String())
+	if len(events) != 2 || events[0].Event != "session" {
+		t.Fatalf("events = %+v, want a session event and an error event", events)
+	}
+	data := events[1].Data
+	if data["done"] != true || data["error_type"] != string(ai.ErrKindAuth) || data["status"] != float64(http.StatusBadGateway) {
+		t.Errorf("error event = %v", data)
+	}
+}
+
+func TestChatControllerStructuredChat(t *testing.T) {
+	model := ai.NewMockModel(ai.ChatMessage{Content: `{"answer": 42}`})
+	r, _, aiConfig := newTestRouter(t, "mock-controller-structured", model)
+	schema := json.RawMessage(`{"type": "object", "properties": {"answer": {"type": "integer"}}, "required": ["answer"]}`)
+
+	w := doJSON(r, http.MethodPost, "/chat/structured", StructuredChatRequest{Message: "答案是什么", Schema: schema, AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Result map[string]interface{} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Result["answer"] != float64(42) {
+		t.Errorf("result = %v", resp.Data.Result)
+	}
+
+	// 非法的Schema返回400
+	w = doJSON(r, http.MethodPost, "/chat/structured", StructuredChatRequest{Message: "你好", Schema: json.RawMessage(`{"type": "text"}`), AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid schema status = %d, want 400", w.Code)
+	}
+
+	// 多次修正仍不符合时返回422
+	w = doJSON(r, http.MethodPost, "/chat/structured", StructuredChatRequest{Message: "你好", Schema: schema, AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid output status = %d, want 422, body = %s", w.Code, w.Body.String())
+	}
+}
+
+// chdirTemp 切换到临时目录，避免上传文件写入源码目录
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// uploadImage 以multipart表单上传图片
+func uploadImage(r http.Handler, fileName string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", fileName)
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/attachments", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChatControllerImageAttachment(t *testing.T) {
+	chdirTemp(t)
+	model := ai.NewMockModel()
+	r, cc, aiConfig := newTestRouter(t, "mock-controller-vision", model)
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+
+	// 非图片内容和不支持的扩展名被拒绝
+	if w := uploadImage(r, "a.png", []byte("plain text")); w.Code != http.StatusBadRequest {
+		t.Errorf("text upload status = %d, want 400", w.Code)
+	}
+	if w := uploadImage(r, "a.bmp", png); w.Code != http.StatusBadRequest {
+		t.Errorf("bmp upload status = %d, want 400", w.Code)
+	}
+
+	w := uploadImage(r, "screen.png", png)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var upload struct {
+		Data models.ChatAttachment `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &upload)
+
+	// 读取附件内容
+	w = doJSON(r, http.MethodGet, "/chat/attachments/"+strconv.Itoa(int(upload.Data.ID)), nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), png) || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("get attachment status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// 文本模型不接受图片
+	req := ChatRequest{Message: "看图", AIConfigID: aiConfig.ID, AttachmentIDs: []uint{upload.Data.ID}}
+	if w := doJSON(r, http.MethodPost, "/chat/completions", req); w.Code != http.StatusBadRequest {
+		t.Errorf("text model status = %d, want 400", w.Code)
+	}
+
+	// 视觉模型收到图片内容
+	cc.DB.Model(&aiConfig).Update("model_name", "moonshot-v1-8k-vision-preview")
+	w = doJSON(r, http.MethodPost, "/chat/completions", req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("vision chat status = %d, body = %s", w.Code, w.Body.String())
+	}
+	messages := model.Requests()[0].Messages
+	if !messages[len(messages)-1].HasImages() {
+		t.Error("vision request has no image parts")
+	}
+}
+
+// stopAfterFirstChunk 在第一个内容数据块之后停止会话的生成
+type stopAfterFirstChunk struct {
+	*ai.MockModel
+	stop func()
+}
+
+func (m stopAfterFirstChunk) StreamChatCompletion(ctx context.Context, request ai.ChatCompletionRequest, callback func(chunk *ai.ChatCompletionChunk)) error {
+	var once sync.Once
+	return m.MockModel.StreamChatCompletion(ctx, request, func(chunk *ai.ChatCompletionChunk) {
+		callback(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			once.Do(m.stop)
+		}
+	})
+}
+
+func TestChatControllerStopGeneration(t *testing.T) {
+	model := ai.NewMockModel(ai.ChatMessage{Content: "这是一段会被中途停止的很长的回复"})
+	model.ChunkSize = 2
+	r, cc, aiConfig := newTestRouter(t, "mock-controller-stop", model)
+	r.POST("/chat/sessions/:id/stop", func(c *gin.Context) { c.Set("userID", testUserID) }, cc.StopGeneration)
+
+	session, err := cc.AIService.GetOrCreateSession(testUserID, 0, "你好")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopPath := "/chat/sessions/" + strconv.Itoa(int(session.ID)) + "/stop"
+
+	// 没有正在进行的生成
+	if w := doJSON(r, http.MethodPost, stopPath, nil); w.Code != http.StatusNotFound {
+		t.Errorf("idle stop status = %d, want 404", w.Code)
+	}
+
+	// 通过停止接口在输出第一个数据块后停止生成
+	ai.RegisterProvider(ai.Provider{Name: "mock-controller-stop", Models: []string{"mock-echo"}, New: func(ai.Provider) ai.AIModel {
+		return stopAfterFirstChunk{MockModel: model, stop: func() {
+			if w := doJSON(r, http.MethodPost, stopPath, nil); w.Code != http.StatusOK {
+				t.Errorf("stop status = %d: %s", w.Code, w.Body.String())
+			}
+		}}
+	}})
+	w := doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{SessionID: session.ID, Message: "你好", AIConfigID: aiConfig.ID})
+
+	events := parseSSE(t, w.Body.String())
+	done := events[len(events)-1].Data
+	if done["done"] != true || done["interrupted"] != true || done["message_id"] == nil {
+		t.Fatalf("last event = %v, want an interrupted done event", done)
+	}
+
+	var message models.ChatMessage
+	if err := cc.DB.First(&message, uint(done["message_id"].(float64))).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !message.Interrupted || message.Content != "这是" {
+		t.Errorf("saved message = %+v", message)
+	}
+}
+
+func TestChatControllerResumeStream(t *testing.T) {
+	model := ai.NewMockModel(ai.ChatMessage{Content: "可以续传的回复"})
+	model.ChunkSize = 2
+	r, cc, aiConfig := newTestRouter(t, "mock-controller-resume", model)
+
+	w := doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{Message: "你好", AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Body.String(), "id: 1\nevent: session\n") {
+		t.Fatalf("body does not start with the session event: %q", w.Body.String())
+	}
+	events := parseSSE(t, w.Body.String())
+	sessionID := uint(events[0].Data["session_id"].(float64))
+	path := "/chat/sessions/" + strconv.Itoa(int(sessionID)) + "/stream"
+
+	// 模拟收到前三个事件后断线，通过Last-Event-ID续传剩余事件
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Last-Event-ID", "3")
+	resumed := httptest.NewRecorder()
+	r.ServeHTTP(resumed, req)
+	if resumed.Code != http.StatusOK {
+		t.Fatalf("resume status = %d, body = %s", resumed.Code, resumed.Body.String())
+	}
+	if !strings.HasPrefix(resumed.Body.String(), "id: 4\n") {
+		t.Errorf("resumed body = %q", resumed.Body.String())
+	}
+	remaining := parseSSE(t, resumed.Body.String())
+	if len(remaining) != len(events)-3 {
+		t.Fatalf("resumed %d events, want %d", len(remaining), len(events)-3)
+	}
+	for i, event := range remaining {
+		if event.Data["content"] != events[i+3].Data["content"] || event.Data["done"] != events[i+3].Data["done"] {
+			t.Errorf("resumed[%d] = %v, want %v", i, event.Data, events[i+3].Data)
+		}
+	}
+
+	// 查询参数与请求头等价
+	w = doJSON(r, http.MethodGet, path+"?last_event_id="+strconv.Itoa(len(events)), nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("resume after last event: status = %d, body = %q", w.Code, w.Body.String())
+	}
+
+	if w := doJSON(r, http.MethodGet, path+"?last_event_id=abc", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid Last-Event-ID status = %d", w.Code)
+	}
+
+	// 从未生成过回复的会话没有可续传的流
+	idle := models.ChatSession{UserID: testUserID, Title: "空会话"}
+	idle.ID = 9999
+	if err := cc.DB.Create(&idle).Error; err != nil {
+		t.Fatal(err)
+	}
+	if w := doJSON(r, http.MethodGet, "/chat/sessions/9999/stream", nil); w.Code != http.StatusNotFound {
+		t.Errorf("idle session status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChatControllerOverlappingStreams(t *testing.T) {
+	model := ai.NewMockModel(ai.ChatMessage{Content: "第一次生成的回复"})
+	model.ChunkSize = 2
+	model.Latency = 20 * time.Millisecond
+	r, cc, aiConfig := newTestRouter(t, "mock-controller-overlap", model)
+	session, _ := cc.AIService.GetOrCreateSession(testUserID, 0, "你好")
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{Message: "你好", SessionID: session.ID, AIConfigID: aiConfig.ID})
+	}()
+	for len(model.Requests()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 第一次生成尚未结束时，同一会话的第二次流式请求被拒绝
+	w := doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{Message: "再见", SessionID: session.ID, AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusConflict {
+		t.Errorf("overlapping stream status = %d, body = %s", w.Code, w.Body.String())
+	}
+	// 非流式请求同样被拒绝
+	w = doJSON(r, http.MethodPost, "/chat/completions", ChatRequest{Message: "再见", SessionID: session.ID, AIConfigID: aiConfig.ID})
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"error_type":"busy"`) {
+		t.Errorf("overlapping chat status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 第一次生成的事件不受影响
+	var content string
+	for _, event := range parseSSE(t, (<-first).Body.String()) {
+		if c, ok := event.Data["content"].(string); ok {
+			content += c
+		}
+	}
+	if content != "第一次生成的回复" {
+		t.Errorf("first stream content = %q", content)
+	}
+
+	// 生成结束后可以再次开始
+	if w := doJSON(r, http.MethodPost, "/chat/stream", ChatRequest{Message: "再见", SessionID: session.ID, AIConfigID: aiConfig.ID}); w.Code != http.StatusOK {
+		t.Errorf("stream after finish status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var count int64
+	cc.DB.Model(&models.ChatMessage{}).Where("session_id = ?", session.ID).Count(&count)
+	if count != 4 {
+		t.Errorf("messages = %d, want 4", count)
+	}
+}
+
+func TestChatControllerCompareChat(t *testing.T) {
+	r, cc, first := newTestRouter(t, "mock-controller-compare-a", ai.NewMockModel(ai.ChatMessage{Content: "回答A"}))
+	ai.RegisterProvider(ai.Provider{Name: "mock-controller-compare-b", Models: []string{"mock-echo"}, New: func(ai.Provider) ai.AIModel {
+		return ai.NewMockModel(ai.ChatMessage{Content: "回答B", ReasoningContent: "思考B"})
+	}})
+	second := models.AIConfig{UserID: testUserID, Provider: "mock-controller-compare-b", ModelName: "mock-echo"}
+	if err := cc.DB.Create(&second).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	w := doJSON(r, http.MethodPost, "/chat/compare", CompareRequest{Message: "你好", AIConfigIDs: []uint{first.ID, second.ID, first.ID}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 数据块按配置区分，每个配置各有一个answer事件
+	contents := make(map[float64]string)
+	answers := make(map[float64]map[string]interface{})
+	var reasoning string
+	var done map[string]interface{}
+	for _, event := range parseSSE(t, w.Body.String()) {
+		switch {
+		case event.Event == "session":
+		case event.Event == "reasoning":
+			if event.Data["ai_config_id"] != float64(second.ID) {
+				t.Errorf("reasoning event = %v", event.Data)
+			}
+			reasoning += event.Data["content"].(string)
+		case event.Event == "answer":
+			answers[event.Data["ai_config_id"].(float64)] = event.Data
+		case event.Data["done"] == true:
+			done = event.Data
+		default:
+			contents[event.Data["ai_config_id"].(float64)] += event.Data["content"].(string)
+		}
+	}
+	if contents[float64(first.ID)] != "回答A" || contents[float64(second.ID)] != "回答B" || reasoning != "思考B" {
+		t.Errorf("contents = %v, reasoning = %q", contents, reasoning)
+	}
+	if done == nil || done["comparison_id"] == nil || done["comparison_id"] == "" {
+		t.Fatalf("done event = %v", done)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("answers = %v", answers)
+	}
+	winner := answers[float64(second.ID)]
+	if winner["message_id"] == nil || winner["finish_reason"] != "stop" || winner["provider"] != "mock-controller-compare-b" {
+		t.Errorf("answer event = %v", winner)
+	}
+
+	// 候选回答在消息列表中带有对比标记
+	sessionPath := "/chat/sessions/" + strconv.Itoa(int(done["session_id"].(float64)))
+	w = doJSON(r, http.MethodGet, sessionPath, nil)
+	var history struct {
+		Data struct {
+			Messages []ChatResponse `json:"messages"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	candidates := 0
+	for _, msg := range history.Data.Messages {
+		if msg.Candidate && msg.ComparisonID == done["comparison_id"] {
+			candidates++
+		}
+	}
+	if candidates != 2 {
+		t.Errorf("messages = %+v", history.Data.Messages)
+	}
+
+	messageID := strconv.Itoa(int(winner["message_id"].(float64)))
+	w = doJSON(r, http.MethodPost, sessionPath+"/messages/"+messageID+"/pick", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("pick status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, http.MethodPost, sessionPath+"/messages/"+messageID+"/pick", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("second pick status = %d", w.Code)
+	}
+
+	w = doJSON(r, http.MethodGet, sessionPath, nil)
+	history.Data.Messages = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Data.Messages) != 2 || history.Data.Messages[1].Content != "回答B" || history.Data.Messages[1].Candidate {
+		t.Errorf("messages after pick = %+v", history.Data.Messages)
+	}
+
+	// 配置数量不足时直接返回错误
+	w = doJSON(r, http.MethodPost, "/chat/compare", CompareRequest{Message: "你好", AIConfigIDs: []uint{first.ID, first.ID}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("single config status = %d", w.Code)
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 多模型对比请求结构体
+type CompareRequest struct {
+	SessionID    uint   `json:"session_id"`
+	Message      string `json:"message" binding:"required"`
+	AIConfigIDs  []uint `json:"ai_config_ids" binding:"required"` // 参与对比的AI配置，2到4个
+	KnowledgeIDs []uint `json:"knowledge_ids"`
+	// 切换会话使用的角色，0表示沿用会话当前的角色
+	PersonaID uint `json:"persona_id"`
+}
+
+// CompareChat 将同一条消息同时发送给多个AI配置，各配置的回答合并在一个SSE流中返回
+func (cc *ChatController) CompareChat(c *gin.Context) {
+	var req CompareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 获取参与对比的AI配置，重复的配置只保留一个
+	var configs []models.AIConfig
+	seen := make(map[uint]bool)
+	for _, id := range req.AIConfigIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		config, err := cc.getAIConfig(id, userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		configs = append(configs, config)
+	}
+	if len(configs) < ai.MinCompareConfigs || len(configs) > ai.MaxCompareConfigs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("对比需要%d到%d个不同的AI配置", ai.MinCompareConfigs, ai.MaxCompareConfigs)})
+		return
+	}
+
+	// 配额用完时直接返回402/429，不创建会话
+	if err := cc.AIService.CheckQuota(userID.(uint)); err != nil {
+		writeAIError(c, "对比失败: ", err)
+		return
+	}
+
+	// 先获取或创建会话，客户端可以据此在生成过程中停止生成
+	session, err := cc.AIService.GetOrCreateSession(userID.(uint), req.SessionID, req.Message)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PersonaID > 0 {
+		if err := cc.AIService.SetSessionPersona(session, req.PersonaID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// 与流式聊天一样缓存事件，断线后可以通过续传接口继续接收
+	stream, err := cc.AIService.OpenStream(c.Request.Context(), session.ID)
+	if errors.Is(err, ai.ErrStreamBusy) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "error_type": aiErrorType(err)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stream.Emit("session", gin.H{"session_id": session.ID})
+
+	ctx, cancel := stream.Detach()
+	go func() {
+		defer cancel()
+		defer stream.Close()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("会话%d对比生成异常: %v", session.ID, r)
+				stream.Emit("", gin.H{"error": "AI服务调用失败", "error_type": "internal", "status": http.StatusInternalServerError, "done": true})
+			}
+		}()
+		cc.compareGeneration(ctx, stream, userID.(uint), session.ID, req, configs)
+	}()
+
+	cc.followStream(c, session.ID, userID.(uint), 0)
+}
+
+// compareGeneration 执行对比生成，各配置的数据块带上ai_config_id和index写入同一个流
+// 每个配置结束时发送answer事件，全部结束后发送done事件
+func (cc *ChatController) compareGeneration(ctx context.Context, stream *ai.StreamWriter, userID, sessionID uint, req CompareRequest, configs []models.AIConfig) {
+	finishReasons := make([]string, len(configs))
+
+	callback := func(index int, chunk *ai.ChatCompletionChunk) {
+		if len(chunk.Choices) == 0 {
+			return
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReasons[index] = choice.FinishReason
+		}
+
+		// 思维链内容以单独的reasoning事件发送
+		if choice.Delta.ReasoningContent != "" {
+			stream.Emit("reasoning", gin.H{
+				"ai_config_id": configs[index].ID,
+				"index":        index,
+				"content":      choice.Delta.ReasoningContent,
+				"done":         false,
+			})
+		}
+		if choice.Delta.Content == "" && choice.FinishReason == "" {
+			return
+		}
+
+		stream.Emit("", gin.H{
+			"ai_config_id":  configs[index].ID,
+			"index":         index,
+			"content":       choice.Delta.Content,
+			"finish_reason": choice.FinishReason,
+			"done":          false,
+		})
+	}
+
+	// 单个配置失败不影响其他配置，结果以answer事件发送
+	done := func(answer ai.ComparisonAnswer) {
+		event := gin.H{
+			"ai_config_id": answer.AIConfig.ID,
+			"index":        answer.Index,
+			"provider":     answer.AIConfig.Provider,
+			"model_name":   answer.AIConfig.ModelName,
+		}
+		if answer.Message != nil {
+			event["message_id"] = answer.Message.ID
+			event["finish_reason"] = finishReasons[answer.Index]
+			if answer.Message.Interrupted {
+				event["interrupted"] = true
+				event["finish_reason"] = "stopped"
+			}
+		} else if answer.Err != nil {
+			if errors.Is(answer.Err, ai.ErrGenerationStopped) {
+				event["interrupted"] = true
+				event["finish_reason"] = "stopped"
+			} else {
+				event["error"] = "AI服务调用失败: " + answer.Err.Error()
+				event["error_type"] = aiErrorType(answer.Err)
+			}
+		}
+		stream.Emit("answer", event)
+	}
+
+	comparisonID, _, err := cc.AIService.CompareChat(ctx, userID, sessionID, req.Message, configs, req.KnowledgeIDs, callback, done)
+	if err != nil {
+		stream.Emit("", gin.H{
+			"error":      "AI服务调用失败: " + err.Error(),
+			"error_type": aiErrorType(err),
+			"status":     ai.HTTPStatus(err),
+			"done":       true,
+		})
+		return
+	}
+
+	stream.Emit("", gin.H{
+		"id":            "done",
+		"content":       "",
+		"done":          true,
+		"session_id":    sessionID,
+		"comparison_id": comparisonID,
+	})
+}
+
+// PickComparisonWinner 选择对比中的胜者保留在会话历史中，其他候选回答被删除
+func (cc *ChatController) PickComparisonWinner(c *gin.Context) {
+	// 获取会话ID和消息ID
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的消息ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	message, err := cc.AIService.PickComparisonWinner(uint(sessionID), uint(messageID), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "选择回答成功",
+		"data": ChatResponse{
+			ID:               message.ID,
+			Role:             message.Role,
+			Content:          message.Content,
+			ReasoningContent: message.ReasoningContent,
+			Provider:         message.Provider,
+			ModelName:        message.ModelName,
+			ComparisonID:     message.ComparisonID,
+			AIConfigID:       message.AIConfigID,
+			CreatedAt:        message.CreatedAt.Format(time.RFC3339),
+		},
+	})
+}
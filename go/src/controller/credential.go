@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"Deepseek-Go/utils/ai"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 用户API密钥控制器
+type CredentialController struct {
+	DB        *gorm.DB
+	AIService *ai.AIService
+}
+
+// API密钥创建请求
+type CredentialCreateRequest struct {
+	Provider string `json:"provider" binding:"required"`
+	Name     string `json:"name"`
+	APIKey   string `json:"api_key" binding:"required"`
+}
+
+// 构造函数
+func NewCredentialController(db *gorm.DB) *CredentialController {
+	return &CredentialController{
+		DB:        db,
+		AIService: ai.NewAIService(db),
+	}
+}
+
+// CreateCredential 保存用户的API密钥，响应中只返回密钥掩码
+func (cc *CredentialController) CreateCredential(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 解析请求体
+	var req CredentialCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的参数: " + err.Error()})
+		return
+	}
+
+	// 校验提供商是否已注册
+	if !ai.HasProvider(req.Provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的AI提供商: " + req.Provider})
+		return
+	}
+
+	credential, err := cc.AIService.CreateCredential(userID.(uint), req.Provider, req.Name, req.APIKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "保存API密钥成功",
+		"data":    credential,
+	})
+}
+
+// GetCredentials 获取用户的所有API密钥
+func (cc *CredentialController) GetCredentials(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	credentials, err := cc.AIService.GetCredentials(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取API密钥列表失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取API密钥列表成功",
+		"data":    credentials,
+	})
+}
+
+// DeleteCredential 删除API密钥
+func (cc *CredentialController) DeleteCredential(c *gin.Context) {
+	// 获取密钥ID
+	credentialID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的密钥ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	if err := cc.AIService.DeleteCredential(uint(credentialID), userID.(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除API密钥成功",
+	})
+}
+
+// TestCredential 使用API密钥向提供商发起一次最小请求，返回测试结果及错误类型
+func (cc *CredentialController) TestCredential(c *gin.Context) {
+	// 获取密钥ID
+	credentialID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的密钥ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	result, err := cc.AIService.TestCredential(uint(credentialID), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 密钥无效属于测试结果，同样返回200
+	c.JSON(http.StatusOK, gin.H{
+		"message": "测试API密钥完成",
+		"data":    result,
+	})
+}
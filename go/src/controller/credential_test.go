@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/utils/ai"
+	"Deepseek-Go/utils/ai/aitest"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCredentialEndpoints(t *testing.T) {
+	previous := config.Config.AI.MasterKey
+	config.Config.AI.MasterKey = "test-master-key"
+	t.Cleanup(func() { config.Config.AI.MasterKey = previous })
+
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-user-secret"
+	ai.RegisterProvider(ai.Provider{Name: "credential-test", BaseURL: server.URL})
+
+	gin.SetMode(gin.TestMode)
+	cc := NewCredentialController(aitest.NewDB(t))
+	r := gin.New()
+	group := r.Group("/credentials", func(c *gin.Context) { c.Set("userID", testUserID) })
+	group.POST("/", cc.CreateCredential)
+	group.GET("/", cc.GetCredentials)
+	group.DELETE("/:id", cc.DeleteCredential)
+	group.POST("/:id/test", cc.TestCredential)
+
+	w := doJSON(r, http.MethodPost, "/credentials/", gin.H{"provider": "credential-test", "name": "个人", "api_key": "sk-user-secret"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "sk-user-secret") {
+		t.Errorf("response leaks the api key: %s", w.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID      uint   `json:"ID"`
+			KeyHint string `json:"key_hint"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Data.KeyHint != "sk-...cret" {
+		t.Errorf("key_hint = %q", created.Data.KeyHint)
+	}
+
+	w = doJSON(r, http.MethodGet, "/credentials/", nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "sk-user-secret") {
+		t.Errorf("list status = %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, http.MethodPost, "/credentials/"+strconv.Itoa(int(created.Data.ID))+"/test", nil)
+	var tested struct {
+		Data ai.KeyTestResult `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &tested)
+	if w.Code != http.StatusOK || !tested.Data.OK {
+		t.Errorf("test status = %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, http.MethodDelete, "/credentials/"+strconv.Itoa(int(created.Data.ID)), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("delete status = %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, http.MethodPost, "/credentials/", gin.H{"provider": "unknown", "api_key": "sk-x"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown provider status = %d", w.Code)
+	}
+}
@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OpenAI兼容网关控制器
+type GatewayController struct {
+	DB        *gorm.DB
+	AIService *ai.AIService
+}
+
+// 访问令牌创建请求
+type AccessTokenCreateRequest struct {
+	Name string `json:"name"`
+}
+
+// gatewayResponse OpenAI格式的非流式响应
+type gatewayResponse struct {
+	ID      string          `json:"id"`
+	Object  string          `json:"object"`
+	Created int64           `json:"created"`
+	Model   string          `json:"model"`
+	Choices []gatewayChoice `json:"choices"`
+	Usage   ai.Usage        `json:"usage"`
+}
+
+// gatewayChoice OpenAI格式的候选回复
+type gatewayChoice struct {
+	Index        int            `json:"index"`
+	Message      gatewayMessage `json:"message"`
+	FinishReason string         `json:"finish_reason"`
+}
+
+// gatewayMessage OpenAI格式的回复消息，只有工具调用时content为null
+type gatewayMessage struct {
+	Role             string        `json:"role"`
+	Content          *string       `json:"content"`
+	ToolCalls        []ai.ToolCall `json:"tool_calls,omitempty"`
+	ReasoningContent string        `json:"reasoning_content,omitempty"`
+}
+
+// newGatewayResponse 将模型的响应转换为OpenAI格式
+func newGatewayResponse(response *ai.ChatCompletionResponse) gatewayResponse {
+	out := gatewayResponse{
+		ID:      response.ID,
+		Object:  response.Object,
+		Created: response.Created,
+		Model:   response.Model,
+		Choices: make([]gatewayChoice, 0, len(response.Choices)),
+		Usage:   response.Usage,
+	}
+	for _, choice := range response.Choices {
+		message := gatewayMessage{
+			Role:             choice.Message.Role,
+			ToolCalls:        choice.Message.ToolCalls,
+			ReasoningContent: choice.Message.ReasoningContent,
+		}
+		if choice.Message.Content != "" || len(choice.Message.ToolCalls) == 0 {
+			content := choice.Message.Content
+			message.Content = &content
+		}
+		out.Choices = append(out.Choices, gatewayChoice{Index: choice.Index, Message: message, FinishReason: choice.FinishReason})
+	}
+	return out
+}
+
+// gatewayChunk OpenAI格式的流式数据块，未结束时finish_reason为null
+type gatewayChunk struct {
+	ID      string               `json:"id"`
+	Object  string               `json:"object"`
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Choices []gatewayChunkChoice `json:"choices"`
+	Usage   *ai.Usage            `json:"usage,omitempty"`
+}
+
+// gatewayChunkChoice OpenAI格式的流式候选回复
+type gatewayChunkChoice struct {
+	Index        int          `json:"index"`
+	Delta        ai.ChatDelta `json:"delta"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+// 构造函数
+func NewGatewayController(db *gorm.DB) *GatewayController {
+	return &GatewayController{
+		DB:        db,
+		AIService: ai.NewAIService(db),
+	}
+}
+
+// gatewayError 按OpenAI的错误格式返回错误
+func gatewayError(c *gin.Context, status int, errType, code, message string) {
+	c.JSON(status, gatewayErrorBody(errType, code, message))
+}
+
+// gatewayErrorBody 构造OpenAI格式的错误内容，没有错误码时code为null
+func gatewayErrorBody(errType, code, message string) gin.H {
+	var codeValue interface{}
+	if code != "" {
+		codeValue = code
+	}
+	return gin.H{"error": gin.H{
+		"message": message,
+		"type":    errType,
+		"param":   nil,
+		"code":    codeValue,
+	}}
+}
+
+// gatewayErrorKind 将提供商错误转换为HTTP状态码以及OpenAI格式的错误类型和错误码
+func gatewayErrorKind(err error) (int, string, string) {
+	status := ai.HTTPStatus(err)
+	if _, ok := ai.AsQuotaError(err); ok {
+		return status, "insufficient_quota", "insufficient_quota"
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		return status, "rate_limit_error", "rate_limit_exceeded"
+	case http.StatusBadRequest:
+		code := ""
+		if providerErr, ok := ai.AsProviderError(err); ok {
+			switch providerErr.Kind {
+			case ai.ErrKindContextLength:
+				code = "context_length_exceeded"
+			case ai.ErrKindAuth:
+				code = "invalid_api_key"
+			}
+		}
+		return status, "invalid_request_error", code
+	case http.StatusPaymentRequired:
+		return status, "insufficient_quota", "insufficient_quota"
+	default:
+		return status, "api_error", ""
+	}
+}
+
+// ListModels 以OpenAI格式列出用户的AI配置，GET /v1/models
+func (gc *GatewayController) ListModels(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		gatewayError(c, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "未授权的访问")
+		return
+	}
+
+	list, err := gc.AIService.GetGatewayModels(userID.(uint))
+	if err != nil {
+		gatewayError(c, http.StatusInternalServerError, "api_error", "", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   list,
+	})
+}
+
+// ChatCompletions OpenAI兼容的聊天接口，POST /v1/chat/completions
+// model为 /v1/models 返回的模型ID；请求头X-Session-Log为true时将本轮问答记录到新会话，
+// X-Session-ID指定记录到已有会话，记录的会话ID通过响应头X-Session-ID返回
+func (gc *GatewayController) ChatCompletions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		gatewayError(c, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "未授权的访问")
+		return
+	}
+
+	var req ai.GatewayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		gatewayError(c, http.StatusBadRequest, "invalid_request_error", "", "无效的参数: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		gatewayError(c, http.StatusBadRequest, "invalid_request_error", "", "messages不能为空")
+		return
+	}
+
+	aiConfig, err := gc.AIService.ResolveGatewayModel(userID.(uint), req.Model)
+	if err != nil {
+		if errors.Is(err, ai.ErrGatewayModelNotFound) {
+			gatewayError(c, http.StatusNotFound, "invalid_request_error", "model_not_found", err.Error())
+			return
+		}
+		gatewayError(c, http.StatusInternalServerError, "api_error", "", err.Error())
+		return
+	}
+
+	// 按请求头决定是否记录到会话
+	var sessionID uint64
+	if value := c.GetHeader("X-Session-ID"); value != "" {
+		sessionID, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			gatewayError(c, http.StatusBadRequest, "invalid_request_error", "", "无效的会话ID")
+			return
+		}
+	}
+	logSession, _ := strconv.ParseBool(c.GetHeader("X-Session-Log"))
+	var session *models.ChatSession
+	if sessionID > 0 || logSession {
+		session, err = gc.AIService.OpenGatewaySession(userID.(uint), uint(sessionID), req)
+		if err != nil {
+			gatewayError(c, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+			return
+		}
+		c.Header("X-Session-ID", strconv.Itoa(int(session.ID)))
+	}
+
+	if req.Stream {
+		gc.streamCompletions(c, *aiConfig, req, session)
+		return
+	}
+
+	response, err := gc.AIService.GatewayChat(c.Request.Context(), *aiConfig, req, session)
+	if err != nil {
+		status, errType, code := gatewayErrorKind(err)
+		gatewayError(c, status, errType, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, newGatewayResponse(response))
+}
+
+// streamCompletions 以OpenAI的SSE格式输出流式回复，以 data: [DONE] 结束
+// 只有请求开启stream_options.include_usage时才输出用量数据块
+func (gc *GatewayController) streamCompletions(c *gin.Context, aiConfig models.AIConfig, req ai.GatewayRequest, session *models.ChatSession) {
+	// 切换备用配置时数据块的ID可能变化，统一使用同一个ID
+	id := "chatcmpl-" + uuid.NewString()
+	created := time.Now().Unix()
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	started := false
+
+	// 第一次输出时才写入SSE响应头，开始输出前出错仍可返回JSON格式的错误
+	writeData := func(data interface{}) {
+		if !started {
+			started = true
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Header("X-Accel-Buffering", "no")
+			c.Status(http.StatusOK)
+		}
+		payload, _ := json.Marshal(data)
+		c.Writer.Write([]byte("data: "))
+		c.Writer.Write(payload)
+		c.Writer.Write([]byte("\n\n"))
+		c.Writer.Flush()
+	}
+
+	err := gc.AIService.GatewayStream(c.Request.Context(), aiConfig, req, session, func(chunk *ai.ChatCompletionChunk) {
+		if len(chunk.Choices) == 0 && (chunk.Usage == nil || !includeUsage) {
+			return
+		}
+
+		out := gatewayChunk{ID: id, Object: "chat.completion.chunk", Created: created, Model: chunk.Model, Choices: []gatewayChunkChoice{}}
+		if out.Model == "" {
+			out.Model = aiConfig.ModelName
+		}
+		for _, choice := range chunk.Choices {
+			converted := gatewayChunkChoice{Index: choice.Index, Delta: choice.Delta}
+			if choice.FinishReason != "" {
+				finishReason := choice.FinishReason
+				converted.FinishReason = &finishReason
+			}
+			out.Choices = append(out.Choices, converted)
+		}
+		if includeUsage {
+			out.Usage = chunk.Usage
+		}
+
+		writeData(out)
+	})
+
+	if err != nil {
+		status, errType, code := gatewayErrorKind(err)
+		if !started {
+			gatewayError(c, status, errType, code, err.Error())
+			return
+		}
+		// 已开始输出时按OpenAI的方式在流中返回错误
+		writeData(gatewayErrorBody(errType, code, err.Error()))
+	}
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+// CreateAccessToken 创建访问令牌，明文令牌只在本次响应中返回
+func (gc *GatewayController) CreateAccessToken(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	var req AccessTokenCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的参数: " + err.Error()})
+		return
+	}
+
+	accessToken, token, err := gc.AIService.CreateAccessToken(userID.(uint), req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "创建访问令牌成功，令牌只显示一次，请妥善保存",
+		"data": gin.H{
+			"token":        token,
+			"access_token": accessToken,
+		},
+	})
+}
+
+// GetAccessTokens 获取用户的所有访问令牌，只返回令牌掩码
+func (gc *GatewayController) GetAccessTokens(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	tokens, err := gc.AIService.GetAccessTokens(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取访问令牌列表失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取访问令牌列表成功",
+		"data":    tokens,
+	})
+}
+
+// DeleteAccessToken 吊销访问令牌
+func (gc *GatewayController) DeleteAccessToken(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的令牌ID"})
+		return
+	}
+
+	if err := gc.AIService.DeleteAccessToken(uint(tokenID), userID.(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除访问令牌成功",
+	})
+}
@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"Deepseek-Go/utils/auth"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newGatewayRouter 创建注册了OpenAI兼容接口和访问令牌接口的测试路由
+func newGatewayRouter(t *testing.T, provider string, model *ai.MockModel) (*gin.Engine, *GatewayController, models.AIConfig) {
+	t.Helper()
+	_, cc, aiConfig := newTestRouter(t, provider, model)
+	gc := &GatewayController{DB: cc.DB, AIService: cc.AIService}
+
+	r := gin.New()
+	setUser := func(c *gin.Context) { c.Set("userID", testUserID) }
+	gateway := r.Group("/v1", setUser)
+	gateway.GET("/models", gc.ListModels)
+	gateway.POST("/chat/completions", gc.ChatCompletions)
+	tokens := r.Group("/tokens", setUser)
+	tokens.POST("/", gc.CreateAccessToken)
+	tokens.GET("/", gc.GetAccessTokens)
+	tokens.DELETE("/:id", gc.DeleteAccessToken)
+
+	return r, gc, aiConfig
+}
+
+// doGateway 发送带请求头的OpenAI兼容请求
+func doGateway(r http.Handler, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGatewayChatCompletions(t *testing.T) {
+	r, gc, _ := newGatewayRouter(t, "mock-controller-gateway", ai.NewMockModel())
+
+	w := doJSON(r, http.MethodGet, "/v1/models", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"object":"list"`) || !strings.Contains(w.Body.String(), `"id":"mock-echo"`) {
+		t.Fatalf("models status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w = doGateway(r, gin.H{"model": "mock-echo", "messages": []gin.H{{"role": "user", "content": "你好"}}}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("completion status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var response ai.ChatCompletionResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Object != "chat.completion" || len(response.Choices) != 1 || response.Choices[0].Message.Content != "echo: 你好" || response.Choices[0].FinishReason != "stop" {
+		t.Errorf("response = %s", w.Body.String())
+	}
+	if w.Header().Get("X-Session-ID") != "" {
+		t.Error("session created without X-Session-Log")
+	}
+
+	// 模型不存在时按OpenAI格式返回错误
+	w = doGateway(r, gin.H{"model": "gpt-4o", "messages": []gin.H{{"role": "user", "content": "你好"}}}, nil)
+	var errBody struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &errBody)
+	if w.Code != http.StatusNotFound || errBody.Error.Code != "model_not_found" || errBody.Error.Type != "invalid_request_error" {
+		t.Errorf("unknown model status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if w := doGateway(r, gin.H{"model": "mock-echo", "messages": []gin.H{}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("empty messages status = %d", w.Code)
+	}
+
+	// 开启记录时本轮问答保存到会话
+	w = doGateway(r, gin.H{"model": "mock-echo", "messages": []gin.H{{"role": "user", "content": "记录我"}}}, map[string]string{"X-Session-Log": "true"})
+	sessionID := w.Header().Get("X-Session-ID")
+	if w.Code != http.StatusOK || sessionID == "" {
+		t.Fatalf("logged completion status = %d, session = %q", w.Code, sessionID)
+	}
+	w = doGateway(r, gin.H{"model": "mock-echo", "messages": []gin.H{{"role": "user", "content": "第二轮"}}}, map[string]string{"X-Session-ID": sessionID})
+	if w.Code != http.StatusOK || w.Header().Get("X-Session-ID") != sessionID {
+		t.Fatalf("second completion status = %d, session = %q", w.Code, w.Header().Get("X-Session-ID"))
+	}
+	var messages []models.ChatMessage
+	gc.DB.Where("session_id = ?", sessionID).Order("id").Find(&messages)
+	if len(messages) != 4 || messages[0].Content != "记录我" || messages[3].Content != "echo: 第二轮" {
+		t.Errorf("logged messages = %+v", messages)
+	}
+}
+
+func TestGatewayToolCallResponse(t *testing.T) {
+	model := ai.NewMockModel(ai.ChatMessage{ToolCalls: []ai.ToolCall{{
+		ID:       "call_1",
+		Type:     "function",
+		Function: ai.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"北京"}`},
+	}}})
+	r, _, _ := newGatewayRouter(t, "mock-controller-gateway-tools", model)
+
+	tools := []gin.H{{"type": "function", "function": gin.H{"name": "get_weather", "parameters": gin.H{"type": "object"}}}}
+	w := doGateway(r, gin.H{"model": "mock-echo", "tools": tools, "messages": []gin.H{{"role": "user", "content": "北京天气"}}}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 只有工具调用的回复与OpenAI一致，content为null而不是空字符串
+	var response struct {
+		Choices []struct {
+			Message      map[string]json.RawMessage `json:"message"`
+			FinishReason string                     `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Choices) != 1 || response.Choices[0].FinishReason != "tool_calls" {
+		t.Fatalf("response = %s", w.Body.String())
+	}
+	message := response.Choices[0].Message
+	if content, ok := message["content"]; !ok || string(content) != "null" {
+		t.Errorf("content = %s, want null", content)
+	}
+	if !strings.Contains(string(message["tool_calls"]), `"get_weather"`) {
+		t.Errorf("tool_calls = %s", message["tool_calls"])
+	}
+
+	// 普通回复的content仍为字符串
+	w = doGateway(r, gin.H{"model": "mock-echo", "messages": []gin.H{{"role": "user", "content": "你好"}}}, nil)
+	if !strings.Contains(w.Body.String(), `"content":"echo: 你好"`) || strings.Contains(w.Body.String(), `"tool_calls"`) {
+		t.Errorf("text response = %s", w.Body.String())
+	}
+}
+
+func TestGatewayStreamFormat(t *testing.T) {
+	r, _, _ := newGatewayRouter(t, "mock-controller-gateway-stream", ai.NewMockModel())
+
+	for _, includeUsage := range []bool{false, true} {
+		body := gin.H{"model": "mock-echo", "stream": true, "messages": []gin.H{{"role": "user", "content": "流式输出"}}}
+		if includeUsage {
+			body["stream_options"] = gin.H{"include_usage": true}
+		}
+		w := doGateway(r, body, nil)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+			t.Fatalf("stream status = %d, headers = %v", w.Code, w.Header())
+		}
+
+		// 每个事件只有data行，以 data: [DONE] 结束
+		events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+		if events[len(events)-1] != "data: [DONE]" {
+			t.Fatalf("last event = %q", events[len(events)-1])
+		}
+		var content, id string
+		var finishReasons []interface{}
+		var usage map[string]interface{}
+		for _, event := range events[:len(events)-1] {
+			var chunk map[string]interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+				t.Fatalf("invalid event %q: %v", event, err)
+			}
+			if chunk["object"] != "chat.completion.chunk" || (id != "" && chunk["id"] != id) {
+				t.Errorf("chunk = %v", chunk)
+			}
+			id, _ = chunk["id"].(string)
+			if u, ok := chunk["usage"].(map[string]interface{}); ok {
+				usage = u
+			}
+			for _, choice := range chunk["choices"].([]interface{}) {
+				choice := choice.(map[string]interface{})
+				delta := choice["delta"].(map[string]interface{})
+				text, _ := delta["content"].(string)
+				content += text
+				finishReasons = append(finishReasons, choice["finish_reason"])
+			}
+		}
+
+		if content != "echo: 流式输出" || !strings.HasPrefix(id, "chatcmpl-") {
+			t.Errorf("content = %q, id = %q", content, id)
+		}
+		// 未结束的数据块finish_reason为null，最后一个为stop
+		if finishReasons[0] != nil || finishReasons[len(finishReasons)-1] != "stop" {
+			t.Errorf("finish reasons = %v", finishReasons)
+		}
+		if includeUsage != (usage != nil) {
+			t.Errorf("include_usage = %v, usage = %v", includeUsage, usage)
+		}
+	}
+}
+
+func TestGatewayStreamError(t *testing.T) {
+	model := ai.NewMockModel()
+	model.Err = &ai.ProviderError{Provider: "mock-controller-gateway-error", Kind: ai.ErrKindRateLimit, StatusCode: 429}
+	r, _, _ := newGatewayRouter(t, "mock-controller-gateway-error", model)
+
+	// 开始输出前出错时返回JSON格式的错误
+	w := doGateway(r, gin.H{"model": "mock-echo", "stream": true, "messages": []gin.H{{"role": "user", "content": "你好"}}}, nil)
+	if w.Code != http.StatusTooManyRequests || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || !strings.Contains(w.Body.String(), `"code":"rate_limit_exceeded"`) {
+		t.Errorf("stream error status = %d, headers = %v, body = %s", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestAccessTokenEndpoints(t *testing.T) {
+	r, gc, _ := newGatewayRouter(t, "mock-controller-gateway-token", ai.NewMockModel())
+
+	w := doJSON(r, http.MethodPost, "/tokens/", gin.H{"name": "IDE插件"})
+	var created struct {
+		Data struct {
+			Token       string             `json:"token"`
+			AccessToken models.AccessToken `json:"access_token"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusOK || !strings.HasPrefix(created.Data.Token, auth.AccessTokenPrefix) || created.Data.AccessToken.Name != "IDE插件" {
+		t.Fatalf("create status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 列表中只返回掩码
+	w = doJSON(r, http.MethodGet, "/tokens/", nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Data.Token) || !strings.Contains(w.Body.String(), created.Data.AccessToken.TokenHint) {
+		t.Errorf("list status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	if userID, err := gc.AIService.AuthenticateAccessToken(created.Data.Token); err != nil || userID != testUserID {
+		t.Errorf("AuthenticateAccessToken() = %d, %v", userID, err)
+	}
+	if w := doJSON(r, http.MethodDelete, "/tokens/"+strconv.Itoa(int(created.Data.AccessToken.ID)), nil); w.Code != http.StatusOK {
+		t.Errorf("delete status = %d", w.Code)
+	}
+	if _, err := gc.AIService.AuthenticateAccessToken(created.Data.Token); err == nil {
+		t.Error("revoked token still authenticates")
+	}
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 角色控制器
+type PersonaController struct {
+	DB        *gorm.DB
+	AIService *ai.AIService
+}
+
+// 角色创建和更新请求
+type PersonaRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Description  string `json:"description"`
+	SystemPrompt string `json:"system_prompt" binding:"required"`
+	AIConfigID   uint   `json:"ai_config_id"`  // 默认AI配置ID，0表示使用用户的默认配置
+	KnowledgeIDs []uint `json:"knowledge_ids"` // 默认知识库文件ID
+	Shared       bool   `json:"shared"`        // 是否共享给本实例的其他用户
+}
+
+// toModel 转换为角色模型
+func (r PersonaRequest) toModel() models.Persona {
+	return models.Persona{
+		Name:         r.Name,
+		Description:  r.Description,
+		SystemPrompt: r.SystemPrompt,
+		AIConfigID:   r.AIConfigID,
+		KnowledgeIDs: r.KnowledgeIDs,
+		Shared:       r.Shared,
+	}
+}
+
+// 构造函数
+func NewPersonaController(db *gorm.DB) *PersonaController {
+	return &PersonaController{
+		DB:        db,
+		AIService: ai.NewAIService(db),
+	}
+}
+
+// CreatePersona 创建角色
+func (pc *PersonaController) CreatePersona(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 解析请求体
+	var req PersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的参数: " + err.Error()})
+		return
+	}
+
+	persona, err := pc.AIService.CreatePersona(userID.(uint), req.toModel())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "创建角色成功",
+		"data":    persona,
+	})
+}
+
+// GetPersonas 获取用户创建的角色和共享的角色
+func (pc *PersonaController) GetPersonas(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	personas, err := pc.AIService.GetPersonas(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取角色列表失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取角色列表成功",
+		"data":    personas,
+	})
+}
+
+// GetPersona 获取单个角色
+func (pc *PersonaController) GetPersona(c *gin.Context) {
+	// 获取角色ID
+	personaID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	persona, err := pc.AIService.GetPersona(uint(personaID), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取角色成功",
+		"data":    persona,
+	})
+}
+
+// UpdatePersona 更新角色，只有创建者可以修改
+func (pc *PersonaController) UpdatePersona(c *gin.Context) {
+	// 获取角色ID
+	personaID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 解析请求体
+	var req PersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的参数: " + err.Error()})
+		return
+	}
+
+	persona, err := pc.AIService.UpdatePersona(uint(personaID), userID.(uint), req.toModel())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "更新角色成功",
+		"data":    persona,
+	})
+}
+
+// DeletePersona 删除角色，只有创建者可以删除
+func (pc *PersonaController) DeletePersona(c *gin.Context) {
+	// 获取角色ID
+	personaID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色ID"})
+		return
+	}
+
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	if err := pc.AIService.DeletePersona(uint(personaID), userID.(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除角色成功",
+	})
+}
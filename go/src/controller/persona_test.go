@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPersonaEndpointsAndChat(t *testing.T) {
+	r, cc, _ := newTestRouter(t, "mock-persona-default", ai.NewMockModel())
+	pc := &PersonaController{DB: cc.DB, AIService: cc.AIService}
+	group := r.Group("/personas", func(c *gin.Context) { c.Set("userID", testUserID) })
+	group.POST("/", pc.CreatePersona)
+	group.GET("/", pc.GetPersonas)
+	group.GET("/:id", pc.GetPersona)
+	group.PUT("/:id", pc.UpdatePersona)
+	group.DELETE("/:id", pc.DeletePersona)
+	r.PUT("/chat/sessions/:id", func(c *gin.Context) { c.Set("userID", testUserID) }, cc.UpdateSession)
+
+	// 角色的默认AI配置使用另一个提供商
+	ai.RegisterProvider(ai.Provider{Name: "mock-persona-model", Models: []string{"mock-echo"}, New: func(ai.Provider) ai.AIModel { return ai.NewMockModel() }})
+	personaConfig := models.AIConfig{UserID: testUserID, Provider: "mock-persona-model", ModelName: "mock-echo"}
+	cc.DB.Create(&personaConfig)
+
+	if w := doJSON(r, http.MethodPost, "/personas/", gin.H{"name": "翻译"}); w.Code != http.StatusBadRequest {
+		t.Errorf("create without prompt status = %d", w.Code)
+	}
+	w := doJSON(r, http.MethodPost, "/personas/", PersonaRequest{Name: "翻译", SystemPrompt: "你是翻译", AIConfigID: personaConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data models.Persona `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	personaPath := "/personas/" + strconv.Itoa(int(created.Data.ID))
+
+	if w := doJSON(r, http.MethodGet, "/personas/", nil); w.Code != http.StatusOK {
+		t.Errorf("list status = %d", w.Code)
+	}
+	w = doJSON(r, http.MethodPut, personaPath, PersonaRequest{Name: "英文翻译", SystemPrompt: "你是英文翻译", AIConfigID: personaConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update status = %d: %s", w.Code, w.Body.String())
+	}
+
+	// 指定角色开始对话时使用角色的默认配置，并记住会话的角色
+	w = doJSON(r, http.MethodPost, "/chat/completions", ChatRequest{Message: "你好", PersonaID: created.Data.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("chat status = %d: %s", w.Code, w.Body.String())
+	}
+	var chat struct {
+		Data      ChatResponse `json:"data"`
+		SessionID uint         `json:"session_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &chat)
+	if chat.Data.Provider != "mock-persona-model" {
+		t.Errorf("provider = %q", chat.Data.Provider)
+	}
+
+	w = doJSON(r, http.MethodPost, "/chat/completions", ChatRequest{Message: "再见", SessionID: chat.SessionID})
+	json.Unmarshal(w.Body.Bytes(), &chat)
+	if chat.Data.Provider != "mock-persona-model" {
+		t.Errorf("provider of the second turn = %q", chat.Data.Provider)
+	}
+
+	// 通过更新会话取消角色
+	w = doJSON(r, http.MethodPut, "/chat/sessions/"+strconv.Itoa(int(chat.SessionID)), gin.H{"persona_id": 0})
+	var session struct {
+		Data models.ChatSession `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if w.Code != http.StatusOK || session.Data.PersonaID != 0 {
+		t.Errorf("update session status = %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := doJSON(r, http.MethodPost, "/chat/completions", ChatRequest{Message: "你好", PersonaID: 999}); w.Code != http.StatusBadRequest {
+		t.Errorf("chat with unknown persona status = %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodDelete, personaPath, nil); w.Code != http.StatusOK {
+		t.Errorf("delete status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodGet, personaPath, nil); w.Code != http.StatusNotFound {
+		t.Errorf("get deleted status = %d", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"Deepseek-Go/utils/ai"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 提供商控制器
+type ProviderController struct {
+	DB        *gorm.DB
+	AIService *ai.AIService
+}
+
+// 构造函数
+func NewProviderController(db *gorm.DB) *ProviderController {
+	return &ProviderController{
+		DB:        db,
+		AIService: ai.NewAIService(db),
+	}
+}
+
+// GetProvidersHealth 获取各提供商在本实例上的熔断状态、错误率和延迟百分位数
+func (pc *ProviderController) GetProvidersHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取提供商健康状态成功",
+		"data":    ai.ProvidersHealth(),
+	})
+}
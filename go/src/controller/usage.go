@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"Deepseek-Go/utils/ai"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 默认统计最近30天的用量
+const defaultUsageDays = 30
+
+// 用量统计控制器
+type UsageController struct {
+	DB        *gorm.DB
+	AIService *ai.AIService
+}
+
+// 构造函数
+func NewUsageController(db *gorm.DB) *UsageController {
+	return &UsageController{
+		DB:        db,
+		AIService: ai.NewAIService(db),
+	}
+}
+
+// usageFilter 解析查询参数中的日期范围和模型筛选条件，end默认为今天，start默认为end之前的30天
+func usageFilter(c *gin.Context) ai.UsageFilter {
+	end := c.Query("end")
+	if end == "" {
+		end = time.Now().Format(ai.UsageDateLayout)
+	}
+	start := c.Query("start")
+	if start == "" {
+		if endDate, err := time.ParseInLocation(ai.UsageDateLayout, end, time.Local); err == nil {
+			start = endDate.AddDate(0, 0, 1-defaultUsageDays).Format(ai.UsageDateLayout)
+		}
+	}
+
+	return ai.UsageFilter{
+		Start:     start,
+		End:       end,
+		Provider:  c.Query("provider"),
+		ModelName: c.Query("model"),
+	}
+}
+
+// GetUsageReport 获取日期范围内的用量合计、每日用量和各模型用量
+// 查询参数：start、end为日期(如2025-03-01，包含两端)，provider、model筛选提供商和模型
+func (uc *UsageController) GetUsageReport(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	report, err := uc.AIService.GetUsageReport(userID.(uint), usageFilter(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取用量统计成功",
+		"data":    report,
+	})
+}
+
+// GetSessionUsage 按会话分页获取日期范围内的用量，按总token数降序，查询参数与GetUsageReport相同
+func (uc *UsageController) GetSessionUsage(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	// 获取分页参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+
+	filter := usageFilter(c)
+	sessions, count, err := uc.AIService.GetSessionUsage(userID.(uint), filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取会话用量成功",
+		"data": gin.H{
+			"start":    filter.Start,
+			"end":      filter.End,
+			"total":    count,
+			"page":     page,
+			"pageSize": pageSize,
+			"sessions": sessions,
+		},
+	})
+}
+
+// GetQuotaStatus 获取各项配额当天和当月的用量，没有配置配额时limits为空
+func (uc *UsageController) GetQuotaStatus(c *gin.Context) {
+	// 获取用户ID
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权的访问"})
+		return
+	}
+
+	status, err := uc.AIService.GetQuotaStatus(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取配额成功",
+		"data":    status,
+	})
+}
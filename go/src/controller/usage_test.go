@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUsageEndpoints(t *testing.T) {
+	r, cc, aiConfig := newTestRouter(t, "mock-controller-usage", ai.NewMockModel())
+	uc := &UsageController{DB: cc.DB, AIService: cc.AIService}
+	usage := r.Group("/usage", func(c *gin.Context) { c.Set("userID", testUserID) })
+	usage.GET("/", uc.GetUsageReport)
+	usage.GET("/sessions", uc.GetSessionUsage)
+
+	w := doJSON(r, http.MethodPost, "/chat/completions", gin.H{"message": "你好", "ai_config_id": aiConfig.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("chat status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var chat struct {
+		Data      ChatResponse `json:"data"`
+		SessionID uint         `json:"session_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &chat)
+	if chat.Data.PromptTokens == 0 || chat.Data.CompletionTokens == 0 {
+		t.Errorf("chat response = %s", w.Body.String())
+	}
+
+	// 默认统计包括今天在内的最近30天
+	w = doJSON(r, http.MethodGet, "/usage/", nil)
+	var report struct {
+		Data ai.UsageReport `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	today := time.Now().Format(ai.UsageDateLayout)
+	if w.Code != http.StatusOK || report.Data.End != today || report.Data.Total.Requests != 1 || len(report.Data.Daily) != 1 || report.Data.Models[0].ModelName != "mock-echo" {
+		t.Errorf("report status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if start, _ := time.Parse(ai.UsageDateLayout, report.Data.Start); report.Data.Start == "" || start.AddDate(0, 0, 29).Format(ai.UsageDateLayout) != today {
+		t.Errorf("default start = %q", report.Data.Start)
+	}
+
+	w = doJSON(r, http.MethodGet, "/usage/?start="+today+"&end="+today+"&model=other", nil)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if w.Code != http.StatusOK || report.Data.Total.Requests != 0 {
+		t.Errorf("filtered report = %s", w.Body.String())
+	}
+	if w := doJSON(r, http.MethodGet, "/usage/?start=2025-13-01", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid date status = %d", w.Code)
+	}
+
+	w = doJSON(r, http.MethodGet, "/usage/sessions", nil)
+	var sessions struct {
+		Data struct {
+			Total    int64             `json:"total"`
+			Sessions []ai.SessionUsage `json:"sessions"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &sessions)
+	if w.Code != http.StatusOK || sessions.Data.Total != 1 || sessions.Data.Sessions[0].SessionID != chat.SessionID {
+		t.Errorf("sessions status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	r, gc, aiConfig := newGatewayRouter(t, "mock-controller-quota", ai.NewMockModel())
+	cc := &ChatController{DB: gc.DB, AIService: gc.AIService}
+	uc := &UsageController{DB: gc.DB, AIService: gc.AIService}
+	chat := r.Group("/chat", func(c *gin.Context) { c.Set("userID", testUserID) })
+	chat.POST("/stream", cc.StreamChat)
+	chat.POST("/compare", cc.CompareChat)
+	r.GET("/usage/quota", func(c *gin.Context) { c.Set("userID", testUserID) }, uc.GetQuotaStatus)
+
+	previous := config.Config.AI.Quota
+	config.Config.AI.Quota = config.QuotaConfig{DailyTokens: 100000, DailyCost: 1}
+	t.Cleanup(func() { config.Config.AI.Quota = previous })
+	gc.DB.Create(&models.UsageDaily{UserID: testUserID, Date: time.Now().Format(ai.UsageDateLayout), Provider: "mock-controller-quota", ModelName: "mock-echo", Requests: 1, PromptTokens: 100, Cost: 1.5})
+
+	w := doJSON(r, http.MethodGet, "/usage/quota", nil)
+	var status struct {
+		Data ai.QuotaStatus `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if w.Code != http.StatusOK || len(status.Data.Limits) != 2 || status.Data.Limits[1].Kind != ai.QuotaCost || status.Data.Limits[1].Used != 1.5 {
+		t.Errorf("quota status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 流式聊天和对比在创建会话前拒绝，返回402和恢复时间
+	w = doJSON(r, http.MethodPost, "/chat/stream", gin.H{"message": "你好", "ai_config_id": aiConfig.ID})
+	if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), `"error_type":"quota"`) || w.Header().Get("Retry-After") == "" {
+		t.Errorf("stream status = %d, headers = %v, body = %s", w.Code, w.Header(), w.Body.String())
+	}
+	second := models.AIConfig{UserID: testUserID, Provider: "mock-controller-quota", ModelName: "mock-echo"}
+	gc.DB.Create(&second)
+	w = doJSON(r, http.MethodPost, "/chat/compare", gin.H{"message": "你好", "ai_config_ids": []uint{aiConfig.ID, second.ID}})
+	if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), `"error_type":"quota"`) {
+		t.Errorf("compare status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var sessions int64
+	gc.DB.Model(&models.ChatSession{}).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("sessions = %d", sessions)
+	}
+
+	// OpenAI兼容接口按OpenAI的格式返回配额错误
+	w = doGateway(r, gin.H{"model": "mock-echo", "messages": []gin.H{{"role": "user", "content": "你好"}}}, nil)
+	if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), `"type":"insufficient_quota"`) {
+		t.Errorf("gateway status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
@@ -24,6 +24,16 @@ var (
 		".txt":  true,
 		".md":   true,
 	}
+	// 允许的聊天图片类型及对应的MIME类型
+	AllowedImageTypes = map[string]string{
+		".png":  "image/png",
+		".jpg":  "image/jpeg",
+		".jpeg": "image/jpeg",
+		".webp": "image/webp",
+		".gif":  "image/gif",
+	}
+	// 聊天图片大小限制 (5MB)
+	MaxImageSize int64 = 5 * 1024 * 1024
 	// 文件上传大小限制 (10MB)
 	MaxFileSize int64 = 10 * 1024 * 1024
 	// 知识块大小（字符数）
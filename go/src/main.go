@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"Deepseek-Go/config"
+	"Deepseek-Go/global"
 	"Deepseek-Go/router"
+	"Deepseek-Go/utils/ai"
 )
 
 func main() {
 	config.InitConfig()
+	// 注册AI提供商
+	ai.InitProviders()
+	// 接收其他实例发出的停止生成通知
+	go ai.ListenGenerationStops(context.Background())
+	// 继续处理上次退出时未完成的批量任务
+	ai.NewAIService(global.DB).ResumeBatchJobs()
 
 	router := router.InitRouter()
 	router.Run(fmt.Sprintf(":%d", config.Config.App.Port))
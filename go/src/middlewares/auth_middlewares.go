@@ -3,6 +3,7 @@ package middlewares
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"Deepseek-Go/global"
 	"Deepseek-Go/models"
@@ -46,3 +47,58 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// GatewayAuthMiddleware OpenAI兼容接口的认证中间件，支持访问令牌和登录令牌，错误按OpenAI的格式返回
+// authenticateAccessToken 校验访问令牌并返回所属用户ID，由路由注入
+func GatewayAuthMiddleware(authenticateAccessToken func(token string) (uint, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		abort := func(message string) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{
+				"message": message,
+				"type":    "invalid_request_error",
+				"param":   nil,
+				"code":    "invalid_api_key",
+			}})
+			c.Abort()
+		}
+
+		token := strings.TrimSpace(c.GetHeader("Authorization"))
+		if len(token) > 7 && strings.HasPrefix(strings.ToLower(token), "bearer ") {
+			token = strings.TrimSpace(token[7:])
+		}
+		if token == "" {
+			abort("未授权")
+			return
+		}
+
+		var user models.User
+		if strings.HasPrefix(token, auth.AccessTokenPrefix) {
+			// 访问令牌
+			userID, err := authenticateAccessToken(token)
+			if err != nil {
+				abort(err.Error())
+				return
+			}
+			if err := global.DB.First(&user, userID).Error; err != nil {
+				abort("用户不存在")
+				return
+			}
+		} else {
+			// 登录令牌
+			username, err := auth.ValidateToken(token)
+			if err != nil {
+				abort("无效的令牌: " + err.Error())
+				return
+			}
+			if err := global.DB.Where("username = ?", username).First(&user).Error; err != nil {
+				abort("用户不存在")
+				return
+			}
+		}
+
+		// 设置用户名和用户ID到上下文
+		c.Set("username", user.Username)
+		c.Set("userID", user.ID)
+		c.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BatchJob 批量提示词任务模型
+type BatchJob struct {
+	gorm.Model
+	UserID       uint   `json:"user_id" gorm:"index"`                           // 用户ID
+	Name         string `json:"name"`                                           // 任务名称，默认为上传的文件名
+	AIConfigID   uint   `json:"ai_config_id"`                                   // 使用的AI配置ID
+	KnowledgeIDs []uint `json:"knowledge_ids" gorm:"serializer:json;type:text"` // 每条提示词都使用的知识库文件
+	// 任务状态：pending, running, completed, cancelled
+	Status     string     `json:"status" gorm:"index"`
+	Total      int        `json:"total"`     // 提示词总数
+	Succeeded  int        `json:"succeeded"` // 成功的条数
+	Failed     int        `json:"failed"`    // 失败的条数
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// BatchItem 批量任务中的一条提示词及其结果
+type BatchItem struct {
+	gorm.Model
+	JobID    uint   `json:"job_id" gorm:"index"`     // 所属任务ID
+	Line     int    `json:"line"`                    // 在上传文件中的序号，从1开始
+	CustomID string `json:"custom_id,omitempty"`     // 上传时指定的自定义ID，原样返回
+	Prompt   string `json:"prompt" gorm:"type:text"` // 提示词
+	// 条目状态：pending, running, succeeded, failed
+	Status           string     `json:"status" gorm:"index"`
+	Attempts         int        `json:"attempts"` // 已请求模型的次数
+	Response         string     `json:"response,omitempty" gorm:"type:text"`
+	ReasoningContent string     `json:"reasoning_content,omitempty" gorm:"type:text"`
+	Provider         string     `json:"provider,omitempty"`
+	ModelName        string     `json:"model_name,omitempty"`
+	Error            string     `json:"error,omitempty" gorm:"type:text"`
+	ErrorType        string     `json:"error_type,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+}
@@ -12,15 +12,59 @@ type ChatSession struct {
 	UserID      uint   `json:"user_id" gorm:"index"` // 用户ID
 	Title       string `json:"title"`                // 会话标题
 	LastMessage string `json:"last_message"`         // 最后一条消息内容
+	AIConfigID  uint   `json:"ai_config_id"`         // 最近使用的AI配置ID
+	PersonaID   uint   `json:"persona_id"`           // 使用的角色ID，0表示使用默认系统提示词
+	// 较早消息的滚动摘要，SummaryUntilID及之前的消息由摘要代替发送给模型
+	Summary        string `json:"summary" gorm:"type:text"`
+	SummaryUntilID uint   `json:"summary_until_id"`
+	// 会话中所有回复累计的token用量和费用
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	Cost             float64 `json:"cost"`
 }
 
 // ChatMessage 聊天消息模型
 type ChatMessage struct {
 	gorm.Model
 	SessionID uint      `json:"session_id" gorm:"index"`  // 所属会话ID
-	Role      string    `json:"role"`                     // 消息角色：user 或 assistant
+	Role      string    `json:"role"`                     // 消息角色：user, assistant 或 tool
 	Content   string    `json:"content" gorm:"type:text"` // 消息内容
 	CreatedAt time.Time `json:"created_at"`               // 创建时间
+	// 推理模型的思维链，单独存储且不会作为历史发送给模型
+	ReasoningContent string `json:"reasoning_content" gorm:"type:text"`
+	// 工具调用相关：assistant消息记录发起的调用(JSON数组)，tool消息记录对应的调用ID
+	ToolCalls  string `json:"tool_calls,omitempty" gorm:"type:text"`
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// 实际生成该回复的提供商和模型，仅assistant消息
+	Provider  string `json:"provider,omitempty"`
+	ModelName string `json:"model_name,omitempty"`
+	// 用户消息附带的图片附件ID
+	AttachmentIDs []uint `json:"attachment_ids,omitempty" gorm:"serializer:json;type:text"`
+	// 回复是否因停止生成或客户端断开而中断，中断的回复只包含已生成的部分
+	Interrupted bool `json:"interrupted,omitempty"`
+	// 回复是否来自回复缓存，而非本次调用模型生成
+	Cached bool `json:"cached,omitempty"`
+	// 多模型对比：同一问题的各个回答共享ComparisonID，选出胜者前均为候选回答，不作为历史发送给模型
+	ComparisonID string `json:"comparison_id,omitempty" gorm:"index"`
+	Candidate    bool   `json:"candidate,omitempty"`
+	AIConfigID   uint   `json:"ai_config_id,omitempty"` // 生成对比回答的AI配置ID
+	// 生成该回复的token用量和费用，包括工具调用的各轮请求，仅assistant消息
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`     // 输入token数
+	CompletionTokens int     `json:"completion_tokens,omitempty"` // 输出token数
+	CachedTokens     int     `json:"cached_tokens,omitempty"`     // 输入中命中提供商缓存的token数
+	Cost             float64 `json:"cost,omitempty"`              // 按模型价格表计算的费用
+}
+
+// ChatAttachment 聊天图片附件模型
+type ChatAttachment struct {
+	gorm.Model
+	UserID    uint   `json:"user_id" gorm:"index"`    // 上传用户ID
+	MessageID uint   `json:"message_id" gorm:"index"` // 引用该附件的消息ID，未发送时为0
+	FileName  string `json:"file_name"`               // 原始文件名
+	FilePath  string `json:"-"`                       // 文件路径
+	FileSize  int64  `json:"file_size"`               // 文件大小(bytes)
+	MimeType  string `json:"mime_type"`               // 图片类型，如image/png
 }
 
 // KnowledgeFile 知识库文件模型
@@ -51,6 +95,45 @@ type AIConfig struct {
 	ModelName   string  `json:"model_name"`           // 模型名称
 	Temperature float64 `json:"temperature"`          // 温度参数
 	MaxTokens   int     `json:"max_tokens"`           // 最大Token数
-	Provider    string  `json:"provider"`             // 提供商 (deepseek, kimi)
+	Provider    string  `json:"provider"`             // 提供商 (deepseek, kimi 或配置文件中声明的提供商)
 	IsDefault   bool    `json:"is_default"`           // 是否为默认配置
+	EnableTools bool    `json:"enable_tools"`         // 是否允许模型调用服务端工具
+	// 扩展采样参数，零值表示使用提供商默认值
+	TopP             float64  `json:"top_p"`                                 // 核采样概率，0表示使用默认值
+	PresencePenalty  float64  `json:"presence_penalty"`                      // 存在惩罚
+	FrequencyPenalty float64  `json:"frequency_penalty"`                     // 频率惩罚
+	Stop             []string `json:"stop" gorm:"serializer:json;type:text"` // 停止序列
+	Seed             *int     `json:"seed"`                                  // 随机种子，为空时不固定
+	ResponseFormat   string   `json:"response_format"`                       // 输出格式：text 或 json_object
+	// 按顺序尝试的备用配置ID，主配置出现可重试错误时依次切换
+	FallbackConfigIDs []uint `json:"fallback_config_ids" gorm:"serializer:json;type:text"`
+	// 使用的用户API密钥ID，0表示使用服务端配置的密钥
+	CredentialID uint `json:"credential_id"`
+}
+
+// ProviderCredential 用户自带的提供商API密钥，密钥使用服务端主密钥加密存储
+type ProviderCredential struct {
+	gorm.Model
+	UserID         uint       `json:"user_id" gorm:"index"` // 用户ID
+	Provider       string     `json:"provider"`             // 提供商
+	Name           string     `json:"name"`                 // 备注名称
+	EncryptedKey   []byte     `json:"-" gorm:"type:blob"`   // AES-GCM加密后的API密钥
+	KeyHint        string     `json:"key_hint"`             // 密钥掩码，如 sk-...abcd
+	LastTestedAt   *time.Time `json:"last_tested_at"`       // 最近一次测试时间
+	LastTestStatus string     `json:"last_test_status"`     // 最近一次测试结果：ok 或错误类型
+}
+
+// Persona 角色模型，包含系统提示词及默认使用的AI配置和知识库文件
+type Persona struct {
+	gorm.Model
+	UserID       uint   `json:"user_id" gorm:"index"`           // 创建者ID
+	Name         string `json:"name"`                           // 角色名称
+	Description  string `json:"description"`                    // 角色说明
+	SystemPrompt string `json:"system_prompt" gorm:"type:text"` // 系统提示词，代替默认提示词
+	// 默认AI配置ID，0表示使用用户的默认配置，仅对创建者生效
+	AIConfigID uint `json:"ai_config_id"`
+	// 默认知识库文件ID，请求未指定知识库时使用
+	KnowledgeIDs []uint `json:"knowledge_ids" gorm:"serializer:json;type:text"`
+	// 是否共享给本实例的其他用户
+	Shared bool `json:"shared" gorm:"index"`
 }
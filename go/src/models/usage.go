@@ -0,0 +1,15 @@
+package models
+
+// UsageDaily 用户每天在每个模型上的token用量和费用汇总
+type UsageDaily struct {
+	ID               uint    `json:"-" gorm:"primarykey"`
+	UserID           uint    `json:"user_id" gorm:"uniqueIndex:idx_usage_daily"`             // 用户ID
+	Date             string  `json:"date" gorm:"size:10;uniqueIndex:idx_usage_daily"`        // 日期，如2025-03-11
+	Provider         string  `json:"provider" gorm:"size:64;uniqueIndex:idx_usage_daily"`    // 提供商
+	ModelName        string  `json:"model_name" gorm:"size:128;uniqueIndex:idx_usage_daily"` // 模型名称
+	Requests         int     `json:"requests"`                                               // 回复次数
+	PromptTokens     int     `json:"prompt_tokens"`                                          // 输入token数
+	CompletionTokens int     `json:"completion_tokens"`                                      // 输出token数
+	CachedTokens     int     `json:"cached_tokens"`                                          // 输入中命中缓存的token数
+	Cost             float64 `json:"cost"`                                                   // 按模型价格表计算的费用
+}
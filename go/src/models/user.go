@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -11,3 +13,13 @@ type User struct {
 	Email         string `json:"email" binding:"required" gorm:"unique"`
 	EmailVerified bool   `json:"email_verified" gorm:"default:false"`
 }
+
+// AccessToken 用户访问令牌，用于OpenAI兼容接口等无法使用登录令牌的客户端，只保存令牌的哈希值
+type AccessToken struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"index"`         // 用户ID
+	Name       string     `json:"name"`                         // 备注名称
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;size:64"` // 令牌的SHA-256哈希
+	TokenHint  string     `json:"token_hint"`                   // 令牌掩码，如 dsk...abcd
+	LastUsedAt *time.Time `json:"last_used_at"`                 // 最近一次使用时间
+}
@@ -18,6 +18,12 @@ func InitRouter() *gin.Engine {
 	chatController := controller.NewChatController(global.DB)
 	knowledgeController := controller.NewKnowledgeController(global.DB)
 	aiConfigController := controller.NewAIConfigController(global.DB)
+	credentialController := controller.NewCredentialController(global.DB)
+	personaController := controller.NewPersonaController(global.DB)
+	batchController := controller.NewBatchController(global.DB)
+	gatewayController := controller.NewGatewayController(global.DB)
+	usageController := controller.NewUsageController(global.DB)
+	providerController := controller.NewProviderController(global.DB)
 
 	api := router.Group("/api/v1")
 	auth := api.Group("/auth")
@@ -38,12 +44,22 @@ func InitRouter() *gin.Engine {
 		// 聊天相关接口
 		chat := authorized.Group("/chat")
 		{
-			chat.POST("/completions", chatController.Chat)               // 普通聊天
-			chat.POST("/stream", chatController.StreamChat)              // 流式聊天
-			chat.GET("/sessions", chatController.GetSessions)            // 获取会话列表
-			chat.GET("/sessions/:id", chatController.GetSessionMessages) // 获取会话消息
-			chat.PUT("/sessions/:id", chatController.UpdateSession)      // 更新会话信息
-			chat.DELETE("/sessions/:id", chatController.DeleteSession)   // 删除会话
+			chat.POST("/completions", chatController.Chat)                 // 普通聊天
+			chat.POST("/stream", chatController.StreamChat)                // 流式聊天
+			chat.POST("/structured", chatController.StructuredChat)        // 结构化输出
+			chat.POST("/compare", chatController.CompareChat)              // 多模型对比
+			chat.POST("/attachments", chatController.UploadAttachment)     // 上传图片附件
+			chat.GET("/attachments/:id", chatController.GetAttachment)     // 获取图片附件
+			chat.GET("/sessions", chatController.GetSessions)              // 获取会话列表
+			chat.GET("/sessions/:id", chatController.GetSessionMessages)   // 获取会话消息
+			chat.PUT("/sessions/:id", chatController.UpdateSession)        // 更新会话信息
+			chat.DELETE("/sessions/:id", chatController.DeleteSession)     // 删除会话
+			chat.POST("/sessions/:id/stop", chatController.StopGeneration) // 停止生成
+			chat.GET("/sessions/:id/stream", chatController.ResumeStream)  // 断线续传
+
+			chat.PUT("/sessions/:id/messages/:message_id", chatController.UpdateMessage)              // 编辑消息
+			chat.DELETE("/sessions/:id/messages/:message_id", chatController.DeleteMessage)           // 删除消息
+			chat.POST("/sessions/:id/messages/:message_id/pick", chatController.PickComparisonWinner) // 选择对比胜者
 		}
 
 		// 知识库相关接口
@@ -66,6 +82,67 @@ func InitRouter() *gin.Engine {
 			aiConfig.PUT("/:id", aiConfigController.UpdateConfig)          // 更新配置
 			aiConfig.DELETE("/:id", aiConfigController.DeleteConfig)       // 删除配置
 		}
+
+		// 用户API密钥相关接口
+		credentials := authorized.Group("/credentials")
+		{
+			credentials.POST("/", credentialController.CreateCredential)       // 保存API密钥
+			credentials.GET("/", credentialController.GetCredentials)          // 获取API密钥列表
+			credentials.DELETE("/:id", credentialController.DeleteCredential)  // 删除API密钥
+			credentials.POST("/:id/test", credentialController.TestCredential) // 测试API密钥
+		}
+
+		// 角色相关接口
+		personas := authorized.Group("/personas")
+		{
+			personas.POST("/", personaController.CreatePersona)      // 创建角色
+			personas.GET("/", personaController.GetPersonas)         // 获取角色列表
+			personas.GET("/:id", personaController.GetPersona)       // 获取单个角色
+			personas.PUT("/:id", personaController.UpdatePersona)    // 更新角色
+			personas.DELETE("/:id", personaController.DeletePersona) // 删除角色
+		}
+
+		// 批量任务相关接口
+		batch := authorized.Group("/batch")
+		{
+			batch.POST("/jobs", batchController.CreateBatchJob)                  // 上传提示词文件创建任务
+			batch.GET("/jobs", batchController.GetBatchJobs)                     // 获取任务列表
+			batch.GET("/jobs/:id", batchController.GetBatchJob)                  // 获取任务进度
+			batch.GET("/jobs/:id/items", batchController.GetBatchItems)          // 获取任务条目
+			batch.GET("/jobs/:id/results", batchController.DownloadBatchResults) // 下载结果
+			batch.POST("/jobs/:id/cancel", batchController.CancelBatchJob)       // 取消任务
+			batch.DELETE("/jobs/:id", batchController.DeleteBatchJob)            // 删除任务
+		}
+
+		// 用量统计相关接口
+		usage := authorized.Group("/usage")
+		{
+			usage.GET("/", usageController.GetUsageReport)          // 用量报表
+			usage.GET("/sessions", usageController.GetSessionUsage) // 按会话统计用量
+			usage.GET("/quota", usageController.GetQuotaStatus)     // 配额和当前用量
+		}
+
+		// 访问令牌相关接口
+		tokens := authorized.Group("/tokens")
+		{
+			tokens.POST("/", gatewayController.CreateAccessToken)      // 创建访问令牌
+			tokens.GET("/", gatewayController.GetAccessTokens)         // 获取访问令牌列表
+			tokens.DELETE("/:id", gatewayController.DeleteAccessToken) // 吊销访问令牌
+		}
+
+		// 提供商相关接口
+		providers := authorized.Group("/providers")
+		{
+			providers.GET("/health", providerController.GetProvidersHealth) // 提供商健康状态
+		}
+	}
+
+	// OpenAI兼容接口，使用访问令牌或登录令牌认证
+	gateway := router.Group("/v1")
+	gateway.Use(middlewares.GatewayAuthMiddleware(gatewayController.AIService.AuthenticateAccessToken))
+	{
+		gateway.GET("/models", gatewayController.ListModels)                 // 模型列表
+		gateway.POST("/chat/completions", gatewayController.ChatCompletions) // 聊天
 	}
 
 	return router
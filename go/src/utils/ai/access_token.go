@@ -0,0 +1,80 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/auth"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hashAccessToken 计算访问令牌的SHA-256哈希
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAccessToken 生成新的访问令牌，明文令牌只在创建时返回一次
+func (s *AIService) CreateAccessToken(userID uint, name string) (*models.AccessToken, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("生成访问令牌失败: %v", err)
+	}
+	token := auth.AccessTokenPrefix + hex.EncodeToString(secret)
+
+	accessToken := models.AccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashAccessToken(token),
+		TokenHint: maskAPIKey(token),
+	}
+	if err := s.DB.Create(&accessToken).Error; err != nil {
+		return nil, "", fmt.Errorf("保存访问令牌失败: %v", err)
+	}
+
+	return &accessToken, token, nil
+}
+
+// GetAccessTokens 获取用户的所有访问令牌
+func (s *AIService) GetAccessTokens(userID uint) ([]models.AccessToken, error) {
+	var tokens []models.AccessToken
+	if err := s.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteAccessToken 吊销访问令牌
+func (s *AIService) DeleteAccessToken(tokenID, userID uint) error {
+	var accessToken models.AccessToken
+	if err := s.DB.First(&accessToken, tokenID).Error; err != nil {
+		return fmt.Errorf("访问令牌不存在")
+	}
+	if accessToken.UserID != userID {
+		return fmt.Errorf("无权访问此访问令牌")
+	}
+
+	if err := s.DB.Delete(&accessToken).Error; err != nil {
+		return fmt.Errorf("删除访问令牌失败: %v", err)
+	}
+	return nil
+}
+
+// AuthenticateAccessToken 校验访问令牌并返回所属用户ID，同时记录令牌的使用时间
+func (s *AIService) AuthenticateAccessToken(token string) (uint, error) {
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, auth.AccessTokenPrefix) {
+		return 0, fmt.Errorf("无效的访问令牌")
+	}
+
+	var accessToken models.AccessToken
+	if err := s.DB.Where("token_hash = ?", hashAccessToken(token)).First(&accessToken).Error; err != nil {
+		return 0, fmt.Errorf("无效的访问令牌")
+	}
+
+	s.DB.Model(&accessToken).UpdateColumn("last_used_at", time.Now())
+	return accessToken.UserID, nil
+}
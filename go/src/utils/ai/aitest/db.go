@@ -0,0 +1,52 @@
+package aitest
+
+import (
+	"Deepseek-Go/models"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// NewDB 创建迁移好所有数据表的内存SQLite数据库，每个测试相互隔离
+func NewDB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+
+	// 内存数据库只使用一个连接，避免后台任务与测试并发写入时锁表
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("获取数据库连接失败: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(
+		&models.User{},
+		&models.ChatSession{},
+		&models.ChatMessage{},
+		&models.ChatAttachment{},
+		&models.KnowledgeFile{},
+		&models.KnowledgeVectorStore{},
+		&models.AIConfig{},
+		&models.ProviderCredential{},
+		&models.Persona{},
+		&models.BatchJob{},
+		&models.BatchItem{},
+		&models.AccessToken{},
+		&models.UsageDaily{},
+	); err != nil {
+		t.Fatalf("迁移测试数据库失败: %v", err)
+	}
+
+	return db
+}
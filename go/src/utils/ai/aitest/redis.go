@@ -0,0 +1,26 @@
+package aitest
+
+import (
+	"Deepseek-Go/global"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// NewRedis 启动内存Redis并设置为global.RedisDB，测试结束后恢复原连接
+func NewRedis(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	previous := global.RedisDB
+	global.RedisDB = client
+	t.Cleanup(func() {
+		global.RedisDB = previous
+		client.Close()
+	})
+
+	return mr
+}
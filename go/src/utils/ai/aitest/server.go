@@ -0,0 +1,279 @@
+// Package aitest 提供模拟OpenAI兼容接口的测试服务器，用于在无网络环境下测试模型客户端
+package aitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failure 描述一次注入的错误响应
+type Failure struct {
+	StatusCode int    // HTTP状态码
+	Message    string // 错误信息
+	RetryAfter string // Retry-After响应头，为空时不设置
+}
+
+// Request 记录服务器收到的请求
+type Request struct {
+	Authorization string
+	Body          map[string]interface{}
+}
+
+// Server 模拟OpenAI兼容接口的测试服务器
+// 依次返回Replies中的回复，用完后回显最后一条用户消息；Failures中的错误会在回复之前依次返回
+type Server struct {
+	*httptest.Server
+
+	APIKey    string    // 非空时校验Authorization请求头
+	Replies   []string  // 按顺序返回的回复
+	Reasoning string    // 每次回复附带的思维链
+	Failures  []Failure // 按顺序注入的错误响应
+	ChunkSize int       // 流式输出时每个数据块的字符数，默认为4
+	Dimension int       // 嵌入向量的维度，默认为8
+	Models    []string  // /v1/models 返回的模型ID
+
+	mu       sync.Mutex
+	replies  int
+	requests []Request
+}
+
+// NewServer 创建并启动测试服务器，使用完毕后需要调用Close
+func NewServer() *Server {
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("/v1/embeddings", s.handleEmbeddings)
+	mux.HandleFunc("/v1/models", s.handleModels)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Requests 返回服务器收到的所有请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// accept 解析并记录请求，校验API密钥并返回注入的错误，请求可以继续处理时返回请求体，GET请求的请求体为nil
+func (s *Server) accept(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	var body map[string]interface{}
+	if r.Method != http.MethodGet {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, Failure{StatusCode: http.StatusBadRequest, Message: "invalid json"})
+			return nil, false
+		}
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Authorization: r.Header.Get("Authorization"), Body: body})
+	var failure *Failure
+	if len(s.Failures) > 0 {
+		failure = &s.Failures[0]
+		s.Failures = s.Failures[1:]
+	}
+	s.mu.Unlock()
+
+	if s.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.APIKey {
+		writeError(w, Failure{StatusCode: http.StatusUnauthorized, Message: "Authentication Fails, Your api key is invalid"})
+		return nil, false
+	}
+	if failure != nil {
+		writeError(w, *failure)
+		return nil, false
+	}
+	return body, true
+}
+
+// handleChatCompletions 处理聊天接口
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.accept(w, r)
+	if !ok {
+		return
+	}
+
+	reply := s.nextReply(body)
+	model, _ := body["model"].(string)
+	if stream, _ := body["stream"].(bool); stream {
+		s.writeStream(w, model, reply)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index": 0,
+			"message": map[string]interface{}{
+				"role":              "assistant",
+				"content":           reply,
+				"reasoning_content": s.Reasoning,
+			},
+			"finish_reason": "stop",
+		}},
+		"usage": usage(reply),
+	})
+}
+
+// handleEmbeddings 处理嵌入接口，按字符编码生成确定的向量，并以倒序返回以检验客户端按index排序
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.accept(w, r)
+	if !ok {
+		return
+	}
+
+	dimension := s.Dimension
+	if dimension <= 0 {
+		dimension = 8
+	}
+	inputs, _ := body["input"].([]interface{})
+	data := make([]map[string]interface{}, 0, len(inputs))
+	for i := len(inputs) - 1; i >= 0; i-- {
+		text, _ := inputs[i].(string)
+		vector := make([]float64, dimension)
+		for j, r := range []rune(text) {
+			vector[(j+int(r))%dimension] += 1
+		}
+		data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": vector})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"model":  body["model"],
+		"data":   data,
+		"usage":  map[string]int{"prompt_tokens": len(inputs), "total_tokens": len(inputs)},
+	})
+}
+
+// handleModels 处理模型列表接口
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.accept(w, r); !ok {
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(s.Models))
+	for _, id := range s.Models {
+		data = append(data, map[string]interface{}{"id": id, "object": "model", "owned_by": "aitest"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+}
+
+// nextReply 返回下一条回复
+func (s *Server) nextReply(body map[string]interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.replies < len(s.Replies) {
+		reply := s.Replies[s.replies]
+		s.replies++
+		return reply
+	}
+
+	// 回声模式
+	messages, _ := body["messages"].([]interface{})
+	for i := len(messages) - 1; i >= 0; i-- {
+		message, _ := messages[i].(map[string]interface{})
+		if message["role"] == "user" {
+			content, _ := message["content"].(string)
+			return "echo: " + content
+		}
+	}
+	return "echo"
+}
+
+// writeStream 以SSE格式输出回复
+func (s *Server) writeStream(w http.ResponseWriter, model, reply string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	writeChunk := func(payload map[string]interface{}) {
+		payload["id"] = "chatcmpl-test"
+		payload["object"] = "chat.completion.chunk"
+		payload["model"] = model
+		data, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	writeDelta := func(delta map[string]interface{}, finishReason interface{}) {
+		writeChunk(map[string]interface{}{
+			"choices": []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		})
+	}
+
+	size := s.ChunkSize
+	if size <= 0 {
+		size = 4
+	}
+
+	writeDelta(map[string]interface{}{"role": "assistant", "content": ""}, nil)
+	for _, part := range split(s.Reasoning, size) {
+		writeDelta(map[string]interface{}{"reasoning_content": part}, nil)
+	}
+	for _, part := range split(reply, size) {
+		writeDelta(map[string]interface{}{"content": part}, nil)
+	}
+	writeDelta(map[string]interface{}{}, "stop")
+	writeChunk(map[string]interface{}{"choices": []interface{}{}, "usage": usage(reply)})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// writeError 以OpenAI格式输出错误响应
+func writeError(w http.ResponseWriter, failure Failure) {
+	if failure.RetryAfter != "" {
+		w.Header().Set("Retry-After", failure.RetryAfter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(failure.StatusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": failure.Message,
+			"type":    "invalid_request_error",
+		},
+	})
+}
+
+// usage 按字符数生成用量
+func usage(reply string) map[string]int {
+	completion := len([]rune(reply))
+	return map[string]int{"prompt_tokens": 10, "completion_tokens": completion, "total_tokens": 10 + completion}
+}
+
+// split 按字符数切分文本
+func split(text string, size int) []string {
+	runes := []rune(text)
+	var parts []string
+	for i := 0; i < len(runes); i += size {
+		end := i + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		parts = append(parts, string(runes[i:end]))
+	}
+	return parts
+}
+
+// LastUserMessage 返回请求中最后一条用户消息的内容
+func (r Request) LastUserMessage() string {
+	messages, _ := r.Body["messages"].([]interface{})
+	for i := len(messages) - 1; i >= 0; i-- {
+		message, _ := messages[i].(map[string]interface{})
+		if message["role"] == "user" {
+			content, _ := message["content"].(string)
+			return strings.TrimSpace(content)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SaveChatAttachment 保存用户上传的聊天图片并记录到数据库
+func (s *AIService) SaveChatAttachment(userID uint, file io.Reader, originalFileName string) (*models.ChatAttachment, error) {
+	fileExt := strings.ToLower(filepath.Ext(originalFileName))
+	mimeType, ok := global.AllowedImageTypes[fileExt]
+	if !ok {
+		return nil, fmt.Errorf("不支持的图片类型: %s", fileExt)
+	}
+
+	// 读取文件内容并校验确实是图片
+	data, err := io.ReadAll(io.LimitReader(file, global.MaxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取图片失败: %v", err)
+	}
+	if int64(len(data)) > global.MaxImageSize {
+		return nil, fmt.Errorf("图片大小不能超过%dMB", global.MaxImageSize/1024/1024)
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil, fmt.Errorf("文件内容不是有效的图片")
+	}
+
+	// 创建上传目录
+	uploadDir := "./uploads/attachments"
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建上传目录失败: %v", err)
+	}
+
+	// 生成唯一文件名并保存
+	filePath := filepath.Join(uploadDir, uuid.New().String()+fileExt)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return nil, fmt.Errorf("保存图片失败: %v", err)
+	}
+
+	attachment := models.ChatAttachment{
+		UserID:   userID,
+		FileName: originalFileName,
+		FilePath: filePath,
+		FileSize: int64(len(data)),
+		MimeType: mimeType,
+	}
+	if err := s.DB.Create(&attachment).Error; err != nil {
+		os.Remove(filePath)
+		return nil, fmt.Errorf("保存附件记录失败: %v", err)
+	}
+
+	return &attachment, nil
+}
+
+// GetChatAttachment 获取用户的聊天附件
+func (s *AIService) GetChatAttachment(attachmentID, userID uint) (*models.ChatAttachment, error) {
+	var attachment models.ChatAttachment
+	if err := s.DB.First(&attachment, attachmentID).Error; err != nil {
+		return nil, fmt.Errorf("附件不存在")
+	}
+
+	if attachment.UserID != userID {
+		return nil, fmt.Errorf("无权访问此附件")
+	}
+
+	return &attachment, nil
+}
+
+// loadAttachments 按ID顺序加载用户的附件
+func (s *AIService) loadAttachments(userID uint, attachmentIDs []uint) ([]models.ChatAttachment, error) {
+	attachments := make([]models.ChatAttachment, 0, len(attachmentIDs))
+	for _, id := range uniqueIDs(attachmentIDs) {
+		attachment, err := s.GetChatAttachment(id, userID)
+		if err != nil {
+			return nil, fmt.Errorf("附件%d: %v", id, err)
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+// imageParts 将附件读取为base64编码的图片内容片段
+func imageParts(attachments []models.ChatAttachment) ([]ContentPart, error) {
+	parts := make([]ContentPart, 0, len(attachments))
+	for _, attachment := range attachments {
+		data, err := os.ReadFile(attachment.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("读取附件%s失败: %v", attachment.FileName, err)
+		}
+		url := "data:" + attachment.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+		parts = append(parts, ImagePart(url))
+	}
+	return parts, nil
+}
@@ -0,0 +1,683 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 批量任务和条目的状态
+const (
+	BatchPending   = "pending"
+	BatchRunning   = "running"
+	BatchCompleted = "completed"
+	BatchCancelled = "cancelled"
+	BatchSucceeded = "succeeded"
+	BatchFailed    = "failed"
+)
+
+const (
+	defaultBatchWorkers     = 4
+	defaultBatchMaxItems    = 1000
+	defaultBatchMaxAttempts = 3
+	// 单条提示词的请求超时时间
+	batchRequestTimeout = 60 * time.Second
+	// 配额检查结果的缓存时间，避免每个条目都查询用量
+	batchQuotaCheckInterval = 10 * time.Second
+	// JSONL文件单行的最大长度
+	maxBatchLineSize = 1024 * 1024
+)
+
+// ErrBatchNotFinished 表示任务仍在处理，还不能下载结果
+var ErrBatchNotFinished = errors.New("批量任务尚未完成")
+
+// batchRetryPolicy 批量任务条目失败后的退避策略，重试次数由max_attempts决定
+var batchRetryPolicy = RetryPolicy{
+	BaseDelay: time.Second,
+	MaxDelay:  time.Minute,
+}
+
+// BatchPrompt 上传文件中的一条提示词
+type BatchPrompt struct {
+	CustomID string `json:"custom_id"`
+	Prompt   string `json:"prompt"`
+}
+
+// ParseBatchPrompts 解析提示词文件，format为jsonl或csv
+// JSONL每行为一个包含prompt和可选custom_id的对象；CSV的第一行为表头，必须包含prompt列
+func ParseBatchPrompts(r io.Reader, format string) ([]BatchPrompt, error) {
+	var prompts []BatchPrompt
+	var err error
+	switch strings.ToLower(format) {
+	case "jsonl":
+		prompts, err = parseJSONLPrompts(r)
+	case "csv":
+		prompts, err = parseCSVPrompts(r)
+	default:
+		return nil, fmt.Errorf("不支持的文件格式，仅支持jsonl和csv")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("文件中没有提示词")
+	}
+	return prompts, nil
+}
+
+// parseJSONLPrompts 解析JSONL格式的提示词，跳过空行
+func parseJSONLPrompts(r io.Reader) ([]BatchPrompt, error) {
+	var prompts []BatchPrompt
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+
+		var prompt BatchPrompt
+		if err := json.Unmarshal([]byte(text), &prompt); err != nil {
+			return nil, fmt.Errorf("第%d行不是有效的JSON对象: %v", line, err)
+		}
+		prompt.Prompt = strings.TrimSpace(prompt.Prompt)
+		if prompt.Prompt == "" {
+			return nil, fmt.Errorf("第%d行缺少prompt", line)
+		}
+		prompts = append(prompts, prompt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+	return prompts, nil
+}
+
+// parseCSVPrompts 解析CSV格式的提示词，按表头查找prompt和custom_id列，跳过prompt为空的行
+func parseCSVPrompts(r io.Reader) ([]BatchPrompt, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取CSV表头失败: %v", err)
+	}
+	promptColumn, idColumn := -1, -1
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case "prompt":
+			promptColumn = i
+		case "custom_id":
+			idColumn = i
+		}
+	}
+	if promptColumn < 0 {
+		return nil, fmt.Errorf("CSV表头缺少prompt列")
+	}
+
+	var prompts []BatchPrompt
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析CSV失败: %v", err)
+		}
+		var prompt BatchPrompt
+		if promptColumn < len(record) {
+			prompt.Prompt = strings.TrimSpace(record[promptColumn])
+		}
+		if idColumn >= 0 && idColumn < len(record) {
+			prompt.CustomID = strings.TrimSpace(record[idColumn])
+		}
+		if prompt.Prompt == "" {
+			continue
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, nil
+}
+
+// CreateBatchJob 创建批量任务并开始处理，知识库文件必须属于该用户
+func (s *AIService) CreateBatchJob(userID uint, name string, aiConfig models.AIConfig, knowledgeIDs []uint, prompts []BatchPrompt) (*models.BatchJob, error) {
+	if maxItems := batchMaxItems(); len(prompts) > maxItems {
+		return nil, fmt.Errorf("单个任务最多包含%d条提示词", maxItems)
+	}
+	if err := s.CheckQuota(userID); err != nil {
+		return nil, err
+	}
+	knowledgeIDs = uniqueIDs(knowledgeIDs)
+	if len(knowledgeIDs) > 0 {
+		var count int64
+		if err := s.DB.Model(&models.KnowledgeFile{}).Where("id IN ? AND user_id = ?", knowledgeIDs, userID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("查询知识库文件失败: %v", err)
+		}
+		if int(count) != len(knowledgeIDs) {
+			return nil, fmt.Errorf("知识库文件不存在或无权访问")
+		}
+	}
+
+	job := models.BatchJob{
+		UserID:       userID,
+		Name:         name,
+		AIConfigID:   aiConfig.ID,
+		KnowledgeIDs: knowledgeIDs,
+		Status:       BatchPending,
+		Total:        len(prompts),
+	}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		items := make([]models.BatchItem, len(prompts))
+		for i, prompt := range prompts {
+			items[i] = models.BatchItem{JobID: job.ID, Line: i + 1, CustomID: prompt.CustomID, Prompt: prompt.Prompt, Status: BatchPending}
+		}
+		return tx.CreateInBatches(items, 100).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建批量任务失败: %v", err)
+	}
+
+	s.StartBatchJob(job.ID)
+	return &job, nil
+}
+
+// GetBatchJobs 分页获取用户的批量任务
+func (s *AIService) GetBatchJobs(userID uint, page, pageSize int) ([]models.BatchJob, int64, error) {
+	var jobs []models.BatchJob
+	var count int64
+	if err := s.DB.Model(&models.BatchJob{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := s.DB.Where("user_id = ?", userID).Order("created_at desc").
+		Offset((page - 1) * pageSize).Limit(pageSize).Find(&jobs).Error; err != nil {
+		return nil, 0, err
+	}
+	return jobs, count, nil
+}
+
+// GetBatchJob 获取批量任务并验证所有权
+func (s *AIService) GetBatchJob(jobID, userID uint) (*models.BatchJob, error) {
+	var job models.BatchJob
+	if err := s.DB.First(&job, jobID).Error; err != nil {
+		return nil, fmt.Errorf("批量任务不存在")
+	}
+	if job.UserID != userID {
+		return nil, fmt.Errorf("无权访问此批量任务")
+	}
+	return &job, nil
+}
+
+// GetBatchItems 分页获取批量任务的条目，status不为空时只返回该状态的条目
+func (s *AIService) GetBatchItems(jobID, userID uint, status string, page, pageSize int) ([]models.BatchItem, int64, error) {
+	if _, err := s.GetBatchJob(jobID, userID); err != nil {
+		return nil, 0, err
+	}
+
+	query := func() *gorm.DB {
+		q := s.DB.Model(&models.BatchItem{}).Where("job_id = ?", jobID)
+		if status != "" {
+			q = q.Where("status = ?", status)
+		}
+		return q
+	}
+	var count int64
+	if err := query().Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	var items []models.BatchItem
+	if err := query().Order("line asc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&items).Error; err != nil {
+		return nil, 0, err
+	}
+	return items, count, nil
+}
+
+// CancelBatchJob 取消未完成的批量任务，已完成的条目保留结果，未处理的条目不再处理
+func (s *AIService) CancelBatchJob(jobID, userID uint) (*models.BatchJob, error) {
+	job, err := s.GetBatchJob(jobID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != BatchPending && job.Status != BatchRunning {
+		return nil, fmt.Errorf("批量任务已结束")
+	}
+
+	now := time.Now()
+	if err := s.DB.Model(job).Updates(map[string]interface{}{"status": BatchCancelled, "finished_at": now}).Error; err != nil {
+		return nil, fmt.Errorf("取消批量任务失败: %v", err)
+	}
+	batches.cancel(job.ID)
+	return job, nil
+}
+
+// DeleteBatchJob 删除批量任务及其全部条目，未完成的任务先取消
+func (s *AIService) DeleteBatchJob(jobID, userID uint) error {
+	job, err := s.GetBatchJob(jobID, userID)
+	if err != nil {
+		return err
+	}
+	batches.cancel(job.ID)
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", job.ID).Delete(&models.BatchItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(job).Error
+	})
+}
+
+// batchResult 结果文件中的一行
+type batchResult struct {
+	Line             int    `json:"line"`
+	CustomID         string `json:"custom_id,omitempty"`
+	Prompt           string `json:"prompt"`
+	Status           string `json:"status"`
+	Response         string `json:"response,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+	ModelName        string `json:"model_name,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorType        string `json:"error_type,omitempty"`
+	Attempts         int    `json:"attempts"`
+}
+
+// WriteBatchResults 按上传顺序以JSONL格式写出已结束任务的全部条目，任务未结束时返回ErrBatchNotFinished
+func (s *AIService) WriteBatchResults(jobID, userID uint, w io.Writer) error {
+	job, err := s.GetBatchJob(jobID, userID)
+	if err != nil {
+		return err
+	}
+	if job.Status != BatchCompleted && job.Status != BatchCancelled {
+		return ErrBatchNotFinished
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	var items []models.BatchItem
+	return s.DB.Where("job_id = ?", job.ID).Order("line asc").FindInBatches(&items, 200, func(tx *gorm.DB, batch int) error {
+		for _, item := range items {
+			result := batchResult{
+				Line:             item.Line,
+				CustomID:         item.CustomID,
+				Prompt:           item.Prompt,
+				Status:           item.Status,
+				Response:         item.Response,
+				ReasoningContent: item.ReasoningContent,
+				Provider:         item.Provider,
+				ModelName:        item.ModelName,
+				Error:            item.Error,
+				ErrorType:        item.ErrorType,
+				Attempts:         item.Attempts,
+			}
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// 任务执行 ---------------------------------------------------------
+
+// batchRunner 记录本实例上正在执行的批量任务，所有任务共享同一组并发槽位
+type batchRunner struct {
+	mu      sync.Mutex
+	slots   chan struct{}
+	cancels map[uint]context.CancelFunc
+}
+
+var batches = &batchRunner{
+	cancels: make(map[uint]context.CancelFunc),
+}
+
+// acquire 等待一个空闲的并发槽位，返回释放槽位的函数，ctx结束时返回false
+func (r *batchRunner) acquire(ctx context.Context) (func(), bool) {
+	r.mu.Lock()
+	if r.slots == nil {
+		workers := config.Config.AI.Batch.Workers
+		if workers <= 0 {
+			workers = defaultBatchWorkers
+		}
+		r.slots = make(chan struct{}, workers)
+	}
+	slots := r.slots
+	r.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// cancel 停止本实例上正在执行的任务
+func (r *batchRunner) cancel(jobID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.cancels[jobID]; ok {
+		cancel()
+	}
+}
+
+// StartBatchJob 在后台处理任务中尚未处理的条目，任务已在本实例上执行时不重复启动
+func (s *AIService) StartBatchJob(jobID uint) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batches.mu.Lock()
+	if _, ok := batches.cancels[jobID]; ok {
+		batches.mu.Unlock()
+		cancel()
+		return
+	}
+	batches.cancels[jobID] = cancel
+	batches.mu.Unlock()
+
+	go func() {
+		defer func() {
+			batches.mu.Lock()
+			delete(batches.cancels, jobID)
+			batches.mu.Unlock()
+			cancel()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("批量任务%d执行异常: %v", jobID, r)
+			}
+		}()
+		s.runBatchJob(ctx, jobID)
+	}()
+}
+
+// ResumeBatchJobs 服务启动时继续处理未结束的批量任务，上次退出时处理中的条目重新处理
+func (s *AIService) ResumeBatchJobs() {
+	var jobs []models.BatchJob
+	if err := s.DB.Where("status IN ?", []string{BatchPending, BatchRunning}).Find(&jobs).Error; err != nil {
+		log.Printf("查询未完成的批量任务失败: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if err := s.DB.Model(&models.BatchItem{}).Where("job_id = ? AND status = ?", job.ID, BatchRunning).Update("status", BatchPending).Error; err != nil {
+			log.Printf("恢复批量任务%d处理中的条目失败: %v", job.ID, err)
+		}
+		s.StartBatchJob(job.ID)
+	}
+	if len(jobs) > 0 {
+		log.Printf("继续处理%d个未完成的批量任务", len(jobs))
+	}
+}
+
+// runBatchJob 依次处理任务中待处理的条目，同时处理的条目数受全局并发槽位限制
+func (s *AIService) runBatchJob(ctx context.Context, jobID uint) {
+	var job models.BatchJob
+	if err := s.DB.First(&job, jobID).Error; err != nil {
+		log.Printf("获取批量任务%d失败: %v", jobID, err)
+		return
+	}
+	if job.Status != BatchPending && job.Status != BatchRunning {
+		return
+	}
+
+	// 只更新未结束的任务，避免覆盖在此期间被取消的任务
+	now := time.Now()
+	updates := map[string]interface{}{"status": BatchRunning}
+	if job.StartedAt == nil {
+		updates["started_at"] = now
+	}
+	result := s.DB.Model(&job).Where("status IN ?", []string{BatchPending, BatchRunning}).Updates(updates)
+	if result.Error != nil {
+		log.Printf("更新批量任务%d的状态失败: %v", jobID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	// 查询失败时任务保持处理中，服务重启后继续处理
+	var items []models.BatchItem
+	if err := s.DB.Where("job_id = ? AND status = ?", job.ID, BatchPending).Order("line asc").Find(&items).Error; err != nil {
+		log.Printf("查询批量任务%d的待处理条目失败: %v", jobID, err)
+		return
+	}
+
+	// AI配置或模型不可用时所有条目都会失败，不再逐条请求
+	runner, err := s.newBatchItemRunner(job)
+	if err != nil {
+		for _, item := range items {
+			s.finishBatchItem(&item, ChatMessage{}, err)
+		}
+		s.finishBatchJob(job.ID)
+		return
+	}
+
+	var wg sync.WaitGroup
+	aborted := false
+	for _, item := range items {
+		release, ok := batches.acquire(ctx)
+		if !ok {
+			break
+		}
+		// 任务可能已在其他实例上被取消或删除
+		var status string
+		if err := s.DB.Model(&models.BatchJob{}).Select("status").Where("id = ?", job.ID).Scan(&status).Error; err != nil {
+			log.Printf("查询批量任务%d的状态失败: %v", jobID, err)
+			aborted = true
+		}
+		if aborted || status != BatchRunning {
+			release()
+			break
+		}
+		wg.Add(1)
+		go func(item models.BatchItem) {
+			defer wg.Done()
+			defer release()
+			runner.run(ctx, &item)
+		}(item)
+	}
+	wg.Wait()
+
+	// 剩余条目未处理时不标记完成
+	if ctx.Err() == nil && !aborted {
+		s.finishBatchJob(job.ID)
+	}
+}
+
+// finishBatchJob 所有条目处理完后将任务标记为已完成，已取消的任务保持取消状态
+func (s *AIService) finishBatchJob(jobID uint) {
+	err := s.DB.Model(&models.BatchJob{}).Where("id = ? AND status = ?", jobID, BatchRunning).
+		Updates(map[string]interface{}{"status": BatchCompleted, "finished_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("更新批量任务%d为已完成失败: %v", jobID, err)
+	}
+}
+
+// batchItemRunner 使用任务的AI配置和知识库处理单条提示词
+type batchItemRunner struct {
+	s           *AIService
+	aiConfig    models.AIConfig
+	aiModel     AIModel
+	limiter     *rateLimiter
+	knowledge   []string
+	model       string
+	budget      int
+	maxAttempts int
+
+	quotaMu        sync.Mutex
+	quotaErr       error
+	quotaCheckedAt time.Time
+}
+
+// newBatchItemRunner 加载任务的AI配置、模型和知识库，批量任务不使用工具调用和备用配置
+func (s *AIService) newBatchItemRunner(job models.BatchJob) (*batchItemRunner, error) {
+	aiConfig, err := s.GetAIConfig(job.AIConfigID, job.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("获取AI配置失败: %v", err)
+	}
+	aiConfig.EnableTools = false
+
+	aiModel, err := s.getAIModel(*aiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("获取AI模型失败: %v", err)
+	}
+	// 重试由run控制，每次请求都经过限速器，Attempts即实际请求次数
+	aiModel = withoutRetry(aiModel)
+
+	maxAttempts := config.Config.AI.Batch.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultBatchMaxAttempts
+	}
+	model, budget := contextBudget([]models.AIConfig{*aiConfig})
+	return &batchItemRunner{
+		s:           s,
+		aiConfig:    *aiConfig,
+		aiModel:     aiModel,
+		limiter:     limiterFor(aiConfig.Provider),
+		knowledge:   s.getKnowledgeContent(job.KnowledgeIDs, job.UserID),
+		model:       model,
+		budget:      budget,
+		maxAttempts: maxAttempts,
+	}, nil
+}
+
+// run 按限速请求模型，可重试的错误退避后重试，触发限流时暂停该提供商的所有批量请求
+// 任务被取消时条目恢复为待处理，不记录结果
+func (r *batchItemRunner) run(ctx context.Context, item *models.BatchItem) {
+	if err := r.s.DB.Model(item).Update("status", BatchRunning).Error; err != nil {
+		log.Printf("更新批量任务条目%d的状态失败: %v", item.ID, err)
+	}
+
+	userMessage := ChatMessage{Role: "user", Content: item.Prompt}
+	aiMessages := r.s.buildAIMessages(&models.ChatSession{}, global.DefaultSystemPrompt, nil, userMessage, r.knowledge, r.model, r.budget)
+	request := r.s.newChatRequest(r.aiConfig, aiMessages, 0)
+
+	// 用完配额后剩余的条目直接失败，不再请求提供商
+	var reply ChatMessage
+	var usage Usage
+	err := r.checkQuota()
+	for err == nil && item.Attempts < r.maxAttempts {
+		if err = r.limiter.wait(ctx); err != nil {
+			break
+		}
+		item.Attempts++
+
+		reply, usage, err = r.complete(ctx, request)
+		if err == nil || !IsRetryable(err) || item.Attempts >= r.maxAttempts {
+			break
+		}
+
+		delay := batchRetryPolicy.backoff(item.Attempts - 1)
+		providerErr, _ := AsProviderError(err)
+		if providerErr.RetryAfter > 0 {
+			delay = providerErr.RetryAfter
+		}
+		if providerErr.Kind == ErrKindRateLimit {
+			r.limiter.pause(delay)
+		}
+		if err = sleepContext(ctx, delay); err != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		if err := r.s.DB.Model(item).Updates(map[string]interface{}{"status": BatchPending, "attempts": item.Attempts}).Error; err != nil {
+			log.Printf("恢复批量任务条目%d为待处理失败: %v", item.ID, err)
+		}
+		return
+	}
+	item.Provider, item.ModelName = r.aiConfig.Provider, r.aiConfig.ModelName
+	r.s.finishBatchItem(item, reply, err)
+
+	// 成功的条目计入用户的每日用量
+	if err == nil {
+		var used tokenUsage
+		used.add(&usage, r.aiConfig.ModelName, request.Messages, reply)
+		r.s.recordUsage(r.aiConfig.UserID, 0, r.aiConfig.Provider, r.aiConfig.ModelName, used)
+	}
+}
+
+// checkQuota 检查任务所属用户的配额，结果缓存batchQuotaCheckInterval；用完配额后不再重新检查
+func (r *batchItemRunner) checkQuota() error {
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+	if r.quotaErr != nil || time.Since(r.quotaCheckedAt) < batchQuotaCheckInterval {
+		return r.quotaErr
+	}
+	r.quotaErr = r.s.CheckQuota(r.aiConfig.UserID)
+	r.quotaCheckedAt = time.Now()
+	return r.quotaErr
+}
+
+// complete 发送一次非流式请求并返回模型回复和用量
+func (r *batchItemRunner) complete(ctx context.Context, request ChatCompletionRequest) (ChatMessage, Usage, error) {
+	ctx, cancel := context.WithTimeout(ctx, batchRequestTimeout)
+	defer cancel()
+
+	response, err := r.aiModel.ChatCompletion(ctx, request)
+	if err != nil {
+		return ChatMessage{}, Usage{}, err
+	}
+	if len(response.Choices) == 0 {
+		return ChatMessage{}, Usage{}, fmt.Errorf("AI返回了空回复")
+	}
+	return response.Choices[0].Message, response.Usage, nil
+}
+
+// finishBatchItem 保存条目的结果并更新任务的进度
+func (s *AIService) finishBatchItem(item *models.BatchItem, reply ChatMessage, err error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":    item.Attempts,
+		"provider":    item.Provider,
+		"model_name":  item.ModelName,
+		"finished_at": now,
+	}
+	counter := "succeeded"
+	if err != nil {
+		counter = "failed"
+		updates["status"] = BatchFailed
+		updates["error"] = err.Error()
+		updates["error_type"] = "internal"
+		if providerErr, ok := AsProviderError(err); ok {
+			updates["error_type"] = string(providerErr.Kind)
+		} else if _, ok := AsQuotaError(err); ok {
+			updates["error_type"] = "quota"
+		}
+	} else {
+		updates["status"] = BatchSucceeded
+		updates["response"] = reply.Content
+		updates["reasoning_content"] = reply.ReasoningContent
+	}
+
+	if err := s.DB.Model(item).Updates(updates).Error; err != nil {
+		log.Printf("保存批量任务条目%d的结果失败: %v", item.ID, err)
+		return
+	}
+	if err := s.DB.Model(&models.BatchJob{}).Where("id = ?", item.JobID).Update(counter, gorm.Expr(counter+" + ?", 1)).Error; err != nil {
+		log.Printf("更新批量任务%d的进度失败: %v", item.JobID, err)
+	}
+}
+
+// batchMaxItems 返回单个任务最多的提示词数
+func batchMaxItems() int {
+	if maxItems := config.Config.AI.Batch.MaxItems; maxItems > 0 {
+		return maxItems
+	}
+	return defaultBatchMaxItems
+}
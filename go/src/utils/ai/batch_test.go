@@ -0,0 +1,355 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai/aitest"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitBatchJob 等待任务在本实例上执行结束并返回任务的最终状态
+func waitBatchJob(t *testing.T, s *AIService, jobID uint) models.BatchJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		batches.mu.Lock()
+		_, running := batches.cancels[jobID]
+		batches.mu.Unlock()
+		if !running {
+			var job models.BatchJob
+			if err := s.DB.First(&job, jobID).Error; err != nil {
+				t.Fatal(err)
+			}
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("batch job %d did not finish", jobID)
+	return models.BatchJob{}
+}
+
+// createBatchConfig 创建属于用户1的AI配置
+func createBatchConfig(t *testing.T, s *AIService, provider string) models.AIConfig {
+	t.Helper()
+	cfg := models.AIConfig{UserID: 1, Provider: provider, ModelName: "mock-echo", EnableTools: true}
+	if err := s.DB.Create(&cfg).Error; err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestParseBatchPrompts(t *testing.T) {
+	jsonl := "\ufeff{\"custom_id\":\"q1\",\"prompt\":\"你好\"}\n\n{\"prompt\":\" 第二条 \"}\n"
+	prompts, err := ParseBatchPrompts(strings.NewReader(jsonl), "jsonl")
+	if err != nil {
+		t.Fatalf("ParseBatchPrompts(jsonl) error = %v", err)
+	}
+	if len(prompts) != 2 || prompts[0] != (BatchPrompt{CustomID: "q1", Prompt: "你好"}) || prompts[1].Prompt != "第二条" {
+		t.Errorf("jsonl prompts = %+v", prompts)
+	}
+
+	csvData := "\ufeffCustom_ID,Prompt\nq1,\"包含,逗号的问题\"\nq2,\nq3,第三条\n"
+	prompts, err = ParseBatchPrompts(strings.NewReader(csvData), "CSV")
+	if err != nil {
+		t.Fatalf("ParseBatchPrompts(csv) error = %v", err)
+	}
+	if len(prompts) != 2 || prompts[0] != (BatchPrompt{CustomID: "q1", Prompt: "包含,逗号的问题"}) || prompts[1].CustomID != "q3" {
+		t.Errorf("csv prompts = %+v", prompts)
+	}
+
+	tests := []struct {
+		name, data, format, want string
+	}{
+		{"invalid json", "{\"prompt\":\"ok\"}\nnot json\n", "jsonl", "第2行"},
+		{"missing prompt", "{\"custom_id\":\"q1\"}\n", "jsonl", "缺少prompt"},
+		{"missing column", "question\n你好\n", "csv", "prompt列"},
+		{"empty", "\n\n", "jsonl", "没有提示词"},
+		{"unknown format", "你好", "txt", "不支持"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBatchPrompts(strings.NewReader(tt.data), tt.format)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchJobCompletes(t *testing.T) {
+	model := NewMockModel()
+	// 第一次请求触发限流，退避后重试成功
+	model.Err = &ProviderError{Provider: "mock-batch", Kind: ErrKindRateLimit, StatusCode: 429, RetryAfter: 20 * time.Millisecond}
+	model.FailTimes = 1
+	s := newTestService(t, map[string]*MockModel{"mock-batch": model})
+	aiConfig := createBatchConfig(t, s, "mock-batch")
+
+	knowledge := models.KnowledgeFile{UserID: 1, FileName: "faq.md", Status: "completed"}
+	s.DB.Create(&knowledge)
+	s.DB.Create(&models.KnowledgeVectorStore{FileID: knowledge.ID, Text: "营业时间为9点到18点"})
+
+	prompts := []BatchPrompt{{CustomID: "a", Prompt: "第一条"}, {Prompt: "第二条"}, {CustomID: "c", Prompt: "第三条"}}
+	job, err := s.CreateBatchJob(1, "nightly", aiConfig, []uint{knowledge.ID}, prompts)
+	if err != nil {
+		t.Fatalf("CreateBatchJob() error = %v", err)
+	}
+
+	finished := waitBatchJob(t, s, job.ID)
+	if finished.Status != BatchCompleted || finished.Succeeded != 3 || finished.Failed != 0 || finished.StartedAt == nil || finished.FinishedAt == nil {
+		t.Fatalf("job = %+v", finished)
+	}
+
+	// 请求不带工具定义，知识库内容加入系统提示词
+	requests := model.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("model called %d times, want 4", len(requests))
+	}
+	for _, request := range requests {
+		if len(request.Tools) > 0 || !strings.Contains(request.Messages[0].Content, "营业时间") {
+			t.Errorf("request = %+v", request)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteBatchResults(job.ID, 1, &buf); err != nil {
+		t.Fatalf("WriteBatchResults() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("results = %q", buf.String())
+	}
+	retried := 0
+	for i, line := range lines {
+		var result batchResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Line != i+1 || result.Prompt != prompts[i].Prompt || result.CustomID != prompts[i].CustomID {
+			t.Errorf("result %d = %+v", i, result)
+		}
+		if result.Status != BatchSucceeded || result.Response != "echo: "+prompts[i].Prompt || result.Provider != "mock-batch" {
+			t.Errorf("result %d = %+v", i, result)
+		}
+		if result.Attempts == 2 {
+			retried++
+		}
+	}
+	if retried != 1 {
+		t.Errorf("retried items = %d, want 1", retried)
+	}
+
+	if err := s.WriteBatchResults(job.ID, 2, &buf); err == nil {
+		t.Error("WriteBatchResults() for another user succeeded")
+	}
+}
+
+func TestBatchJobCountsProviderRequests(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Failures = []aitest.Failure{{StatusCode: http.StatusServiceUnavailable}}
+	registerDiscoveryProvider("batch-http", "", server, nil)
+	s := NewAIService(aitest.NewDB(t))
+	aiConfig := createBatchConfig(t, s, "batch-http")
+
+	previous := batchRetryPolicy
+	batchRetryPolicy = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	t.Cleanup(func() { batchRetryPolicy = previous })
+
+	job, err := s.CreateBatchJob(1, "attempts", aiConfig, nil, []BatchPrompt{{Prompt: "你好"}})
+	if err != nil {
+		t.Fatalf("CreateBatchJob() error = %v", err)
+	}
+	if finished := waitBatchJob(t, s, job.ID); finished.Succeeded != 1 {
+		t.Fatalf("job = %+v", finished)
+	}
+
+	// 模型内部不重试，每次请求都由批量任务发起并计入Attempts
+	items, _, err := s.GetBatchItems(job.ID, 1, BatchSucceeded, 1, 10)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("GetBatchItems() = %+v, err = %v", items, err)
+	}
+	if items[0].Attempts != 2 || len(server.Requests()) != 2 {
+		t.Errorf("attempts = %d, provider requests = %d, want 2", items[0].Attempts, len(server.Requests()))
+	}
+}
+
+func TestBatchJobItemErrors(t *testing.T) {
+	model := NewMockModel()
+	model.Err = &ProviderError{Provider: "mock-batch-fail", Kind: ErrKindInvalidRequest, StatusCode: 400, Message: "bad prompt"}
+	s := newTestService(t, map[string]*MockModel{"mock-batch-fail": model})
+	aiConfig := createBatchConfig(t, s, "mock-batch-fail")
+
+	job, err := s.CreateBatchJob(1, "errors", aiConfig, nil, []BatchPrompt{{Prompt: "一"}, {Prompt: "二"}})
+	if err != nil {
+		t.Fatalf("CreateBatchJob() error = %v", err)
+	}
+	finished := waitBatchJob(t, s, job.ID)
+	if finished.Status != BatchCompleted || finished.Failed != 2 || finished.Succeeded != 0 {
+		t.Fatalf("job = %+v", finished)
+	}
+
+	// 不可重试的错误只请求一次，记录错误类型
+	items, count, err := s.GetBatchItems(job.ID, 1, BatchFailed, 1, 10)
+	if err != nil || count != 2 {
+		t.Fatalf("GetBatchItems() count = %d, err = %v", count, err)
+	}
+	for _, item := range items {
+		if item.Attempts != 1 || item.ErrorType != string(ErrKindInvalidRequest) || !strings.Contains(item.Error, "bad prompt") {
+			t.Errorf("item = %+v", item)
+		}
+	}
+}
+
+func TestBatchJobCancel(t *testing.T) {
+	model := NewMockModel()
+	model.Latency = 200 * time.Millisecond
+	s := newTestService(t, map[string]*MockModel{"mock-batch-cancel": model})
+	aiConfig := createBatchConfig(t, s, "mock-batch-cancel")
+
+	prompts := make([]BatchPrompt, 20)
+	for i := range prompts {
+		prompts[i].Prompt = "问题"
+	}
+	job, err := s.CreateBatchJob(1, "cancel", aiConfig, nil, prompts)
+	if err != nil {
+		t.Fatalf("CreateBatchJob() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteBatchResults(job.ID, 1, &buf); err != ErrBatchNotFinished {
+		t.Errorf("WriteBatchResults() before finish error = %v", err)
+	}
+
+	if _, err := s.CancelBatchJob(job.ID, 1); err != nil {
+		t.Fatalf("CancelBatchJob() error = %v", err)
+	}
+	finished := waitBatchJob(t, s, job.ID)
+	if finished.Status != BatchCancelled || finished.Succeeded == 20 {
+		t.Fatalf("job = %+v", finished)
+	}
+
+	// 被中断的条目恢复为待处理，不记录失败
+	var running int64
+	s.DB.Model(&models.BatchItem{}).Where("job_id = ? AND status = ?", job.ID, BatchRunning).Count(&running)
+	if running != 0 || finished.Failed != 0 {
+		t.Errorf("running items = %d, failed = %d", running, finished.Failed)
+	}
+	if _, err := s.CancelBatchJob(job.ID, 1); err == nil {
+		t.Error("cancelling a finished job succeeded")
+	}
+	if err := s.WriteBatchResults(job.ID, 1, &buf); err != nil {
+		t.Errorf("WriteBatchResults() after cancel error = %v", err)
+	}
+
+	if err := s.DeleteBatchJob(job.ID, 1); err != nil {
+		t.Fatalf("DeleteBatchJob() error = %v", err)
+	}
+	if _, err := s.GetBatchJob(job.ID, 1); err == nil {
+		t.Error("deleted job still exists")
+	}
+}
+
+func TestCreateBatchJobValidation(t *testing.T) {
+	s := newTestService(t, map[string]*MockModel{"mock-batch-validate": NewMockModel()})
+	aiConfig := createBatchConfig(t, s, "mock-batch-validate")
+
+	other := models.KnowledgeFile{UserID: 2, FileName: "other.md", Status: "completed"}
+	s.DB.Create(&other)
+	if _, err := s.CreateBatchJob(1, "job", aiConfig, []uint{other.ID}, []BatchPrompt{{Prompt: "你好"}}); err == nil {
+		t.Error("CreateBatchJob() with another user's knowledge succeeded")
+	}
+
+	prompts := make([]BatchPrompt, batchMaxItems()+1)
+	if _, err := s.CreateBatchJob(1, "job", aiConfig, nil, prompts); err == nil || !strings.Contains(err.Error(), "最多") {
+		t.Errorf("CreateBatchJob() with too many prompts error = %v", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{interval: 30 * time.Millisecond}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("3 requests took %v, want at least 60ms", elapsed)
+	}
+
+	// 暂停期间不放行请求
+	limiter.pause(80 * time.Millisecond)
+	start = time.Now()
+	if err := limiter.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("wait after pause took %v", elapsed)
+	}
+}
+
+func TestResumeBatchJobs(t *testing.T) {
+	s := newTestService(t, map[string]*MockModel{"mock-batch-resume": NewMockModel()})
+	aiConfig := createBatchConfig(t, s, "mock-batch-resume")
+
+	// 模拟上次退出时正在处理的任务
+	job := models.BatchJob{UserID: 1, Name: "resume", AIConfigID: aiConfig.ID, Status: BatchRunning, Total: 2}
+	s.DB.Create(&job)
+	s.DB.Create(&[]models.BatchItem{
+		{JobID: job.ID, Line: 1, Prompt: "一", Status: BatchRunning, Attempts: 1},
+		{JobID: job.ID, Line: 2, Prompt: "二", Status: BatchPending},
+	})
+
+	s.ResumeBatchJobs()
+	finished := waitBatchJob(t, s, job.ID)
+	if finished.Status != BatchCompleted || finished.Succeeded != 2 {
+		t.Fatalf("job = %+v", finished)
+	}
+}
+
+func TestBatchJobKeepsRunningWhenItemsUnavailable(t *testing.T) {
+	s := newTestService(t, map[string]*MockModel{"mock-batch-db-error": NewMockModel()})
+	aiConfig := createBatchConfig(t, s, "mock-batch-db-error")
+	job := models.BatchJob{UserID: 1, Name: "db error", AIConfigID: aiConfig.ID, Status: BatchPending, Total: 1}
+	s.DB.Create(&job)
+
+	// 查询待处理条目失败时不能把任务标记为已完成
+	if err := s.DB.Migrator().DropTable(&models.BatchItem{}); err != nil {
+		t.Fatal(err)
+	}
+	s.runBatchJob(context.Background(), job.ID)
+
+	var saved models.BatchJob
+	s.DB.First(&saved, job.ID)
+	if saved.Status != BatchRunning || saved.FinishedAt != nil {
+		t.Errorf("job = %+v", saved)
+	}
+}
+
+func TestBatchRunnerCachesQuota(t *testing.T) {
+	setQuota(t, config.QuotaConfig{DailyTokens: 1000})
+	s := newTestService(t, map[string]*MockModel{"mock-batch-quota": NewMockModel()})
+	runner := &batchItemRunner{s: s, aiConfig: models.AIConfig{UserID: 1, Provider: "mock-batch-quota", ModelName: "mock-echo"}}
+	if err := runner.checkQuota(); err != nil {
+		t.Fatalf("checkQuota() error = %v", err)
+	}
+
+	// 缓存期间不重新查询用量
+	s.DB.Create(&models.UsageDaily{UserID: 1, Date: time.Now().Format(UsageDateLayout), Provider: "mock-batch-quota", ModelName: "mock-echo", Requests: 1, PromptTokens: 2000})
+	if err := runner.checkQuota(); err != nil {
+		t.Errorf("cached checkQuota() error = %v", err)
+	}
+
+	runner.quotaCheckedAt = time.Now().Add(-batchQuotaCheckInterval)
+	if _, ok := AsQuotaError(runner.checkQuota()); !ok {
+		t.Error("checkQuota() after the interval did not report the exceeded quota")
+	}
+}
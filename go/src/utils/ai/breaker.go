@@ -0,0 +1,329 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // 正常放行请求
+	BreakerOpen     BreakerState = "open"      // 熔断中，请求立即失败
+	BreakerHalfOpen BreakerState = "half_open" // 放行一个探测请求，成功后恢复
+)
+
+// BreakerPolicy 定义熔断策略
+type BreakerPolicy struct {
+	FailureThreshold int           // 连续失败多少次后熔断
+	OpenTimeout      time.Duration // 熔断后多久放行探测请求
+	WindowSize       int           // 统计错误率和延迟使用的最近请求数
+}
+
+// DefaultBreakerPolicy 默认熔断策略，InitProviders会根据配置文件覆盖
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+	WindowSize:       100,
+}
+
+// circuitBreaker 单个提供商的熔断器，同时记录最近请求的错误率和延迟
+type circuitBreaker struct {
+	mu       sync.Mutex
+	provider string
+	userKey  bool // 是否为用户API密钥的熔断器
+	policy   BreakerPolicy
+
+	state               BreakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool // 半开状态下是否已有探测请求在进行
+
+	totalRequests int64
+	totalFailures int64
+	lastError     string
+	lastErrorAt   time.Time
+
+	// 最近WindowSize个请求的结果和延迟，环形缓冲
+	failures  []bool
+	latencies []time.Duration
+	next      int
+}
+
+// breakerRegistry 按提供商名称保存熔断器，用户API密钥的熔断器按提供商和密钥ID保存
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var breakers = &breakerRegistry{
+	breakers: make(map[string]*circuitBreaker),
+}
+
+// breakerFor 返回提供商服务端密钥的熔断器，不存在时使用默认策略创建
+func breakerFor(provider string) *circuitBreaker {
+	return breakers.get(provider, provider, false)
+}
+
+// credentialBreakerFor 返回用户API密钥的熔断器
+func credentialBreakerFor(provider string, credentialID uint) *circuitBreaker {
+	return breakers.get(fmt.Sprintf("%s/credential/%d", provider, credentialID), provider, true)
+}
+
+// get 按键返回熔断器，不存在时使用默认策略创建
+func (r *breakerRegistry) get(key, provider string, userKey bool) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[key]
+	if !ok {
+		b = &circuitBreaker{provider: provider, userKey: userKey, policy: DefaultBreakerPolicy, state: BreakerClosed}
+		r.breakers[key] = b
+	}
+	return b
+}
+
+// allow 判断是否放行请求并返回该请求是否为探测请求，熔断中返回ErrKindCircuitOpen类型的错误
+func (b *circuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		wait := b.policy.OpenTimeout - time.Since(b.openedAt)
+		if wait > 0 {
+			return false, b.openError(wait)
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, b.openError(0)
+		}
+	default:
+		return false, nil
+	}
+	b.probing = true
+	return true, nil
+}
+
+// openError 构造熔断中的错误
+func (b *circuitBreaker) openError(wait time.Duration) *ProviderError {
+	message := "提供商连续请求失败，正在等待探测请求恢复"
+	if wait > 0 {
+		message = fmt.Sprintf("提供商连续请求失败，已暂停请求，%d秒后重试", int(wait.Seconds()+0.999))
+	}
+	return &ProviderError{Provider: b.provider, Kind: ErrKindCircuitOpen, Message: message, RetryAfter: wait}
+}
+
+// record 记录请求结果，只有超时、网络错误和服务端错误计为失败
+// 主动取消的请求不计入统计，其他错误说明提供商可以正常响应，按成功处理
+func (b *circuitBreaker) record(probe bool, err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	failed := isBreakerFailure(err)
+	b.totalRequests++
+	if b.policy.WindowSize > 0 {
+		if len(b.failures) < b.policy.WindowSize {
+			b.failures = append(b.failures, failed)
+			b.latencies = append(b.latencies, latency)
+		} else {
+			b.failures[b.next] = failed
+			b.latencies[b.next] = latency
+		}
+		b.next = (b.next + 1) % b.policy.WindowSize
+	}
+
+	if !failed {
+		b.consecutiveFailures = 0
+		if probe && b.state == BreakerHalfOpen {
+			b.state = BreakerClosed
+		}
+		return
+	}
+
+	b.totalFailures++
+	b.consecutiveFailures++
+	b.lastError = err.Error()
+	b.lastErrorAt = time.Now()
+	if (probe && b.state == BreakerHalfOpen) || b.consecutiveFailures >= b.policy.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// markUserKey 标记使用用户API密钥的请求返回的提供商错误
+func (b *circuitBreaker) markUserKey(err error) error {
+	if providerErr, ok := AsProviderError(err); ok && b.userKey {
+		providerErr.UserKey = true
+	}
+	return err
+}
+
+// isBreakerFailure 判断错误是否说明提供商不可用
+func isBreakerFailure(err error) bool {
+	providerErr, ok := AsProviderError(err)
+	if !ok {
+		return false
+	}
+	switch providerErr.Kind {
+	case ErrKindServer, ErrKindTimeout, ErrKindNetwork:
+		return true
+	}
+	return false
+}
+
+// ProviderHealth 提供商的熔断状态和最近请求的统计
+type ProviderHealth struct {
+	Provider            string       `json:"provider"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	RetryInSeconds      int          `json:"retry_in_seconds,omitempty"` // 熔断中距离放行探测请求的秒数
+	TotalRequests       int64        `json:"total_requests"`
+	TotalFailures       int64        `json:"total_failures"`
+	WindowRequests      int          `json:"window_requests"` // 参与统计错误率和延迟的最近请求数
+	ErrorRate           float64      `json:"error_rate"`
+	LatencyP50MS        int64        `json:"latency_p50_ms"`
+	LatencyP95MS        int64        `json:"latency_p95_ms"`
+	LatencyP99MS        int64        `json:"latency_p99_ms"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time   `json:"last_error_at,omitempty"`
+}
+
+// health 返回熔断器的当前状态和统计
+func (b *circuitBreaker) health() ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	health := ProviderHealth{
+		Provider:            b.provider,
+		State:               b.state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		TotalRequests:       b.totalRequests,
+		TotalFailures:       b.totalFailures,
+		WindowRequests:      len(b.failures),
+		LastError:           b.lastError,
+	}
+	if b.state == BreakerOpen {
+		if wait := b.policy.OpenTimeout - time.Since(b.openedAt); wait > 0 {
+			health.RetryInSeconds = int(wait.Seconds() + 0.999)
+		}
+	}
+	if !b.lastErrorAt.IsZero() {
+		lastErrorAt := b.lastErrorAt
+		health.LastErrorAt = &lastErrorAt
+	}
+
+	if len(b.failures) == 0 {
+		return health
+	}
+	failures := 0
+	for _, failed := range b.failures {
+		if failed {
+			failures++
+		}
+	}
+	health.ErrorRate = float64(failures) / float64(len(b.failures))
+
+	latencies := append([]time.Duration(nil), b.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	health.LatencyP50MS = percentile(latencies, 0.50).Milliseconds()
+	health.LatencyP95MS = percentile(latencies, 0.95).Milliseconds()
+	health.LatencyP99MS = percentile(latencies, 0.99).Milliseconds()
+	return health
+}
+
+// percentile 使用最近秩法计算已排序数据的百分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(float64(len(sorted))*p+0.999) - 1
+	return sorted[min(max(index, 0), len(sorted)-1)]
+}
+
+// ProvidersHealth 按注册顺序返回所有提供商在本实例上的熔断状态和统计
+func ProvidersHealth() []ProviderHealth {
+	providers := ListProviders()
+	result := make([]ProviderHealth, 0, len(providers))
+	for _, p := range providers {
+		result = append(result, breakerFor(p.Name).health())
+	}
+	return result
+}
+
+// breakerModel 在模型请求外包装熔断器
+type breakerModel struct {
+	AIModel
+	breaker *circuitBreaker
+}
+
+// breakerListerModel 支持查询模型列表的熔断包装，查询模型列表不经过熔断器
+type breakerListerModel struct {
+	*breakerModel
+	lister ModelLister
+}
+
+// withBreaker 为模型实例包装熔断器
+func withBreaker(breaker *circuitBreaker, model AIModel) AIModel {
+	wrapped := &breakerModel{AIModel: model, breaker: breaker}
+	if lister, ok := model.(ModelLister); ok {
+		return &breakerListerModel{breakerModel: wrapped, lister: lister}
+	}
+	return wrapped
+}
+
+// setRetryPolicy 调整被包装模型的重试策略
+func (m *breakerModel) setRetryPolicy(policy RetryPolicy) {
+	if setter, ok := m.AIModel.(retryPolicySetter); ok {
+		setter.setRetryPolicy(policy)
+	}
+}
+
+// ChatCompletion 熔断中立即返回错误，否则发送请求并记录结果和延迟
+func (m *breakerModel) ChatCompletion(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	probe, err := m.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	response, err := m.AIModel.ChatCompletion(ctx, request)
+	m.breaker.record(probe, err, time.Since(start))
+	return response, m.breaker.markUserKey(err)
+}
+
+// StreamChatCompletion 熔断中立即返回错误，否则发送请求并记录结果，延迟按收到第一个数据块的时间计算
+func (m *breakerModel) StreamChatCompletion(ctx context.Context, request ChatCompletionRequest, callback func(chunk *ChatCompletionChunk)) error {
+	probe, err := m.breaker.allow()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	var firstChunk time.Duration
+	err = m.AIModel.StreamChatCompletion(ctx, request, func(chunk *ChatCompletionChunk) {
+		if firstChunk == 0 {
+			firstChunk = time.Since(start)
+		}
+		callback(chunk)
+	})
+	if firstChunk == 0 {
+		firstChunk = time.Since(start)
+	}
+	m.breaker.record(probe, err, firstChunk)
+	return m.breaker.markUserKey(err)
+}
+
+// ListModels 查询提供商的模型列表
+func (m *breakerListerModel) ListModels(ctx context.Context) ([]RemoteModel, error) {
+	remoteModels, err := m.lister.ListModels(ctx)
+	return remoteModels, m.breaker.markUserKey(err)
+}
@@ -0,0 +1,162 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"testing"
+	"time"
+)
+
+// setBreakerPolicy 设置提供商熔断器的策略
+func setBreakerPolicy(t *testing.T, provider string, policy BreakerPolicy) *circuitBreaker {
+	t.Helper()
+	b := breakerFor(provider)
+	b.mu.Lock()
+	b.policy = policy
+	b.mu.Unlock()
+	return b
+}
+
+// removeBreakers 移除提供商及其用户API密钥的熔断器，重复运行测试时重新开始统计
+func removeBreakers(provider string) {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	for key, b := range breakers.breakers {
+		if b.provider == provider {
+			delete(breakers.breakers, key)
+		}
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	model := NewMockModel()
+	model.Err = &ProviderError{Provider: "mock-breaker", Kind: ErrKindTimeout, Message: "timeout"}
+	newTestService(t, map[string]*MockModel{"mock-breaker": model})
+	setBreakerPolicy(t, "mock-breaker", BreakerPolicy{FailureThreshold: 3, OpenTimeout: 50 * time.Millisecond, WindowSize: 10})
+
+	aiModel, err := GetAIModel("mock-breaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := ChatCompletionRequest{Messages: []ChatMessage{{Role: "user", Content: "你好"}}}
+	for i := 0; i < 3; i++ {
+		if _, err := aiModel.ChatCompletion(context.Background(), request); err == nil {
+			t.Fatal("ChatCompletion() error = nil")
+		}
+	}
+
+	// 熔断后立即失败，不再请求提供商
+	_, err = aiModel.ChatCompletion(context.Background(), request)
+	providerErr, ok := AsProviderError(err)
+	if !ok || providerErr.Kind != ErrKindCircuitOpen || !IsRetryable(err) || HTTPStatus(err) != 503 {
+		t.Fatalf("error = %v, want retryable circuit_open", err)
+	}
+	if len(model.Requests()) != 3 {
+		t.Errorf("provider called %d times, want 3", len(model.Requests()))
+	}
+	if health := breakerFor("mock-breaker").health(); health.State != BreakerOpen || health.RetryInSeconds != 1 {
+		t.Errorf("health = %+v", health)
+	}
+
+	// 等待后放行探测请求，探测成功后恢复
+	time.Sleep(60 * time.Millisecond)
+	model.Err = nil
+	if _, err := aiModel.ChatCompletion(context.Background(), request); err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	health := breakerFor("mock-breaker").health()
+	if health.State != BreakerClosed || health.ConsecutiveFailures != 0 {
+		t.Errorf("health after probe = %+v", health)
+	}
+	if health.TotalRequests != 4 || health.TotalFailures != 3 || health.ErrorRate != 0.75 || health.LastError == "" {
+		t.Errorf("health stats = %+v", health)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := &circuitBreaker{provider: "half-open", policy: BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Millisecond, WindowSize: 10}, state: BreakerClosed}
+	failure := &ProviderError{Provider: "half-open", Kind: ErrKindServer, StatusCode: 502}
+
+	probe, _ := b.allow()
+	b.record(probe, failure, time.Millisecond)
+	if b.state != BreakerOpen {
+		t.Fatalf("state = %s, want open", b.state)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// 半开状态只放行一个探测请求
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow() = %v, %v, want probe", probe, err)
+	}
+	if _, err := b.allow(); err == nil {
+		t.Error("second request during probe was allowed")
+	}
+
+	// 探测失败后重新熔断
+	b.record(probe, failure, time.Millisecond)
+	if b.state != BreakerOpen {
+		t.Errorf("state after failed probe = %s, want open", b.state)
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	b := &circuitBreaker{provider: "client-errors", policy: BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute, WindowSize: 10}, state: BreakerClosed}
+
+	for i := 0; i < 5; i++ {
+		b.record(false, &ProviderError{Provider: "client-errors", Kind: ErrKindAuth, StatusCode: 401}, time.Millisecond)
+		b.record(false, &ProviderError{Provider: "client-errors", Kind: ErrKindRateLimit, StatusCode: 429}, time.Millisecond)
+		b.record(false, context.Canceled, time.Millisecond)
+	}
+	health := b.health()
+	if health.State != BreakerClosed || health.TotalRequests != 10 || health.ErrorRate != 0 {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestCircuitBreakerLatencyPercentiles(t *testing.T) {
+	b := &circuitBreaker{provider: "latency", policy: BreakerPolicy{FailureThreshold: 5, OpenTimeout: time.Minute, WindowSize: 100}, state: BreakerClosed}
+
+	// 窗口只保留最近100个请求
+	for i := 0; i < 10; i++ {
+		b.record(false, nil, time.Hour)
+	}
+	for i := 1; i <= 100; i++ {
+		b.record(false, nil, time.Duration(i)*time.Millisecond)
+	}
+	health := b.health()
+	if health.WindowRequests != 100 || health.TotalRequests != 110 {
+		t.Errorf("window = %d, total = %d", health.WindowRequests, health.TotalRequests)
+	}
+	if health.LatencyP50MS != 50 || health.LatencyP95MS != 95 || health.LatencyP99MS != 99 {
+		t.Errorf("percentiles = %d/%d/%d", health.LatencyP50MS, health.LatencyP95MS, health.LatencyP99MS)
+	}
+}
+
+func TestChatFallbackWhenCircuitOpen(t *testing.T) {
+	primary := NewMockModel()
+	primary.Err = &ProviderError{Provider: "mock-breaker-primary", Kind: ErrKindNetwork, Message: "connection refused"}
+	backup := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-breaker-primary": primary, "mock-breaker-backup": backup})
+	setBreakerPolicy(t, "mock-breaker-primary", BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute, WindowSize: 10})
+
+	backupConfig := models.AIConfig{UserID: 1, Provider: "mock-breaker-backup", ModelName: "mock-echo"}
+	if err := s.DB.Create(&backupConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	primaryConfig := models.AIConfig{UserID: 1, Provider: "mock-breaker-primary", ModelName: "mock-echo", FallbackConfigIDs: []uint{backupConfig.ID}}
+
+	for i := 0; i < 3; i++ {
+		reply, _, err := s.Chat(context.Background(), 1, 0, "你好", primaryConfig, nil, nil)
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		if reply.Provider != "mock-breaker-backup" {
+			t.Errorf("reply from %q", reply.Provider)
+		}
+	}
+	// 熔断后直接切换到备用配置，主提供商只被请求一次
+	if len(primary.Requests()) != 1 {
+		t.Errorf("primary called %d times, want 1", len(primary.Requests()))
+	}
+}
@@ -0,0 +1,239 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// 精确缓存在Redis中的键前缀，后接用户、密钥和请求的哈希
+	responseCachePrefix = "ai:cache:"
+	// 语义缓存在Redis中的键前缀，后接嵌入模型和上下文的哈希(同样包含用户和密钥)，值为问题向量列表
+	semanticCachePrefix = "ai:cache:semantic:"
+	// 每个上下文最多保留的语义缓存条目数
+	semanticCacheSize = 200
+	// 读写缓存的超时时间
+	cacheTimeout = 5 * time.Second
+
+	defaultCacheTTL          = time.Hour
+	defaultSemanticThreshold = 0.95
+)
+
+// cachedReply 缓存的模型回复
+type cachedReply struct {
+	Content          string `json:"content"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// semanticCacheEntry 语义缓存条目，记录问题向量及对应的精确缓存键
+type semanticCacheEntry struct {
+	Key      string    `json:"key"`
+	Question string    `json:"question"`
+	Vector   []float32 `json:"vector"`
+}
+
+// responseCache 一次对话的回复缓存，问题向量在查询和写入之间只计算一次
+// 缓存只在同一用户内共享，回复可能来自用户的知识库或用户自己的API密钥
+type responseCache struct {
+	userID   uint
+	messages []ChatMessage
+	vector   []float32
+	embedder Embedder
+}
+
+// newResponseCache 为构建好的请求消息创建回复缓存，未开启缓存或未配置Redis时返回nil
+func newResponseCache(userID uint, aiMessages []ChatMessage) *responseCache {
+	if !config.Config.AI.Cache.Enabled || global.RedisDB == nil {
+		return nil
+	}
+	return &responseCache{userID: userID, messages: aiMessages}
+}
+
+// cacheTTL 返回缓存有效期
+func cacheTTL() time.Duration {
+	if ttl := config.Config.AI.Cache.TTL; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return defaultCacheTTL
+}
+
+// hashRequest 计算用户、API密钥、提供商和请求的哈希
+func hashRequest(userID, credentialID uint, provider string, request ChatCompletionRequest) string {
+	data, _ := json.Marshal(struct {
+		UserID       uint                  `json:"user_id"`
+		CredentialID uint                  `json:"credential_id"`
+		Provider     string                `json:"provider"`
+		Request      ChatCompletionRequest `json:"request"`
+	}{userID, credentialID, provider, request})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheKeys 返回精确缓存键、语义缓存的上下文哈希和用于语义匹配的问题
+// 上下文为除最新用户消息外的全部请求内容，最新消息带图片时不做语义匹配
+func (s *AIService) cacheKeys(c *responseCache, aiConfig models.AIConfig) (string, string, string) {
+	request := s.newChatRequest(aiConfig, c.messages, 0)
+	key := responseCachePrefix + hashRequest(c.userID, aiConfig.CredentialID, aiConfig.Provider, request)
+
+	last := c.messages[len(c.messages)-1]
+	if !config.Config.AI.Cache.Semantic || last.Role != "user" || len(last.Parts) > 0 {
+		return key, "", ""
+	}
+	request.Messages = c.messages[:len(c.messages)-1]
+	return key, hashRequest(c.userID, aiConfig.CredentialID, aiConfig.Provider, request), last.Content
+}
+
+// questionVector 计算问题的嵌入向量，失败时返回nil
+func (c *responseCache) questionVector(ctx context.Context, question string) []float32 {
+	if c.vector != nil {
+		return c.vector
+	}
+	embedder, err := GetEmbedder()
+	if err != nil {
+		log.Printf("创建嵌入实例失败，跳过语义缓存: %v", err)
+		return nil
+	}
+	vectors, err := embedder.Embed(ctx, []string{question})
+	if err != nil || len(vectors) == 0 {
+		log.Printf("计算问题向量失败，跳过语义缓存: %v", err)
+		return nil
+	}
+	c.vector, c.embedder = vectors[0], embedder
+	return c.vector
+}
+
+// semanticKey 返回上下文对应的语义缓存键，包含嵌入模型以免混用不同模型的向量
+func (c *responseCache) semanticKey(contextHash string) string {
+	return semanticCachePrefix + c.embedder.Model() + ":" + contextHash
+}
+
+// lookupCache 查询配置对应的缓存回复，未命中或缓存不可用时返回nil
+// 先按完整请求精确匹配，开启语义缓存时再在相同上下文中查找最相似的问题
+func (s *AIService) lookupCache(ctx context.Context, c *responseCache, aiConfig models.AIConfig) *cachedReply {
+	if c == nil || aiConfig.EnableTools {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+
+	key, contextHash, question := s.cacheKeys(c, aiConfig)
+	if reply := getCachedReply(ctx, key); reply != nil || question == "" {
+		return reply
+	}
+
+	vector := c.questionVector(ctx, question)
+	if vector == nil {
+		return nil
+	}
+	items, err := global.RedisDB.LRange(ctx, c.semanticKey(contextHash), 0, -1).Result()
+	if err != nil {
+		log.Printf("查询语义缓存失败: %v", err)
+		return nil
+	}
+
+	threshold := config.Config.AI.Cache.Threshold
+	if threshold <= 0 {
+		threshold = defaultSemanticThreshold
+	}
+	best, bestScore := "", threshold
+	for _, item := range items {
+		var entry semanticCacheEntry
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			continue
+		}
+		if score := CosineSimilarity(vector, entry.Vector); score >= bestScore {
+			best, bestScore = entry.Key, score
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return getCachedReply(ctx, best)
+}
+
+// getCachedReply 读取精确缓存，不存在或读取失败时返回nil
+func getCachedReply(ctx context.Context, key string) *cachedReply {
+	data, err := global.RedisDB.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil
+	}
+	var reply cachedReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return nil
+	}
+	return &reply
+}
+
+// storeCache 缓存配置生成的完整回复，缓存失败只记录日志
+func (s *AIService) storeCache(ctx context.Context, c *responseCache, aiConfig models.AIConfig, message *models.ChatMessage) {
+	if c == nil || aiConfig.EnableTools || message.Interrupted || message.Content == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+
+	key, contextHash, question := s.cacheKeys(c, aiConfig)
+	data, _ := json.Marshal(cachedReply{Content: message.Content, ReasoningContent: message.ReasoningContent})
+	ttl := cacheTTL()
+	if err := global.RedisDB.Set(ctx, key, data, ttl).Err(); err != nil {
+		log.Printf("写入回复缓存失败: %v", err)
+		return
+	}
+	if question == "" {
+		return
+	}
+
+	vector := c.questionVector(ctx, question)
+	if vector == nil {
+		return
+	}
+	entry, _ := json.Marshal(semanticCacheEntry{Key: key, Question: question, Vector: vector})
+	semanticKey := c.semanticKey(contextHash)
+	pipe := global.RedisDB.TxPipeline()
+	pipe.LPush(ctx, semanticKey, entry)
+	pipe.LTrim(ctx, semanticKey, 0, semanticCacheSize-1)
+	pipe.Expire(ctx, semanticKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("写入语义缓存失败: %v", err)
+	}
+}
+
+// saveCachedReply 将命中的缓存回复保存为会话中的AI回复
+func (s *AIService) saveCachedReply(sessionID uint, aiConfig models.AIConfig, reply *cachedReply) (*models.ChatMessage, error) {
+	assistantMessage := models.ChatMessage{
+		SessionID:        sessionID,
+		Role:             "assistant",
+		Content:          reply.Content,
+		ReasoningContent: reply.ReasoningContent,
+		Provider:         aiConfig.Provider,
+		ModelName:        aiConfig.ModelName,
+		Cached:           true,
+		CreatedAt:        time.Now(),
+	}
+	if err := s.DB.Create(&assistantMessage).Error; err != nil {
+		return nil, err
+	}
+	return &assistantMessage, nil
+}
+
+// cachedChunks 将缓存的回复转换为流式数据块，与模型生成的回复走相同的输出流程
+func cachedChunks(reply *cachedReply) []*ChatCompletionChunk {
+	var chunks []*ChatCompletionChunk
+	if reply.ReasoningContent != "" {
+		chunks = append(chunks, &ChatCompletionChunk{
+			ID:      "cached",
+			Choices: []ChatCompletionChunkChoice{{Delta: ChatDelta{Role: "assistant", ReasoningContent: reply.ReasoningContent}}},
+		})
+	}
+	return append(chunks, &ChatCompletionChunk{
+		ID:      "cached",
+		Choices: []ChatCompletionChunkChoice{{Delta: ChatDelta{Role: "assistant", Content: reply.Content}, FinishReason: "stop"}},
+	})
+}
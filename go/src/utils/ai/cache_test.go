@@ -0,0 +1,171 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"testing"
+)
+
+// enableCache 开启回复缓存并启动测试用Redis，测试结束后恢复配置
+func enableCache(t *testing.T, semantic bool, threshold float64) {
+	t.Helper()
+	aitest.NewRedis(t)
+	previous := config.Config.AI.Cache
+	config.Config.AI.Cache.Enabled = true
+	config.Config.AI.Cache.Semantic = semantic
+	config.Config.AI.Cache.Threshold = threshold
+	t.Cleanup(func() { config.Config.AI.Cache = previous })
+}
+
+func TestChatExactCache(t *testing.T) {
+	enableCache(t, false, 0)
+	model := NewMockModel(ChatMessage{Content: "请在设置页面重置密码", ReasoningContent: "用户询问重置密码"})
+	s := newTestService(t, map[string]*MockModel{"mock-cache": model})
+	aiConfig := models.AIConfig{Provider: "mock-cache", ModelName: "mock-echo", Temperature: 0.2}
+
+	first, _, err := s.Chat(context.Background(), 1, 0, "如何重置密码", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if first.Cached {
+		t.Error("first reply is marked as cached")
+	}
+
+	// 同一用户在另一个会话中完全相同的请求命中缓存，不再调用模型
+	second, session, err := s.Chat(context.Background(), 1, 0, "如何重置密码", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if !second.Cached || second.Content != first.Content || second.ReasoningContent != first.ReasoningContent {
+		t.Errorf("second reply = %+v", second)
+	}
+	if len(model.Requests()) != 1 {
+		t.Errorf("model called %d times, want 1", len(model.Requests()))
+	}
+	if messages := sessionMessages(t, s, session.ID); len(messages) != 2 || !messages[1].Cached {
+		t.Errorf("session messages = %+v", messages)
+	}
+
+	// 采样参数不同时不命中
+	aiConfig.Temperature = 0.9
+	third, _, err := s.Chat(context.Background(), 1, 0, "如何重置密码", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if third.Cached || len(model.Requests()) != 2 {
+		t.Errorf("reply with other temperature cached = %v, calls = %d", third.Cached, len(model.Requests()))
+	}
+}
+
+func TestStreamChatCacheHit(t *testing.T) {
+	enableCache(t, false, 0)
+	model := NewMockModel(ChatMessage{Content: "缓存的流式回复"})
+	s := newTestService(t, map[string]*MockModel{"mock-cache-stream": model})
+	aiConfig := models.AIConfig{Provider: "mock-cache-stream", ModelName: "mock-echo"}
+
+	if _, _, err := s.Chat(context.Background(), 1, 0, "你好", aiConfig, nil, nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var content, finishReason string
+	reply, _, err := s.StreamChat(context.Background(), 1, 0, "你好", aiConfig, nil, nil, func(chunk *ChatCompletionChunk) {
+		content += chunk.Choices[0].Delta.Content
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if !reply.Cached || content != "缓存的流式回复" || finishReason != "stop" {
+		t.Errorf("reply = %+v, streamed = %q, finish = %q", reply, content, finishReason)
+	}
+	if len(model.Requests()) != 1 {
+		t.Errorf("model called %d times, want 1", len(model.Requests()))
+	}
+}
+
+func TestChatSemanticCache(t *testing.T) {
+	enableCache(t, true, 0.8)
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-cache-semantic": model})
+	aiConfig := models.AIConfig{Provider: "mock-cache-semantic", ModelName: "mock-echo"}
+
+	if _, _, err := s.Chat(context.Background(), 1, 0, "公司的年假有多少天？", aiConfig, nil, nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	// 相似的问题命中语义缓存
+	similar, _, err := s.Chat(context.Background(), 1, 0, "公司的年假有多少天", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if !similar.Cached || similar.Content != "echo: 公司的年假有多少天？" {
+		t.Errorf("similar reply = %+v", similar)
+	}
+
+	// 不相关的问题仍然调用模型
+	other, _, err := s.Chat(context.Background(), 1, 0, "明天会下雨吗", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if other.Cached || len(model.Requests()) != 2 {
+		t.Errorf("unrelated reply cached = %v, calls = %d", other.Cached, len(model.Requests()))
+	}
+}
+
+func TestChatCacheIsolatedPerUser(t *testing.T) {
+	enableCache(t, true, 0.8)
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-cache-users": model})
+	aiConfig := models.AIConfig{Provider: "mock-cache-users", ModelName: "mock-echo"}
+
+	if _, _, err := s.Chat(context.Background(), 1, 0, "公司的年假有多少天？", aiConfig, nil, nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	// 其他用户完全相同或相似的问题都不命中
+	for userID, question := range map[uint]string{2: "公司的年假有多少天？", 3: "公司的年假有多少天"} {
+		reply, _, err := s.Chat(context.Background(), userID, 0, question, aiConfig, nil, nil)
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		if reply.Cached {
+			t.Errorf("user %d got user 1's cached reply for %q", userID, question)
+		}
+	}
+	if len(model.Requests()) != 3 {
+		t.Errorf("model called %d times, want 3", len(model.Requests()))
+	}
+
+	// 同一用户换用自己的API密钥时，精确缓存和语义缓存的键都不同
+	cache := newResponseCache(1, []ChatMessage{{Role: "system", Content: "你是助手"}, {Role: "user", Content: "你好"}})
+	key, contextHash, _ := s.cacheKeys(cache, aiConfig)
+	aiConfig.CredentialID = 1
+	byoKey, byoContextHash, _ := s.cacheKeys(cache, aiConfig)
+	if key == byoKey || contextHash == byoContextHash {
+		t.Error("cache keys are shared between the server key and a user credential")
+	}
+}
+
+func TestChatCacheSkipsTools(t *testing.T) {
+	enableCache(t, false, 0)
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-cache-tools": model})
+	aiConfig := models.AIConfig{Provider: "mock-cache-tools", ModelName: "mock-echo", EnableTools: true}
+
+	for i := 0; i < 2; i++ {
+		reply, _, err := s.Chat(context.Background(), 1, 0, "现在几点", aiConfig, nil, nil)
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		if reply.Cached {
+			t.Error("reply with tools enabled is cached")
+		}
+	}
+	if len(model.Requests()) != 2 {
+		t.Errorf("model called %d times, want 2", len(model.Requests()))
+	}
+}
@@ -0,0 +1,167 @@
+package ai
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// Calculate 计算数学表达式，支持 + - * / % ^、一元正负号和括号
+func Calculate(expression string) (float64, error) {
+	p := &exprParser{input: []rune(expression)}
+	value, err := p.parseExpr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("表达式在位置%d处存在无法识别的字符: %q", p.pos, p.input[p.pos])
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("计算结果无效")
+	}
+	return value, nil
+}
+
+// exprParser 递归下降的表达式解析器
+type exprParser struct {
+	input []rune
+	pos   int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek 返回下一个非空白字符
+func (p *exprParser) peek() rune {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// parseExpr 解析加减法
+func (p *exprParser) parseExpr() (float64, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+// parseTerm 解析乘除和取模
+func (p *exprParser) parseTerm() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("除数不能为0")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("除数不能为0")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+// parseUnary 解析一元正负号
+func (p *exprParser) parseUnary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// parsePower 解析乘方，右结合
+func (p *exprParser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exponent), nil
+	}
+	return base, nil
+}
+
+// parsePrimary 解析数字和括号
+func (p *exprParser) parsePrimary() (float64, error) {
+	ch := p.peek()
+	if ch == '(' {
+		p.pos++
+		value, err := p.parseExpr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("缺少右括号")
+		}
+		p.pos++
+		return value, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, fmt.Errorf("表达式不完整")
+		}
+		return 0, fmt.Errorf("表达式在位置%d处存在无法识别的字符: %q", p.pos, p.input[p.pos])
+	}
+	value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("无效的数字: %s", string(p.input[start:p.pos]))
+	}
+	return value, nil
+}
@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"fmt"
+)
+
+// ModelCapabilities 描述模型的上下文长度、参数限制和支持的功能
+type ModelCapabilities struct {
+	Description     string  `json:"description"`       // 模型说明
+	ContextLength   int     `json:"context_length"`    // 上下文长度(token)
+	MaxOutputTokens int     `json:"max_output_tokens"` // 单次回复的最大token数，0表示只受上下文长度限制(需为输入保留minInputTokens)
+	MaxTemperature  float64 `json:"max_temperature"`   // 温度参数上限
+	MaxStop         int     `json:"-"`                 // 停止序列的最大数量
+	Sampling        bool    `json:"-"`                 // 是否支持top_p、presence_penalty、frequency_penalty等采样参数
+	JSONMode        bool    `json:"json_mode"`         // 是否支持response_format为json_object
+	Vision          bool    `json:"vision"`            // 是否支持图片输入
+	Tools           bool    `json:"tools"`             // 是否支持工具调用
+	Reasoning       bool    `json:"reasoning"`         // 是否返回思维链
+}
+
+// 上下文中至少为系统提示词、历史和知识库保留的token数，max_tokens不能占满上下文
+const minInputTokens = 1024
+
+// 未知模型的默认能力，按OpenAI兼容接口的通用范围校验
+var defaultCapabilities = ModelCapabilities{
+	ContextLength:  8192,
+	MaxTemperature: 2,
+	MaxStop:        4,
+	Sampling:       true,
+	JSONMode:       true,
+	Tools:          true,
+}
+
+// 已知模型的能力表
+var modelCapabilities = map[string]ModelCapabilities{
+	"deepseek-chat": {Description: "基础模型", ContextLength: 65536, MaxOutputTokens: 8192, MaxTemperature: 2, MaxStop: 16, Sampling: true, JSONMode: true, Tools: true},
+	// 推理模型会忽略采样参数，也不支持JSON输出和工具调用
+	"deepseek-reasoner": {Description: "深度思考模型", ContextLength: 65536, MaxOutputTokens: 8192, MaxTemperature: 2, MaxStop: 16, Reasoning: true},
+	// Moonshot的温度范围为[0, 1]，max_tokens只受上下文长度限制
+	"moonshot-v1-8k":   {Description: "基础模型，支持8K上下文", ContextLength: 8192, MaxTemperature: 1, MaxStop: 5, Sampling: true, JSONMode: true, Tools: true},
+	"moonshot-v1-32k":  {Description: "基础模型，支持32K上下文", ContextLength: 32768, MaxTemperature: 1, MaxStop: 5, Sampling: true, JSONMode: true, Tools: true},
+	"moonshot-v1-128k": {Description: "基础模型，支持128K上下文", ContextLength: 131072, MaxTemperature: 1, MaxStop: 5, Sampling: true, JSONMode: true, Tools: true},
+	"moonshot-v1-auto": {Description: "自动选择模型，根据上下文长度", ContextLength: 131072, MaxTemperature: 1, MaxStop: 5, Sampling: true, JSONMode: true, Tools: true},
+	// Moonshot视觉模型
+	"moonshot-v1-8k-vision-preview":   {Description: "视觉模型，支持图片输入和8K上下文", ContextLength: 8192, MaxTemperature: 1, MaxStop: 5, Sampling: true, Vision: true},
+	"moonshot-v1-32k-vision-preview":  {Description: "视觉模型，支持图片输入和32K上下文", ContextLength: 32768, MaxTemperature: 1, MaxStop: 5, Sampling: true, Vision: true},
+	"moonshot-v1-128k-vision-preview": {Description: "视觉模型，支持图片输入和128K上下文", ContextLength: 131072, MaxTemperature: 1, MaxStop: 5, Sampling: true, Vision: true},
+	"kimi-latest":                     {Description: "Kimi最新模型，支持图片输入和128K上下文", ContextLength: 131072, MaxTemperature: 1, MaxStop: 5, Sampling: true, JSONMode: true, Vision: true, Tools: true},
+}
+
+// Capabilities 返回模型的能力，未知模型返回默认能力
+func Capabilities(model string) ModelCapabilities {
+	capabilities, _ := lookupCapabilities(model)
+	return capabilities
+}
+
+// lookupCapabilities 返回模型的能力以及该模型是否在能力表中
+func lookupCapabilities(model string) (ModelCapabilities, bool) {
+	if capabilities, ok := modelCapabilities[model]; ok {
+		return capabilities, true
+	}
+	return defaultCapabilities, false
+}
+
+// maxOutputTokens 返回单次回复允许的最大token数，上下文中至少保留minInputTokens给输入
+func (c ModelCapabilities) maxOutputTokens() int {
+	limit := c.ContextLength - minInputTokens
+	if c.MaxOutputTokens > 0 {
+		return min(c.MaxOutputTokens, limit)
+	}
+	return limit
+}
+
+// ValidateSamplingParams 按模型能力校验AI配置中的采样参数
+// top_p为0表示未设置，请求中不发送，由提供商使用默认值
+func ValidateSamplingParams(config models.AIConfig) error {
+	capabilities := Capabilities(config.ModelName)
+
+	if config.Temperature < 0 || config.Temperature > capabilities.MaxTemperature {
+		return fmt.Errorf("模型%s的temperature取值范围为[0, %g]", config.ModelName, capabilities.MaxTemperature)
+	}
+	if maxTokens := capabilities.maxOutputTokens(); config.MaxTokens < 1 || config.MaxTokens > maxTokens {
+		return fmt.Errorf("模型%s的max_tokens取值范围为[1, %d]", config.ModelName, maxTokens)
+	}
+	if config.TopP < 0 || config.TopP > 1 {
+		return fmt.Errorf("top_p取值范围为(0, 1]，0表示使用默认值")
+	}
+	if config.PresencePenalty < -2 || config.PresencePenalty > 2 {
+		return fmt.Errorf("presence_penalty取值范围为[-2, 2]")
+	}
+	if config.FrequencyPenalty < -2 || config.FrequencyPenalty > 2 {
+		return fmt.Errorf("frequency_penalty取值范围为[-2, 2]")
+	}
+	if len(config.Stop) > capabilities.MaxStop {
+		return fmt.Errorf("模型%s最多支持%d个停止序列", config.ModelName, capabilities.MaxStop)
+	}
+	for _, stop := range config.Stop {
+		if stop == "" {
+			return fmt.Errorf("停止序列不能为空")
+		}
+	}
+
+	switch config.ResponseFormat {
+	case "", "text":
+	case "json_object":
+		if !capabilities.JSONMode {
+			return fmt.Errorf("模型%s不支持JSON输出", config.ModelName)
+		}
+	default:
+		return fmt.Errorf("不支持的response_format: %s", config.ResponseFormat)
+	}
+
+	return nil
+}
+
+// filterChain 返回调用链中满足能力要求的配置，保持原有顺序
+func filterChain(chain []models.AIConfig, supports func(ModelCapabilities) bool) []models.AIConfig {
+	var filtered []models.AIConfig
+	for _, cfg := range chain {
+		if supports(Capabilities(cfg.ModelName)) {
+			filtered = append(filtered, cfg)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,87 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"testing"
+)
+
+func TestValidateSamplingParams(t *testing.T) {
+	seed := 42
+	tests := []struct {
+		name    string
+		config  models.AIConfig
+		wantErr bool
+	}{
+		{"deepseek allows temperature 2", models.AIConfig{ModelName: "deepseek-chat", Temperature: 2, MaxTokens: 8192}, false},
+		{"deepseek output limit", models.AIConfig{ModelName: "deepseek-chat", Temperature: 1, MaxTokens: 8193}, true},
+		{"moonshot temperature limit", models.AIConfig{ModelName: "moonshot-v1-8k", Temperature: 1.5, MaxTokens: 1024}, true},
+		{"moonshot output within context", models.AIConfig{ModelName: "moonshot-v1-32k", Temperature: 0.3, MaxTokens: 16384}, false},
+		{"moonshot output leaves room for input", models.AIConfig{ModelName: "moonshot-v1-8k", Temperature: 0.3, MaxTokens: 8192}, true},
+		{"missing max tokens", models.AIConfig{ModelName: "deepseek-chat"}, true},
+		{"top_p out of range", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, TopP: 1.2}, true},
+		{"top_p zero means unset", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, TopP: 0}, false},
+		{"top_p upper bound", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, TopP: 1}, false},
+		{"negative top_p", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, TopP: -0.1}, true},
+		{"penalty out of range", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, PresencePenalty: -2.5}, true},
+		{"too many stop sequences", models.AIConfig{ModelName: "unknown-model", MaxTokens: 1024, Stop: []string{"a", "b", "c", "d", "e"}}, true},
+		{"empty stop sequence", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, Stop: []string{""}}, true},
+		{"reasoner has no json mode", models.AIConfig{ModelName: "deepseek-reasoner", MaxTokens: 1024, ResponseFormat: "json_object"}, true},
+		{"unknown response format", models.AIConfig{ModelName: "deepseek-chat", MaxTokens: 1024, ResponseFormat: "xml"}, true},
+		{
+			"all params",
+			models.AIConfig{
+				ModelName: "deepseek-chat", Temperature: 0.7, MaxTokens: 2048, TopP: 0.9, PresencePenalty: 0.5,
+				FrequencyPenalty: -0.5, Stop: []string{"\n\n"}, Seed: &seed, ResponseFormat: "json_object",
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSamplingParams(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSamplingParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewChatRequestSamplingParams(t *testing.T) {
+	seed := 7
+	s := &AIService{}
+	config := models.AIConfig{
+		ModelName: "deepseek-chat", Temperature: 0.5, MaxTokens: 512, TopP: 0.8, FrequencyPenalty: 0.3,
+		Stop: []string{"END"}, Seed: &seed, ResponseFormat: "json_object",
+	}
+
+	request := s.newChatRequest(config, nil, 0)
+	if request.TopP != 0.8 || request.FrequencyPenalty != 0.3 || request.Seed == nil || *request.Seed != 7 {
+		t.Errorf("request = %+v", request)
+	}
+	if len(request.Stop) != 1 || request.ResponseFormat == nil || request.ResponseFormat.Type != "json_object" {
+		t.Errorf("Stop = %v, ResponseFormat = %+v", request.Stop, request.ResponseFormat)
+	}
+
+	// 推理模型不发送采样参数
+	config.ModelName = "deepseek-reasoner"
+	config.ResponseFormat = "text"
+	request = s.newChatRequest(config, nil, 0)
+	if request.TopP != 0 || request.FrequencyPenalty != 0 || request.ResponseFormat != nil {
+		t.Errorf("reasoner request = %+v", request)
+	}
+}
+
+func TestContextBudgetKeepsRoomForInput(t *testing.T) {
+	// 旧配置的max_tokens占满上下文时，仍为历史和知识库保留预算
+	model, budget := contextBudget([]models.AIConfig{{ModelName: "moonshot-v1-8k", MaxTokens: 8192}})
+	if model != "moonshot-v1-8k" || budget != minInputTokens*9/10 {
+		t.Errorf("contextBudget() = %s, %d, want %d", model, budget, minInputTokens*9/10)
+	}
+
+	// 预算取调用链中最小的配置
+	model, budget = contextBudget([]models.AIConfig{{ModelName: "deepseek-chat", MaxTokens: 4096}, {ModelName: "moonshot-v1-32k", MaxTokens: 1024}})
+	if model != "moonshot-v1-32k" || budget != (32768-1024)*9/10 {
+		t.Errorf("contextBudget() = %s, %d", model, budget)
+	}
+}
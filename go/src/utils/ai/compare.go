@@ -0,0 +1,111 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// 一次对比的最少和最多AI配置数
+	MinCompareConfigs = 2
+	MaxCompareConfigs = 4
+)
+
+// ComparisonAnswer 对比中一个AI配置的回答结果
+type ComparisonAnswer struct {
+	Index    int                 // 配置在请求中的位置
+	AIConfig models.AIConfig     // 生成回答的AI配置
+	Message  *models.ChatMessage // 保存的候选回答，失败时为nil，中断时为部分回答
+	Err      error               // 生成失败或中断的原因
+}
+
+// CompareChat 将同一条消息并发发送给多个AI配置，各配置的回答保存为同一对比下的候选回答
+// callback收到各配置的流式数据块，done在每个配置结束时调用，两者不会并发执行
+// 对比不使用备用配置、回复缓存和工具调用；配额由调用方在打开流之前检查；返回对比ID和会话
+func (s *AIService) CompareChat(ctx context.Context, userID uint, sessionID uint, message string, configs []models.AIConfig, knowledgeIDs []uint, callback func(index int, chunk *ChatCompletionChunk), done func(answer ComparisonAnswer)) (string, *models.ChatSession, error) {
+	if len(configs) < MinCompareConfigs || len(configs) > MaxCompareConfigs {
+		return "", nil, fmt.Errorf("对比需要%d到%d个AI配置", MinCompareConfigs, MaxCompareConfigs)
+	}
+
+	// 多个模型并发调用工具会在会话中交错保存工具消息，对比时关闭工具调用
+	chain := make([]models.AIConfig, len(configs))
+	for i, cfg := range configs {
+		cfg.EnableTools = false
+		chain[i] = cfg
+	}
+
+	// 获取会话、构建请求消息并保存用户消息，请求消息按上下文窗口最小的配置裁剪
+	ctx, finish, session, aiMessages, err := s.prepareChat(ctx, userID, sessionID, message, knowledgeIDs, nil, chain)
+	if err != nil {
+		return "", nil, err
+	}
+	defer finish()
+
+	comparisonID := uuid.New().String()
+	env := ToolEnv{DB: s.DB, UserID: userID, KnowledgeIDs: knowledgeIDs}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, cfg := range chain {
+		wg.Add(1)
+		go func(index int, cfg models.AIConfig) {
+			defer wg.Done()
+			assistantMessage, err := s.streamWithConfig(ctx, env, session.ID, aiMessages, cfg, comparisonID, func(chunk *ChatCompletionChunk) {
+				mu.Lock()
+				defer mu.Unlock()
+				callback(index, chunk)
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			done(ComparisonAnswer{Index: index, AIConfig: cfg, Message: assistantMessage, Err: err})
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	return comparisonID, session, nil
+}
+
+// markCandidate 将回复标记为对比的候选回答，comparisonID为空时不做修改
+func markCandidate(message *models.ChatMessage, comparisonID string, aiConfig models.AIConfig) {
+	if comparisonID == "" {
+		return
+	}
+	message.ComparisonID = comparisonID
+	message.Candidate = true
+	message.AIConfigID = aiConfig.ID
+}
+
+// PickComparisonWinner 选择对比的胜者保留在历史中，同一对比的其他候选回答被删除
+func (s *AIService) PickComparisonWinner(sessionID, messageID, userID uint) (*models.ChatMessage, error) {
+	session, message, err := s.getOwnedMessage(sessionID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ComparisonID == "" || !message.Candidate {
+		return nil, fmt.Errorf("该消息不是待选择的对比回答")
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ? AND comparison_id = ? AND id <> ?", sessionID, message.ComparisonID, message.ID).Delete(&models.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(message).Update("candidate", false).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存对比结果失败: %v", err)
+	}
+
+	// 胜者成为会话最近使用的配置，后续摘要也使用该配置
+	s.updateLastMessage(session, message.Content)
+	if message.AIConfigID > 0 {
+		s.DB.Model(session).Update("ai_config_id", message.AIConfigID)
+	}
+
+	return message, nil
+}
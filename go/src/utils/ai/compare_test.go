@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"strings"
+	"testing"
+)
+
+// createCompareConfigs 为每个提供商创建一个属于用户1的AI配置
+func createCompareConfigs(t *testing.T, s *AIService, providers ...string) []models.AIConfig {
+	t.Helper()
+	var configs []models.AIConfig
+	for _, provider := range providers {
+		cfg := models.AIConfig{UserID: 1, Provider: provider, ModelName: "mock-echo", EnableTools: true}
+		if err := s.DB.Create(&cfg).Error; err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs
+}
+
+func TestCompareChatAndPickWinner(t *testing.T) {
+	first := NewMockModel(ChatMessage{Content: "第一个模型的回答"})
+	second := NewMockModel(ChatMessage{Content: "第二个模型的回答"})
+	s := newTestService(t, map[string]*MockModel{"mock-compare-a": first, "mock-compare-b": second})
+	configs := createCompareConfigs(t, s, "mock-compare-a", "mock-compare-b")
+
+	contents := make([]string, len(configs))
+	answers := make(map[int]ComparisonAnswer)
+	comparisonID, session, err := s.CompareChat(context.Background(), 1, 0, "哪个模型更好", configs, nil,
+		func(index int, chunk *ChatCompletionChunk) {
+			if len(chunk.Choices) > 0 {
+				contents[index] += chunk.Choices[0].Delta.Content
+			}
+		},
+		func(answer ComparisonAnswer) {
+			answers[answer.Index] = answer
+		})
+	if err != nil {
+		t.Fatalf("CompareChat() error = %v", err)
+	}
+	if comparisonID == "" {
+		t.Fatal("comparison id is empty")
+	}
+	if contents[0] != "第一个模型的回答" || contents[1] != "第二个模型的回答" {
+		t.Errorf("streamed contents = %q", contents)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("answers = %+v", answers)
+	}
+	for i, answer := range answers {
+		if answer.Err != nil || answer.Message == nil {
+			t.Fatalf("answer %d = %+v", i, answer)
+		}
+		if !answer.Message.Candidate || answer.Message.ComparisonID != comparisonID || answer.Message.AIConfigID != configs[i].ID {
+			t.Errorf("answer %d message = %+v", i, answer.Message)
+		}
+	}
+
+	// 对比时不开启工具调用
+	for _, model := range []*MockModel{first, second} {
+		requests := model.Requests()
+		if len(requests) != 1 || len(requests[0].Tools) > 0 {
+			t.Errorf("requests = %+v", requests)
+		}
+	}
+
+	// 选出胜者前候选回答不属于历史
+	if messages := sessionMessages(t, s, session.ID); len(messages) != 1 || messages[0].Role != "user" {
+		t.Errorf("history before pick = %+v", messages)
+	}
+	_, count, err := s.GetSessionMessages(session.ID, 1, 1, 20)
+	if err != nil || count != 3 {
+		t.Errorf("GetSessionMessages() count = %d, err = %v", count, err)
+	}
+
+	winner, err := s.PickComparisonWinner(session.ID, answers[1].Message.ID, 1)
+	if err != nil {
+		t.Fatalf("PickComparisonWinner() error = %v", err)
+	}
+	if winner.Candidate {
+		t.Error("winner is still a candidate")
+	}
+	messages := sessionMessages(t, s, session.ID)
+	if len(messages) != 2 || messages[1].ID != winner.ID || messages[1].Content != "第二个模型的回答" {
+		t.Errorf("history after pick = %+v", messages)
+	}
+	updated, err := s.GetSession(session.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.AIConfigID != configs[1].ID || updated.LastMessage != "第二个模型的回答" {
+		t.Errorf("session = %+v", updated)
+	}
+
+	// 已选出胜者后不能再次选择
+	if _, err := s.PickComparisonWinner(session.ID, winner.ID, 1); err == nil {
+		t.Error("picking a non-candidate message succeeded")
+	}
+}
+
+func TestCompareChatPartialFailure(t *testing.T) {
+	ok := NewMockModel(ChatMessage{Content: "正常回答"})
+	failing := NewMockModel()
+	failing.Err = &ProviderError{Provider: "mock-compare-fail", Kind: ErrKindAuth, Message: "invalid api key"}
+	s := newTestService(t, map[string]*MockModel{"mock-compare-ok": ok, "mock-compare-fail": failing})
+	configs := createCompareConfigs(t, s, "mock-compare-ok", "mock-compare-fail")
+
+	answers := make(map[int]ComparisonAnswer)
+	_, session, err := s.CompareChat(context.Background(), 1, 0, "你好", configs, nil,
+		func(int, *ChatCompletionChunk) {},
+		func(answer ComparisonAnswer) { answers[answer.Index] = answer })
+	if err != nil {
+		t.Fatalf("CompareChat() error = %v", err)
+	}
+	if answers[0].Err != nil || answers[0].Message == nil {
+		t.Errorf("successful answer = %+v", answers[0])
+	}
+	if answers[1].Err == nil || answers[1].Message != nil {
+		t.Errorf("failed answer = %+v", answers[1])
+	}
+
+	// 失败的配置不保存回答
+	_, count, err := s.GetSessionMessages(session.ID, 1, 1, 20)
+	if err != nil || count != 2 {
+		t.Errorf("GetSessionMessages() count = %d, err = %v", count, err)
+	}
+}
+
+func TestCompareChatValidation(t *testing.T) {
+	s := newTestService(t, map[string]*MockModel{"mock-compare-one": NewMockModel()})
+	configs := createCompareConfigs(t, s, "mock-compare-one")
+
+	_, _, err := s.CompareChat(context.Background(), 1, 0, "你好", configs, nil, func(int, *ChatCompletionChunk) {}, func(ComparisonAnswer) {})
+	if err == nil || !strings.Contains(err.Error(), "对比需要") {
+		t.Errorf("CompareChat() with one config error = %v", err)
+	}
+
+	// 普通回答不能作为对比胜者
+	reply, session, err := s.Chat(context.Background(), 1, 0, "你好", configs[0], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PickComparisonWinner(session.ID, reply.ID, 1); err == nil {
+		t.Error("picking a normal reply succeeded")
+	}
+	if _, err := s.PickComparisonWinner(session.ID, reply.ID, 2); err == nil {
+		t.Error("picking another user's message succeeded")
+	}
+}
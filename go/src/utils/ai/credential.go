@@ -0,0 +1,244 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+// 测试API密钥的超时时间
+const keyTestTimeout = 15 * time.Second
+
+// KeyTestResult 测试API密钥的结果
+type KeyTestResult struct {
+	OK         bool      `json:"ok"`
+	Kind       ErrorKind `json:"error_type,omitempty"`  // 失败时的错误类型
+	StatusCode int       `json:"status_code,omitempty"` // 提供商返回的HTTP状态码
+	Message    string    `json:"message,omitempty"`     // 失败时的错误信息
+	LatencyMS  int64     `json:"latency_ms"`            // 请求耗时(毫秒)
+}
+
+// newKeyCipher 使用服务端主密钥创建AES-256-GCM加密器
+func newKeyCipher() (cipher.AEAD, error) {
+	masterKey := config.Config.AI.MasterKey
+	if masterKey == "" {
+		return nil, fmt.Errorf("服务端未配置主密钥，无法使用自定义API密钥")
+	}
+
+	// 主密钥可以是任意长度的字符串，通过SHA-256派生出32字节的AES密钥
+	key := sha256.Sum256([]byte(masterKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptAPIKey 加密API密钥，返回随机nonce与密文拼接后的数据
+func EncryptAPIKey(apiKey string) ([]byte, error) {
+	gcm, err := newKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, []byte(apiKey), nil), nil
+}
+
+// DecryptAPIKey 解密EncryptAPIKey加密的数据
+func DecryptAPIKey(data []byte) (string, error) {
+	gcm, err := newKeyCipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("无效的密钥数据")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("解密API密钥失败，主密钥可能已更换: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// maskAPIKey 返回只保留首尾字符的密钥掩码
+func maskAPIKey(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return "****"
+	}
+	return apiKey[:3] + "..." + apiKey[len(apiKey)-4:]
+}
+
+// CreateCredential 加密保存用户的API密钥
+func (s *AIService) CreateCredential(userID uint, provider, name, apiKey string) (*models.ProviderCredential, error) {
+	if !HasProvider(provider) {
+		return nil, fmt.Errorf("不支持的AI提供商: %s", provider)
+	}
+
+	encrypted, err := EncryptAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := models.ProviderCredential{
+		UserID:       userID,
+		Provider:     provider,
+		Name:         name,
+		EncryptedKey: encrypted,
+		KeyHint:      maskAPIKey(apiKey),
+	}
+	if err := s.DB.Create(&credential).Error; err != nil {
+		return nil, fmt.Errorf("保存API密钥失败: %v", err)
+	}
+
+	return &credential, nil
+}
+
+// GetCredentials 获取用户的所有API密钥
+func (s *AIService) GetCredentials(userID uint) ([]models.ProviderCredential, error) {
+	var credentials []models.ProviderCredential
+	if err := s.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// GetCredential 获取单个API密钥
+func (s *AIService) GetCredential(credentialID, userID uint) (*models.ProviderCredential, error) {
+	var credential models.ProviderCredential
+	if err := s.DB.First(&credential, credentialID).Error; err != nil {
+		return nil, fmt.Errorf("API密钥不存在")
+	}
+
+	if credential.UserID != userID {
+		return nil, fmt.Errorf("无权访问此API密钥")
+	}
+
+	return &credential, nil
+}
+
+// DeleteCredential 删除API密钥，仍被AI配置使用时拒绝删除，避免配置静默切换为服务端密钥
+func (s *AIService) DeleteCredential(credentialID, userID uint) error {
+	credential, err := s.GetCredential(credentialID, userID)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.AIConfig{}).Where("credential_id = ?", credentialID).Count(&count).Error; err != nil {
+		return fmt.Errorf("查询AI配置失败: %v", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("该API密钥正被%d个AI配置使用，请先修改相关配置", count)
+	}
+
+	if err := s.DB.Delete(credential).Error; err != nil {
+		return fmt.Errorf("删除API密钥失败: %v", err)
+	}
+	return nil
+}
+
+// TestCredential 使用API密钥向提供商发起一次最小请求，并记录测试结果
+func (s *AIService) TestCredential(credentialID, userID uint) (*KeyTestResult, error) {
+	credential, err := s.GetCredential(credentialID, userID)
+	if err != nil {
+		return nil, err
+	}
+	apiKey, err := DecryptAPIKey(credential.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	aiModel, err := GetAIModelWithKey(credential.Provider, apiKey, credential.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyTestTimeout)
+	defer cancel()
+	result := testAPIKey(ctx, aiModel, credential.Provider)
+
+	status := "ok"
+	if !result.OK {
+		status = string(result.Kind)
+	}
+	now := time.Now()
+	s.DB.Model(credential).Updates(map[string]interface{}{"last_tested_at": now, "last_test_status": status})
+
+	return result, nil
+}
+
+// testAPIKey 优先查询模型列表，不支持时发送一个只生成1个token的聊天请求
+func testAPIKey(ctx context.Context, aiModel AIModel, provider string) *KeyTestResult {
+	start := time.Now()
+
+	var err error
+	if lister, ok := aiModel.(ModelLister); ok {
+		_, err = lister.ListModels(ctx)
+	} else {
+		request := ChatCompletionRequest{
+			Messages:  []ChatMessage{{Role: "user", Content: "ping"}},
+			MaxTokens: 1,
+		}
+		if p, ok := GetProvider(provider); ok && len(p.Models) > 0 {
+			request.Model = p.Models[0]
+		}
+		_, err = aiModel.ChatCompletion(ctx, request)
+	}
+
+	result := &KeyTestResult{OK: err == nil, LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Message = err.Error()
+		if providerErr, ok := AsProviderError(err); ok {
+			result.Kind = providerErr.Kind
+			result.StatusCode = providerErr.StatusCode
+			result.Message = providerErr.Message
+		}
+	}
+	return result
+}
+
+// validateCredential 校验AI配置引用的API密钥属于该用户且提供商一致
+func (s *AIService) validateCredential(userID, credentialID uint, provider string) error {
+	if credentialID == 0 {
+		return nil
+	}
+	credential, err := s.GetCredential(credentialID, userID)
+	if err != nil {
+		return err
+	}
+	if credential.Provider != provider {
+		return fmt.Errorf("API密钥属于提供商%s，与配置的提供商%s不一致", credential.Provider, provider)
+	}
+	return nil
+}
+
+// getAIModel 根据AI配置创建模型实例，配置了用户API密钥时使用该密钥代替服务端密钥
+func (s *AIService) getAIModel(aiConfig models.AIConfig) (AIModel, error) {
+	if aiConfig.CredentialID == 0 {
+		return GetAIModel(aiConfig.Provider)
+	}
+
+	credential, err := s.GetCredential(aiConfig.CredentialID, aiConfig.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if credential.Provider != aiConfig.Provider {
+		return nil, fmt.Errorf("API密钥属于提供商%s，与配置的提供商%s不一致", credential.Provider, aiConfig.Provider)
+	}
+	apiKey, err := DecryptAPIKey(credential.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	return GetAIModelWithKey(aiConfig.Provider, apiKey, credential.ID)
+}
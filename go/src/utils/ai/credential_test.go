@@ -0,0 +1,226 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai/aitest"
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setMasterKey 设置测试使用的服务端主密钥，测试结束后恢复
+func setMasterKey(t *testing.T, key string) {
+	t.Helper()
+	previous := config.Config.AI.MasterKey
+	config.Config.AI.MasterKey = key
+	t.Cleanup(func() { config.Config.AI.MasterKey = previous })
+}
+
+func TestEncryptAPIKey(t *testing.T) {
+	setMasterKey(t, "test-master-key")
+
+	first, err := EncryptAPIKey("sk-secret-1234")
+	if err != nil {
+		t.Fatalf("EncryptAPIKey() error = %v", err)
+	}
+	second, _ := EncryptAPIKey("sk-secret-1234")
+	if bytes.Equal(first, second) {
+		t.Error("ciphertexts of the same key should differ")
+	}
+	if bytes.Contains(first, []byte("sk-secret")) {
+		t.Error("ciphertext contains the plaintext key")
+	}
+
+	plain, err := DecryptAPIKey(first)
+	if err != nil || plain != "sk-secret-1234" {
+		t.Errorf("DecryptAPIKey() = %q, %v", plain, err)
+	}
+
+	// 篡改密文或更换主密钥后无法解密
+	tampered := append([]byte(nil), first...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := DecryptAPIKey(tampered); err == nil {
+		t.Error("DecryptAPIKey(tampered) error = nil")
+	}
+	setMasterKey(t, "another-master-key")
+	if _, err := DecryptAPIKey(first); err == nil {
+		t.Error("DecryptAPIKey() with another master key error = nil")
+	}
+
+	setMasterKey(t, "")
+	if _, err := EncryptAPIKey("sk-secret-1234"); err == nil {
+		t.Error("EncryptAPIKey() without master key error = nil")
+	}
+}
+
+func TestMaskAPIKey(t *testing.T) {
+	if got := maskAPIKey("sk-abcdefgh1234"); got != "sk-...1234" {
+		t.Errorf("maskAPIKey() = %q", got)
+	}
+	if got := maskAPIKey("short"); got != "****" {
+		t.Errorf("maskAPIKey(short) = %q", got)
+	}
+}
+
+func TestChatUsesUserCredential(t *testing.T) {
+	setMasterKey(t, "test-master-key")
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-user-key"
+	RegisterProvider(Provider{Name: "byok", BaseURL: server.URL, APIKey: "sk-server-key"})
+	s := NewAIService(aitest.NewDB(t))
+
+	// 服务端密钥被拒绝
+	aiConfig := models.AIConfig{UserID: 1, Provider: "byok", ModelName: "byok-chat", MaxTokens: 256}
+	if _, _, err := s.Chat(context.Background(), 1, 0, "你好", aiConfig, nil, nil); err == nil {
+		t.Fatal("Chat() with server key error = nil")
+	}
+
+	credential, err := s.CreateCredential(1, "byok", "我的密钥", "sk-user-key")
+	if err != nil {
+		t.Fatalf("CreateCredential() error = %v", err)
+	}
+	if credential.KeyHint != "sk-...-key" {
+		t.Errorf("KeyHint = %q", credential.KeyHint)
+	}
+
+	userConfig, err := s.CreateAIConfig(1, models.AIConfig{Provider: "byok", ModelName: "byok-chat", MaxTokens: 256, CredentialID: credential.ID})
+	if err != nil {
+		t.Fatalf("CreateAIConfig() error = %v", err)
+	}
+	reply, _, err := s.Chat(context.Background(), 1, 0, "你好", *userConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() with user key error = %v", err)
+	}
+	if reply.Content != "echo: 你好" {
+		t.Errorf("reply = %q", reply.Content)
+	}
+	requests := server.Requests()
+	if got := requests[len(requests)-1].Authorization; got != "Bearer sk-user-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+
+	// 密钥被使用时不能删除
+	if err := s.DeleteCredential(credential.ID, 1); err == nil {
+		t.Error("DeleteCredential() of a credential in use error = nil")
+	}
+}
+
+func TestUserCredentialErrors(t *testing.T) {
+	setMasterKey(t, "test-master-key")
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-server-key"
+	RegisterProvider(Provider{Name: "byok-errors", BaseURL: server.URL, APIKey: "sk-server-key", New: func(p Provider) AIModel {
+		model := NewOpenAICompatibleModel(p.Name, p.APIKey, p.BaseURL)
+		model.RetryPolicy = RetryPolicy{}
+		return model
+	}})
+	s := NewAIService(aitest.NewDB(t))
+	credential, err := s.CreateCredential(1, "byok-errors", "", "sk-revoked-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aiConfig := models.AIConfig{UserID: 1, Provider: "byok-errors", ModelName: "byok-chat", CredentialID: credential.ID}
+	request := ChatCompletionRequest{Model: "byok-chat", Messages: []ChatMessage{{Role: "user", Content: "你好"}}}
+
+	// 用户密钥被拒绝时返回4xx，而不是服务端账户问题的502
+	aiModel, err := s.getAIModel(aiConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = aiModel.ChatCompletion(context.Background(), request)
+	if status := HTTPStatus(err); status != http.StatusBadRequest || !strings.Contains(err.Error(), "拒绝了您的API密钥") {
+		t.Errorf("user key error = %v, status = %d", err, status)
+	}
+
+	// 用户密钥的失败不会让服务端密钥熔断
+	t.Cleanup(func() { removeBreakers("byok-errors") })
+	policy := BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute, WindowSize: 10}
+	setBreakerPolicy(t, "byok-errors", policy)
+	userBreaker := credentialBreakerFor("byok-errors", credential.ID)
+	userBreaker.mu.Lock()
+	userBreaker.policy = policy
+	userBreaker.mu.Unlock()
+
+	server.APIKey = ""
+	server.Failures = []aitest.Failure{{StatusCode: http.StatusServiceUnavailable}}
+	if _, err := aiModel.ChatCompletion(context.Background(), request); err == nil {
+		t.Fatal("ChatCompletion() error = nil")
+	}
+	_, err = aiModel.ChatCompletion(context.Background(), request)
+	if providerErr, ok := AsProviderError(err); !ok || providerErr.Kind != ErrKindCircuitOpen {
+		t.Errorf("user key error = %v, want circuit_open", err)
+	}
+	serverModel, err := GetAIModel("byok-errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serverModel.ChatCompletion(context.Background(), request); err != nil {
+		t.Errorf("server key ChatCompletion() error = %v", err)
+	}
+	if health := breakerFor("byok-errors").health(); health.State != BreakerClosed || health.TotalFailures != 0 {
+		t.Errorf("server key breaker = %+v", health)
+	}
+}
+
+func TestAIConfigCredentialValidation(t *testing.T) {
+	setMasterKey(t, "test-master-key")
+	s := newTestService(t, map[string]*MockModel{"cred-a": NewMockModel(), "cred-b": NewMockModel()})
+	credential, err := s.CreateCredential(1, "cred-a", "", "sk-aaaaaaaaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他用户的密钥
+	if _, err := s.CreateAIConfig(2, models.AIConfig{Provider: "cred-a", ModelName: "mock-echo", CredentialID: credential.ID}); err == nil {
+		t.Error("CreateAIConfig() with another user's credential error = nil")
+	}
+	// 提供商不一致
+	if _, err := s.CreateAIConfig(1, models.AIConfig{Provider: "cred-b", ModelName: "mock-echo", CredentialID: credential.ID}); err == nil {
+		t.Error("CreateAIConfig() with mismatched provider error = nil")
+	}
+	if _, err := s.CreateAIConfig(1, models.AIConfig{Provider: "cred-a", ModelName: "mock-echo", CredentialID: credential.ID}); err != nil {
+		t.Errorf("CreateAIConfig() error = %v", err)
+	}
+}
+
+func TestTestCredential(t *testing.T) {
+	setMasterKey(t, "test-master-key")
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-valid-key"
+	RegisterProvider(Provider{Name: "keytest", BaseURL: server.URL})
+	s := NewAIService(aitest.NewDB(t))
+
+	valid, _ := s.CreateCredential(1, "keytest", "", "sk-valid-key")
+	result, err := s.TestCredential(valid.ID, 1)
+	if err != nil {
+		t.Fatalf("TestCredential() error = %v", err)
+	}
+	if !result.OK {
+		t.Errorf("valid key result = %+v", result)
+	}
+
+	invalid, _ := s.CreateCredential(1, "keytest", "", "sk-wrong-key")
+	result, err = s.TestCredential(invalid.ID, 1)
+	if err != nil {
+		t.Fatalf("TestCredential() error = %v", err)
+	}
+	if result.OK || result.Kind != ErrKindAuth || result.StatusCode != http.StatusUnauthorized {
+		t.Errorf("invalid key result = %+v", result)
+	}
+
+	saved, _ := s.GetCredential(invalid.ID, 1)
+	if saved.LastTestStatus != string(ErrKindAuth) || saved.LastTestedAt == nil {
+		t.Errorf("saved test status = %q, tested at %v", saved.LastTestStatus, saved.LastTestedAt)
+	}
+
+	if _, err := s.TestCredential(valid.ID, 2); err == nil {
+		t.Error("TestCredential() by another user error = nil")
+	}
+}
@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// 模型列表在Redis中的缓存键前缀，后接提供商名称
+	modelCacheKeyPrefix = "ai:models:"
+	// 模型列表缓存时间
+	modelCacheTTL = time.Hour
+	// 查询单个提供商模型列表的超时时间，各提供商并发查询，也是整体查询的最长时间
+	modelDiscoveryTimeout = 5 * time.Second
+	// 查询失败后在该时间内直接使用本地配置，不再请求提供商
+	modelFailureTTL = time.Minute
+)
+
+// failedDiscoveries 记录本实例上查询模型列表失败的提供商及失败时间
+var failedDiscoveries = struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}{since: make(map[string]time.Time)}
+
+// ModelInfo 描述一个可用模型及其能力
+type ModelInfo struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	// Source 为remote表示提供商的 /v1/models 接口返回了该模型，为config表示只来自本地配置
+	Source string `json:"source"`
+	// Known 表示模型在本地能力表中，为false时能力为默认值
+	Known bool `json:"known"`
+	ModelCapabilities
+}
+
+// DiscoverModels 按提供商分组返回可用模型，合并本地配置的模型和提供商接口返回的模型
+// 各提供商并发查询，refresh为true时忽略缓存重新查询提供商
+func DiscoverModels(ctx context.Context, refresh bool) map[string][]ModelInfo {
+	result := make(map[string][]ModelInfo)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range ListProviders() {
+		wg.Add(1)
+		go func(p Provider) {
+			defer wg.Done()
+			infos := providerModels(ctx, p, refresh)
+			mu.Lock()
+			result[p.Name] = infos
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+	return result
+}
+
+// ValidateModel 校验模型是否属于提供商，提供商没有可用的模型列表时不做限制
+func ValidateModel(ctx context.Context, provider, model string) error {
+	p, ok := GetProvider(provider)
+	if !ok {
+		return fmt.Errorf("不支持的AI提供商: %s", provider)
+	}
+
+	infos := providerModels(ctx, p, false)
+	if len(infos) == 0 {
+		return nil
+	}
+	for _, info := range infos {
+		if info.Name == model {
+			return nil
+		}
+	}
+	return fmt.Errorf("提供商%s不支持模型: %s", provider, model)
+}
+
+// providerModels 合并提供商的配置模型和远程模型，配置中的模型排在前面
+func providerModels(ctx context.Context, p Provider, refresh bool) []ModelInfo {
+	remote := remoteModelIDs(ctx, p, refresh)
+
+	infos := make([]ModelInfo, 0, len(p.Models)+len(remote))
+	add := func(name, source string) {
+		capabilities, known := lookupCapabilities(name)
+		infos = append(infos, ModelInfo{Name: name, Provider: p.Name, Source: source, Known: known, ModelCapabilities: capabilities})
+	}
+	for _, name := range p.Models {
+		source := "config"
+		if slices.Contains(remote, name) {
+			source = "remote"
+		}
+		add(name, source)
+	}
+	for _, name := range remote {
+		if !slices.Contains(p.Models, name) {
+			add(name, "remote")
+		}
+	}
+	return infos
+}
+
+// remoteModelIDs 返回提供商接口返回的模型ID，优先读取Redis缓存，查询失败时返回nil
+// 查询不重试，失败后modelFailureTTL内不再请求该提供商，避免不可用的提供商拖慢配置的创建和校验
+func remoteModelIDs(ctx context.Context, p Provider, refresh bool) []string {
+	key := modelCacheKeyPrefix + p.Name
+	if !refresh {
+		if global.RedisDB != nil {
+			if data, err := global.RedisDB.Get(ctx, key).Bytes(); err == nil {
+				var ids []string
+				if err := json.Unmarshal(data, &ids); err == nil {
+					return ids
+				}
+			}
+		}
+		failedDiscoveries.mu.Lock()
+		since, failed := failedDiscoveries.since[p.Name]
+		failedDiscoveries.mu.Unlock()
+		if failed && time.Since(since) < modelFailureTTL {
+			return nil
+		}
+	}
+
+	lister, ok := withoutRetry(p.newModel()).(ModelLister)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, modelDiscoveryTimeout)
+	defer cancel()
+
+	remote, err := lister.ListModels(ctx)
+	failedDiscoveries.mu.Lock()
+	if err != nil {
+		failedDiscoveries.since[p.Name] = time.Now()
+	} else {
+		delete(failedDiscoveries.since, p.Name)
+	}
+	failedDiscoveries.mu.Unlock()
+	if err != nil {
+		log.Printf("查询提供商%s的模型列表失败，%v内使用本地配置: %v", p.Name, modelFailureTTL, err)
+		return nil
+	}
+
+	ids := make([]string, 0, len(remote))
+	for _, m := range remote {
+		if m.ID != "" && !slices.Contains(ids, m.ID) {
+			ids = append(ids, m.ID)
+		}
+	}
+
+	if global.RedisDB != nil {
+		data, _ := json.Marshal(ids)
+		if err := global.RedisDB.Set(ctx, key, data, modelCacheTTL).Err(); err != nil {
+			log.Printf("缓存提供商%s的模型列表失败: %v", p.Name, err)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"net/http"
+	"testing"
+)
+
+// registerDiscoveryProvider 注册指向测试服务器的提供商
+func registerDiscoveryProvider(name, apiKey string, server *aitest.Server, configured []string) {
+	RegisterProvider(Provider{Name: name, BaseURL: server.URL, APIKey: apiKey, Models: configured, New: func(p Provider) AIModel {
+		model := NewOpenAICompatibleModel(p.Name, p.APIKey, p.BaseURL)
+		model.RetryPolicy = fastRetryPolicy
+		return model
+	}})
+}
+
+// discover 查询单个提供商的模型，避免请求其他测试注册的提供商
+func discover(t *testing.T, name string, refresh bool) []ModelInfo {
+	t.Helper()
+	p, ok := GetProvider(name)
+	if !ok {
+		t.Fatalf("provider %q is not registered", name)
+	}
+	return providerModels(context.Background(), p, refresh)
+}
+
+func TestDiscoverModelsMergesRemoteModels(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-test"
+	server.Models = []string{"deepseek-chat", "deepseek-v9"}
+	registerDiscoveryProvider("discover-merge", "sk-test", server, []string{"deepseek-chat", "deepseek-reasoner"})
+
+	infos := discover(t, "discover-merge", false)
+	if len(infos) != 3 {
+		t.Fatalf("len(infos) = %d, want 3: %+v", len(infos), infos)
+	}
+
+	want := []struct {
+		name   string
+		source string
+		known  bool
+	}{
+		{"deepseek-chat", "remote", true},
+		{"deepseek-reasoner", "config", true},
+		{"deepseek-v9", "remote", false},
+	}
+	for i, w := range want {
+		info := infos[i]
+		if info.Name != w.name || info.Source != w.source || info.Known != w.known {
+			t.Errorf("infos[%d] = %+v, want %+v", i, info, w)
+		}
+	}
+	if !infos[0].Tools || infos[0].ContextLength != 65536 {
+		t.Errorf("deepseek-chat capabilities = %+v", infos[0].ModelCapabilities)
+	}
+	if !infos[1].Reasoning || infos[1].Tools {
+		t.Errorf("deepseek-reasoner capabilities = %+v", infos[1].ModelCapabilities)
+	}
+	if infos[2].ContextLength != defaultCapabilities.ContextLength {
+		t.Errorf("unknown model capabilities = %+v", infos[2].ModelCapabilities)
+	}
+}
+
+func TestDiscoverModelsUsesRedisCache(t *testing.T) {
+	mr := aitest.NewRedis(t)
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Models = []string{"qwen-max"}
+	registerDiscoveryProvider("discover-cache", "", server, nil)
+
+	discover(t, "discover-cache", false)
+	if !mr.Exists(modelCacheKeyPrefix + "discover-cache") {
+		t.Fatal("model list was not cached")
+	}
+	if ttl := mr.TTL(modelCacheKeyPrefix + "discover-cache"); ttl != modelCacheTTL {
+		t.Errorf("TTL = %v, want %v", ttl, modelCacheTTL)
+	}
+
+	// 命中缓存时不再请求提供商
+	server.Models = []string{"qwen-max", "qwen-plus"}
+	requests := len(server.Requests())
+	infos := discover(t, "discover-cache", false)
+	if len(infos) != 1 || len(server.Requests()) != requests {
+		t.Errorf("cached infos = %+v, requests = %d, want 1 model and no new request", infos, len(server.Requests())-requests)
+	}
+
+	// refresh忽略缓存
+	infos = discover(t, "discover-cache", true)
+	if len(infos) != 2 {
+		t.Errorf("refreshed infos = %+v, want 2 models", infos)
+	}
+}
+
+func TestDiscoverModelsFallsBackToConfig(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-test"
+	registerDiscoveryProvider("discover-fail", "sk-wrong", server, []string{"moonshot-v1-8k"})
+
+	infos := discover(t, "discover-fail", false)
+	if len(infos) != 1 || infos[0].Name != "moonshot-v1-8k" || infos[0].Source != "config" {
+		t.Errorf("infos = %+v, want configured model only", infos)
+	}
+}
+
+func TestDiscoverModelsCachesFailures(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Models = []string{"moonshot-v1-32k"}
+	server.Failures = []aitest.Failure{{StatusCode: http.StatusServiceUnavailable}}
+	registerDiscoveryProvider("discover-unavailable", "", server, []string{"moonshot-v1-8k"})
+
+	// 查询不重试，失败结果在一段时间内直接使用本地配置
+	if infos := discover(t, "discover-unavailable", false); len(infos) != 1 || len(server.Requests()) != 1 {
+		t.Errorf("infos = %+v, requests = %d, want configured model and a single request", infos, len(server.Requests()))
+	}
+	if infos := discover(t, "discover-unavailable", false); len(infos) != 1 || len(server.Requests()) != 1 {
+		t.Errorf("infos = %+v, requests = %d, want the cached failure", infos, len(server.Requests()))
+	}
+
+	// refresh忽略失败缓存，查询成功后清除失败记录
+	if infos := discover(t, "discover-unavailable", true); len(infos) != 2 {
+		t.Errorf("refreshed infos = %+v", infos)
+	}
+	failedDiscoveries.mu.Lock()
+	_, failed := failedDiscoveries.since["discover-unavailable"]
+	failedDiscoveries.mu.Unlock()
+	if failed {
+		t.Error("failure is still cached after a successful refresh")
+	}
+}
+
+func TestValidateModel(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Models = []string{"glm-4-plus"}
+	registerDiscoveryProvider("validate", "", server, []string{"glm-4-flash"})
+
+	// 没有任何模型列表的提供商不做限制
+	empty := aitest.NewServer()
+	defer empty.Close()
+	registerDiscoveryProvider("validate-empty", "", empty, nil)
+
+	tests := []struct {
+		provider string
+		model    string
+		wantErr  bool
+	}{
+		{"validate", "glm-4-flash", false},
+		{"validate", "glm-4-plus", false},
+		{"validate", "deepseek-v1-8k", true},
+		{"validate-empty", "anything", false},
+		{"not-registered", "glm-4-flash", true},
+	}
+	for _, tt := range tests {
+		err := ValidateModel(context.Background(), tt.provider, tt.model)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateModel(%q, %q) error = %v, wantErr %v", tt.provider, tt.model, err, tt.wantErr)
+		}
+	}
+}
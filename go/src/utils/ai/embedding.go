@@ -0,0 +1,240 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// 本地嵌入的默认向量维度
+	defaultLocalDimension = 256
+	// 每次嵌入请求最多包含的文本数
+	embeddingBatchSize = 32
+)
+
+// Embedder 定义文本嵌入接口
+type Embedder interface {
+	// Embed 返回每段文本的向量，顺序与输入一致
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model 返回嵌入模型名称，记录在向量元数据中
+	Model() string
+}
+
+// EmbeddingRequest 定义嵌入请求参数
+type EmbeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"` // 输出维度，部分模型支持
+}
+
+// EmbeddingResponse 定义嵌入响应结构
+type EmbeddingResponse struct {
+	Object string `json:"object"`
+	Model  string `json:"model"`
+	Data   []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage Usage `json:"usage"`
+}
+
+// OpenAICompatibleEmbedder 调用兼容OpenAI协议的 /v1/embeddings 接口
+type OpenAICompatibleEmbedder struct {
+	client     *OpenAICompatibleModel
+	model      string
+	dimensions int
+}
+
+// NewOpenAICompatibleEmbedder 创建一个新的OpenAI兼容嵌入客户端，dimensions为0时使用模型默认维度
+func NewOpenAICompatibleEmbedder(provider, apiKey, baseURL, model string, dimensions int) *OpenAICompatibleEmbedder {
+	return &OpenAICompatibleEmbedder{
+		client:     NewOpenAICompatibleModel(provider, apiKey, baseURL),
+		model:      model,
+		dimensions: dimensions,
+	}
+}
+
+// Model 返回嵌入模型名称
+func (e *OpenAICompatibleEmbedder) Model() string {
+	return e.model
+}
+
+// Embed 分批请求嵌入接口
+func (e *OpenAICompatibleEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		batch := texts[start:min(start+embeddingBatchSize, len(texts))]
+		resp, err := e.client.send(ctx, client, "/embeddings", EmbeddingRequest{Model: e.model, Input: batch, Dimensions: e.dimensions}, false)
+		if err != nil {
+			return nil, err
+		}
+
+		var response EmbeddingResponse
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			return nil, &ProviderError{Provider: e.client.Name(), Kind: ErrKindServer, Message: "解析嵌入响应失败: " + err.Error(), Err: err}
+		}
+		if len(response.Data) != len(batch) {
+			return nil, &ProviderError{Provider: e.client.Name(), Kind: ErrKindServer, Message: fmt.Sprintf("嵌入结果数量不匹配: 请求%d条，返回%d条", len(batch), len(response.Data))}
+		}
+
+		// 按index还原顺序
+		result := make([][]float32, len(batch))
+		for _, item := range response.Data {
+			if item.Index < 0 || item.Index >= len(batch) {
+				return nil, &ProviderError{Provider: e.client.Name(), Kind: ErrKindServer, Message: fmt.Sprintf("无效的嵌入序号: %d", item.Index)}
+			}
+			result[item.Index] = item.Embedding
+		}
+		vectors = append(vectors, result...)
+	}
+
+	return vectors, nil
+}
+
+// LocalEmbedder 不依赖网络的本地嵌入，将字符n-gram哈希到固定维度并归一化
+// 只能反映字面相似度，用于离线开发或没有嵌入模型的部署
+type LocalEmbedder struct {
+	dimension int
+}
+
+// NewLocalEmbedder 创建一个新的本地嵌入实例，dimension不大于0时使用默认维度
+func NewLocalEmbedder(dimension int) *LocalEmbedder {
+	if dimension <= 0 {
+		dimension = defaultLocalDimension
+	}
+	return &LocalEmbedder{dimension: dimension}
+}
+
+// Model 返回嵌入模型名称，包含维度以区分不同配置生成的向量
+func (e *LocalEmbedder) Model() string {
+	return fmt.Sprintf("local-ngram-%d", e.dimension)
+}
+
+// Embed 计算每段文本的哈希n-gram向量
+func (e *LocalEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+// embed 使用字符的1-gram到3-gram，按哈希的符号位累加以减少冲突带来的偏差
+func (e *LocalEmbedder) embed(text string) []float32 {
+	vector := make([]float32, e.dimension)
+
+	runes := make([]rune, 0, len(text))
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			runes = append(runes, r)
+		} else if len(runes) > 0 && runes[len(runes)-1] != ' ' {
+			runes = append(runes, ' ')
+		}
+	}
+
+	for n := 1; n <= 3; n++ {
+		for i := 0; i+n <= len(runes); i++ {
+			gram := string(runes[i : i+n])
+			if strings.TrimSpace(gram) == "" {
+				continue
+			}
+			h := fnv.New64a()
+			h.Write([]byte(gram))
+			sum := h.Sum64()
+			sign := float32(1)
+			if sum>>63 == 1 {
+				sign = -1
+			}
+			vector[sum%uint64(e.dimension)] += sign * float32(n)
+		}
+	}
+
+	return normalize(vector)
+}
+
+// normalize 将向量归一化为单位长度
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+	return vector
+}
+
+// CosineSimilarity 计算两个向量的余弦相似度，维度不同时返回0
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// EncodeEmbedding 将向量编码为小端序float32字节，用于存储到KnowledgeVectorStore.Embedding
+func EncodeEmbedding(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// DecodeEmbedding 将存储的字节解码为向量
+func DecodeEmbedding(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("无效的向量数据长度: %d", len(data))
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector, nil
+}
+
+// GetEmbedder 根据配置创建嵌入实例，未配置或配置为local时使用本地嵌入
+func GetEmbedder() (Embedder, error) {
+	cfg := config.Config.AI.Embedding
+	if cfg.Provider == "" || cfg.Provider == "local" {
+		return NewLocalEmbedder(cfg.Dimension), nil
+	}
+
+	p, ok := GetProvider(cfg.Provider)
+	if !ok {
+		return nil, fmt.Errorf("不支持的嵌入提供商: %s", cfg.Provider)
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("未配置嵌入模型")
+	}
+	return NewOpenAICompatibleEmbedder(p.Name, p.APIKey, p.BaseURL, cfg.Model, cfg.Dimension), nil
+}
@@ -0,0 +1,144 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenAICompatibleEmbedder(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-test"
+
+	// 超过一批的输入会拆分为多次请求
+	texts := make([]string, embeddingBatchSize+3)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("文本%d", i)
+	}
+
+	embedder := NewOpenAICompatibleEmbedder("qwen", "sk-test", server.URL, "text-embedding-v3", 8)
+	vectors, err := embedder.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if len(vectors) != len(texts) {
+		t.Fatalf("len(vectors) = %d, want %d", len(vectors), len(texts))
+	}
+
+	// 服务器倒序返回，结果仍应与输入顺序一致
+	single, _ := embedder.Embed(context.Background(), texts[1:2])
+	if CosineSimilarity(vectors[1], single[0]) < 0.9999 {
+		t.Errorf("vectors[1] = %v, want %v", vectors[1], single[0])
+	}
+
+	requests := server.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("len(requests) = %d, want 3", len(requests))
+	}
+	if requests[0].Body["model"] != "text-embedding-v3" || requests[0].Body["dimensions"] != float64(8) {
+		t.Errorf("request body = %v", requests[0].Body)
+	}
+	if embedder.Model() != "text-embedding-v3" {
+		t.Errorf("Model() = %q", embedder.Model())
+	}
+}
+
+func TestOpenAICompatibleEmbedderError(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-right"
+
+	_, err := NewOpenAICompatibleEmbedder("qwen", "sk-wrong", server.URL, "text-embedding-v3", 0).Embed(context.Background(), []string{"a"})
+	if providerErr, ok := AsProviderError(err); !ok || providerErr.Kind != ErrKindAuth {
+		t.Fatalf("error = %v, want auth ProviderError", err)
+	}
+}
+
+func TestLocalEmbedder(t *testing.T) {
+	embedder := NewLocalEmbedder(0)
+	if embedder.Model() != "local-ngram-256" {
+		t.Errorf("Model() = %q", embedder.Model())
+	}
+
+	vectors, err := embedder.Embed(context.Background(), []string{
+		"如何重置账户密码",
+		"忘记密码后怎么重置",
+		"今天的天气很好",
+		"",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors[0]) != defaultLocalDimension {
+		t.Fatalf("dimension = %d", len(vectors[0]))
+	}
+
+	related := CosineSimilarity(vectors[0], vectors[1])
+	unrelated := CosineSimilarity(vectors[0], vectors[2])
+	if related <= unrelated {
+		t.Errorf("related similarity %.3f should exceed unrelated %.3f", related, unrelated)
+	}
+	if self := CosineSimilarity(vectors[0], vectors[0]); self < 0.9999 {
+		t.Errorf("self similarity = %f", self)
+	}
+	if CosineSimilarity(vectors[0], vectors[3]) != 0 {
+		t.Error("empty text should have a zero vector")
+	}
+}
+
+func TestEncodeDecodeEmbedding(t *testing.T) {
+	vector := []float32{0.5, -1.25, 3e-8, 0}
+	decoded, err := DecodeEmbedding(EncodeEmbedding(vector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range vector {
+		if decoded[i] != vector[i] {
+			t.Errorf("decoded[%d] = %v, want %v", i, decoded[i], vector[i])
+		}
+	}
+	if _, err := DecodeEmbedding([]byte{1, 2, 3}); err == nil {
+		t.Error("DecodeEmbedding() should reject truncated data")
+	}
+}
+
+func TestProcessKnowledgeFileStoresEmbeddings(t *testing.T) {
+	s := newTestService(t, nil)
+	path := filepath.Join(t.TempDir(), "faq.txt")
+	if err := os.WriteFile(path, []byte(strings.Repeat("重置密码请在登录页点击忘记密码。", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := models.KnowledgeFile{UserID: 1, FileName: "faq.txt", FilePath: path, FileType: "txt", Status: "pending"}
+	s.DB.Create(&file)
+
+	s.ProcessKnowledgeFile(file)
+
+	s.DB.First(&file, file.ID)
+	if file.Status != "completed" {
+		t.Fatalf("Status = %q, want completed", file.Status)
+	}
+	var vectors []models.KnowledgeVectorStore
+	s.DB.Where("file_id = ?", file.ID).Order("id").Find(&vectors)
+	if len(vectors) < 2 {
+		t.Fatalf("len(vectors) = %d, want several chunks", len(vectors))
+	}
+	for i, vector := range vectors {
+		embedding, err := DecodeEmbedding(vector.Embedding)
+		if err != nil || len(embedding) != defaultLocalDimension {
+			t.Errorf("chunk %d embedding length = %d, err = %v", i, len(embedding), err)
+		}
+		var metadata map[string]interface{}
+		json.Unmarshal([]byte(vector.Metadata), &metadata)
+		if metadata["embedding_model"] != "local-ngram-256" || metadata["dimension"] != float64(defaultLocalDimension) ||
+			metadata["source"] != "faq.txt" || metadata["chunk_index"] != float64(i) {
+			t.Errorf("chunk %d metadata = %v", i, metadata)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind 提供商错误类型
+type ErrorKind string
+
+const (
+	ErrKindAuth           ErrorKind = "auth"            // API密钥无效或无权限
+	ErrKindBilling        ErrorKind = "billing"         // 提供商账户余额不足
+	ErrKindRateLimit      ErrorKind = "rate_limit"      // 触发限流
+	ErrKindContextLength  ErrorKind = "context_length"  // 超出模型上下文长度
+	ErrKindInvalidRequest ErrorKind = "invalid_request" // 其他请求参数错误
+	ErrKindServer         ErrorKind = "server"          // 提供商服务端错误
+	ErrKindTimeout        ErrorKind = "timeout"         // 请求超时
+	ErrKindNetwork        ErrorKind = "network"         // 网络连接失败
+	ErrKindCircuitOpen    ErrorKind = "circuit_open"    // 提供商连续失败，熔断器暂停了请求
+)
+
+// ProviderError 表示AI提供商返回的类型化错误
+type ProviderError struct {
+	Provider   string        // 提供商名称
+	Kind       ErrorKind     // 错误类型
+	StatusCode int           // HTTP状态码，网络错误时为0
+	Message    string        // 错误信息
+	RetryAfter time.Duration // 提供商要求的重试等待时间
+	Err        error         // 原始错误
+	UserKey    bool          // 请求是否使用用户自带的API密钥
+}
+
+func (e *ProviderError) Error() string {
+	if e.UserKey {
+		switch e.Kind {
+		case ErrKindAuth:
+			return fmt.Sprintf("%s拒绝了您的API密钥，请检查或更换密钥: %s", e.Provider, e.Message)
+		case ErrKindBilling:
+			return fmt.Sprintf("您的%s API密钥余额不足，请充值后重试: %s", e.Provider, e.Message)
+		}
+	}
+	if e.StatusCode > 0 {
+		return fmt.Sprintf("%s请求失败(%s)，状态码: %d, 响应: %s", e.Provider, e.Kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s请求失败(%s): %s", e.Provider, e.Kind, e.Message)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable 判断错误是否可以重试
+func (e *ProviderError) Retryable() bool {
+	switch e.Kind {
+	case ErrKindRateLimit, ErrKindServer, ErrKindTimeout, ErrKindNetwork, ErrKindCircuitOpen:
+		return true
+	}
+	return false
+}
+
+// AsProviderError 从错误链中提取ProviderError
+func AsProviderError(err error) (*ProviderError, bool) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr, true
+	}
+	return nil, false
+}
+
+// IsRetryable 判断错误链中是否存在可重试的提供商错误
+func IsRetryable(err error) bool {
+	providerErr, ok := AsProviderError(err)
+	return ok && providerErr.Retryable()
+}
+
+// HTTPStatus 将错误映射为返回给客户端的HTTP状态码
+func HTTPStatus(err error) int {
+	if errors.Is(err, ErrGenerationStopped) || errors.Is(err, ErrStreamBusy) {
+		return http.StatusConflict
+	}
+	if quotaErr, ok := AsQuotaError(err); ok {
+		if quotaErr.Kind == QuotaCost {
+			return http.StatusPaymentRequired
+		}
+		return http.StatusTooManyRequests
+	}
+
+	providerErr, ok := AsProviderError(err)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	// 用户自带的密钥被拒绝或余额不足需要用户自己处理
+	if providerErr.UserKey {
+		switch providerErr.Kind {
+		case ErrKindAuth:
+			return http.StatusBadRequest
+		case ErrKindBilling:
+			return http.StatusPaymentRequired
+		}
+	}
+
+	switch providerErr.Kind {
+	case ErrKindRateLimit:
+		return http.StatusTooManyRequests
+	case ErrKindContextLength, ErrKindInvalidRequest:
+		return http.StatusBadRequest
+	case ErrKindTimeout:
+		return http.StatusGatewayTimeout
+	case ErrKindCircuitOpen:
+		return http.StatusServiceUnavailable
+	default:
+		// 服务端密钥错误和余额不足属于服务端账户问题，不能返回401或4xx以免客户端误以为登录失效或请求有误
+		return http.StatusBadGateway
+	}
+}
+
+// newStatusError 根据HTTP响应构造类型化错误
+func newStatusError(provider string, resp *http.Response, body []byte) *ProviderError {
+	providerErr := &ProviderError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    errorMessage(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		providerErr.Kind = ErrKindAuth
+	case resp.StatusCode == http.StatusPaymentRequired:
+		providerErr.Kind = ErrKindBilling
+	case resp.StatusCode == http.StatusTooManyRequests:
+		providerErr.Kind = ErrKindRateLimit
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusGatewayTimeout:
+		providerErr.Kind = ErrKindTimeout
+	case resp.StatusCode >= 500:
+		providerErr.Kind = ErrKindServer
+	case isContextLengthMessage(providerErr.Message):
+		providerErr.Kind = ErrKindContextLength
+	default:
+		providerErr.Kind = ErrKindInvalidRequest
+	}
+
+	return providerErr
+}
+
+// newTransportError 根据请求发送失败的错误构造类型化错误，主动取消时返回原始错误
+func newTransportError(provider string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	kind := ErrKindNetwork
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		kind = ErrKindTimeout
+	}
+
+	return &ProviderError{
+		Provider: provider,
+		Kind:     kind,
+		Message:  err.Error(),
+		Err:      err,
+	}
+}
+
+// errorMessage 提取OpenAI格式错误响应中的错误信息
+func errorMessage(body []byte) string {
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Error.Message != "" {
+		if code, ok := payload.Error.Code.(string); ok && code != "" {
+			return code + ": " + payload.Error.Message
+		}
+		return payload.Error.Message
+	}
+
+	message := strings.TrimSpace(string(body))
+	if len(message) > 500 {
+		message = message[:500]
+	}
+	return message
+}
+
+// isContextLengthMessage 判断错误信息是否为上下文超长
+func isContextLengthMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, marker := range []string{"context_length", "context length", "maximum context", "too many tokens", "exceeds the model"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter 解析Retry-After响应头，支持秒数和HTTP日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
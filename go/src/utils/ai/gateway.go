@@ -0,0 +1,345 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 网关模型ID中引用AI配置ID的前缀，如 config-12
+const gatewayConfigPrefix = "config-"
+
+// ErrGatewayModelNotFound 请求的模型不对应用户的任何AI配置
+var ErrGatewayModelNotFound = errors.New("模型不存在")
+
+// GatewayRequest OpenAI兼容接口的聊天请求，为空的采样参数使用AI配置中的值
+type GatewayRequest struct {
+	Model               string          `json:"model"`
+	Messages            []ChatMessage   `json:"messages"`
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	MaxTokens           *int            `json:"max_tokens"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"` // 新版SDK使用的max_tokens别名
+	PresencePenalty     *float64        `json:"presence_penalty"`
+	FrequencyPenalty    *float64        `json:"frequency_penalty"`
+	Stop                StopSequences   `json:"stop"`
+	Seed                *int            `json:"seed"`
+	ResponseFormat      *ResponseFormat `json:"response_format"`
+	Tools               []Tool          `json:"tools"` // 客户端定义的工具，由客户端执行，不使用服务端工具
+	ToolChoice          interface{}     `json:"tool_choice"`
+	Stream              bool            `json:"stream"`
+	StreamOptions       *StreamOptions  `json:"stream_options"`
+	User                string          `json:"user"`
+}
+
+// StopSequences 停止序列，兼容字符串和字符串数组两种格式
+type StopSequences []string
+
+// UnmarshalJSON 将单个字符串解析为只有一个元素的数组
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = StopSequences{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// GatewayModel OpenAI兼容接口 /v1/models 返回的模型
+type GatewayModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// gatewayConfigs 获取用户的AI配置，默认配置排在最前，其余按创建顺序排列
+func (s *AIService) gatewayConfigs(userID uint) ([]models.AIConfig, error) {
+	configs, err := s.GetAIConfigs(userID)
+	if err != nil {
+		return nil, fmt.Errorf("获取AI配置失败: %v", err)
+	}
+	sort.SliceStable(configs, func(i, j int) bool {
+		if configs[i].IsDefault != configs[j].IsDefault {
+			return configs[i].IsDefault
+		}
+		return configs[i].ID < configs[j].ID
+	})
+	return configs, nil
+}
+
+// GetGatewayModels 将用户的AI配置列为模型，每个模型名第一次出现的配置使用模型名作为ID，
+// 同名的其他配置使用 config-<配置ID>
+func (s *AIService) GetGatewayModels(userID uint) ([]GatewayModel, error) {
+	configs, err := s.gatewayConfigs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(configs))
+	list := make([]GatewayModel, 0, len(configs))
+	for _, cfg := range configs {
+		id := cfg.ModelName
+		if seen[id] {
+			id = gatewayConfigPrefix + strconv.Itoa(int(cfg.ID))
+		}
+		seen[cfg.ModelName] = true
+		list = append(list, GatewayModel{ID: id, Object: "model", Created: cfg.CreatedAt.Unix(), OwnedBy: cfg.Provider})
+	}
+	return list, nil
+}
+
+// ResolveGatewayModel 根据请求的模型名查找AI配置：config-<配置ID> 指定配置，
+// 其他名称匹配模型名相同的第一个配置，为空时使用默认配置
+func (s *AIService) ResolveGatewayModel(userID uint, model string) (*models.AIConfig, error) {
+	if model == "" {
+		return s.GetDefaultAIConfig(userID)
+	}
+	if value, ok := strings.CutPrefix(model, gatewayConfigPrefix); ok {
+		if configID, err := strconv.ParseUint(value, 10, 32); err == nil {
+			cfg, err := s.GetAIConfig(uint(configID), userID)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrGatewayModelNotFound, model)
+			}
+			return cfg, nil
+		}
+	}
+
+	configs, err := s.gatewayConfigs(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range configs {
+		if cfg.ModelName == model {
+			return &cfg, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrGatewayModelNotFound, model)
+}
+
+// OpenGatewaySession 获取或创建记录网关对话的会话，sessionID为0时以最后一条用户消息为标题创建新会话
+func (s *AIService) OpenGatewaySession(userID, sessionID uint, request GatewayRequest) (*models.ChatSession, error) {
+	return s.GetOrCreateSession(userID, sessionID, lastUserContent(request.Messages))
+}
+
+// GatewayChat 使用AI配置完成一次OpenAI兼容的非流式请求，原样返回提供商的响应
+// 主配置出现可重试错误时依次切换到备用配置；session不为空时将本轮问答记录到会话
+func (s *AIService) GatewayChat(ctx context.Context, aiConfig models.AIConfig, request GatewayRequest, session *models.ChatSession) (*ChatCompletionResponse, error) {
+	if len(request.Messages) == 0 {
+		return nil, fmt.Errorf("messages不能为空")
+	}
+	if err := s.CheckQuota(aiConfig.UserID); err != nil {
+		return nil, err
+	}
+
+	var response *ChatCompletionResponse
+	err := s.withFallback(s.getFallbackChain(aiConfig), func(cfg models.AIConfig) error {
+		var err error
+		response, err = s.gatewayCompletion(ctx, cfg, request)
+		if err != nil {
+			return err
+		}
+		var reply ChatMessage
+		if len(response.Choices) > 0 {
+			reply = response.Choices[0].Message
+		}
+		var used tokenUsage
+		used.add(&response.Usage, cfg.ModelName, request.Messages, reply)
+		s.finishGatewayExchange(session, cfg, request, reply, used)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// gatewayCompletion 使用指定配置发起一次非流式请求
+func (s *AIService) gatewayCompletion(ctx context.Context, aiConfig models.AIConfig, request GatewayRequest) (*ChatCompletionResponse, error) {
+	aiModel, err := s.getAIModel(aiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("获取AI模型失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	response, err := aiModel.ChatCompletion(ctx, s.newGatewayRequest(aiConfig, request))
+	if err != nil {
+		return nil, err
+	}
+	if response.Object == "" {
+		response.Object = "chat.completion"
+	}
+	if response.Model == "" {
+		response.Model = aiConfig.ModelName
+	}
+	return response, nil
+}
+
+// GatewayStream 使用AI配置完成一次OpenAI兼容的流式请求，提供商的数据块原样传给callback
+// 尚未输出内容时出现可重试错误会切换到备用配置；session不为空时将本轮问答记录到会话
+func (s *AIService) GatewayStream(ctx context.Context, aiConfig models.AIConfig, request GatewayRequest, session *models.ChatSession, callback func(chunk *ChatCompletionChunk)) error {
+	if len(request.Messages) == 0 {
+		return fmt.Errorf("messages不能为空")
+	}
+	if err := s.CheckQuota(aiConfig.UserID); err != nil {
+		return err
+	}
+
+	streamed := false
+	return s.withFallback(s.getFallbackChain(aiConfig), func(cfg models.AIConfig) error {
+		// 收集完整回复和用量，用于统计用量和记录到会话
+		var reply ChatMessage
+		var usage *Usage
+		streamCallback := func(chunk *ChatCompletionChunk) {
+			if len(chunk.Choices) > 0 {
+				delta := chunk.Choices[0].Delta
+				if delta.Content != "" || delta.ReasoningContent != "" || len(delta.ToolCalls) > 0 {
+					streamed = true
+				}
+				reply.Content += delta.Content
+				reply.ReasoningContent += delta.ReasoningContent
+				reply.ToolCalls = mergeToolCallDeltas(reply.ToolCalls, delta.ToolCalls)
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			callback(chunk)
+		}
+
+		if err := s.gatewayStream(ctx, cfg, request, streamCallback); err != nil {
+			if streamed {
+				return &noFallbackError{err}
+			}
+			return err
+		}
+		var used tokenUsage
+		used.add(usage, cfg.ModelName, request.Messages, reply)
+		s.finishGatewayExchange(session, cfg, request, reply, used)
+		return nil
+	})
+}
+
+// gatewayStream 使用指定配置发起一次流式请求
+func (s *AIService) gatewayStream(ctx context.Context, aiConfig models.AIConfig, request GatewayRequest, callback func(chunk *ChatCompletionChunk)) error {
+	aiModel, err := s.getAIModel(aiConfig)
+	if err != nil {
+		return fmt.Errorf("获取AI模型失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	return aiModel.StreamChatCompletion(ctx, s.newGatewayRequest(aiConfig, request), callback)
+}
+
+// newGatewayRequest 以AI配置为基础构建发给提供商的请求，请求中指定的参数覆盖配置中的值
+// 网关不执行服务端工具，只透传客户端定义的工具
+func (s *AIService) newGatewayRequest(aiConfig models.AIConfig, request GatewayRequest) ChatCompletionRequest {
+	// 思维链不能回传给提供商
+	messages := make([]ChatMessage, len(request.Messages))
+	for i, message := range request.Messages {
+		message.ReasoningContent = ""
+		messages[i] = message
+	}
+
+	aiConfig.EnableTools = false
+	result := s.newChatRequest(aiConfig, messages, 0)
+	result.Tools = request.Tools
+	result.ToolChoice = request.ToolChoice
+
+	if request.Temperature != nil {
+		result.Temperature = *request.Temperature
+	}
+	if request.MaxCompletionTokens != nil {
+		result.MaxTokens = *request.MaxCompletionTokens
+	}
+	if request.MaxTokens != nil {
+		result.MaxTokens = *request.MaxTokens
+	}
+	if request.Stop != nil {
+		result.Stop = request.Stop
+	}
+	if request.Seed != nil {
+		result.Seed = request.Seed
+	}
+	if request.ResponseFormat != nil {
+		result.ResponseFormat = request.ResponseFormat
+		if request.ResponseFormat.Type == "text" {
+			result.ResponseFormat = nil
+		}
+	}
+
+	// 推理模型会忽略采样参数，不支持时不发送
+	if Capabilities(aiConfig.ModelName).Sampling {
+		if request.TopP != nil {
+			result.TopP = *request.TopP
+		}
+		if request.PresencePenalty != nil {
+			result.PresencePenalty = *request.PresencePenalty
+		}
+		if request.FrequencyPenalty != nil {
+			result.FrequencyPenalty = *request.FrequencyPenalty
+		}
+	}
+
+	return result
+}
+
+// finishGatewayExchange 统计本次请求的用量，session不为空时将最后一条用户消息和模型回复保存到会话
+// 记录失败不影响响应；客户端工具的调用记录不保存，以免会话继续对话时发送没有结果的工具调用
+// 只有工具调用没有文本的回复不记录，客户端带着工具结果再次请求时再记录最终回复
+func (s *AIService) finishGatewayExchange(session *models.ChatSession, aiConfig models.AIConfig, request GatewayRequest, reply ChatMessage, used tokenUsage) {
+	if session == nil || (reply.Content == "" && len(reply.ToolCalls) > 0) {
+		s.recordUsage(aiConfig.UserID, 0, aiConfig.Provider, aiConfig.ModelName, used)
+		return
+	}
+
+	now := time.Now()
+	messages := []models.ChatMessage{
+		{SessionID: session.ID, Role: "user", Content: lastUserContent(request.Messages), CreatedAt: now},
+		{
+			SessionID:        session.ID,
+			Role:             "assistant",
+			Content:          reply.Content,
+			ReasoningContent: reply.ReasoningContent,
+			Provider:         aiConfig.Provider,
+			ModelName:        aiConfig.ModelName,
+			CreatedAt:        now.Add(time.Millisecond),
+		},
+	}
+	used.apply(&messages[1])
+	if err := s.DB.Create(&messages).Error; err != nil {
+		log.Printf("记录网关对话到会话%d失败: %v", session.ID, err)
+		s.recordUsage(aiConfig.UserID, 0, aiConfig.Provider, aiConfig.ModelName, used)
+		return
+	}
+	s.recordUsage(aiConfig.UserID, session.ID, aiConfig.Provider, aiConfig.ModelName, used)
+
+	session.AIConfigID = aiConfig.ID
+	s.DB.Model(session).Update("ai_config_id", session.AIConfigID)
+	s.updateLastMessage(session, reply.Content)
+}
+
+// lastUserContent 返回最后一条用户消息的文本内容
+func lastUserContent(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,249 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/auth"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGatewayModels(t *testing.T) {
+	s := newTestService(t, map[string]*MockModel{"mock-gateway-models": NewMockModel()})
+	first := models.AIConfig{UserID: 1, Provider: "mock-gateway-models", ModelName: "mock-echo"}
+	second := models.AIConfig{UserID: 1, Provider: "mock-gateway-models", ModelName: "mock-echo", Temperature: 1.2}
+	other := models.AIConfig{UserID: 1, Provider: "mock-gateway-models", ModelName: "mock-pro", IsDefault: true}
+	for _, cfg := range []*models.AIConfig{&first, &second, &other} {
+		s.DB.Create(cfg)
+	}
+
+	list, err := s.GetGatewayModels(1)
+	if err != nil {
+		t.Fatalf("GetGatewayModels() error = %v", err)
+	}
+	var ids []string
+	for _, model := range list {
+		ids = append(ids, model.ID)
+	}
+	want := []string{"mock-pro", "mock-echo", "config-" + strconv.Itoa(int(second.ID))}
+	if strings.Join(ids, ",") != strings.Join(want, ",") || list[0].Object != "model" || list[0].OwnedBy != "mock-gateway-models" {
+		t.Errorf("models = %+v, want ids %v", list, want)
+	}
+
+	tests := []struct {
+		model  string
+		wantID uint
+	}{
+		{"mock-echo", first.ID},
+		{"config-" + strconv.Itoa(int(second.ID)), second.ID},
+		{"", other.ID},
+	}
+	for _, tt := range tests {
+		cfg, err := s.ResolveGatewayModel(1, tt.model)
+		if err != nil || cfg.ID != tt.wantID {
+			t.Errorf("ResolveGatewayModel(%q) = %v, %v, want config %d", tt.model, cfg, err, tt.wantID)
+		}
+	}
+	for _, model := range []string{"gpt-4o", "config-" + strconv.Itoa(int(second.ID+100))} {
+		if _, err := s.ResolveGatewayModel(1, model); !errors.Is(err, ErrGatewayModelNotFound) {
+			t.Errorf("ResolveGatewayModel(%q) error = %v", model, err)
+		}
+	}
+	if _, err := s.ResolveGatewayModel(2, "config-"+strconv.Itoa(int(first.ID))); !errors.Is(err, ErrGatewayModelNotFound) {
+		t.Errorf("ResolveGatewayModel() for another user error = %v", err)
+	}
+}
+
+func TestGatewayChat(t *testing.T) {
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-gateway": model})
+	cfg := models.AIConfig{UserID: 1, Provider: "mock-gateway", ModelName: "mock-echo", Temperature: 0.7, MaxTokens: 256, EnableTools: true}
+	s.DB.Create(&cfg)
+
+	var request GatewayRequest
+	body := `{
+		"model": "mock-echo",
+		"messages": [
+			{"role": "system", "content": "你是助手"},
+			{"role": "assistant", "content": "之前的回答", "reasoning_content": "之前的思考"},
+			{"role": "user", "content": [{"type": "text", "text": "你好"}]}
+		],
+		"temperature": 0.1,
+		"max_completion_tokens": 64,
+		"stop": "END",
+		"tools": [{"type": "function", "function": {"name": "read_file"}}]
+	}`
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := s.GatewayChat(context.Background(), cfg, request, nil)
+	if err != nil {
+		t.Fatalf("GatewayChat() error = %v", err)
+	}
+	if response.Object != "chat.completion" || response.Choices[0].Message.Content != "echo: 你好" || response.Usage.TotalTokens == 0 {
+		t.Errorf("response = %+v", response)
+	}
+
+	// 请求参数覆盖配置，只透传客户端的工具，思维链不回传
+	sent := model.Requests()[0]
+	if sent.Temperature != 0.1 || sent.MaxTokens != 64 || strings.Join(sent.Stop, ",") != "END" {
+		t.Errorf("request = %+v", sent)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Function.Name != "read_file" {
+		t.Errorf("tools = %+v", sent.Tools)
+	}
+	if len(sent.Messages) != 3 || sent.Messages[1].ReasoningContent != "" {
+		t.Errorf("messages = %+v", sent.Messages)
+	}
+
+	// 未记录会话时不产生聊天记录
+	var count int64
+	s.DB.Model(&models.ChatMessage{}).Count(&count)
+	if count != 0 {
+		t.Errorf("messages saved without logging: %d", count)
+	}
+
+	if _, err := s.GatewayChat(context.Background(), cfg, GatewayRequest{}, nil); err == nil {
+		t.Error("GatewayChat() without messages succeeded")
+	}
+}
+
+func TestGatewayStreamLogsSession(t *testing.T) {
+	primary := NewMockModel()
+	primary.Err = &ProviderError{Provider: "mock-gateway-primary", Kind: ErrKindServer, StatusCode: 503}
+	backup := NewMockModel(ChatMessage{Content: "备用回复", ReasoningContent: "思考"})
+	s := newTestService(t, map[string]*MockModel{"mock-gateway-primary": primary, "mock-gateway-backup": backup})
+
+	fallback := models.AIConfig{UserID: 1, Provider: "mock-gateway-backup", ModelName: "mock-echo"}
+	s.DB.Create(&fallback)
+	cfg := models.AIConfig{UserID: 1, Provider: "mock-gateway-primary", ModelName: "mock-echo", FallbackConfigIDs: []uint{fallback.ID}}
+	s.DB.Create(&cfg)
+
+	request := GatewayRequest{Messages: []ChatMessage{{Role: "user", Content: "写一首诗"}}, Stream: true}
+	session, err := s.OpenGatewaySession(1, 0, request)
+	if err != nil {
+		t.Fatalf("OpenGatewaySession() error = %v", err)
+	}
+
+	var content string
+	var usage *Usage
+	err = s.GatewayStream(context.Background(), cfg, request, session, func(chunk *ChatCompletionChunk) {
+		if len(chunk.Choices) > 0 {
+			content += chunk.Choices[0].Delta.Content
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	})
+	if err != nil {
+		t.Fatalf("GatewayStream() error = %v", err)
+	}
+	if content != "备用回复" || usage == nil {
+		t.Errorf("content = %q, usage = %v", content, usage)
+	}
+
+	// 问答记录到会话，会话标题取自用户消息
+	messages := sessionMessages(t, s, session.ID)
+	if len(messages) != 2 || messages[0].Content != "写一首诗" || messages[1].Content != "备用回复" || messages[1].Provider != "mock-gateway-backup" {
+		t.Fatalf("messages = %+v", messages)
+	}
+	var saved models.ChatSession
+	s.DB.First(&saved, session.ID)
+	if saved.Title != "写一首诗" || saved.LastMessage != "备用回复" || saved.AIConfigID != fallback.ID {
+		t.Errorf("session = %+v", saved)
+	}
+
+	if _, err := s.OpenGatewaySession(2, session.ID, request); err == nil {
+		t.Error("OpenGatewaySession() with another user's session succeeded")
+	}
+}
+
+func TestGatewaySkipsToolCallOnlyReplies(t *testing.T) {
+	toolCall := ChatMessage{ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "read_file", Arguments: `{"path":"a.go"}`}}}}
+	model := NewMockModel(toolCall, ChatMessage{Content: "文件内容是空的"}, toolCall)
+	s := newTestService(t, map[string]*MockModel{"mock-gateway-tools": model})
+	cfg := models.AIConfig{UserID: 1, Provider: "mock-gateway-tools", ModelName: "mock-echo"}
+	s.DB.Create(&cfg)
+
+	request := GatewayRequest{Messages: []ChatMessage{{Role: "user", Content: "读一下a.go"}}}
+	session, _ := s.OpenGatewaySession(1, 0, request)
+
+	// 只有工具调用的回复不记录到会话
+	if _, err := s.GatewayChat(context.Background(), cfg, request, session); err != nil {
+		t.Fatalf("GatewayChat() error = %v", err)
+	}
+	if messages := sessionMessages(t, s, session.ID); len(messages) != 0 {
+		t.Fatalf("messages after tool call = %+v", messages)
+	}
+
+	// 客户端带着工具结果再次请求时记录最终回复
+	request.Messages = append(request.Messages,
+		ChatMessage{Role: "assistant", ToolCalls: toolCall.ToolCalls},
+		ChatMessage{Role: "tool", ToolCallID: "call_1", Content: ""},
+	)
+	if _, err := s.GatewayChat(context.Background(), cfg, request, session); err != nil {
+		t.Fatalf("GatewayChat() error = %v", err)
+	}
+	messages := sessionMessages(t, s, session.ID)
+	if len(messages) != 2 || messages[0].Content != "读一下a.go" || messages[1].Content != "文件内容是空的" {
+		t.Fatalf("messages = %+v", messages)
+	}
+
+	// 流式请求同样不记录只有工具调用的回复
+	stream := GatewayRequest{Messages: []ChatMessage{{Role: "user", Content: "再读一次"}}, Stream: true}
+	var calls []ToolCall
+	err := s.GatewayStream(context.Background(), cfg, stream, session, func(chunk *ChatCompletionChunk) {
+		if len(chunk.Choices) > 0 {
+			calls = mergeToolCallDeltas(calls, chunk.Choices[0].Delta.ToolCalls)
+		}
+	})
+	if err != nil || len(calls) != 1 {
+		t.Fatalf("GatewayStream() error = %v, tool calls = %+v", err, calls)
+	}
+	if messages := sessionMessages(t, s, session.ID); len(messages) != 2 {
+		t.Errorf("messages after streamed tool call = %+v", messages)
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	s := newTestService(t, nil)
+
+	accessToken, token, err := s.CreateAccessToken(1, "IDE")
+	if err != nil {
+		t.Fatalf("CreateAccessToken() error = %v", err)
+	}
+	if !strings.HasPrefix(token, auth.AccessTokenPrefix) || accessToken.TokenHash == token || strings.Contains(accessToken.TokenHint, token[8:20]) {
+		t.Errorf("token = %q, access token = %+v", token, accessToken)
+	}
+
+	userID, err := s.AuthenticateAccessToken(token)
+	if err != nil || userID != 1 {
+		t.Fatalf("AuthenticateAccessToken() = %d, %v", userID, err)
+	}
+	var used models.AccessToken
+	s.DB.First(&used, accessToken.ID)
+	if used.LastUsedAt == nil || time.Since(*used.LastUsedAt) > time.Minute {
+		t.Errorf("last_used_at = %v", used.LastUsedAt)
+	}
+
+	for _, invalid := range []string{"", token + "x", "sk-" + token[4:]} {
+		if _, err := s.AuthenticateAccessToken(invalid); err == nil {
+			t.Errorf("AuthenticateAccessToken(%q) succeeded", invalid)
+		}
+	}
+
+	if err := s.DeleteAccessToken(accessToken.ID, 2); err == nil {
+		t.Error("DeleteAccessToken() by another user succeeded")
+	}
+	if err := s.DeleteAccessToken(accessToken.ID, 1); err != nil {
+		t.Fatalf("DeleteAccessToken() error = %v", err)
+	}
+	if _, err := s.AuthenticateAccessToken(token); err == nil {
+		t.Error("revoked token still authenticates")
+	}
+}
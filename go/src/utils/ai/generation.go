@@ -0,0 +1,167 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrGenerationStopped 表示生成被用户主动停止
+var ErrGenerationStopped = errors.New("生成已被停止")
+
+const (
+	// 停止生成的Redis发布订阅频道，消息内容为会话ID
+	generationStopChannel = "ai:generation:stop"
+	// 正在生成的会话在Redis中的键前缀，后接会话ID，用于跨实例判断会话是否在生成
+	generationKeyPrefix = "ai:generation:"
+	// 生成标记的过期时间，防止实例异常退出后标记残留
+	generationKeyTTL = 10 * time.Minute
+)
+
+// activeGeneration 一次正在进行的生成
+type activeGeneration struct {
+	token  string
+	cancel context.CancelCauseFunc
+}
+
+// generationRegistry 记录本实例上每个会话正在进行的生成
+type generationRegistry struct {
+	mu     sync.Mutex
+	active map[uint]*activeGeneration
+}
+
+var generations = &generationRegistry{
+	active: make(map[uint]*activeGeneration),
+}
+
+// beginGeneration 登记会话的生成，返回可被StopGeneration取消的context和生成结束时调用的函数
+// 同一会话同时只能有一次生成，本实例或其他实例上已有生成时返回ErrStreamBusy，避免两次生成交错保存和删除消息
+func beginGeneration(ctx context.Context, sessionID uint) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	generation := &activeGeneration{token: uuid.New().String(), cancel: cancel}
+
+	generations.mu.Lock()
+	if _, busy := generations.active[sessionID]; busy {
+		generations.mu.Unlock()
+		cancel(nil)
+		return nil, nil, ErrStreamBusy
+	}
+	generations.active[sessionID] = generation
+	generations.mu.Unlock()
+
+	release := func() {
+		generations.mu.Lock()
+		if generations.active[sessionID] == generation {
+			delete(generations.active, sessionID)
+		}
+		generations.mu.Unlock()
+		cancel(nil)
+	}
+
+	key := generationKeyPrefix + strconv.FormatUint(uint64(sessionID), 10)
+	if global.RedisDB == nil {
+		return ctx, release, nil
+	}
+	claimed, err := global.RedisDB.SetNX(context.Background(), key, generation.token, generationKeyTTL).Result()
+	if err != nil {
+		// Redis不可用时只在本实例内保证互斥
+		log.Printf("记录会话%d的生成状态失败: %v", sessionID, err)
+		return ctx, release, nil
+	}
+	if !claimed {
+		release()
+		return nil, nil, ErrStreamBusy
+	}
+
+	return ctx, func() {
+		release()
+		if token, err := global.RedisDB.Get(context.Background(), key).Result(); err == nil && token == generation.token {
+			global.RedisDB.Del(context.Background(), key)
+		}
+	}, nil
+}
+
+// stop 停止本实例上会话正在进行的生成，返回是否存在该生成
+func (r *generationRegistry) stop(sessionID uint) bool {
+	r.mu.Lock()
+	generation, ok := r.active[sessionID]
+	r.mu.Unlock()
+
+	if ok {
+		generation.cancel(ErrGenerationStopped)
+	}
+	return ok
+}
+
+// StopGeneration 停止会话正在进行的生成，配置了Redis时通知其他实例，返回是否有生成被停止
+func (s *AIService) StopGeneration(ctx context.Context, sessionID, userID uint) (bool, error) {
+	if _, err := s.GetSession(sessionID, userID); err != nil {
+		return false, err
+	}
+
+	stopped := generations.stop(sessionID)
+	if global.RedisDB == nil {
+		return stopped, nil
+	}
+
+	// 生成可能运行在其他实例上
+	key := generationKeyPrefix + strconv.FormatUint(uint64(sessionID), 10)
+	exists, err := global.RedisDB.Exists(ctx, key).Result()
+	if err != nil {
+		return stopped, fmt.Errorf("查询生成状态失败: %v", err)
+	}
+	if exists > 0 {
+		if err := global.RedisDB.Publish(ctx, generationStopChannel, sessionID).Err(); err != nil {
+			return stopped, fmt.Errorf("通知停止生成失败: %v", err)
+		}
+		stopped = true
+	}
+	return stopped, nil
+}
+
+// ListenGenerationStops 订阅其他实例发出的停止生成通知，直到ctx结束，未配置Redis时直接返回
+func ListenGenerationStops(ctx context.Context) {
+	if global.RedisDB == nil {
+		return
+	}
+
+	pubsub := global.RedisDB.Subscribe(ctx, generationStopChannel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			sessionID, err := strconv.ParseUint(msg.Payload, 10, 64)
+			if err != nil {
+				log.Printf("无效的停止生成通知: %q", msg.Payload)
+				continue
+			}
+			generations.stop(uint(sessionID))
+		}
+	}
+}
+
+// interruptedCause 返回生成被停止或客户端断开的原因，其他情况返回nil
+func interruptedCause(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	if errors.Is(cause, ErrGenerationStopped) || errors.Is(cause, context.Canceled) {
+		return cause
+	}
+	return nil
+}
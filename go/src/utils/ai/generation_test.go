@@ -0,0 +1,219 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamUntilFirstContent 流式聊天，收到第一个内容数据块时调用interrupt
+func streamUntilFirstContent(t *testing.T, s *AIService, ctx context.Context, sessionID uint, aiConfig models.AIConfig, interrupt func()) (*models.ChatMessage, error) {
+	t.Helper()
+	var once sync.Once
+	reply, _, err := s.StreamChat(ctx, 1, sessionID, "讲个故事", aiConfig, nil, nil, func(chunk *ChatCompletionChunk) {
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			once.Do(interrupt)
+		}
+	})
+	return reply, err
+}
+
+func TestStreamChatStopSavesPartialReply(t *testing.T) {
+	fullReply := "从前有座山，山里有座庙，庙里有个老和尚在讲故事"
+	model := NewMockModel(ChatMessage{Content: fullReply})
+	model.ChunkSize = 2
+	model.Latency = 5 * time.Millisecond
+	s := newTestService(t, map[string]*MockModel{"mock-stop": model})
+	aiConfig := models.AIConfig{Provider: "mock-stop", ModelName: "mock-echo", MaxTokens: 256}
+
+	session, err := s.GetOrCreateSession(1, 0, "讲个故事")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := streamUntilFirstContent(t, s, context.Background(), session.ID, aiConfig, func() {
+		stopped, err := s.StopGeneration(context.Background(), session.ID, 1)
+		if err != nil || !stopped {
+			t.Errorf("StopGeneration() = %v, %v", stopped, err)
+		}
+	})
+	if !errors.Is(err, ErrGenerationStopped) {
+		t.Fatalf("StreamChat() error = %v, want ErrGenerationStopped", err)
+	}
+	if reply == nil || !reply.Interrupted {
+		t.Fatalf("reply = %+v, want an interrupted partial reply", reply)
+	}
+	if reply.Content == "" || len(reply.Content) >= len(fullReply) || !strings.HasPrefix(fullReply, reply.Content) {
+		t.Errorf("partial content = %q", reply.Content)
+	}
+
+	messages := sessionMessages(t, s, session.ID)
+	last := messages[len(messages)-1]
+	if last.ID != reply.ID || !last.Interrupted || last.Content != reply.Content {
+		t.Errorf("saved message = %+v", last)
+	}
+
+	// 生成结束后没有可停止的生成
+	if stopped, _ := s.StopGeneration(context.Background(), session.ID, 1); stopped {
+		t.Error("StopGeneration() after completion = true")
+	}
+	if _, err := s.StopGeneration(context.Background(), session.ID, 2); err == nil {
+		t.Error("StopGeneration() by another user error = nil")
+	}
+}
+
+func TestStreamChatClientDisconnect(t *testing.T) {
+	model := NewMockModel(ChatMessage{Content: "一段很长很长的回复内容"})
+	model.ChunkSize = 2
+	model.Latency = 5 * time.Millisecond
+	s := newTestService(t, map[string]*MockModel{"mock-disconnect": model})
+	aiConfig := models.AIConfig{Provider: "mock-disconnect", ModelName: "mock-echo", MaxTokens: 256}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reply, err := streamUntilFirstContent(t, s, ctx, 0, aiConfig, cancel)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("StreamChat() error = %v, want context.Canceled", err)
+	}
+	if reply == nil || !reply.Interrupted || reply.Content == "" {
+		t.Errorf("reply = %+v, want an interrupted partial reply", reply)
+	}
+}
+
+func TestChatStop(t *testing.T) {
+	model := NewMockModel()
+	model.Latency = time.Second
+	s := newTestService(t, map[string]*MockModel{"mock-chat-stop": model})
+	aiConfig := models.AIConfig{Provider: "mock-chat-stop", ModelName: "mock-echo", MaxTokens: 256}
+	session, _ := s.GetOrCreateSession(1, 0, "你好")
+
+	stopDone := make(chan struct{})
+	defer func() { <-stopDone }()
+	go func() {
+		defer close(stopDone)
+		for {
+			if stopped, _ := s.StopGeneration(context.Background(), session.ID, 1); stopped {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	_, _, err := s.Chat(context.Background(), 1, session.ID, "你好", aiConfig, nil, nil)
+	if !errors.Is(err, ErrGenerationStopped) {
+		t.Fatalf("Chat() error = %v, want ErrGenerationStopped", err)
+	}
+	if elapsed := time.Since(start); elapsed >= model.Latency {
+		t.Errorf("Chat() returned after %v, want the upstream request cancelled", elapsed)
+	}
+}
+
+func TestChatRejectsBusySession(t *testing.T) {
+	model := NewMockModel()
+	model.Latency = 200 * time.Millisecond
+	s := newTestService(t, map[string]*MockModel{"mock-chat-busy": model})
+	aiConfig := models.AIConfig{Provider: "mock-chat-busy", ModelName: "mock-echo", MaxTokens: 256}
+	session, _ := s.GetOrCreateSession(1, 0, "你好")
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := s.Chat(context.Background(), 1, session.ID, "第一条", aiConfig, nil, nil)
+		done <- err
+	}()
+	for {
+		generations.mu.Lock()
+		_, running := generations.active[session.ID]
+		generations.mu.Unlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 会话正在生成时，普通聊天和结构化输出都被拒绝，且不保存用户消息
+	if _, _, err := s.Chat(context.Background(), 1, session.ID, "第二条", aiConfig, nil, nil); !errors.Is(err, ErrStreamBusy) || HTTPStatus(err) != http.StatusConflict {
+		t.Errorf("Chat() error = %v, want ErrStreamBusy", err)
+	}
+	schema, _ := ParseJSONSchema(json.RawMessage(testSchema))
+	if _, _, _, err := s.StructuredChat(context.Background(), 1, session.ID, "第三条", schema, aiConfig, nil); !errors.Is(err, ErrStreamBusy) {
+		t.Errorf("StructuredChat() error = %v, want ErrStreamBusy", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("first Chat() error = %v", err)
+	}
+	if messages := sessionMessages(t, s, session.ID); len(messages) != 2 {
+		t.Errorf("session messages = %+v, want only the first exchange", messages)
+	}
+
+	// 生成结束后可以继续对话
+	if _, _, err := s.Chat(context.Background(), 1, session.ID, "第四条", aiConfig, nil, nil); err != nil {
+		t.Errorf("Chat() after the generation finished error = %v", err)
+	}
+}
+
+func TestGenerationStopAcrossInstances(t *testing.T) {
+	mr := aitest.NewRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ListenGenerationStops(ctx)
+	for mr.PubSubNumSub(generationStopChannel)[generationStopChannel] == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	s := NewAIService(aitest.NewDB(t))
+	session, _ := s.GetOrCreateSession(1, 0, "你好")
+	genCtx, finish, err := beginGeneration(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("beginGeneration() error = %v", err)
+	}
+	key := generationKeyPrefix + strconv.FormatUint(uint64(session.ID), 10)
+	if !mr.Exists(key) {
+		t.Fatalf("generation key %q was not set", key)
+	}
+
+	// 模拟生成运行在其他实例上：本实例没有登记，只能通过Redis通知
+	generations.mu.Lock()
+	local := generations.active[session.ID]
+	delete(generations.active, session.ID)
+	generations.mu.Unlock()
+
+	stopped, err := s.StopGeneration(context.Background(), session.ID, 1)
+	if err != nil || !stopped {
+		t.Fatalf("StopGeneration() = %v, %v", stopped, err)
+	}
+
+	// 生成运行在其他实例上时，本实例不能在同一会话开始新的生成
+	if _, _, err := beginGeneration(context.Background(), session.ID); !errors.Is(err, ErrStreamBusy) {
+		t.Errorf("beginGeneration() on another instance error = %v, want ErrStreamBusy", err)
+	}
+
+	// 其他实例收到通知后停止
+	generations.mu.Lock()
+	generations.active[session.ID] = local
+	generations.mu.Unlock()
+	global.RedisDB.Publish(context.Background(), generationStopChannel, session.ID)
+
+	select {
+	case <-genCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("generation was not stopped by the pub/sub notification")
+	}
+	if cause := context.Cause(genCtx); !errors.Is(cause, ErrGenerationStopped) {
+		t.Errorf("Cause = %v", cause)
+	}
+
+	finish()
+	if mr.Exists(key) {
+		t.Error("generation key was not removed after finish")
+	}
+}
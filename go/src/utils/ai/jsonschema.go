@@ -0,0 +1,397 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema 结构化输出使用的JSON Schema，支持常用的校验关键字：
+// type, properties, required, additionalProperties, items, enum, const,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength,
+// pattern, minItems, maxItems, anyOf, oneOf, allOf
+type JSONSchema struct {
+	raw  json.RawMessage
+	node *schemaNode
+}
+
+// schemaNode 解析后的Schema节点
+type schemaNode struct {
+	Types                []string
+	Properties           map[string]*schemaNode
+	Required             []string
+	AdditionalProperties *schemaNode // 为nil时允许任意额外属性
+	NoAdditional         bool        // additionalProperties为false
+	Items                *schemaNode
+	Enum                 []interface{}
+	Const                interface{}
+	HasConst             bool
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	MinItems             *int
+	MaxItems             *int
+	AnyOf                []*schemaNode
+	OneOf                []*schemaNode
+	AllOf                []*schemaNode
+}
+
+// 支持的类型名称
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// ParseJSONSchema 解析JSON Schema，Schema本身不合法时返回错误
+func ParseJSONSchema(raw json.RawMessage) (*JSONSchema, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("Schema不是合法的JSON: %v", err)
+	}
+	node, err := parseSchemaNode(value, "$")
+	if err != nil {
+		return nil, err
+	}
+
+	compact, _ := json.Marshal(value)
+	return &JSONSchema{raw: compact, node: node}, nil
+}
+
+// String 返回压缩后的Schema文本，用于提示词
+func (s *JSONSchema) String() string {
+	return string(s.raw)
+}
+
+// Validate 校验值是否符合Schema，返回所有不符合之处
+func (s *JSONSchema) Validate(value interface{}) []string {
+	var errs []string
+	s.node.validate(value, "$", &errs)
+	return errs
+}
+
+// parseSchemaNode 递归解析Schema节点
+func parseSchemaNode(value interface{}, path string) (*schemaNode, error) {
+	// true表示任意值
+	if b, ok := value.(bool); ok && b {
+		return &schemaNode{}, nil
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: Schema必须是对象", path)
+	}
+
+	node := &schemaNode{}
+	var err error
+
+	switch t := object["type"].(type) {
+	case nil:
+	case string:
+		node.Types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.type: 类型名称必须是字符串", path)
+			}
+			node.Types = append(node.Types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s.type: 必须是字符串或字符串数组", path)
+	}
+	for _, name := range node.Types {
+		if !schemaTypes[name] {
+			return nil, fmt.Errorf("%s.type: 不支持的类型%q", path, name)
+		}
+	}
+
+	if properties, ok := object["properties"]; ok {
+		propertyMap, ok := properties.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s.properties: 必须是对象", path)
+		}
+		node.Properties = make(map[string]*schemaNode, len(propertyMap))
+		for name, property := range propertyMap {
+			if node.Properties[name], err = parseSchemaNode(property, path+".properties."+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if required, ok := object["required"]; ok {
+		list, ok := required.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s.required: 必须是字符串数组", path)
+		}
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.required: 必须是字符串数组", path)
+			}
+			node.Required = append(node.Required, name)
+		}
+	}
+
+	switch additional := object["additionalProperties"].(type) {
+	case nil:
+	case bool:
+		node.NoAdditional = !additional
+	default:
+		if node.AdditionalProperties, err = parseSchemaNode(additional, path+".additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+
+	if items, ok := object["items"]; ok {
+		if node.Items, err = parseSchemaNode(items, path+".items"); err != nil {
+			return nil, err
+		}
+	}
+
+	if enum, ok := object["enum"]; ok {
+		list, ok := enum.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s.enum: 必须是非空数组", path)
+		}
+		node.Enum = list
+	}
+	if constValue, ok := object["const"]; ok {
+		node.Const, node.HasConst = constValue, true
+	}
+
+	numbers := map[string]**float64{
+		"minimum": &node.Minimum, "maximum": &node.Maximum,
+		"exclusiveMinimum": &node.ExclusiveMinimum, "exclusiveMaximum": &node.ExclusiveMaximum,
+	}
+	for key, target := range numbers {
+		if raw, ok := object[key]; ok {
+			number, ok := raw.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: 必须是数字", path, key)
+			}
+			*target = &number
+		}
+	}
+
+	counts := map[string]**int{
+		"minLength": &node.MinLength, "maxLength": &node.MaxLength,
+		"minItems": &node.MinItems, "maxItems": &node.MaxItems,
+	}
+	for key, target := range counts {
+		if raw, ok := object[key]; ok {
+			number, ok := raw.(float64)
+			if !ok || number < 0 || number != math.Trunc(number) {
+				return nil, fmt.Errorf("%s.%s: 必须是非负整数", path, key)
+			}
+			count := int(number)
+			*target = &count
+		}
+	}
+
+	if raw, ok := object["pattern"]; ok {
+		pattern, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s.pattern: 必须是字符串", path)
+		}
+		if node.Pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s.pattern: 无效的正则表达式: %v", path, err)
+		}
+	}
+
+	combinators := map[string]*[]*schemaNode{"anyOf": &node.AnyOf, "oneOf": &node.OneOf, "allOf": &node.AllOf}
+	for key, target := range combinators {
+		raw, ok := object[key]
+		if !ok {
+			continue
+		}
+		list, ok := raw.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s.%s: 必须是非空数组", path, key)
+		}
+		for i, item := range list {
+			child, err := parseSchemaNode(item, fmt.Sprintf("%s.%s[%d]", path, key, i))
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, child)
+		}
+	}
+
+	return node, nil
+}
+
+// validate 递归校验值，错误追加到errs
+func (n *schemaNode) validate(value interface{}, path string, errs *[]string) {
+	if len(n.Types) > 0 && !n.matchesType(value) {
+		*errs = append(*errs, fmt.Sprintf("%s: 应为%s类型，实际为%s", path, strings.Join(n.Types, "或"), jsonTypeOf(value)))
+		return
+	}
+
+	if n.Enum != nil && !containsJSONValue(n.Enum, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: 取值必须是%s之一", path, compactJSON(n.Enum)))
+	}
+	if n.HasConst && !jsonEqual(n.Const, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: 取值必须是%s", path, compactJSON(n.Const)))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		n.validateObject(v, path, errs)
+	case []interface{}:
+		n.validateArray(v, path, errs)
+	case string:
+		n.validateString(v, path, errs)
+	case float64:
+		n.validateNumber(v, path, errs)
+	}
+
+	n.validateCombinators(value, path, errs)
+}
+
+func (n *schemaNode) validateObject(object map[string]interface{}, path string, errs *[]string) {
+	for _, name := range n.Required {
+		if _, ok := object[name]; !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: 缺少必需字段%q", path, name))
+		}
+	}
+	for name, value := range object {
+		childPath := path + "." + name
+		if property, ok := n.Properties[name]; ok {
+			property.validate(value, childPath, errs)
+		} else if n.NoAdditional {
+			*errs = append(*errs, fmt.Sprintf("%s: 不允许的字段", childPath))
+		} else if n.AdditionalProperties != nil {
+			n.AdditionalProperties.validate(value, childPath, errs)
+		}
+	}
+}
+
+func (n *schemaNode) validateArray(array []interface{}, path string, errs *[]string) {
+	if n.MinItems != nil && len(array) < *n.MinItems {
+		*errs = append(*errs, fmt.Sprintf("%s: 至少需要%d个元素", path, *n.MinItems))
+	}
+	if n.MaxItems != nil && len(array) > *n.MaxItems {
+		*errs = append(*errs, fmt.Sprintf("%s: 最多允许%d个元素", path, *n.MaxItems))
+	}
+	if n.Items != nil {
+		for i, item := range array {
+			n.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func (n *schemaNode) validateString(s string, path string, errs *[]string) {
+	length := utf8.RuneCountInString(s)
+	if n.MinLength != nil && length < *n.MinLength {
+		*errs = append(*errs, fmt.Sprintf("%s: 长度不能少于%d", path, *n.MinLength))
+	}
+	if n.MaxLength != nil && length > *n.MaxLength {
+		*errs = append(*errs, fmt.Sprintf("%s: 长度不能超过%d", path, *n.MaxLength))
+	}
+	if n.Pattern != nil && !n.Pattern.MatchString(s) {
+		*errs = append(*errs, fmt.Sprintf("%s: 不匹配正则表达式%s", path, n.Pattern.String()))
+	}
+}
+
+func (n *schemaNode) validateNumber(number float64, path string, errs *[]string) {
+	if n.Minimum != nil && number < *n.Minimum {
+		*errs = append(*errs, fmt.Sprintf("%s: 不能小于%g", path, *n.Minimum))
+	}
+	if n.Maximum != nil && number > *n.Maximum {
+		*errs = append(*errs, fmt.Sprintf("%s: 不能大于%g", path, *n.Maximum))
+	}
+	if n.ExclusiveMinimum != nil && number <= *n.ExclusiveMinimum {
+		*errs = append(*errs, fmt.Sprintf("%s: 必须大于%g", path, *n.ExclusiveMinimum))
+	}
+	if n.ExclusiveMaximum != nil && number >= *n.ExclusiveMaximum {
+		*errs = append(*errs, fmt.Sprintf("%s: 必须小于%g", path, *n.ExclusiveMaximum))
+	}
+}
+
+func (n *schemaNode) validateCombinators(value interface{}, path string, errs *[]string) {
+	for _, child := range n.AllOf {
+		child.validate(value, path, errs)
+	}
+
+	if len(n.AnyOf) > 0 && countMatches(n.AnyOf, value, path) == 0 {
+		*errs = append(*errs, fmt.Sprintf("%s: 不符合anyOf中的任何一个Schema", path))
+	}
+	if len(n.OneOf) > 0 {
+		if matches := countMatches(n.OneOf, value, path); matches != 1 {
+			*errs = append(*errs, fmt.Sprintf("%s: 必须恰好符合oneOf中的一个Schema，实际符合%d个", path, matches))
+		}
+	}
+}
+
+// countMatches 返回值符合的Schema数量
+func countMatches(nodes []*schemaNode, value interface{}, path string) int {
+	matches := 0
+	for _, node := range nodes {
+		var childErrs []string
+		node.validate(value, path, &childErrs)
+		if len(childErrs) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// matchesType 判断值是否符合任一声明的类型
+func (n *schemaNode) matchesType(value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, name := range n.Types {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf 返回值的JSON类型名称，整数值返回integer
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// containsJSONValue 判断列表中是否包含相等的JSON值
+func containsJSONValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if jsonEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual 按JSON序列化结果比较两个值
+func jsonEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONSchemaInvalid(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`[]`,
+		`{"type": "text"}`,
+		`{"type": "object", "properties": {"a": 1}}`,
+		`{"type": "string", "pattern": "("}`,
+		`{"enum": []}`,
+		`{"minLength": -1}`,
+	}
+	for _, raw := range tests {
+		if _, err := ParseJSONSchema(json.RawMessage(raw)); err == nil {
+			t.Errorf("ParseJSONSchema(%s) should fail", raw)
+		}
+	}
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := ParseJSONSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"level": {"enum": ["low", "high"]},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 2},
+			"score": {"type": ["number", "null"]},
+			"contact": {"oneOf": [
+				{"type": "object", "properties": {"email": {"type": "string"}}, "required": ["email"]},
+				{"type": "object", "properties": {"phone": {"type": "string"}}, "required": ["phone"]}
+			]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatalf("ParseJSONSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string // 期望错误中包含的路径，为空表示校验通过
+	}{
+		{"valid", `{"name": "张三", "age": 30, "level": "high", "tags": ["go"], "score": null, "contact": {"email": "a@b.c"}}`, nil},
+		{"integer accepts whole float", `{"name": "a", "age": 30.0, "score": 1.5}`, nil},
+		{"missing required", `{"name": "a"}`, []string{`缺少必需字段"age"`}},
+		{"wrong type", `{"name": 1, "age": "30"}`, []string{"$.name", "$.age"}},
+		{"not integer", `{"name": "a", "age": 1.5}`, []string{"$.age"}},
+		{"string length in runes", `{"name": "一二三四五六", "age": 1}`, []string{"$.name"}},
+		{"exclusive maximum", `{"name": "a", "age": 150}`, []string{"$.age"}},
+		{"enum", `{"name": "a", "age": 1, "level": "mid"}`, []string{"$.level"}},
+		{"array items and size", `{"name": "a", "age": 1, "tags": ["ok", "Bad", "x"]}`, []string{"$.tags:", "$.tags[1]"}},
+		{"additional property", `{"name": "a", "age": 1, "extra": true}`, []string{"$.extra"}},
+		{"oneOf none", `{"name": "a", "age": 1, "contact": {}}`, []string{"$.contact"}},
+		{"oneOf both", `{"name": "a", "age": 1, "contact": {"email": "x", "phone": "y"}}`, []string{"恰好符合"}},
+		{"root type", `[1, 2]`, []string{"$: 应为object类型"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			errs := schema.Validate(value)
+			if len(tt.want) == 0 {
+				if len(errs) > 0 {
+					t.Errorf("Validate() = %v, want no errors", errs)
+				}
+				return
+			}
+			joined := strings.Join(errs, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(joined, want) {
+					t.Errorf("Validate() = %v, want an error containing %q", errs, want)
+				}
+			}
+		})
+	}
+}
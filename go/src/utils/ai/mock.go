@@ -0,0 +1,199 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MockModel 本地模拟模型，不访问网络，用于离线开发和测试
+// 依次返回Script中的回复，脚本用完后回显最后一条用户消息
+type MockModel struct {
+	Script    []ChatMessage // 按调用顺序返回的脚本回复，可包含思维链和工具调用
+	Latency   time.Duration // 每次请求的延迟，流式输出时为每个数据块的延迟
+	Err       error         // 注入的错误
+	FailTimes int           // 前FailTimes次调用返回Err，为0时每次调用都返回Err
+	FailAfter int           // 前FailAfter次调用正常返回后才开始返回Err，用于模拟工具调用后出错
+	ChunkSize int           // 流式输出时每个数据块的字符数，默认为4
+
+	mu       sync.Mutex
+	calls    int
+	requests []ChatCompletionRequest
+}
+
+// NewMockModel 创建一个新的模拟模型实例
+func NewMockModel(script ...ChatMessage) *MockModel {
+	return &MockModel{Script: script}
+}
+
+// Requests 返回模型收到的所有请求
+func (m *MockModel) Requests() []ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ChatCompletionRequest(nil), m.requests...)
+}
+
+// next 记录请求并返回本次调用的回复或注入的错误
+func (m *MockModel) next(request ChatCompletionRequest) (ChatMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	call := m.calls
+	m.calls++
+	m.requests = append(m.requests, request)
+
+	if m.Err != nil && call >= m.FailAfter && (m.FailTimes == 0 || call-m.FailAfter < m.FailTimes) {
+		return ChatMessage{}, m.Err
+	}
+
+	// 注入错误的调用不消耗脚本
+	index := call
+	if m.Err != nil && call >= m.FailAfter {
+		index -= m.FailTimes
+	}
+	if index < len(m.Script) {
+		reply := m.Script[index]
+		reply.Role = "assistant"
+		return reply, nil
+	}
+
+	// 回声模式
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == "user" {
+			return ChatMessage{Role: "assistant", Content: "echo: " + request.Messages[i].Content}, nil
+		}
+	}
+	return ChatMessage{Role: "assistant", Content: "echo"}, nil
+}
+
+// wait 模拟网络延迟
+func (m *MockModel) wait(ctx context.Context) error {
+	if m.Latency <= 0 {
+		if err := ctx.Err(); err != nil {
+			return newTransportError("mock", err)
+		}
+		return nil
+	}
+	timer := time.NewTimer(m.Latency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return newTransportError("mock", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ChatCompletion 实现非流式聊天接口
+func (m *MockModel) ChatCompletion(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	reply, err := m.next(request)
+	if err != nil {
+		return nil, err
+	}
+
+	finishReason := "stop"
+	if len(reply.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+
+	response := &ChatCompletionResponse{
+		ID:      fmt.Sprintf("mock-%d", time.Now().UnixNano()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   request.Model,
+		Usage:   m.usage(request, reply),
+	}
+	response.Choices = append(response.Choices, ChatCompletionChoice{Message: reply, FinishReason: finishReason})
+
+	return response, nil
+}
+
+// StreamChatCompletion 实现流式聊天接口，将回复按ChunkSize切分为多个数据块
+func (m *MockModel) StreamChatCompletion(ctx context.Context, request ChatCompletionRequest, callback func(chunk *ChatCompletionChunk)) error {
+	if err := m.wait(ctx); err != nil {
+		return err
+	}
+	reply, err := m.next(request)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("mock-%d", time.Now().UnixNano())
+	send := func(delta ChatDelta, finishReason string, usage *Usage) {
+		chunk := &ChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   request.Model,
+			Usage:   usage,
+		}
+		if usage == nil {
+			chunk.Choices = append(chunk.Choices, ChatCompletionChunkChoice{Delta: delta, FinishReason: finishReason})
+		}
+		callback(chunk)
+	}
+
+	send(ChatDelta{Role: "assistant"}, "", nil)
+	for _, part := range splitRunes(reply.ReasoningContent, m.chunkSize()) {
+		if err := m.wait(ctx); err != nil {
+			return err
+		}
+		send(ChatDelta{ReasoningContent: part}, "", nil)
+	}
+	for _, part := range splitRunes(reply.Content, m.chunkSize()) {
+		if err := m.wait(ctx); err != nil {
+			return err
+		}
+		send(ChatDelta{Content: part}, "", nil)
+	}
+
+	finishReason := "stop"
+	if len(reply.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+		deltas := make([]ToolCallDelta, 0, len(reply.ToolCalls))
+		for i, call := range reply.ToolCalls {
+			deltas = append(deltas, ToolCallDelta{Index: i, ID: call.ID, Type: "function", Function: call.Function})
+		}
+		send(ChatDelta{ToolCalls: deltas}, "", nil)
+	}
+	send(ChatDelta{}, finishReason, nil)
+
+	usage := m.usage(request, reply)
+	send(ChatDelta{}, "", &usage)
+	return nil
+}
+
+// chunkSize 返回流式输出的数据块大小
+func (m *MockModel) chunkSize() int {
+	if m.ChunkSize > 0 {
+		return m.ChunkSize
+	}
+	return 4
+}
+
+// usage 按估算的token数生成用量
+func (m *MockModel) usage(request ChatCompletionRequest, reply ChatMessage) Usage {
+	prompt := EstimateMessagesTokens(request.Model, request.Messages)
+	completion := EstimateMessageTokens(request.Model, reply)
+	return Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+// splitRunes 按字符数切分文本
+func splitRunes(text string, size int) []string {
+	runes := []rune(text)
+	var parts []string
+	for i := 0; i < len(runes); i += size {
+		end := min(i+size, len(runes))
+		parts = append(parts, string(runes[i:end]))
+	}
+	return parts
+}
+
+// ListModels 返回模拟模型列表
+func (m *MockModel) ListModels(ctx context.Context) ([]RemoteModel, error) {
+	return []RemoteModel{{ID: "mock-echo", Object: "model", OwnedBy: "mock"}}, nil
+}
@@ -1,59 +1,253 @@
 package ai
 
 import (
-	"Deepseek-Go/config"
+	"bytes"
 	"context"
-	"errors"
+	"encoding/json"
+	"strings"
 )
 
 // ChatMessage 定义聊天消息的结构
 type ChatMessage struct {
-	Role    string `json:"role"`    // 消息角色：user, assistant, system
-	Content string `json:"content"` // 消息内容
+	Role       string        `json:"role"`                   // 消息角色：user, assistant, system, tool
+	Content    string        `json:"content"`                // 消息内容
+	Parts      []ContentPart `json:"-"`                      // 多模态内容，不为空时代替Content以数组形式发送
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`   // 模型发起的工具调用，仅assistant消息
+	ToolCallID string        `json:"tool_call_id,omitempty"` // 对应的工具调用ID，仅tool消息
+	// 推理模型(如deepseek-reasoner)返回的思维链，仅出现在响应中，不能回传给提供商
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// ContentPart 定义多模态消息中的一个内容片段
+type ContentPart struct {
+	Type     string    `json:"type"`                // text 或 image_url
+	Text     string    `json:"text,omitempty"`      // 文本内容，仅text类型
+	ImageURL *ImageURL `json:"image_url,omitempty"` // 图片，仅image_url类型
+}
+
+// ImageURL 定义图片地址，可以是http(s)链接或base64编码的data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"` // 图片精度：auto, low, high
+}
+
+// TextPart 创建文本内容片段
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// ImagePart 创建图片内容片段
+func ImagePart(url string) ContentPart {
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}}
+}
+
+// MarshalJSON 存在多模态内容时将content编码为内容片段数组
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+// UnmarshalJSON 兼容字符串和内容片段数组两种content格式，数组中的文本会拼接到Content
+func (m *ChatMessage) UnmarshalJSON(data []byte) error {
+	type plain ChatMessage
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = ChatMessage(raw.plain)
+
+	content := bytes.TrimSpace(raw.Content)
+	switch {
+	case len(content) == 0 || bytes.Equal(content, []byte("null")):
+		return nil
+	case content[0] == '"':
+		return json.Unmarshal(content, &m.Content)
+	}
+
+	if err := json.Unmarshal(content, &m.Parts); err != nil {
+		return err
+	}
+	var texts []string
+	for _, part := range m.Parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// HasImages 判断消息是否包含图片
+func (m ChatMessage) HasImages() bool {
+	for _, part := range m.Parts {
+		if part.Type == "image_url" {
+			return true
+		}
+	}
+	return false
+}
+
+// Tool 定义可供模型调用的工具
+type Tool struct {
+	Type     string       `json:"type"` // 工具类型，目前只支持function
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 定义函数工具的描述
+type ToolFunction struct {
+	Name        string          `json:"name"`                  // 函数名称
+	Description string          `json:"description,omitempty"` // 函数说明，模型据此决定是否调用
+	Parameters  json.RawMessage `json:"parameters,omitempty"`  // 参数的JSON Schema
+}
+
+// ToolCall 定义模型发起的一次工具调用
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 定义工具调用的函数名和参数
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // 模型生成的JSON参数
+}
+
+// ToolCallDelta 定义流式响应中的增量工具调用
+type ToolCallDelta struct {
+	Index    int              `json:"index"` // 工具调用序号，同一序号的增量需要拼接
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
 }
 
 // ChatCompletionRequest 定义聊天请求参数
 type ChatCompletionRequest struct {
-	Model        string        `json:"model"`                   // 模型名称
-	Messages     []ChatMessage `json:"messages"`                // 消息历史
-	Temperature  float64       `json:"temperature,omitempty"`   // 温度参数，控制随机性
-	MaxTokens    int           `json:"max_tokens,omitempty"`    // 最大token数
-	Stream       bool          `json:"stream,omitempty"`        // 是否使用流式输出
-	KnowledgeIDs []uint        `json:"knowledge_ids,omitempty"` // 知识库ID列表
+	Model            string          `json:"model"`                       // 模型名称
+	Messages         []ChatMessage   `json:"messages"`                    // 消息历史
+	Temperature      float64         `json:"temperature,omitempty"`       // 温度参数，控制随机性
+	MaxTokens        int             `json:"max_tokens,omitempty"`        // 最大token数
+	Stream           bool            `json:"stream,omitempty"`            // 是否使用流式输出
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`    // 流式输出选项
+	TopP             float64         `json:"top_p,omitempty"`             // 核采样概率
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`  // 存在惩罚，正值鼓励谈论新话题
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"` // 频率惩罚，正值减少重复
+	Stop             []string        `json:"stop,omitempty"`              // 停止序列
+	Seed             *int            `json:"seed,omitempty"`              // 随机种子
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`   // 输出格式
+	Tools            []Tool          `json:"tools,omitempty"`             // 可供调用的工具
+	ToolChoice       interface{}     `json:"tool_choice,omitempty"`       // 工具选择策略：auto, none 或指定函数
+	KnowledgeIDs     []uint          `json:"knowledge_ids,omitempty"`     // 知识库ID列表
+}
+
+// ResponseFormat 定义模型的输出格式
+type ResponseFormat struct {
+	Type string `json:"type"` // text 或 json_object
+}
+
+// StreamOptions 定义流式输出选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // 是否在最后一个数据块中返回用量
+}
+
+// Usage 定义token用量，各提供商以不同字段返回命中缓存的输入token数
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// DeepSeek返回的命中和未命中缓存的输入token数
+	PromptCacheHitTokens  int `json:"prompt_cache_hit_tokens,omitempty"`
+	PromptCacheMissTokens int `json:"prompt_cache_miss_tokens,omitempty"`
+	// Kimi返回的命中缓存的输入token数
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	// OpenAI返回的输入token明细
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails 定义输入token明细
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"` // 命中缓存的输入token数
+}
+
+// CachedPromptTokens 返回命中缓存的输入token数，兼容各提供商的字段
+func (u Usage) CachedPromptTokens() int {
+	switch {
+	case u.PromptCacheHitTokens > 0:
+		return u.PromptCacheHitTokens
+	case u.CachedTokens > 0:
+		return u.CachedTokens
+	case u.PromptTokensDetails != nil:
+		return u.PromptTokensDetails.CachedTokens
+	}
+	return 0
 }
 
 // ChatCompletionResponse 定义聊天响应结构
 type ChatCompletionResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index        int         `json:"index"`
-		Message      ChatMessage `json:"message"`
-		FinishReason string      `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   Usage                  `json:"usage"`
+}
+
+// ChatCompletionChoice 定义非流式响应中的一个候选回复
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// ChatDelta 定义流式响应中的增量消息
+type ChatDelta struct {
+	Role      string          `json:"role,omitempty"`       // 消息角色，通常只在第一个数据块中出现
+	Content   string          `json:"content,omitempty"`    // 增量内容
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"` // 增量工具调用
+	// 增量思维链内容，仅推理模型返回
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// ChatCompletionChunk 定义流式响应的数据块结构
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+	Usage   *Usage                      `json:"usage,omitempty"` // 仅在开启include_usage时出现在最后一个数据块中
+}
+
+// ChatCompletionChunkChoice 定义流式数据块中的一个候选回复
+type ChatCompletionChunkChoice struct {
+	Index        int       `json:"index"`
+	Delta        ChatDelta `json:"delta"`
+	FinishReason string    `json:"finish_reason"` // 结束原因：stop, length, tool_calls, content_filter 等，未结束时为空
 }
 
 // AIModel 定义AI模型接口
 type AIModel interface {
 	ChatCompletion(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error)
-	StreamChatCompletion(ctx context.Context, request ChatCompletionRequest, callback func(response *ChatCompletionResponse)) error
+	StreamChatCompletion(ctx context.Context, request ChatCompletionRequest, callback func(chunk *ChatCompletionChunk)) error
 }
 
-// GetAIModel 根据提供商获取对应的AI模型实例
-func GetAIModel(provider string) (AIModel, error) {
-	switch provider {
-	case "deepseek":
-		return NewDeepSeekModel(config.Config.AI.DeepSeek.APIKey, config.Config.AI.DeepSeek.BaseURL), nil
-	case "kimi":
-		return NewKimiModel(config.Config.AI.Kimi.APIKey, config.Config.AI.Kimi.BaseURL), nil
-	default:
-		return nil, errors.New("不支持的AI提供商")
-	}
+// RemoteModel 定义提供商 /v1/models 接口返回的模型
+type RemoteModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// ModelLister 可以查询可用模型列表的AI模型
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]RemoteModel, error)
 }
@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestChatMessageJSONContentParts(t *testing.T) {
+	message := ChatMessage{
+		Role:    "user",
+		Content: "这张图里有什么",
+		Parts:   []ContentPart{TextPart("这张图里有什么"), ImagePart("data:image/png;base64,AAAA")},
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"user","content":[{"type":"text","text":"这张图里有什么"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]}`
+	if string(data) != want {
+		t.Errorf("Marshal() = %s\nwant %s", data, want)
+	}
+
+	var decoded ChatMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Content != "这张图里有什么" || !decoded.HasImages() || len(decoded.Parts) != 2 {
+		t.Errorf("Unmarshal() = %+v", decoded)
+	}
+}
+
+func TestChatMessageJSONPlainContent(t *testing.T) {
+	data, err := json.Marshal(ChatMessage{Role: "assistant", Content: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"role":"assistant","content":"你好"}` {
+		t.Errorf("Marshal() = %s", data)
+	}
+
+	var decoded ChatMessage
+	if err := json.Unmarshal([]byte(`{"role":"assistant","content":null,"tool_calls":[{"id":"1","type":"function","function":{"name":"f","arguments":"{}"}}]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Content != "" || len(decoded.ToolCalls) != 1 || decoded.Parts != nil {
+		t.Errorf("Unmarshal() = %+v", decoded)
+	}
+
+	// 请求中的消息列表同样使用自定义编码
+	request, _ := json.Marshal(ChatCompletionRequest{Messages: []ChatMessage{{Role: "user", Parts: []ContentPart{ImagePart("https://example.com/a.png")}}}})
+	if !strings.Contains(string(request), `"content":[{"type":"image_url"`) {
+		t.Errorf("request = %s", request)
+	}
+}
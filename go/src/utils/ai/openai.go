@@ -0,0 +1,209 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 匹配以版本号结尾的基础URL，如 https://open.bigmodel.cn/api/paas/v4
+var apiVersionPattern = regexp.MustCompile(`/v\d+$`)
+
+// OpenAICompatibleModel 表示兼容OpenAI协议的通用模型客户端
+// DeepSeek、Kimi以及配置文件中声明的其他提供商均由它实现
+type OpenAICompatibleModel struct {
+	name    string
+	apiKey  string
+	baseURL string
+	// RetryPolicy 请求失败时的重试策略
+	RetryPolicy RetryPolicy
+}
+
+// NewOpenAICompatibleModel 创建一个新的OpenAI兼容模型实例
+func NewOpenAICompatibleModel(name, apiKey, baseURL string) *OpenAICompatibleModel {
+	return &OpenAICompatibleModel{
+		name:        name,
+		apiKey:      apiKey,
+		baseURL:     baseURL,
+		RetryPolicy: DefaultRetryPolicy,
+	}
+}
+
+// setRetryPolicy 修改请求失败时的重试策略
+func (m *OpenAICompatibleModel) setRetryPolicy(policy RetryPolicy) {
+	m.RetryPolicy = policy
+}
+
+// Name 返回提供商名称
+func (m *OpenAICompatibleModel) Name() string {
+	return m.name
+}
+
+// endpoint 拼接接口地址，基础URL未带版本号时默认使用 /v1
+func (m *OpenAICompatibleModel) endpoint(path string) string {
+	base := strings.TrimRight(m.baseURL, "/")
+	if !apiVersionPattern.MatchString(base) {
+		base += "/v1"
+	}
+	return base + path
+}
+
+// newRequest 创建带认证信息的HTTP请求
+func (m *OpenAICompatibleModel) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		// 转换请求为JSON格式
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("JSON编码请求失败: %v", err)
+		}
+		reader = bytes.NewBuffer(requestBody)
+	}
+
+	// 创建HTTP请求
+	req, err := http.NewRequestWithContext(ctx, method, m.endpoint(path), reader)
+	if err != nil {
+		return nil, fmt.Errorf("创建HTTP请求失败: %v", err)
+	}
+
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.apiKey))
+	}
+
+	return req, nil
+}
+
+// send 发送POST请求，连接失败或返回可重试的错误状态码时自动重试
+// 返回的响应状态码一定为200，调用方负责关闭响应体
+func (m *OpenAICompatibleModel) send(ctx context.Context, client *http.Client, path string, body interface{}, stream bool) (*http.Response, error) {
+	return m.do(ctx, client, "POST", path, body, stream)
+}
+
+// do 发送请求并按重试策略重试，语义同send
+func (m *OpenAICompatibleModel) do(ctx context.Context, client *http.Client, method, path string, body interface{}, stream bool) (*http.Response, error) {
+	var resp *http.Response
+	err := withRetry(ctx, m.RetryPolicy, func() error {
+		req, err := m.newRequest(ctx, method, path, body)
+		if err != nil {
+			return err
+		}
+		if stream {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+
+		// 发送请求
+		r, err := client.Do(req)
+		if err != nil {
+			return newTransportError(m.name, err)
+		}
+
+		// 检查响应状态码
+		if r.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			r.Body.Close()
+			return newStatusError(m.name, r, bodyBytes)
+		}
+
+		resp = r
+		return nil
+	})
+	return resp, err
+}
+
+// ChatCompletion 实现非流式聊天接口
+func (m *OpenAICompatibleModel) ChatCompletion(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	request.Stream = false
+	resp, err := m.send(ctx, &http.Client{Timeout: 60 * time.Second}, "/chat/completions", request, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 解析响应
+	var response ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, &ProviderError{Provider: m.name, Kind: ErrKindServer, Message: "解析响应失败: " + err.Error(), Err: err}
+	}
+
+	return &response, nil
+}
+
+// StreamChatCompletion 实现流式聊天接口，只有在开始接收数据前的失败才会重试
+func (m *OpenAICompatibleModel) StreamChatCompletion(ctx context.Context, request ChatCompletionRequest, callback func(chunk *ChatCompletionChunk)) error {
+	// 确保请求是流式的，并在最后一个数据块中返回用量
+	request.Stream = true
+	if request.StreamOptions == nil {
+		request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	resp, err := m.send(ctx, &http.Client{}, "/chat/completions", request, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 读取SSE流
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return newTransportError(m.name, fmt.Errorf("读取流失败: %w", err))
+		}
+
+		// 跳过空行
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		// 解析SSE
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+			// 检查流结束
+			if data == "[DONE]" {
+				break
+			}
+
+			// 解析JSON数据
+			var chunk ChatCompletionChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return &ProviderError{Provider: m.name, Kind: ErrKindServer, Message: "解析SSE数据失败: " + err.Error(), Err: err}
+			}
+
+			// 调用回调函数处理数据
+			callback(&chunk)
+		}
+	}
+
+	return nil
+}
+
+// ListModels 查询提供商 /v1/models 接口返回的可用模型
+func (m *OpenAICompatibleModel) ListModels(ctx context.Context) ([]RemoteModel, error) {
+	resp, err := m.do(ctx, &http.Client{Timeout: 15 * time.Second}, "GET", "/models", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Data []RemoteModel `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, &ProviderError{Provider: m.name, Kind: ErrKindServer, Message: "解析模型列表失败: " + err.Error(), Err: err}
+	}
+
+	return response.Data, nil
+}
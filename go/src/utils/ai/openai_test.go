@@ -0,0 +1,220 @@
+package ai
+
+import (
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 测试中使用的快速重试策略
+var fastRetryPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+func TestOpenAICompatibleModelEndpoint(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		want    string
+	}{
+		{"https://api.deepseek.com", "https://api.deepseek.com/v1/chat/completions"},
+		{"https://api.deepseek.com/", "https://api.deepseek.com/v1/chat/completions"},
+		{"https://dashscope.aliyuncs.com/compatible-mode/v1", "https://dashscope.aliyuncs.com/compatible-mode/v1/chat/completions"},
+		{"https://open.bigmodel.cn/api/paas/v4/", "https://open.bigmodel.cn/api/paas/v4/chat/completions"},
+	}
+
+	for _, tt := range tests {
+		model := NewOpenAICompatibleModel("test", "", tt.baseURL)
+		if got := model.endpoint("/chat/completions"); got != tt.want {
+			t.Errorf("endpoint(%q) = %q, want %q", tt.baseURL, got, tt.want)
+		}
+	}
+}
+
+func TestDeepSeekModelChatCompletion(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.APIKey = "sk-test"
+	server.Replies = []string{"你好，我是DeepSeek"}
+	server.Reasoning = "用户在打招呼"
+
+	model := NewDeepSeekModel("sk-test", server.URL)
+	response, err := model.ChatCompletion(context.Background(), ChatCompletionRequest{
+		Model:    "deepseek-reasoner",
+		Messages: []ChatMessage{{Role: "user", Content: "你好"}},
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+
+	if len(response.Choices) != 1 {
+		t.Fatalf("len(Choices) = %d, want 1", len(response.Choices))
+	}
+	message := response.Choices[0].Message
+	if message.Content != "你好，我是DeepSeek" {
+		t.Errorf("Content = %q", message.Content)
+	}
+	if message.ReasoningContent != "用户在打招呼" {
+		t.Errorf("ReasoningContent = %q", message.ReasoningContent)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("len(Requests) = %d, want 1", len(requests))
+	}
+	if requests[0].Authorization != "Bearer sk-test" {
+		t.Errorf("Authorization = %q", requests[0].Authorization)
+	}
+	if requests[0].Body["model"] != "deepseek-reasoner" {
+		t.Errorf("model = %v", requests[0].Body["model"])
+	}
+}
+
+func TestKimiModelStreamChatCompletion(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Replies = []string{"月之暗面的流式回复"}
+
+	model := NewKimiModel("sk-test", server.URL)
+	var content, finishReason string
+	var usage *Usage
+	err := model.StreamChatCompletion(context.Background(), ChatCompletionRequest{
+		Model:    "moonshot-v1-8k",
+		Messages: []ChatMessage{{Role: "user", Content: "你好"}},
+	}, func(chunk *ChatCompletionChunk) {
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("StreamChatCompletion() error = %v", err)
+	}
+
+	if content != "月之暗面的流式回复" {
+		t.Errorf("content = %q", content)
+	}
+	if finishReason != "stop" {
+		t.Errorf("finishReason = %q, want stop", finishReason)
+	}
+	if usage == nil || usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v, want completion tokens", usage)
+	}
+
+	// 流式请求需要携带include_usage
+	body := server.Requests()[0].Body
+	options, _ := body["stream_options"].(map[string]interface{})
+	if body["stream"] != true || options["include_usage"] != true {
+		t.Errorf("stream = %v, stream_options = %v", body["stream"], body["stream_options"])
+	}
+}
+
+func TestOpenAICompatibleModelRetriesRetryableErrors(t *testing.T) {
+	server := aitest.NewServer()
+	defer server.Close()
+	server.Failures = []aitest.Failure{
+		{StatusCode: http.StatusTooManyRequests, Message: "rate limit", RetryAfter: "0"},
+		{StatusCode: http.StatusServiceUnavailable, Message: "overloaded"},
+	}
+	server.Replies = []string{"ok"}
+
+	model := NewOpenAICompatibleModel("test", "", server.URL)
+	model.RetryPolicy = fastRetryPolicy
+	response, err := model.ChatCompletion(context.Background(), ChatCompletionRequest{Model: "test"})
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if response.Choices[0].Message.Content != "ok" {
+		t.Errorf("Content = %q, want ok", response.Choices[0].Message.Content)
+	}
+	if got := len(server.Requests()); got != 3 {
+		t.Errorf("len(Requests) = %d, want 3", got)
+	}
+}
+
+func TestOpenAICompatibleModelTypedErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  aitest.Failure
+		kind     ErrorKind
+		status   int
+		requests int
+	}{
+		{
+			name:     "auth",
+			failure:  aitest.Failure{StatusCode: http.StatusUnauthorized, Message: "invalid api key"},
+			kind:     ErrKindAuth,
+			status:   http.StatusBadGateway,
+			requests: 1,
+		},
+		{
+			name:     "insufficient balance",
+			failure:  aitest.Failure{StatusCode: http.StatusPaymentRequired, Message: "Insufficient Balance"},
+			kind:     ErrKindBilling,
+			status:   http.StatusBadGateway,
+			requests: 1,
+		},
+		{
+			name:     "context length",
+			failure:  aitest.Failure{StatusCode: http.StatusBadRequest, Message: "This model's maximum context length is 65536 tokens"},
+			kind:     ErrKindContextLength,
+			status:   http.StatusBadRequest,
+			requests: 1,
+		},
+		{
+			name:     "rate limit exhausted",
+			failure:  aitest.Failure{StatusCode: http.StatusTooManyRequests, Message: "rate limit"},
+			kind:     ErrKindRateLimit,
+			status:   http.StatusTooManyRequests,
+			requests: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := aitest.NewServer()
+			defer server.Close()
+			server.Failures = []aitest.Failure{tt.failure, tt.failure, tt.failure}
+
+			model := NewOpenAICompatibleModel("test", "", server.URL)
+			model.RetryPolicy = fastRetryPolicy
+			_, err := model.ChatCompletion(context.Background(), ChatCompletionRequest{Model: "test"})
+
+			providerErr, ok := AsProviderError(err)
+			if !ok {
+				t.Fatalf("error = %v, want *ProviderError", err)
+			}
+			if providerErr.Kind != tt.kind {
+				t.Errorf("Kind = %q, want %q", providerErr.Kind, tt.kind)
+			}
+			if got := HTTPStatus(err); got != tt.status {
+				t.Errorf("HTTPStatus() = %d, want %d", got, tt.status)
+			}
+			if got := len(server.Requests()); got != tt.requests {
+				t.Errorf("len(Requests) = %d, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestOpenAICompatibleModelNetworkError(t *testing.T) {
+	server := aitest.NewServer()
+	server.Close()
+
+	model := NewOpenAICompatibleModel("test", "", server.URL)
+	model.RetryPolicy = RetryPolicy{}
+	_, err := model.ChatCompletion(context.Background(), ChatCompletionRequest{Model: "test"})
+
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Kind != ErrKindNetwork {
+		t.Fatalf("error = %v, want network ProviderError", err)
+	}
+	if !providerErr.Retryable() {
+		t.Error("network error should be retryable")
+	}
+}
@@ -0,0 +1,169 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"fmt"
+	"strings"
+)
+
+// 角色系统提示词的最大长度(字符)
+const maxPersonaPromptLength = 8000
+
+// CreatePersona 创建角色
+func (s *AIService) CreatePersona(userID uint, input models.Persona) (*models.Persona, error) {
+	persona := models.Persona{UserID: userID}
+	applyPersonaInput(&persona, input)
+	if err := s.validatePersona(userID, persona); err != nil {
+		return nil, err
+	}
+
+	if err := s.DB.Create(&persona).Error; err != nil {
+		return nil, fmt.Errorf("创建角色失败: %v", err)
+	}
+	return &persona, nil
+}
+
+// GetPersonas 获取用户创建的角色和其他用户共享的角色
+func (s *AIService) GetPersonas(userID uint) ([]models.Persona, error) {
+	var personas []models.Persona
+	if err := s.DB.Where("user_id = ? OR shared = ?", userID, true).Order("created_at DESC").Find(&personas).Error; err != nil {
+		return nil, err
+	}
+	return personas, nil
+}
+
+// GetPersona 获取用户可以使用的角色，即自己创建的或共享的角色
+func (s *AIService) GetPersona(personaID, userID uint) (*models.Persona, error) {
+	var persona models.Persona
+	if err := s.DB.First(&persona, personaID).Error; err != nil {
+		return nil, fmt.Errorf("角色不存在")
+	}
+
+	if persona.UserID != userID && !persona.Shared {
+		return nil, fmt.Errorf("无权使用此角色")
+	}
+
+	return &persona, nil
+}
+
+// getOwnedPersona 获取用户创建的角色，共享的角色只有创建者可以修改
+func (s *AIService) getOwnedPersona(personaID, userID uint) (*models.Persona, error) {
+	persona, err := s.GetPersona(personaID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if persona.UserID != userID {
+		return nil, fmt.Errorf("无权修改此角色")
+	}
+	return persona, nil
+}
+
+// UpdatePersona 更新角色
+func (s *AIService) UpdatePersona(personaID, userID uint, input models.Persona) (*models.Persona, error) {
+	persona, err := s.getOwnedPersona(personaID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	applyPersonaInput(persona, input)
+	if err := s.validatePersona(userID, *persona); err != nil {
+		return nil, err
+	}
+
+	if err := s.DB.Save(persona).Error; err != nil {
+		return nil, fmt.Errorf("更新角色失败: %v", err)
+	}
+	return persona, nil
+}
+
+// DeletePersona 删除角色，使用该角色的会话改为使用默认系统提示词
+func (s *AIService) DeletePersona(personaID, userID uint) error {
+	persona, err := s.getOwnedPersona(personaID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.DB.Delete(persona).Error; err != nil {
+		return fmt.Errorf("删除角色失败: %v", err)
+	}
+	s.DB.Model(&models.ChatSession{}).Where("persona_id = ?", personaID).Update("persona_id", 0)
+	return nil
+}
+
+// applyPersonaInput 将请求中可修改的字段复制到角色
+func applyPersonaInput(persona *models.Persona, input models.Persona) {
+	persona.Name = strings.TrimSpace(input.Name)
+	persona.Description = input.Description
+	persona.SystemPrompt = strings.TrimSpace(input.SystemPrompt)
+	persona.AIConfigID = input.AIConfigID
+	persona.KnowledgeIDs = uniqueIDs(input.KnowledgeIDs)
+	persona.Shared = input.Shared
+}
+
+// validatePersona 校验角色内容，默认AI配置和知识库文件必须属于创建者
+func (s *AIService) validatePersona(userID uint, persona models.Persona) error {
+	if persona.Name == "" {
+		return fmt.Errorf("角色名称不能为空")
+	}
+	if persona.SystemPrompt == "" {
+		return fmt.Errorf("系统提示词不能为空")
+	}
+	if len([]rune(persona.SystemPrompt)) > maxPersonaPromptLength {
+		return fmt.Errorf("系统提示词不能超过%d个字符", maxPersonaPromptLength)
+	}
+
+	if persona.AIConfigID > 0 {
+		if _, err := s.GetAIConfig(persona.AIConfigID, userID); err != nil {
+			return fmt.Errorf("默认AI配置无效: %v", err)
+		}
+	}
+	if len(persona.KnowledgeIDs) > 0 {
+		var count int64
+		if err := s.DB.Model(&models.KnowledgeFile{}).Where("id IN ? AND user_id = ?", persona.KnowledgeIDs, userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("查询知识库文件失败: %v", err)
+		}
+		if int(count) != len(persona.KnowledgeIDs) {
+			return fmt.Errorf("知识库文件不存在或无权访问")
+		}
+	}
+	return nil
+}
+
+// SetSessionPersona 切换会话使用的角色，personaID为0时恢复默认系统提示词
+func (s *AIService) SetSessionPersona(session *models.ChatSession, personaID uint) error {
+	if personaID > 0 {
+		if _, err := s.GetPersona(personaID, session.UserID); err != nil {
+			return err
+		}
+	}
+	if session.PersonaID == personaID {
+		return nil
+	}
+
+	if err := s.DB.Model(session).Update("persona_id", personaID).Error; err != nil {
+		return fmt.Errorf("更新会话角色失败: %v", err)
+	}
+	session.PersonaID = personaID
+	return nil
+}
+
+// SessionPersona 返回会话使用的角色，未设置或角色已删除、已取消共享时返回nil
+func (s *AIService) SessionPersona(session *models.ChatSession) *models.Persona {
+	if session.PersonaID == 0 {
+		return nil
+	}
+	persona, err := s.GetPersona(session.PersonaID, session.UserID)
+	if err != nil {
+		return nil
+	}
+	return persona
+}
+
+// systemPrompt 返回会话使用的系统提示词
+func systemPrompt(persona *models.Persona) string {
+	if persona == nil {
+		return global.DefaultSystemPrompt
+	}
+	return persona.SystemPrompt
+}
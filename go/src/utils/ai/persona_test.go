@@ -0,0 +1,101 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"Deepseek-Go/models"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPersonaSharing(t *testing.T) {
+	s := newTestService(t, nil)
+
+	if _, err := s.CreatePersona(1, models.Persona{Name: "空角色"}); err == nil {
+		t.Error("CreatePersona() without prompt error = nil")
+	}
+	otherConfig := models.AIConfig{UserID: 2, Provider: "deepseek", ModelName: "deepseek-chat"}
+	s.DB.Create(&otherConfig)
+	if _, err := s.CreatePersona(1, models.Persona{Name: "客服", SystemPrompt: "你是客服", AIConfigID: otherConfig.ID}); err == nil {
+		t.Error("CreatePersona() with another user's config error = nil")
+	}
+
+	persona, err := s.CreatePersona(1, models.Persona{Name: " 客服 ", SystemPrompt: "你是公司的客服"})
+	if err != nil {
+		t.Fatalf("CreatePersona() error = %v", err)
+	}
+	if persona.Name != "客服" || persona.UserID != 1 {
+		t.Errorf("persona = %+v", persona)
+	}
+
+	// 未共享的角色其他用户不可见
+	if _, err := s.GetPersona(persona.ID, 2); err == nil {
+		t.Error("GetPersona() of a private persona by another user error = nil")
+	}
+	if personas, _ := s.GetPersonas(2); len(personas) != 0 {
+		t.Errorf("GetPersonas(2) = %+v", personas)
+	}
+
+	// 共享后其他用户可以使用但不能修改
+	if _, err := s.UpdatePersona(persona.ID, 1, models.Persona{Name: "客服", SystemPrompt: "你是公司的客服", Shared: true}); err != nil {
+		t.Fatalf("UpdatePersona() error = %v", err)
+	}
+	if personas, _ := s.GetPersonas(2); len(personas) != 1 {
+		t.Errorf("GetPersonas(2) = %+v", personas)
+	}
+	if _, err := s.GetPersona(persona.ID, 2); err != nil {
+		t.Errorf("GetPersona() of a shared persona error = %v", err)
+	}
+	if _, err := s.UpdatePersona(persona.ID, 2, models.Persona{Name: "改名", SystemPrompt: "改写"}); err == nil {
+		t.Error("UpdatePersona() by another user error = nil")
+	}
+	if err := s.DeletePersona(persona.ID, 2); err == nil {
+		t.Error("DeletePersona() by another user error = nil")
+	}
+}
+
+func TestChatUsesSessionPersona(t *testing.T) {
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-persona": model})
+	aiConfig := models.AIConfig{Provider: "mock-persona", ModelName: "mock-echo"}
+
+	// 创建者的知识库随共享角色一起使用
+	file := models.KnowledgeFile{UserID: 1, FileName: "faq.txt", Status: "completed"}
+	s.DB.Create(&file)
+	s.DB.Create(&models.KnowledgeVectorStore{FileID: file.ID, Text: "年假为每年15天"})
+	persona, err := s.CreatePersona(1, models.Persona{Name: "人事助手", SystemPrompt: "你是人事助手", KnowledgeIDs: []uint{file.ID}, Shared: true})
+	if err != nil {
+		t.Fatalf("CreatePersona() error = %v", err)
+	}
+
+	session, _ := s.GetOrCreateSession(2, 0, "年假有几天")
+	if err := s.SetSessionPersona(session, persona.ID); err != nil {
+		t.Fatalf("SetSessionPersona() error = %v", err)
+	}
+	for _, message := range []string{"年假有几天", "病假呢"} {
+		if _, _, err := s.Chat(context.Background(), 2, session.ID, message, aiConfig, nil, nil); err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+	}
+	for i, request := range model.Requests() {
+		system := request.Messages[0].Content
+		if !strings.HasPrefix(system, "你是人事助手") || !strings.Contains(system, "年假为每年15天") {
+			t.Errorf("request %d system prompt = %q", i, system)
+		}
+	}
+
+	// 删除角色后会话恢复默认系统提示词
+	if err := s.DeletePersona(persona.ID, 1); err != nil {
+		t.Fatalf("DeletePersona() error = %v", err)
+	}
+	if _, _, err := s.Chat(context.Background(), 2, session.ID, "谢谢", aiConfig, nil, nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	requests := model.Requests()
+	if system := requests[len(requests)-1].Messages[0].Content; system != global.DefaultSystemPrompt {
+		t.Errorf("system prompt after delete = %q", system)
+	}
+	if updated, _ := s.GetSession(session.ID, 2); updated.PersonaID != 0 {
+		t.Errorf("PersonaID after delete = %d", updated.PersonaID)
+	}
+}
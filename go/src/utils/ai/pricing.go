@@ -0,0 +1,49 @@
+package ai
+
+import "Deepseek-Go/config"
+
+// 默认的货币单位
+const defaultCurrency = "CNY"
+
+// Currency 返回价格表使用的货币单位
+func Currency() string {
+	if currency := config.Config.AI.Pricing.Currency; currency != "" {
+		return currency
+	}
+	return defaultCurrency
+}
+
+// ModelPriceOf 查找模型的价格，优先匹配提供商和模型名都相同的价格，其次匹配未指定提供商的同名模型
+func ModelPriceOf(provider, model string) (config.ModelPrice, bool) {
+	var fallback *config.ModelPrice
+	for i, price := range config.Config.AI.Pricing.Models {
+		if price.Model != model {
+			continue
+		}
+		if price.Provider == provider {
+			return price, true
+		}
+		if price.Provider == "" && fallback == nil {
+			fallback = &config.Config.AI.Pricing.Models[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return config.ModelPrice{}, false
+}
+
+// cost 按价格表计算用量的费用，命中缓存的输入按缓存价格计算，没有配置价格时为0
+func (u tokenUsage) cost(provider, model string) float64 {
+	price, ok := ModelPriceOf(provider, model)
+	if !ok {
+		return 0
+	}
+	cachedPrice := price.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = price.Input
+	}
+
+	cached := min(u.Cached, u.Prompt)
+	return (float64(u.Prompt-cached)*price.Input + float64(cached)*cachedPrice + float64(u.Completion)*price.Output) / 1e6
+}
@@ -0,0 +1,173 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Provider 描述一个已注册的AI提供商
+type Provider struct {
+	Name    string   // 提供商名称，即AIConfig中的provider
+	BaseURL string   // API基础URL
+	APIKey  string   // API密钥
+	Models  []string // 可用模型列表
+	// New 创建模型实例，为空时使用通用的OpenAI兼容客户端
+	New func(p Provider) AIModel
+}
+
+// providerRegistry 提供商注册表
+type providerRegistry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+	order     []string
+}
+
+var registry = &providerRegistry{
+	providers: make(map[string]Provider),
+}
+
+// RegisterProvider 注册或覆盖一个AI提供商
+func RegisterProvider(p Provider) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, exists := registry.providers[p.Name]; !exists {
+		registry.order = append(registry.order, p.Name)
+	}
+	registry.providers[p.Name] = p
+
+	// 重新注册后地址或密钥可能已改变，之前的查询失败不再适用
+	failedDiscoveries.mu.Lock()
+	delete(failedDiscoveries.since, p.Name)
+	failedDiscoveries.mu.Unlock()
+}
+
+// GetProvider 根据名称获取已注册的提供商
+func GetProvider(name string) (Provider, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	p, ok := registry.providers[name]
+	return p, ok
+}
+
+// ListProviders 按注册顺序返回所有提供商
+func ListProviders() []Provider {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	providers := make([]Provider, 0, len(registry.order))
+	for _, name := range registry.order {
+		providers = append(providers, registry.providers[name])
+	}
+	return providers
+}
+
+// InitProviders 从配置文件加载提供商
+func InitProviders() {
+	// 熔断策略
+	if threshold := config.Config.AI.CircuitBreaker.FailureThreshold; threshold > 0 {
+		DefaultBreakerPolicy.FailureThreshold = threshold
+	}
+	if seconds := config.Config.AI.CircuitBreaker.OpenSeconds; seconds > 0 {
+		DefaultBreakerPolicy.OpenTimeout = time.Duration(seconds) * time.Second
+	}
+
+	// 内置提供商
+	RegisterProvider(Provider{
+		Name:    "deepseek",
+		BaseURL: config.Config.AI.DeepSeek.BaseURL,
+		APIKey:  config.Config.AI.DeepSeek.APIKey,
+		Models:  []string{"deepseek-chat", "deepseek-reasoner"},
+		New: func(p Provider) AIModel {
+			return NewDeepSeekModel(p.APIKey, p.BaseURL)
+		},
+	})
+	RegisterProvider(Provider{
+		Name:    "kimi",
+		BaseURL: config.Config.AI.Kimi.BaseURL,
+		APIKey:  config.Config.AI.Kimi.APIKey,
+		Models: []string{
+			"moonshot-v1-8k", "moonshot-v1-32k", "moonshot-v1-128k", "moonshot-v1-auto",
+			"moonshot-v1-8k-vision-preview", "moonshot-v1-32k-vision-preview", "moonshot-v1-128k-vision-preview",
+		},
+		New: func(p Provider) AIModel {
+			return NewKimiModel(p.APIKey, p.BaseURL)
+		},
+	})
+
+	// 本地模拟提供商
+	if mock := config.Config.AI.Mock; mock.Enabled {
+		RegisterProvider(Provider{
+			Name:   "mock",
+			Models: []string{"mock-echo"},
+			New: func(p Provider) AIModel {
+				model := NewMockModel()
+				model.Latency = time.Duration(mock.Latency) * time.Millisecond
+				for _, reply := range mock.Replies {
+					model.Script = append(model.Script, ChatMessage{Content: reply})
+				}
+				if mock.Error != "" {
+					model.Err = &ProviderError{Provider: "mock", Kind: ErrorKind(mock.Error), Message: "模拟错误"}
+				}
+				return model
+			},
+		})
+	}
+
+	// 配置文件中声明的提供商，同名时覆盖内置配置
+	for _, pc := range config.Config.AI.Providers {
+		if pc.Name == "" || pc.BaseURL == "" {
+			continue
+		}
+		p := Provider{
+			Name:    pc.Name,
+			BaseURL: pc.BaseURL,
+			APIKey:  pc.APIKey,
+			Models:  pc.Models,
+		}
+		if existing, ok := GetProvider(pc.Name); ok {
+			p.New = existing.New
+			if len(p.Models) == 0 {
+				p.Models = existing.Models
+			}
+		}
+		RegisterProvider(p)
+	}
+}
+
+// HasProvider 判断提供商是否已注册
+func HasProvider(name string) bool {
+	_, ok := GetProvider(name)
+	return ok
+}
+
+// newModel 根据提供商配置创建模型实例
+func (p Provider) newModel() AIModel {
+	if p.New != nil {
+		return p.New(p)
+	}
+	return NewOpenAICompatibleModel(p.Name, p.APIKey, p.BaseURL)
+}
+
+// GetAIModel 根据提供商获取对应的AI模型实例，请求经过提供商的熔断器
+func GetAIModel(provider string) (AIModel, error) {
+	p, ok := GetProvider(provider)
+	if !ok {
+		return nil, fmt.Errorf("不支持的AI提供商: %s", provider)
+	}
+	return withBreaker(breakerFor(p.Name), p.newModel()), nil
+}
+
+// GetAIModelWithKey 使用用户的API密钥代替提供商配置的密钥创建模型实例
+// 每个密钥使用独立的熔断器，用户密钥的失败不会暂停服务端密钥的请求
+func GetAIModelWithKey(provider, apiKey string, credentialID uint) (AIModel, error) {
+	p, ok := GetProvider(provider)
+	if !ok {
+		return nil, fmt.Errorf("不支持的AI提供商: %s", provider)
+	}
+	p.APIKey = apiKey
+	return withBreaker(credentialBreakerFor(p.Name, credentialID), p.newModel()), nil
+}
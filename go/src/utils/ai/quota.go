@@ -0,0 +1,180 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/email"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 配额的周期和类型
+const (
+	QuotaDaily   = "daily"   // 按自然日统计
+	QuotaMonthly = "monthly" // 按自然月统计
+	QuotaTokens  = "tokens"  // 输入和输出token数之和
+	QuotaCost    = "cost"    // 按模型价格表计算的费用
+)
+
+// 默认在用量达到配额的80%时提醒
+const defaultQuotaWarningRatio = 0.8
+
+// sendQuotaWarning 发送配额提醒邮件，测试时替换
+var sendQuotaWarning = email.SendQuotaWarningEmail
+
+// QuotaLimit 一项配额的限制和当前周期的用量
+type QuotaLimit struct {
+	Period  string    `json:"period"`   // daily 或 monthly
+	Kind    string    `json:"kind"`     // tokens 或 cost
+	Limit   float64   `json:"limit"`    // 配额
+	Used    float64   `json:"used"`     // 当前周期已使用的量
+	ResetAt time.Time `json:"reset_at"` // 下一个周期开始的时间
+}
+
+// label 返回配额的中文名称，如"每日token"
+func (l QuotaLimit) label() string {
+	period := "每日"
+	if l.Period == QuotaMonthly {
+		period = "每月"
+	}
+	if l.Kind == QuotaCost {
+		return period + "费用"
+	}
+	return period + "token"
+}
+
+// format 按配额类型格式化数量，费用带货币单位
+func (l QuotaLimit) format(value float64) string {
+	if l.Kind == QuotaCost {
+		return fmt.Sprintf("%.2f %s", value, Currency())
+	}
+	return strconv.FormatFloat(value, 'f', 0, 64)
+}
+
+// QuotaError 用户的用量已达到配额，超出token配额对应429，超出费用配额对应402
+type QuotaError struct {
+	QuotaLimit
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("已用完%s配额(已用%s，配额%s)，将于%s恢复",
+		e.label(), e.format(e.Used), e.format(e.Limit), e.ResetAt.Format("2006-01-02 15:04"))
+}
+
+// AsQuotaError 从错误链中提取QuotaError
+func AsQuotaError(err error) (*QuotaError, bool) {
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return quotaErr, true
+	}
+	return nil, false
+}
+
+// QuotaStatus 用户各项配额的当前用量，只包含配置了的配额
+type QuotaStatus struct {
+	Currency string       `json:"currency"`
+	Limits   []QuotaLimit `json:"limits"`
+}
+
+// GetQuotaStatus 按每日用量汇总统计用户当天和当月的用量
+func (s *AIService) GetQuotaStatus(userID uint) (*QuotaStatus, error) {
+	quota := config.Config.AI.Quota
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	periods := []struct {
+		period       string
+		start, reset time.Time
+		tokens       int64
+		cost         float64
+	}{
+		{QuotaDaily, today, today.AddDate(0, 0, 1), quota.DailyTokens, quota.DailyCost},
+		{QuotaMonthly, month, month.AddDate(0, 1, 0), quota.MonthlyTokens, quota.MonthlyCost},
+	}
+
+	status := &QuotaStatus{Currency: Currency(), Limits: []QuotaLimit{}}
+	for _, p := range periods {
+		if p.tokens <= 0 && p.cost <= 0 {
+			continue
+		}
+
+		var totals UsageTotals
+		filter := UsageFilter{Start: p.start.Format(UsageDateLayout), End: today.Format(UsageDateLayout)}
+		if err := s.dailyUsageQuery(userID, filter).Select(usageSumColumns).Scan(&totals).Error; err != nil {
+			return nil, fmt.Errorf("查询用量失败: %v", err)
+		}
+		if p.tokens > 0 {
+			status.Limits = append(status.Limits, QuotaLimit{Period: p.period, Kind: QuotaTokens, Limit: float64(p.tokens), Used: float64(totals.TotalTokens), ResetAt: p.reset})
+		}
+		if p.cost > 0 {
+			status.Limits = append(status.Limits, QuotaLimit{Period: p.period, Kind: QuotaCost, Limit: p.cost, Used: totals.Cost, ResetAt: p.reset})
+		}
+	}
+	return status, nil
+}
+
+// CheckQuota 在请求提供商之前检查用户的配额，任一配额用完时返回QuotaError
+// 按请求前的用量判断，配额用完前的最后一次请求可能略微超出配额
+func (s *AIService) CheckQuota(userID uint) error {
+	status, err := s.GetQuotaStatus(userID)
+	if err != nil {
+		return err
+	}
+	for _, limit := range status.Limits {
+		if limit.Used >= limit.Limit {
+			return &QuotaError{QuotaLimit: limit}
+		}
+	}
+	return nil
+}
+
+// notifyQuotaWarning 本次用量使配额跨过提醒比例时向用户邮箱发送提醒
+// 只在跨过提醒线的那次请求后发送，每个周期每项配额只提醒一次；发送失败只记录日志
+func (s *AIService) notifyQuotaWarning(userID uint, tokens int64, cost float64) {
+	quota := config.Config.AI.Quota
+	if quota.DailyTokens <= 0 && quota.MonthlyTokens <= 0 && quota.DailyCost <= 0 && quota.MonthlyCost <= 0 {
+		return
+	}
+	ratio := quota.WarningRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = defaultQuotaWarningRatio
+	}
+
+	status, err := s.GetQuotaStatus(userID)
+	if err != nil {
+		log.Printf("检查用户%d的配额提醒失败: %v", userID, err)
+		return
+	}
+	var lines []string
+	for _, limit := range status.Limits {
+		delta := float64(tokens)
+		if limit.Kind == QuotaCost {
+			delta = cost
+		}
+		threshold := limit.Limit * ratio
+		if limit.Used < threshold || limit.Used-delta >= threshold {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s用量已达到配额的%.0f%%：已用%s，配额%s，将于%s恢复",
+			limit.label(), limit.Used/limit.Limit*100, limit.format(limit.Used), limit.format(limit.Limit), limit.ResetAt.Format("2006-01-02 15:04")))
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	var user models.User
+	if err := s.DB.Select("id", "email").First(&user, userID).Error; err != nil || user.Email == "" {
+		log.Printf("用户%d的用量达到配额提醒线，但没有可用的邮箱", userID)
+		return
+	}
+	send := sendQuotaWarning
+	go func() {
+		if err := send(user.Email, lines); err != nil {
+			log.Printf("发送配额提醒邮件到%s失败: %v", user.Email, err)
+		}
+	}()
+}
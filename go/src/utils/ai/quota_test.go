@@ -0,0 +1,217 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"Deepseek-Go/models"
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setPricing 设置测试用的模型价格表，测试结束后恢复配置
+func setPricing(t *testing.T, prices ...config.ModelPrice) {
+	t.Helper()
+	previous := config.Config.AI.Pricing
+	config.Config.AI.Pricing.Models = prices
+	t.Cleanup(func() { config.Config.AI.Pricing = previous })
+}
+
+// setQuota 设置测试用的配额，测试结束后恢复配置
+func setQuota(t *testing.T, quota config.QuotaConfig) {
+	t.Helper()
+	previous := config.Config.AI.Quota
+	config.Config.AI.Quota = quota
+	t.Cleanup(func() { config.Config.AI.Quota = previous })
+}
+
+func TestUsageCost(t *testing.T) {
+	setPricing(t,
+		config.ModelPrice{Model: "shared-model", Input: 1, Output: 2},
+		config.ModelPrice{Provider: "deepseek", Model: "deepseek-chat", Input: 2, CachedInput: 0.5, Output: 8},
+		config.ModelPrice{Provider: "other", Model: "shared-model", Input: 10, Output: 20},
+	)
+
+	used := tokenUsage{Prompt: 1_000_000, Completion: 500_000, Cached: 400_000}
+	tests := []struct {
+		provider, model string
+		want            float64
+	}{
+		{"deepseek", "deepseek-chat", 0.6*2 + 0.4*0.5 + 0.5*8},
+		{"kimi", "shared-model", 1 + 0.5*2}, // 未配置缓存价格时按输入价格计算
+		{"other", "shared-model", 10 + 0.5*20},
+		{"kimi", "deepseek-chat", 0},
+	}
+	for _, tt := range tests {
+		if got := used.cost(tt.provider, tt.model); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("cost(%s, %s) = %v, want %v", tt.provider, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestChatRecordsCost(t *testing.T) {
+	setPricing(t, config.ModelPrice{Model: "mock-echo", Input: 2, Output: 8})
+	s := newTestService(t, map[string]*MockModel{"mock-cost": NewMockModel()})
+	aiConfig := models.AIConfig{Provider: "mock-cost", ModelName: "mock-echo"}
+
+	reply, session, err := s.Chat(context.Background(), 1, 0, "你好", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	want := (float64(reply.PromptTokens)*2 + float64(reply.CompletionTokens)*8) / 1e6
+	if math.Abs(reply.Cost-want) > 1e-12 || reply.Cost == 0 {
+		t.Errorf("reply cost = %v, want %v", reply.Cost, want)
+	}
+
+	var saved models.ChatSession
+	s.DB.First(&saved, session.ID)
+	report, err := s.GetUsageReport(1, UsageFilter{Start: time.Now().Format(UsageDateLayout), End: time.Now().Format(UsageDateLayout)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(saved.Cost-want) > 1e-12 || math.Abs(report.Total.Cost-want) > 1e-12 || report.Currency != "CNY" {
+		t.Errorf("session cost = %v, report = %+v", saved.Cost, report)
+	}
+}
+
+func TestQuotaBlocksChat(t *testing.T) {
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-quota": model})
+	aiConfig := models.AIConfig{Provider: "mock-quota", ModelName: "mock-echo"}
+	now := time.Now()
+	s.DB.Create(&[]models.UsageDaily{
+		{UserID: 1, Date: now.Format(UsageDateLayout), Provider: "mock-quota", ModelName: "mock-echo", Requests: 1, PromptTokens: 800, CompletionTokens: 200, Cost: 0.5},
+		// 上个月的用量不计入当月配额
+		{UserID: 1, Date: now.AddDate(0, 0, -now.Day()).Format(UsageDateLayout), Provider: "mock-quota", ModelName: "mock-echo", Requests: 1, PromptTokens: 100000, Cost: 100},
+	})
+
+	tests := []struct {
+		name       string
+		quota      config.QuotaConfig
+		wantStatus int
+	}{
+		{"daily tokens", config.QuotaConfig{DailyTokens: 1000}, http.StatusTooManyRequests},
+		{"monthly cost", config.QuotaConfig{MonthlyTokens: 5000, MonthlyCost: 0.5}, http.StatusPaymentRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setQuota(t, tt.quota)
+
+			_, _, err := s.Chat(context.Background(), 1, 0, "你好", aiConfig, nil, nil)
+			quotaErr, ok := AsQuotaError(err)
+			if !ok || HTTPStatus(err) != tt.wantStatus || !strings.Contains(err.Error(), "配额") {
+				t.Fatalf("Chat() error = %v, status = %d", err, HTTPStatus(err))
+			}
+			if quotaErr.ResetAt.Before(now) {
+				t.Errorf("reset at = %v", quotaErr.ResetAt)
+			}
+		})
+	}
+
+	// 被拒绝的请求不发送给提供商，也不保存消息
+	var count int64
+	s.DB.Model(&models.ChatMessage{}).Count(&count)
+	if len(model.Requests()) != 0 || count != 0 {
+		t.Errorf("requests = %d, messages = %d", len(model.Requests()), count)
+	}
+
+	// 其他用户不受影响
+	setQuota(t, config.QuotaConfig{DailyTokens: 1000})
+	if _, _, err := s.Chat(context.Background(), 2, 0, "你好", aiConfig, nil, nil); err != nil {
+		t.Errorf("Chat() for another user error = %v", err)
+	}
+}
+
+func TestQuotaSkipsSummary(t *testing.T) {
+	model := NewMockModel(ChatMessage{Content: "用户在咨询年假"})
+	s := newTestService(t, map[string]*MockModel{"mock-quota-summary": model})
+	aiConfig := models.AIConfig{Provider: "mock-quota-summary", ModelName: "mock-echo"}
+	setQuota(t, config.QuotaConfig{DailyTokens: 1000})
+
+	session, _ := s.GetOrCreateSession(1, 0, "年假")
+	message := models.ChatMessage{SessionID: session.ID, Role: "user", Content: "公司的年假有多少天？"}
+	s.DB.Create(&message)
+	s.DB.Model(session).Updates(map[string]interface{}{"summary": "旧摘要", "summary_until_id": message.ID})
+
+	// 摘要的用量计入配额
+	if err := s.summarizeSession(session.ID, aiConfig, true); err != nil {
+		t.Fatalf("summarizeSession() error = %v", err)
+	}
+	status, _ := s.GetQuotaStatus(1)
+	if len(status.Limits) != 1 || status.Limits[0].Used == 0 {
+		t.Fatalf("quota status = %+v", status)
+	}
+
+	// 配额用完后不再请求模型，重新生成时清除旧摘要
+	s.DB.Create(&models.UsageDaily{UserID: 1, Date: time.Now().Format(UsageDateLayout), Provider: "other", ModelName: "other", PromptTokens: 1000})
+	err := s.summarizeSession(session.ID, aiConfig, true)
+	if _, ok := AsQuotaError(err); !ok {
+		t.Errorf("summarizeSession() error = %v, want QuotaError", err)
+	}
+	if len(model.Requests()) != 1 {
+		t.Errorf("model called %d times, want 1", len(model.Requests()))
+	}
+	var saved models.ChatSession
+	s.DB.First(&saved, session.ID)
+	if saved.Summary != "" || saved.SummaryUntilID != 0 {
+		t.Errorf("summary = %q until %d, want cleared", saved.Summary, saved.SummaryUntilID)
+	}
+}
+
+func TestQuotaWarningEmail(t *testing.T) {
+	sent := make(chan []string, 4)
+	previous := sendQuotaWarning
+	sendQuotaWarning = func(to string, lines []string) error {
+		if to != "quota@example.com" {
+			t.Errorf("sent to %q", to)
+		}
+		sent <- lines
+		return nil
+	}
+	t.Cleanup(func() { sendQuotaWarning = previous })
+
+	s := newTestService(t, map[string]*MockModel{"mock-quota-warning": NewMockModel()})
+	user := models.User{Username: "quota", Password: "x", Email: "quota@example.com"}
+	s.DB.Create(&user)
+	aiConfig := models.AIConfig{Provider: "mock-quota-warning", ModelName: "mock-echo"}
+
+	// 第一次回复的用量作为提醒线的参考
+	reply, _, err := s.Chat(context.Background(), user.ID, 0, "你好", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := int64(reply.PromptTokens + reply.CompletionTokens)
+	setQuota(t, config.QuotaConfig{DailyTokens: tokens * 4, WarningRatio: 0.3})
+	select {
+	case lines := <-sent:
+		t.Fatalf("warning sent before quota was configured: %v", lines)
+	default:
+	}
+
+	// 第二次回复跨过30%的提醒线，只提醒一次
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.Chat(context.Background(), user.ID, 0, "你好", aiConfig, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case lines := <-sent:
+		if len(lines) != 1 || !strings.Contains(lines[0], "每日token") {
+			t.Errorf("lines = %v", lines)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("warning email not sent")
+	}
+	select {
+	case lines := <-sent:
+		t.Errorf("warning sent twice: %v", lines)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	status, err := s.GetQuotaStatus(user.ID)
+	if err != nil || len(status.Limits) != 1 || status.Limits[0].Used < float64(tokens*3) {
+		t.Errorf("status = %+v, err = %v", status, err)
+	}
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"Deepseek-Go/config"
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter 按固定间隔依次放行请求，提供商返回限流错误后暂停放行一段时间
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // 相邻两个请求的最小间隔，0表示不限速
+	next     time.Time     // 下一个请求最早的发送时间
+}
+
+// limiterRegistry 按提供商名称保存批量任务使用的限速器
+type limiterRegistry struct {
+	mu       sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+var limiters = &limiterRegistry{
+	limiters: make(map[string]*rateLimiter),
+}
+
+// limiterFor 返回提供商的限速器，不存在时按配置的每分钟请求数创建
+func limiterFor(provider string) *rateLimiter {
+	limiters.mu.Lock()
+	defer limiters.mu.Unlock()
+
+	l, ok := limiters.limiters[provider]
+	if !ok {
+		rpm := config.Config.AI.Batch.RequestsPerMinute
+		if limit, ok := config.Config.AI.Batch.RateLimits[provider]; ok {
+			rpm = limit
+		}
+		l = &rateLimiter{}
+		if rpm > 0 {
+			l.interval = time.Minute / time.Duration(rpm)
+		}
+		limiters.limiters[provider] = l
+	}
+	return l
+}
+
+// wait 预约下一个发送时间并等待到该时间，ctx结束时返回ctx的错误
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	at := time.Now()
+	if l.next.After(at) {
+		at = l.next
+	}
+	if l.interval > 0 {
+		l.next = at.Add(l.interval)
+	}
+	l.mu.Unlock()
+
+	return sleepContext(ctx, time.Until(at))
+}
+
+// pause 在d时间内不再放行请求
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
+
+// sleepContext 等待d时间，ctx先结束时返回ctx的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 定义请求失败后的重试策略
+type RetryPolicy struct {
+	MaxRetries int           // 最大重试次数
+	BaseDelay  time.Duration // 初始退避时间
+	MaxDelay   time.Duration // 最大退避时间，同样限制Retry-After
+}
+
+// DefaultRetryPolicy 默认重试策略
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 2,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   10 * time.Second,
+}
+
+// retryPolicySetter 可以调整内部重试策略的模型
+type retryPolicySetter interface {
+	setRetryPolicy(policy RetryPolicy)
+}
+
+// withoutRetry 关闭模型内部的重试，由调用方控制是否重试，不支持调整的模型原样返回
+func withoutRetry(model AIModel) AIModel {
+	if setter, ok := model.(retryPolicySetter); ok {
+		setter.setRetryPolicy(RetryPolicy{})
+	}
+	return model
+}
+
+// withRetry 执行fn，遇到可重试的提供商错误时按带抖动的指数退避重试
+func withRetry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		// 计算等待时间
+		providerErr, _ := AsProviderError(err)
+		delay := policy.backoff(attempt)
+		if providerErr.RetryAfter > 0 {
+			// 提供商要求的等待时间超过上限时不再重试
+			if providerErr.RetryAfter > policy.MaxDelay {
+				return err
+			}
+			delay = providerErr.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff 计算第attempt次重试的等待时间，采用full jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling))) + 1
+}
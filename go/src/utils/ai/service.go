@@ -4,14 +4,16 @@ import (
 	"Deepseek-Go/global"
 	"Deepseek-Go/models"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -30,165 +32,466 @@ func NewAIService(db *gorm.DB) *AIService {
 
 // 聊天相关服务 ---------------------------------------------------------
 
-// Chat 处理普通聊天请求
-func (s *AIService) Chat(userID uint, sessionID uint, message string, aiConfig models.AIConfig, knowledgeIDs []uint) (*models.ChatMessage, *models.ChatSession, error) {
-	// 获取或创建会话
-	session, err := s.getOrCreateSession(userID, sessionID, message)
+// Chat 处理普通聊天请求，开启回复缓存时优先返回缓存，主配置出现可重试错误时依次切换到备用配置
+// ctx结束或会话被StopGeneration停止时取消对提供商的请求
+func (s *AIService) Chat(ctx context.Context, userID uint, sessionID uint, message string, aiConfig models.AIConfig, knowledgeIDs, attachmentIDs []uint) (*models.ChatMessage, *models.ChatSession, error) {
+	// 获取会话、构建请求消息并保存用户消息
+	chain, err := s.chatChain(aiConfig, attachmentIDs)
 	if err != nil {
-		return nil, nil, fmt.Errorf("会话处理失败: %v", err)
+		return nil, nil, err
 	}
-
-	// 获取历史消息
-	messages, err := s.getSessionMessages(session.ID)
+	if err := s.CheckQuota(userID); err != nil {
+		return nil, nil, err
+	}
+	ctx, finish, session, aiMessages, err := s.prepareChat(ctx, userID, sessionID, message, knowledgeIDs, attachmentIDs, chain)
 	if err != nil {
-		return nil, nil, fmt.Errorf("获取历史消息失败: %v", err)
+		return nil, nil, err
 	}
+	defer finish()
 
-	// 构建AI请求消息
-	aiMessages := s.buildAIMessages(messages, message, knowledgeIDs, userID)
+	// 相同的请求命中缓存时直接返回缓存的回复
+	cache := newResponseCache(userID, aiMessages)
+	if reply := s.lookupCache(ctx, cache, chain[0]); reply != nil {
+		assistantMessage, err := s.saveCachedReply(session.ID, chain[0], reply)
+		if err != nil {
+			return nil, nil, fmt.Errorf("保存AI回复失败: %v", err)
+		}
+		s.updateLastMessage(session, assistantMessage.Content)
+		return assistantMessage, session, nil
+	}
+
+	env := ToolEnv{DB: s.DB, UserID: userID, KnowledgeIDs: knowledgeIDs}
+	var assistantMessage *models.ChatMessage
+	err = s.withFallback(chain, func(cfg models.AIConfig) error {
+		// 每个配置都从prepareChat构建的请求消息重新开始，失败尝试的工具调用不带给备用配置
+		lastID := s.lastMessageID(session.ID)
+		var err error
+		assistantMessage, err = s.chatWithConfig(ctx, env, session.ID, aiMessages, cfg)
+		if err != nil {
+			s.discardToolMessages(session.ID, lastID)
+			return err
+		}
+		s.storeCache(ctx, cache, cfg, assistantMessage)
 
-	// 保存用户消息
-	userMessage := models.ChatMessage{
-		SessionID: session.ID,
-		Role:      "user",
-		Content:   message,
-		CreatedAt: time.Now(),
-	}
-	if err := s.DB.Create(&userMessage).Error; err != nil {
-		return nil, nil, fmt.Errorf("保存用户消息失败: %v", err)
+		// 更新会话最后消息，并在历史过长时异步更新摘要
+		s.updateLastMessage(session, assistantMessage.Content)
+		go s.maybeSummarize(session.ID, cfg)
+		return nil
+	})
+	if err == nil {
+		return assistantMessage, session, nil
 	}
 
-	// 更新会话最后消息
-	session.LastMessage = message
-	s.DB.Save(&session)
+	if cause := interruptedCause(ctx); cause != nil {
+		err = cause
+	}
+	return nil, nil, fmt.Errorf("AI服务调用失败: %w", err)
+}
 
+// chatWithConfig 使用指定配置完成一次非流式对话，包括工具调用循环，返回保存的AI回复
+func (s *AIService) chatWithConfig(ctx context.Context, env ToolEnv, sessionID uint, aiMessages []ChatMessage, aiConfig models.AIConfig) (*models.ChatMessage, error) {
 	// 获取AI模型
-	aiModel, err := GetAIModel(aiConfig.Provider)
+	aiModel, err := s.getAIModel(aiConfig)
 	if err != nil {
-		return nil, nil, fmt.Errorf("获取AI模型失败: %v", err)
+		return nil, fmt.Errorf("获取AI模型失败: %v", err)
 	}
 
 	// 调用AI服务
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	response, err := aiModel.ChatCompletion(ctx, ChatCompletionRequest{
-		Model:       aiConfig.ModelName,
-		Messages:    aiMessages,
-		Temperature: aiConfig.Temperature,
-		MaxTokens:   aiConfig.MaxTokens,
-	})
+	var used tokenUsage
+	for iteration := 0; ; iteration++ {
+		response, err := aiModel.ChatCompletion(ctx, s.newChatRequest(aiConfig, aiMessages, iteration))
+		if err != nil {
+			return nil, err
+		}
+
+		// 提取AI回复
+		if len(response.Choices) == 0 {
+			return nil, fmt.Errorf("AI返回了空回复")
+		}
+		reply := response.Choices[0].Message
+		used.add(&response.Usage, aiConfig.ModelName, aiMessages, reply)
+
+		// 模型请求调用工具时，执行工具后再次请求模型
+		if len(reply.ToolCalls) > 0 && iteration < maxToolIterations {
+			aiMessages, err = s.runToolCalls(ctx, env, sessionID, aiMessages, reply, aiConfig)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// 保存AI回复到数据库
+		assistantMessage := models.ChatMessage{
+			SessionID:        sessionID,
+			Role:             "assistant",
+			Content:          reply.Content,
+			ReasoningContent: reply.ReasoningContent,
+			Provider:         aiConfig.Provider,
+			ModelName:        aiConfig.ModelName,
+			CreatedAt:        time.Now(),
+		}
+		used.apply(&assistantMessage)
+		if err := s.DB.Create(&assistantMessage).Error; err != nil {
+			return nil, fmt.Errorf("保存AI回复失败: %v", err)
+		}
+		s.recordUsage(env.UserID, sessionID, aiConfig.Provider, aiConfig.ModelName, used)
+
+		return &assistantMessage, nil
+	}
+}
+
+// StreamChat 处理流式聊天，开启回复缓存时优先输出缓存，在尚未向客户端输出内容时出现可重试错误会切换到备用配置
+// ctx结束或会话被StopGeneration停止时取消对提供商的请求，已生成的部分回复标记为中断后保存并随错误一起返回
+// 配额由调用方在打开流之前检查，以便直接返回错误响应
+func (s *AIService) StreamChat(ctx context.Context, userID uint, sessionID uint, message string, aiConfig models.AIConfig, knowledgeIDs, attachmentIDs []uint, callback func(chunk *ChatCompletionChunk)) (*models.ChatMessage, *models.ChatSession, error) {
+	// 获取会话、构建请求消息并保存用户消息
+	chain, err := s.chatChain(aiConfig, attachmentIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, finish, session, aiMessages, err := s.prepareChat(ctx, userID, sessionID, message, knowledgeIDs, attachmentIDs, chain)
 	if err != nil {
-		return nil, nil, fmt.Errorf("AI服务调用失败: %v", err)
+		return nil, nil, err
+	}
+	defer finish()
+
+	// 记录是否已经向客户端输出过内容，输出后不再切换配置
+	streamed := false
+	streamCallback := func(chunk *ChatCompletionChunk) {
+		if len(chunk.Choices) > 0 {
+			delta := chunk.Choices[0].Delta
+			if delta.Content != "" || delta.ReasoningContent != "" {
+				streamed = true
+			}
+		}
+		callback(chunk)
 	}
 
-	// 提取AI回复
-	if len(response.Choices) == 0 {
-		return nil, nil, fmt.Errorf("AI返回了空回复")
+	// 相同的请求命中缓存时以数据块的形式输出缓存的回复
+	cache := newResponseCache(userID, aiMessages)
+	if reply := s.lookupCache(ctx, cache, chain[0]); reply != nil {
+		assistantMessage, err := s.saveCachedReply(session.ID, chain[0], reply)
+		if err != nil {
+			return nil, nil, fmt.Errorf("保存AI回复失败: %v", err)
+		}
+		for _, chunk := range cachedChunks(reply) {
+			callback(chunk)
+		}
+		s.updateLastMessage(session, assistantMessage.Content)
+		return assistantMessage, session, nil
+	}
+
+	env := ToolEnv{DB: s.DB, UserID: userID, KnowledgeIDs: knowledgeIDs}
+	var assistantMessage *models.ChatMessage
+	err = s.withFallback(chain, func(cfg models.AIConfig) error {
+		// 每个配置都从prepareChat构建的请求消息重新开始，失败尝试的工具调用不带给备用配置
+		lastID := s.lastMessageID(session.ID)
+		var err error
+		assistantMessage, err = s.streamWithConfig(ctx, env, session.ID, aiMessages, cfg, "", streamCallback)
+		if err != nil {
+			if assistantMessage != nil {
+				// 部分回复已保存，保留之前的工具调用记录
+				return &noFallbackError{err}
+			}
+			s.discardToolMessages(session.ID, lastID)
+			if streamed {
+				return &noFallbackError{err}
+			}
+			return err
+		}
+		s.storeCache(ctx, cache, cfg, assistantMessage)
+
+		// 更新会话最后消息，并在历史过长时异步更新摘要
+		s.updateLastMessage(session, assistantMessage.Content)
+		go s.maybeSummarize(session.ID, cfg)
+		return nil
+	})
+	if err == nil {
+		return assistantMessage, session, nil
+	}
+	if assistantMessage != nil {
+		// 生成中断，已保存部分回复
+		s.updateLastMessage(session, assistantMessage.Content)
+		return assistantMessage, session, fmt.Errorf("AI服务调用失败: %w", err)
 	}
 
-	aiReply := response.Choices[0].Message.Content
+	if cause := interruptedCause(ctx); cause != nil {
+		err = cause
+	}
+	return nil, nil, fmt.Errorf("AI服务调用失败: %w", err)
+}
 
-	// 保存AI回复到数据库
-	assistantMessage := models.ChatMessage{
-		SessionID: session.ID,
-		Role:      "assistant",
-		Content:   aiReply,
-		CreatedAt: time.Now(),
+// streamWithConfig 使用指定配置完成一次流式对话，包括工具调用循环
+// 生成被停止或客户端断开时，保存已生成的部分回复并返回中断原因
+// comparisonID不为空时，回复保存为该对比的候选回答
+func (s *AIService) streamWithConfig(ctx context.Context, env ToolEnv, sessionID uint, aiMessages []ChatMessage, aiConfig models.AIConfig, comparisonID string, callback func(chunk *ChatCompletionChunk)) (*models.ChatMessage, error) {
+	// 获取AI模型
+	aiModel, err := s.getAIModel(aiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("获取AI模型失败: %v", err)
 	}
-	if err := s.DB.Create(&assistantMessage).Error; err != nil {
-		return nil, nil, fmt.Errorf("保存AI回复失败: %v", err)
+
+	// 调用AI服务（流式）
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	var used tokenUsage
+	for iteration := 0; ; iteration++ {
+		// 用于收集完整回复、思维链、工具调用和用量的缓冲区
+		var fullReply, fullReasoning string
+		var toolCalls []ToolCall
+		var usage *Usage
+
+		// 处理流式回复的回调函数
+		streamCallback := func(chunk *ChatCompletionChunk) {
+			if len(chunk.Choices) > 0 {
+				delta := chunk.Choices[0].Delta
+				fullReply += delta.Content
+				fullReasoning += delta.ReasoningContent
+				toolCalls = mergeToolCallDeltas(toolCalls, delta.ToolCalls)
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			callback(chunk)
+		}
+
+		err = aiModel.StreamChatCompletion(ctx, s.newChatRequest(aiConfig, aiMessages, iteration), streamCallback)
+		if err != nil {
+			cause := interruptedCause(ctx)
+			if cause == nil {
+				return nil, err
+			}
+			if fullReply == "" && fullReasoning == "" {
+				return nil, cause
+			}
+			used.add(usage, aiConfig.ModelName, aiMessages, ChatMessage{Role: "assistant", Content: fullReply, ReasoningContent: fullReasoning})
+
+			// 保存已生成的部分回复，请求可能已被取消，不能使用ctx
+			partialMessage := models.ChatMessage{
+				SessionID:        sessionID,
+				Role:             "assistant",
+				Content:          fullReply,
+				ReasoningContent: fullReasoning,
+				Provider:         aiConfig.Provider,
+				ModelName:        aiConfig.ModelName,
+				Interrupted:      true,
+				CreatedAt:        time.Now(),
+			}
+			markCandidate(&partialMessage, comparisonID, aiConfig)
+			used.apply(&partialMessage)
+			if err := s.DB.Create(&partialMessage).Error; err != nil {
+				return nil, fmt.Errorf("保存中断的AI回复失败: %v", err)
+			}
+			s.recordUsage(env.UserID, sessionID, aiConfig.Provider, aiConfig.ModelName, used)
+			return &partialMessage, cause
+		}
+
+		reply := ChatMessage{Role: "assistant", Content: fullReply, ReasoningContent: fullReasoning, ToolCalls: toolCalls}
+		used.add(usage, aiConfig.ModelName, aiMessages, reply)
+
+		// 模型请求调用工具时，执行工具后再次请求模型
+		if len(toolCalls) > 0 && iteration < maxToolIterations {
+			aiMessages, err = s.runToolCalls(ctx, env, sessionID, aiMessages, reply, aiConfig)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// 保存完整回复到数据库
+		assistantMessage := models.ChatMessage{
+			SessionID:        sessionID,
+			Role:             "assistant",
+			Content:          fullReply,
+			ReasoningContent: fullReasoning,
+			Provider:         aiConfig.Provider,
+			ModelName:        aiConfig.ModelName,
+			CreatedAt:        time.Now(),
+		}
+		markCandidate(&assistantMessage, comparisonID, aiConfig)
+		used.apply(&assistantMessage)
+		if err := s.DB.Create(&assistantMessage).Error; err != nil {
+			return nil, fmt.Errorf("保存AI回复失败: %v", err)
+		}
+		s.recordUsage(env.UserID, sessionID, aiConfig.Provider, aiConfig.ModelName, used)
+
+		return &assistantMessage, nil
 	}
+}
 
-	// 更新会话最后消息
-	session.LastMessage = aiReply
-	s.DB.Save(&session)
+// chatChain 返回本次对话的配置调用链，附带图片时只保留支持图片输入的配置
+func (s *AIService) chatChain(aiConfig models.AIConfig, attachmentIDs []uint) ([]models.AIConfig, error) {
+	chain := s.getFallbackChain(aiConfig)
+	if len(attachmentIDs) == 0 {
+		return chain, nil
+	}
 
-	return &assistantMessage, session, nil
+	chain = filterChain(chain, func(c ModelCapabilities) bool { return c.Vision })
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("模型%s不支持图片输入", aiConfig.ModelName)
+	}
+	return chain, nil
 }
 
-// StreamChat 处理流式聊天
-func (s *AIService) StreamChat(userID uint, sessionID uint, message string, aiConfig models.AIConfig, knowledgeIDs []uint, writer gin.ResponseWriter, callback func(response *ChatCompletionResponse)) (string, *models.ChatSession, error) {
+// prepareChat 获取或创建会话并登记生成，构建AI请求消息并保存用户消息，返回生成的context和生成结束时调用的函数
+// 请求消息按调用链中上下文窗口最小的配置裁剪，保证切换备用配置时同样可用
+// 会话正在生成回复时返回ErrStreamBusy，不保存用户消息
+func (s *AIService) prepareChat(ctx context.Context, userID, sessionID uint, message string, knowledgeIDs, attachmentIDs []uint, chain []models.AIConfig) (context.Context, func(), *models.ChatSession, []ChatMessage, error) {
+	// 加载图片附件，图片随用户消息一起发送
+	newMessage := ChatMessage{Role: "user", Content: message}
+	if len(attachmentIDs) > 0 {
+		attachments, err := s.loadAttachments(userID, attachmentIDs)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		images, err := imageParts(attachments)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if message != "" {
+			newMessage.Parts = append(newMessage.Parts, TextPart(message))
+		}
+		newMessage.Parts = append(newMessage.Parts, images...)
+		attachmentIDs = uniqueIDs(attachmentIDs)
+	}
+
 	// 获取或创建会话
-	session, err := s.getOrCreateSession(userID, sessionID, message)
+	session, err := s.GetOrCreateSession(userID, sessionID, message)
 	if err != nil {
-		return "", nil, fmt.Errorf("会话处理失败: %v", err)
+		return nil, nil, nil, nil, fmt.Errorf("会话处理失败: %v", err)
+	}
+	ctx, finish, err := beginGeneration(ctx, session.ID)
+	if err != nil {
+		return nil, nil, nil, nil, err
 	}
 
 	// 获取历史消息
 	messages, err := s.getSessionMessages(session.ID)
 	if err != nil {
-		return "", nil, fmt.Errorf("获取历史消息失败: %v", err)
+		finish()
+		return nil, nil, nil, nil, fmt.Errorf("获取历史消息失败: %v", err)
 	}
 
-	// 构建AI请求消息
-	aiMessages := s.buildAIMessages(messages, message, knowledgeIDs, userID)
+	// 构建AI请求消息，未指定知识库时使用会话角色的默认知识库，共享角色的知识库属于角色创建者
+	persona := s.SessionPersona(session)
+	var knowledge []string
+	if len(knowledgeIDs) > 0 {
+		knowledge = s.getKnowledgeContent(knowledgeIDs, userID)
+	} else if persona != nil && len(persona.KnowledgeIDs) > 0 {
+		knowledge = s.getKnowledgeContent(persona.KnowledgeIDs, persona.UserID)
+	}
+	model, budget := contextBudget(chain)
+	aiMessages := s.buildAIMessages(session, systemPrompt(persona), messages, newMessage, knowledge, model, budget)
 
 	// 保存用户消息
 	userMessage := models.ChatMessage{
-		SessionID: session.ID,
-		Role:      "user",
-		Content:   message,
-		CreatedAt: time.Now(),
+		SessionID:     session.ID,
+		Role:          "user",
+		Content:       message,
+		AttachmentIDs: attachmentIDs,
+		CreatedAt:     time.Now(),
 	}
 	if err := s.DB.Create(&userMessage).Error; err != nil {
-		return "", nil, fmt.Errorf("保存用户消息失败: %v", err)
+		finish()
+		return nil, nil, nil, nil, fmt.Errorf("保存用户消息失败: %v", err)
 	}
-
-	// 更新会话最后消息
-	session.LastMessage = message
-	s.DB.Save(&session)
-
-	// 获取AI模型
-	aiModel, err := GetAIModel(aiConfig.Provider)
-	if err != nil {
-		return "", nil, fmt.Errorf("获取AI模型失败: %v", err)
+	if len(attachmentIDs) > 0 {
+		s.DB.Model(&models.ChatAttachment{}).Where("id IN ?", attachmentIDs).Update("message_id", userMessage.ID)
 	}
 
-	// 用于收集完整回复的缓冲区
-	var fullReply string
-
-	// 调用AI服务（流式）
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
+	// 更新会话最后消息和使用的AI配置
+	session.AIConfigID = chain[0].ID
+	s.DB.Model(session).Update("ai_config_id", session.AIConfigID)
+	s.updateLastMessage(session, message)
 
-	// 处理流式回复的回调函数
-	streamCallback := func(response *ChatCompletionResponse) {
-		if len(response.Choices) > 0 {
-			chunk := response.Choices[0].Message.Content
-			fullReply += chunk
-		}
-		callback(response)
-	}
+	return ctx, finish, session, aiMessages, nil
+}
 
-	err = aiModel.StreamChatCompletion(ctx, ChatCompletionRequest{
+// newChatRequest 根据AI配置构建聊天请求，iteration为当前的工具调用轮数
+func (s *AIService) newChatRequest(aiConfig models.AIConfig, aiMessages []ChatMessage, iteration int) ChatCompletionRequest {
+	request := ChatCompletionRequest{
 		Model:       aiConfig.ModelName,
 		Messages:    aiMessages,
 		Temperature: aiConfig.Temperature,
 		MaxTokens:   aiConfig.MaxTokens,
-		Stream:      true,
-	}, streamCallback)
-
-	if err != nil {
-		return "", nil, fmt.Errorf("AI服务调用失败: %v", err)
+		Stop:        aiConfig.Stop,
+		Seed:        aiConfig.Seed,
 	}
 
-	// 保存完整回复到数据库
-	assistantMessage := models.ChatMessage{
-		SessionID: session.ID,
-		Role:      "assistant",
-		Content:   fullReply,
-		CreatedAt: time.Now(),
+	// 推理模型会忽略采样参数，不支持时不发送
+	if Capabilities(aiConfig.ModelName).Sampling {
+		request.TopP = aiConfig.TopP
+		request.PresencePenalty = aiConfig.PresencePenalty
+		request.FrequencyPenalty = aiConfig.FrequencyPenalty
 	}
-	if err := s.DB.Create(&assistantMessage).Error; err != nil {
-		return "", nil, fmt.Errorf("保存AI回复失败: %v", err)
+	if aiConfig.ResponseFormat != "" && aiConfig.ResponseFormat != "text" {
+		request.ResponseFormat = &ResponseFormat{Type: aiConfig.ResponseFormat}
 	}
 
-	// 更新会话最后消息
-	session.LastMessage = fullReply
-	s.DB.Save(&session)
+	// 开启工具时附带工具定义，达到最大轮数后要求模型直接回答
+	if aiConfig.EnableTools {
+		request.Tools = ListTools()
+		if iteration >= maxToolIterations {
+			request.ToolChoice = "none"
+		}
+	}
+
+	return request
+}
+
+// runToolCalls 保存模型的工具调用消息，执行工具并保存结果，返回追加后的请求消息
+func (s *AIService) runToolCalls(ctx context.Context, env ToolEnv, sessionID uint, aiMessages []ChatMessage, reply ChatMessage, aiConfig models.AIConfig) ([]ChatMessage, error) {
+	// 保存发起工具调用的assistant消息
+	toolCallsJSON, err := json.Marshal(reply.ToolCalls)
+	if err != nil {
+		return nil, fmt.Errorf("编码工具调用失败: %v", err)
+	}
+	callMessage := models.ChatMessage{
+		SessionID:        sessionID,
+		Role:             "assistant",
+		Content:          reply.Content,
+		ReasoningContent: reply.ReasoningContent,
+		ToolCalls:        string(toolCallsJSON),
+		Provider:         aiConfig.Provider,
+		ModelName:        aiConfig.ModelName,
+		CreatedAt:        time.Now(),
+	}
+	if err := s.DB.Create(&callMessage).Error; err != nil {
+		return nil, fmt.Errorf("保存工具调用失败: %v", err)
+	}
+	// 限制容量使追加时复制底层数组，不修改调用方传入的请求消息
+	aiMessages = append(aiMessages[:len(aiMessages):len(aiMessages)], ChatMessage{
+		Role:      "assistant",
+		Content:   reply.Content,
+		ToolCalls: reply.ToolCalls,
+	})
+
+	// 依次执行工具并保存结果
+	for _, call := range reply.ToolCalls {
+		result := executeToolCall(ctx, env, call)
 
-	return fullReply, session, nil
+		toolMessage := models.ChatMessage{
+			SessionID:  sessionID,
+			Role:       "tool",
+			Content:    result,
+			ToolCallID: call.ID,
+			CreatedAt:  time.Now(),
+		}
+		if err := s.DB.Create(&toolMessage).Error; err != nil {
+			return nil, fmt.Errorf("保存工具结果失败: %v", err)
+		}
+		aiMessages = append(aiMessages, ChatMessage{
+			Role:       "tool",
+			Content:    result,
+			ToolCallID: call.ID,
+		})
+	}
+
+	return aiMessages, nil
 }
 
 // 会话管理服务 ---------------------------------------------------------
@@ -241,8 +544,23 @@ func (s *AIService) GetSessionMessages(sessionID, userID uint, page, pageSize in
 	return messages, count, nil
 }
 
-// UpdateSession 更新会话信息
-func (s *AIService) UpdateSession(sessionID, userID uint, title string) (*models.ChatSession, error) {
+// GetSession 获取会话详情
+func (s *AIService) GetSession(sessionID, userID uint) (*models.ChatSession, error) {
+	var session models.ChatSession
+	if err := s.DB.First(&session, sessionID).Error; err != nil {
+		return nil, fmt.Errorf("会话不存在")
+	}
+
+	if session.UserID != userID {
+		return nil, fmt.Errorf("无权访问此会话")
+	}
+
+	return &session, nil
+}
+
+// UpdateSession 更新会话信息，title、summary和personaID为nil时保持不变
+// 手动编辑摘要不改变摘要覆盖的消息范围，清空摘要时所有历史消息恢复原文发送
+func (s *AIService) UpdateSession(sessionID, userID uint, title, summary *string, personaID *uint) (*models.ChatSession, error) {
 	// 验证会话存在性和所有权
 	var session models.ChatSession
 	if err := s.DB.First(&session, sessionID).Error; err != nil {
@@ -253,15 +571,88 @@ func (s *AIService) UpdateSession(sessionID, userID uint, title string) (*models
 		return nil, fmt.Errorf("无权修改此会话")
 	}
 
-	// 更新会话标题
-	session.Title = title
-	if err := s.DB.Save(&session).Error; err != nil {
-		return nil, fmt.Errorf("更新会话失败: %v", err)
+	// 切换会话角色
+	if personaID != nil {
+		if err := s.SetSessionPersona(&session, *personaID); err != nil {
+			return nil, err
+		}
+	}
+
+	// 更新会话标题和摘要
+	updates := map[string]interface{}{}
+	if title != nil {
+		updates["title"] = *title
+	}
+	if summary != nil {
+		updates["summary"] = *summary
+		if *summary == "" {
+			updates["summary_until_id"] = 0
+		}
+	}
+	if len(updates) > 0 {
+		if err := s.DB.Model(&session).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("更新会话失败: %v", err)
+		}
+		s.DB.First(&session, sessionID)
 	}
 
 	return &session, nil
 }
 
+// UpdateMessage 编辑会话中的消息内容
+func (s *AIService) UpdateMessage(sessionID, messageID, userID uint, content string) (*models.ChatMessage, error) {
+	session, message, err := s.getOwnedMessage(sessionID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Content = content
+	if err := s.DB.Model(message).Update("content", content).Error; err != nil {
+		return nil, fmt.Errorf("更新消息失败: %v", err)
+	}
+
+	// 被编辑的消息已包含在摘要中时重新生成摘要
+	if session.Summary != "" && message.ID <= session.SummaryUntilID {
+		go s.recomputeSummary(session.ID)
+	}
+
+	return message, nil
+}
+
+// DeleteMessage 删除会话中的消息
+func (s *AIService) DeleteMessage(sessionID, messageID, userID uint) error {
+	session, message, err := s.getOwnedMessage(sessionID, messageID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.DB.Delete(message).Error; err != nil {
+		return fmt.Errorf("删除消息失败: %v", err)
+	}
+
+	// 被删除的消息已包含在摘要中时重新生成摘要
+	if session.Summary != "" && message.ID <= session.SummaryUntilID {
+		go s.recomputeSummary(session.ID)
+	}
+
+	return nil
+}
+
+// getOwnedMessage 获取属于用户会话的消息
+func (s *AIService) getOwnedMessage(sessionID, messageID, userID uint) (*models.ChatSession, *models.ChatMessage, error) {
+	session, err := s.GetSession(sessionID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var message models.ChatMessage
+	if err := s.DB.Where("id = ? AND session_id = ?", messageID, sessionID).First(&message).Error; err != nil {
+		return nil, nil, fmt.Errorf("消息不存在")
+	}
+
+	return session, &message, nil
+}
+
 // DeleteSession 删除会话
 func (s *AIService) DeleteSession(sessionID, userID uint) error {
 	// 验证会话存在性和所有权
@@ -323,9 +714,6 @@ func (s *AIService) ProcessKnowledgeFile(file models.KnowledgeFile) {
 	// 更新状态为处理中
 	s.DB.Model(&file).Update("status", "processing")
 
-	// 模拟处理时间
-	time.Sleep(2 * time.Second)
-
 	// 读取文件内容
 	content, err := os.ReadFile(file.FilePath)
 	if err != nil {
@@ -337,13 +725,35 @@ func (s *AIService) ProcessKnowledgeFile(file models.KnowledgeFile) {
 	text := string(content)
 	chunks := s.ChunkText(text, global.ChunkSize)
 
-	// 保存文本块到向量存储
-	for _, chunk := range chunks {
+	// 计算文本块的向量嵌入
+	embedder, err := GetEmbedder()
+	if err != nil {
+		log.Printf("知识库文件%d获取嵌入模型失败: %v", file.ID, err)
+		s.DB.Model(&file).Update("status", "failed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	vectors, err := embedder.Embed(ctx, chunks)
+	if err != nil {
+		log.Printf("知识库文件%d计算嵌入失败: %v", file.ID, err)
+		s.DB.Model(&file).Update("status", "failed")
+		return
+	}
+
+	// 保存文本块到向量存储，元数据记录嵌入模型和维度
+	for i, chunk := range chunks {
+		metadata, _ := json.Marshal(map[string]interface{}{
+			"source":          file.FileName,
+			"chunk_index":     i,
+			"embedding_model": embedder.Model(),
+			"dimension":       len(vectors[i]),
+		})
 		vectorStore := models.KnowledgeVectorStore{
 			FileID:    file.ID,
 			Text:      chunk,
-			Embedding: []byte{}, // 实际应该调用嵌入模型
-			Metadata:  `{"source": "` + file.FileName + `"}`,
+			Embedding: EncodeEmbedding(vectors[i]),
+			Metadata:  string(metadata),
 		}
 
 		if err := s.DB.Create(&vectorStore).Error; err != nil {
@@ -475,7 +885,7 @@ func (s *AIService) GetDefaultAIConfig(userID uint) (*models.AIConfig, error) {
 		// 如果没有默认配置，则创建一个
 		newConfig := models.AIConfig{
 			UserID:      userID,
-			ModelName:   "deepseek-v1-8k",
+			ModelName:   "deepseek-chat",
 			Temperature: 0.7,
 			MaxTokens:   2048,
 			Provider:    "deepseek",
@@ -518,7 +928,7 @@ func (s *AIService) GetAIConfigs(userID uint) ([]models.AIConfig, error) {
 		// 为DeepSeek创建默认配置
 		deepseekConfig := models.AIConfig{
 			UserID:      userID,
-			ModelName:   "deepseek-v1-8k",
+			ModelName:   "deepseek-chat",
 			Temperature: 0.7,
 			MaxTokens:   2048,
 			Provider:    "deepseek",
@@ -547,9 +957,17 @@ func (s *AIService) GetAIConfigs(userID uint) ([]models.AIConfig, error) {
 }
 
 // CreateAIConfig 创建AI配置
-func (s *AIService) CreateAIConfig(userID uint, modelName string, temperature float64, maxTokens int, provider string, isDefault bool) (*models.AIConfig, error) {
+func (s *AIService) CreateAIConfig(userID uint, input models.AIConfig) (*models.AIConfig, error) {
+	// 校验备用配置
+	if err := s.validateFallbackConfigs(userID, 0, input.FallbackConfigIDs); err != nil {
+		return nil, err
+	}
+	if err := s.validateCredential(userID, input.CredentialID, input.Provider); err != nil {
+		return nil, err
+	}
+
 	// 如果设置为默认，则将其他配置设为非默认
-	if isDefault {
+	if input.IsDefault {
 		if err := s.DB.Model(&models.AIConfig{}).Where("user_id = ?", userID).
 			Update("is_default", false).Error; err != nil {
 			return nil, fmt.Errorf("更新默认配置状态失败: %v", err)
@@ -558,12 +976,21 @@ func (s *AIService) CreateAIConfig(userID uint, modelName string, temperature fl
 
 	// 创建新配置
 	config := models.AIConfig{
-		UserID:      userID,
-		ModelName:   modelName,
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Provider:    provider,
-		IsDefault:   isDefault,
+		UserID:            userID,
+		ModelName:         input.ModelName,
+		Temperature:       input.Temperature,
+		MaxTokens:         input.MaxTokens,
+		Provider:          input.Provider,
+		IsDefault:         input.IsDefault,
+		EnableTools:       input.EnableTools,
+		TopP:              input.TopP,
+		PresencePenalty:   input.PresencePenalty,
+		FrequencyPenalty:  input.FrequencyPenalty,
+		Stop:              input.Stop,
+		Seed:              input.Seed,
+		ResponseFormat:    input.ResponseFormat,
+		FallbackConfigIDs: input.FallbackConfigIDs,
+		CredentialID:      input.CredentialID,
 	}
 
 	if err := s.DB.Create(&config).Error; err != nil {
@@ -574,7 +1001,7 @@ func (s *AIService) CreateAIConfig(userID uint, modelName string, temperature fl
 }
 
 // UpdateAIConfig 更新AI配置
-func (s *AIService) UpdateAIConfig(configID, userID uint, modelName string, temperature float64, maxTokens int, provider string, isDefault bool) (*models.AIConfig, error) {
+func (s *AIService) UpdateAIConfig(configID, userID uint, input models.AIConfig) (*models.AIConfig, error) {
 	// 获取配置
 	var config models.AIConfig
 	if err := s.DB.First(&config, configID).Error; err != nil {
@@ -586,8 +1013,16 @@ func (s *AIService) UpdateAIConfig(configID, userID uint, modelName string, temp
 		return nil, fmt.Errorf("无权修改此配置")
 	}
 
+	// 校验备用配置
+	if err := s.validateFallbackConfigs(userID, configID, input.FallbackConfigIDs); err != nil {
+		return nil, err
+	}
+	if err := s.validateCredential(userID, input.CredentialID, input.Provider); err != nil {
+		return nil, err
+	}
+
 	// 如果将配置设置为默认，则将其他配置设为非默认
-	if isDefault && !config.IsDefault {
+	if input.IsDefault && !config.IsDefault {
 		if err := s.DB.Model(&models.AIConfig{}).Where("user_id = ?", userID).
 			Update("is_default", false).Error; err != nil {
 			return nil, fmt.Errorf("更新默认配置状态失败: %v", err)
@@ -595,11 +1030,20 @@ func (s *AIService) UpdateAIConfig(configID, userID uint, modelName string, temp
 	}
 
 	// 更新配置
-	config.ModelName = modelName
-	config.Temperature = temperature
-	config.MaxTokens = maxTokens
-	config.Provider = provider
-	config.IsDefault = isDefault
+	config.ModelName = input.ModelName
+	config.Temperature = input.Temperature
+	config.MaxTokens = input.MaxTokens
+	config.Provider = input.Provider
+	config.IsDefault = input.IsDefault
+	config.EnableTools = input.EnableTools
+	config.TopP = input.TopP
+	config.PresencePenalty = input.PresencePenalty
+	config.FrequencyPenalty = input.FrequencyPenalty
+	config.Stop = input.Stop
+	config.Seed = input.Seed
+	config.ResponseFormat = input.ResponseFormat
+	config.FallbackConfigIDs = input.FallbackConfigIDs
+	config.CredentialID = input.CredentialID
 
 	if err := s.DB.Save(&config).Error; err != nil {
 		return nil, fmt.Errorf("更新配置失败: %v", err)
@@ -636,8 +1080,8 @@ func (s *AIService) DeleteAIConfig(configID, userID uint) error {
 
 // 辅助方法 ---------------------------------------------------------
 
-// getOrCreateSession 获取或创建会话
-func (s *AIService) getOrCreateSession(userID, sessionID uint, message string) (*models.ChatSession, error) {
+// GetOrCreateSession 获取或创建会话，sessionID为0时以消息内容作为标题创建新会话
+func (s *AIService) GetOrCreateSession(userID, sessionID uint, message string) (*models.ChatSession, error) {
 	var session models.ChatSession
 
 	if sessionID > 0 {
@@ -670,70 +1114,262 @@ func (s *AIService) getOrCreateSession(userID, sessionID uint, message string) (
 	return &session, nil
 }
 
-// getSessionMessages 获取会话的历史消息
+// updateLastMessage 更新会话最后消息，只更新该字段以免覆盖并发生成的摘要
+func (s *AIService) updateLastMessage(session *models.ChatSession, content string) {
+	session.LastMessage = content
+	s.DB.Model(session).Update("last_message", content)
+}
+
+// validateFallbackConfigs 校验备用配置均属于该用户且不包含配置自身
+func (s *AIService) validateFallbackConfigs(userID, configID uint, fallbackIDs []uint) error {
+	for _, id := range fallbackIDs {
+		if id == configID {
+			return fmt.Errorf("备用配置不能包含自身")
+		}
+	}
+	if len(fallbackIDs) == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.AIConfig{}).Where("id IN ? AND user_id = ?", fallbackIDs, userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("查询备用配置失败: %v", err)
+	}
+	if int(count) != len(uniqueIDs(fallbackIDs)) {
+		return fmt.Errorf("备用配置不存在或无权访问")
+	}
+	return nil
+}
+
+// getFallbackChain 返回主配置及其备用配置组成的调用链，已删除的备用配置会被跳过
+func (s *AIService) getFallbackChain(aiConfig models.AIConfig) []models.AIConfig {
+	chain := []models.AIConfig{aiConfig}
+	if len(aiConfig.FallbackConfigIDs) == 0 {
+		return chain
+	}
+
+	var fallbacks []models.AIConfig
+	if err := s.DB.Where("id IN ? AND user_id = ?", aiConfig.FallbackConfigIDs, aiConfig.UserID).Find(&fallbacks).Error; err != nil {
+		return chain
+	}
+
+	// 按声明顺序排列
+	byID := make(map[uint]models.AIConfig, len(fallbacks))
+	for _, fallback := range fallbacks {
+		byID[fallback.ID] = fallback
+	}
+	for _, id := range uniqueIDs(aiConfig.FallbackConfigIDs) {
+		if fallback, ok := byID[id]; ok && id != aiConfig.ID {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// lastMessageID 返回会话中最后一条消息的ID，没有消息时返回0
+func (s *AIService) lastMessageID(sessionID uint) uint {
+	var id uint
+	s.DB.Model(&models.ChatMessage{}).Where("session_id = ?", sessionID).Select("COALESCE(MAX(id), 0)").Scan(&id)
+	return id
+}
+
+// discardToolMessages 删除会话中afterID之后保存的工具调用和工具结果，用于清理失败尝试的记录
+func (s *AIService) discardToolMessages(sessionID, afterID uint) {
+	if err := s.DB.Where("session_id = ? AND id > ? AND (role = ? OR tool_calls <> '')", sessionID, afterID, "tool").Delete(&models.ChatMessage{}).Error; err != nil {
+		log.Printf("清理会话%d失败尝试的工具调用记录失败: %v", sessionID, err)
+	}
+}
+
+// noFallbackError 包装不应再切换备用配置的错误，例如已经向客户端输出过内容
+type noFallbackError struct {
+	err error
+}
+
+func (e *noFallbackError) Error() string { return e.err.Error() }
+func (e *noFallbackError) Unwrap() error { return e.err }
+
+// withFallback 依次使用调用链中的配置执行attempt，出现可重试错误时切换到下一个配置
+// attempt返回noFallbackError时直接返回其中的错误，不再切换
+func (s *AIService) withFallback(chain []models.AIConfig, attempt func(cfg models.AIConfig) error) error {
+	var err error
+	for i, cfg := range chain {
+		err = attempt(cfg)
+		if err == nil {
+			return nil
+		}
+
+		var stop *noFallbackError
+		if errors.As(err, &stop) {
+			return stop.err
+		}
+		if !IsRetryable(err) || i == len(chain)-1 {
+			break
+		}
+		log.Printf("AI配置%d(%s/%s)调用失败，切换到备用配置: %v", cfg.ID, cfg.Provider, cfg.ModelName, err)
+	}
+	return err
+}
+
+// uniqueIDs 去除重复ID并保持顺序
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// getSessionMessages 获取会话的历史消息，尚未选出胜者的对比回答不属于历史
 func (s *AIService) getSessionMessages(sessionID uint) ([]models.ChatMessage, error) {
 	var messages []models.ChatMessage
-	if err := s.DB.Where("session_id = ?", sessionID).Order("created_at asc").Find(&messages).Error; err != nil {
+	if err := s.DB.Where("session_id = ? AND candidate = ?", sessionID, false).Order("created_at asc").Find(&messages).Error; err != nil {
 		return nil, err
 	}
 	return messages, nil
 }
 
-// buildAIMessages 构建AI请求消息列表
-func (s *AIService) buildAIMessages(messages []models.ChatMessage, newMessage string, knowledgeIDs []uint, userID uint) []ChatMessage {
-	aiMessages := []ChatMessage{}
-
-	// 添加系统消息
-	aiMessages = append(aiMessages, ChatMessage{
+// buildAIMessages 构建AI请求消息列表，并按token预算裁剪
+// 系统提示词和最新的用户消息始终保留；知识库最多占用剩余预算的一半，
+// 历史消息从最新的开始倒序保留，超出预算的旧消息和知识库内容会被丢弃或截断
+func (s *AIService) buildAIMessages(session *models.ChatSession, prompt string, messages []models.ChatMessage, userMessage ChatMessage, knowledge []string, model string, budget int) []ChatMessage {
+	// 系统消息
+	systemMessage := ChatMessage{
 		Role:    "system",
-		Content: global.DefaultSystemPrompt,
-	})
+		Content: prompt,
+	}
 
-	// 添加知识库内容到系统提示（如果有）
-	if len(knowledgeIDs) > 0 {
-		knowledgeContent := s.getKnowledgeContent(knowledgeIDs, userID)
-		if knowledgeContent != "" {
-			aiMessages[0].Content += "\n\n以下是一些你可以参考的知识：\n" + knowledgeContent
+	// 转换历史消息，已被摘要的消息和思维链(ReasoningContent)不回传给模型
+	history := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if session.Summary != "" && msg.ID <= session.SummaryUntilID {
+			continue
+		}
+		aiMessage := ChatMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+		// 历史图片不再重复发送，以文字说明代替
+		if len(msg.AttachmentIDs) > 0 {
+			aiMessage.Content += fmt.Sprintf("\n[用户上传了%d张图片]", len(msg.AttachmentIDs))
 		}
+		if msg.ToolCalls != "" {
+			json.Unmarshal([]byte(msg.ToolCalls), &aiMessage.ToolCalls)
+		}
+		history = append(history, aiMessage)
 	}
 
-	// 添加历史消息
-	for _, msg := range messages {
-		aiMessages = append(aiMessages, ChatMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
+	remaining := budget - EstimateMessageTokens(model, systemMessage) - EstimateMessageTokens(model, userMessage)
+
+	// 摘要紧跟在系统提示词之后，与系统提示词一样始终保留
+	var prefix []ChatMessage
+	if session.Summary != "" {
+		prefix = append(prefix, summaryMessage(session.Summary))
+		remaining -= EstimateMessagesTokens(model, prefix)
 	}
 
-	// 添加用户最新消息
-	aiMessages = append(aiMessages, ChatMessage{
-		Role:    "user",
-		Content: newMessage,
-	})
+	// 为知识库预留最多一半的剩余预算，其余用于历史消息
+	knowledgeTokens := 0
+	for _, chunk := range knowledge {
+		knowledgeTokens += EstimateTokens(model, chunk)
+	}
+	knowledgeReserve := min(knowledgeTokens, max(remaining, 0)/2)
+	history = trimHistory(model, history, remaining-knowledgeReserve)
+	remaining -= EstimateMessagesTokens(model, history)
 
-	return aiMessages
+	// 添加知识库内容到系统提示（如果有），历史消息未用完的预算也可用于知识库
+	if len(knowledge) > 0 {
+		remaining -= EstimateTokens(model, knowledgeHeader)
+		if knowledgeContent := fitKnowledge(model, knowledge, remaining); knowledgeContent != "" {
+			systemMessage.Content += knowledgeHeader + knowledgeContent
+		}
+	}
+
+	aiMessages := []ChatMessage{systemMessage}
+	aiMessages = append(aiMessages, prefix...)
+	aiMessages = append(aiMessages, history...)
+	return append(aiMessages, userMessage)
+}
+
+// 知识库内容在系统提示词中的引导语
+const knowledgeHeader = "\n\n以下是一些你可以参考的知识：\n"
+
+// trimHistory 从最新的消息开始倒序保留不超过预算的历史消息
+func trimHistory(model string, history []ChatMessage, budget int) []ChatMessage {
+	start := len(history)
+	used := 0
+	for start > 0 {
+		tokens := EstimateMessageTokens(model, history[start-1])
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start--
+	}
+
+	// 对应的工具调用已被裁掉的工具结果不能单独发送
+	for start < len(history) && history[start].Role == "tool" {
+		start++
+	}
+	return history[start:]
+}
+
+// fitKnowledge 按顺序拼接不超过预算的知识块，最后一个放不下的知识块会被截断
+func fitKnowledge(model string, knowledge []string, budget int) string {
+	var builder strings.Builder
+	for _, chunk := range knowledge {
+		if budget <= 0 {
+			break
+		}
+		tokens := EstimateTokens(model, chunk+"\n")
+		if tokens > budget {
+			builder.WriteString(truncateToTokens(model, chunk, budget))
+			break
+		}
+		builder.WriteString(chunk + "\n")
+		budget -= tokens
+	}
+	return builder.String()
+}
+
+// contextBudget 计算调用链中所有配置都能容纳的输入token预算，返回预算最小的模型及其预算
+// 预算为上下文长度扣除为回复预留的MaxTokens，并保留10%余量抵消估算误差
+// 预留的MaxTokens不超过模型允许的上限，避免旧配置的max_tokens占满上下文导致历史和知识库被全部丢弃
+func contextBudget(chain []models.AIConfig) (string, int) {
+	model, budget := "", 0
+	for i, cfg := range chain {
+		reserved := min(cfg.MaxTokens, Capabilities(cfg.ModelName).maxOutputTokens())
+		cfgBudget := (ContextLimit(cfg.ModelName) - reserved) * 9 / 10
+		if i == 0 || cfgBudget < budget {
+			model, budget = cfg.ModelName, cfgBudget
+		}
+	}
+	return model, budget
 }
 
-// getKnowledgeContent 获取知识库内容
-func (s *AIService) getKnowledgeContent(knowledgeIDs []uint, userID uint) string {
-	var knowledgeContent string
+// getKnowledgeContent 获取知识库内容，按文件和分块顺序返回
+func (s *AIService) getKnowledgeContent(knowledgeIDs []uint, userID uint) []string {
+	var knowledgeContent []string
 
 	// 获取用户所有可用知识库文件
 	var knowledgeFiles []models.KnowledgeFile
 	if err := s.DB.Where("id IN ? AND user_id = ? AND status = ?", knowledgeIDs, userID, "completed").Find(&knowledgeFiles).Error; err != nil {
-		return ""
+		return nil
 	}
 
 	// 对于每个知识库文件，获取其向量存储内容
 	for _, file := range knowledgeFiles {
 		var vectors []models.KnowledgeVectorStore
-		if err := s.DB.Where("file_id = ?", file.ID).Find(&vectors).Error; err != nil {
+		if err := s.DB.Where("file_id = ?", file.ID).Order("id asc").Find(&vectors).Error; err != nil {
 			continue
 		}
 
-		// 向系统消息添加知识内容
 		for _, vector := range vectors {
-			knowledgeContent += vector.Text + "\n"
+			knowledgeContent = append(knowledgeContent, vector.Text)
 		}
 	}
 
@@ -0,0 +1,357 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestService 创建使用内存数据库的AI服务，并注册一个使用给定模拟模型的提供商
+func newTestService(t *testing.T, providers map[string]*MockModel) *AIService {
+	t.Helper()
+	for name, model := range providers {
+		model := model
+		RegisterProvider(Provider{Name: name, Models: []string{"mock-echo"}, New: func(Provider) AIModel { return model }})
+		t.Cleanup(func() { removeBreakers(name) })
+	}
+	return NewAIService(aitest.NewDB(t))
+}
+
+// sessionMessages 按顺序返回会话中保存的所有消息
+func sessionMessages(t *testing.T, s *AIService, sessionID uint) []models.ChatMessage {
+	t.Helper()
+	messages, err := s.getSessionMessages(sessionID)
+	if err != nil {
+		t.Fatalf("getSessionMessages() error = %v", err)
+	}
+	return messages
+}
+
+func TestAIServiceChatEcho(t *testing.T) {
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-chat": model})
+	aiConfig := models.AIConfig{Provider: "mock-chat", ModelName: "mock-echo", MaxTokens: 256}
+
+	reply, session, err := s.Chat(context.Background(), 1, 0, "你好", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if reply.Content != "echo: 你好" {
+		t.Errorf("Content = %q", reply.Content)
+	}
+	if reply.Provider != "mock-chat" || reply.ModelName != "mock-echo" {
+		t.Errorf("Provider/ModelName = %q/%q", reply.Provider, reply.ModelName)
+	}
+
+	// 第二轮对话应带上第一轮的历史
+	if _, _, err := s.Chat(context.Background(), 1, session.ID, "再见", aiConfig, nil, nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	messages := sessionMessages(t, s, session.ID)
+	if len(messages) != 4 {
+		t.Fatalf("len(messages) = %d, want 4", len(messages))
+	}
+	requests := model.Requests()
+	last := requests[len(requests)-1].Messages
+	if len(last) != 4 || last[1].Content != "你好" || last[2].Content != "echo: 你好" || last[3].Content != "再见" {
+		t.Errorf("request messages = %+v", last)
+	}
+}
+
+func TestAIServiceChatToolLoop(t *testing.T) {
+	model := NewMockModel(
+		ChatMessage{ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: ToolCallFunction{Name: "calculator", Arguments: `{"expression":"(1+2)*3"}`},
+		}}},
+		ChatMessage{Content: "结果是9"},
+	)
+	s := newTestService(t, map[string]*MockModel{"mock-tools": model})
+	aiConfig := models.AIConfig{Provider: "mock-tools", ModelName: "mock-echo", EnableTools: true}
+
+	reply, session, err := s.Chat(context.Background(), 1, 0, "(1+2)*3等于多少", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if reply.Content != "结果是9" {
+		t.Errorf("Content = %q", reply.Content)
+	}
+
+	// 用户消息、工具调用、工具结果和最终回复都应保存
+	messages := sessionMessages(t, s, session.ID)
+	roles := make([]string, 0, len(messages))
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	if want := []string{"user", "assistant", "tool", "assistant"}; len(roles) != len(want) || roles[1] != want[1] || roles[2] != want[2] {
+		t.Fatalf("roles = %v, want %v", roles, want)
+	}
+	if messages[2].Content != "9" || messages[2].ToolCallID != "call_1" {
+		t.Errorf("tool message = %+v", messages[2])
+	}
+	var calls []ToolCall
+	if err := json.Unmarshal([]byte(messages[1].ToolCalls), &calls); err != nil || len(calls) != 1 {
+		t.Errorf("ToolCalls = %q, err = %v", messages[1].ToolCalls, err)
+	}
+
+	// 第二次请求应携带工具结果
+	requests := model.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("len(requests) = %d, want 2", len(requests))
+	}
+	if len(requests[0].Tools) == 0 {
+		t.Error("first request has no tools")
+	}
+	last := requests[1].Messages[len(requests[1].Messages)-1]
+	if last.Role != "tool" || last.Content != "9" {
+		t.Errorf("last request message = %+v", last)
+	}
+}
+
+func TestAIServiceChatFallback(t *testing.T) {
+	primary := NewMockModel()
+	primary.Err = &ProviderError{Provider: "mock-primary", Kind: ErrKindServer, StatusCode: 503, Message: "overloaded"}
+	backup := NewMockModel(ChatMessage{Content: "来自备用配置"})
+	s := newTestService(t, map[string]*MockModel{"mock-primary": primary, "mock-backup": backup})
+
+	backupConfig := models.AIConfig{UserID: 1, Provider: "mock-backup", ModelName: "mock-echo"}
+	if err := s.DB.Create(&backupConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	primaryConfig := models.AIConfig{UserID: 1, Provider: "mock-primary", ModelName: "mock-echo", FallbackConfigIDs: []uint{backupConfig.ID}}
+	if err := s.DB.Create(&primaryConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	reply, _, err := s.Chat(context.Background(), 1, 0, "你好", primaryConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if reply.Content != "来自备用配置" || reply.Provider != "mock-backup" {
+		t.Errorf("reply = %q from %q", reply.Content, reply.Provider)
+	}
+}
+
+func TestAIServiceChatFallbackAfterToolCalls(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		primary := NewMockModel(ChatMessage{ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: ToolCallFunction{Name: "calculator", Arguments: `{"expression":"1+1"}`},
+		}}})
+		primary.Err = &ProviderError{Provider: "mock-tools-primary", Kind: ErrKindServer, StatusCode: 503, Message: "overloaded"}
+		primary.FailAfter = 1
+		backup := NewMockModel(ChatMessage{Content: "来自备用配置"})
+		s := newTestService(t, map[string]*MockModel{"mock-tools-primary": primary, "mock-tools-backup": backup})
+
+		// 备用配置未开启工具，请求中不能带有主配置的工具调用
+		backupConfig := models.AIConfig{UserID: 1, Provider: "mock-tools-backup", ModelName: "mock-echo"}
+		if err := s.DB.Create(&backupConfig).Error; err != nil {
+			t.Fatal(err)
+		}
+		primaryConfig := models.AIConfig{UserID: 1, Provider: "mock-tools-primary", ModelName: "mock-echo", EnableTools: true, FallbackConfigIDs: []uint{backupConfig.ID}}
+		if err := s.DB.Create(&primaryConfig).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		var session *models.ChatSession
+		var err error
+		if stream {
+			_, session, err = s.StreamChat(context.Background(), 1, 0, "1+1等于多少", primaryConfig, nil, nil, func(*ChatCompletionChunk) {})
+		} else {
+			_, session, err = s.Chat(context.Background(), 1, 0, "1+1等于多少", primaryConfig, nil, nil)
+		}
+		if err != nil {
+			t.Fatalf("stream = %v, error = %v", stream, err)
+		}
+
+		requests := backup.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("stream = %v, backup requests = %d", stream, len(requests))
+		}
+		for _, message := range requests[0].Messages {
+			if message.Role == "tool" || len(message.ToolCalls) > 0 {
+				t.Errorf("stream = %v, backup request carries tool message %+v", stream, message)
+			}
+		}
+
+		// 失败尝试的工具调用记录不保留在会话中
+		messages := sessionMessages(t, s, session.ID)
+		if len(messages) != 2 || messages[0].Role != "user" || messages[1].Content != "来自备用配置" {
+			t.Errorf("stream = %v, messages = %+v", stream, messages)
+		}
+	}
+}
+
+func TestAIServiceChatNonRetryableError(t *testing.T) {
+	model := NewMockModel()
+	model.Err = &ProviderError{Provider: "mock-auth", Kind: ErrKindAuth, StatusCode: 401, Message: "invalid api key"}
+	s := newTestService(t, map[string]*MockModel{"mock-auth": model})
+
+	_, _, err := s.Chat(context.Background(), 1, 0, "你好", models.AIConfig{Provider: "mock-auth", ModelName: "mock-echo"}, nil, nil)
+	providerErr, ok := AsProviderError(err)
+	if !ok || providerErr.Kind != ErrKindAuth {
+		t.Fatalf("error = %v, want auth ProviderError", err)
+	}
+}
+
+func TestAIServiceStreamChat(t *testing.T) {
+	model := NewMockModel(ChatMessage{ReasoningContent: "先想一想", Content: "这是一个流式回复"})
+	model.ChunkSize = 2
+	s := newTestService(t, map[string]*MockModel{"mock-stream": model})
+	aiConfig := models.AIConfig{Provider: "mock-stream", ModelName: "mock-echo"}
+
+	var content, reasoning, finishReason string
+	var chunks int
+	reply, session, err := s.StreamChat(context.Background(), 1, 0, "你好", aiConfig, nil, nil, func(chunk *ChatCompletionChunk) {
+		chunks++
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+			reasoning += choice.Delta.ReasoningContent
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+
+	if content != "这是一个流式回复" || reasoning != "先想一想" || finishReason != "stop" {
+		t.Errorf("content = %q, reasoning = %q, finishReason = %q", content, reasoning, finishReason)
+	}
+	if chunks < 6 {
+		t.Errorf("chunks = %d, want the reply split into several chunks", chunks)
+	}
+	if reply.Content != content || reply.ReasoningContent != reasoning {
+		t.Errorf("saved reply = %+v", reply)
+	}
+
+	// 思维链不能回传给提供商
+	if _, _, err := s.StreamChat(context.Background(), 1, session.ID, "继续", aiConfig, nil, nil, func(*ChatCompletionChunk) {}); err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	requests := model.Requests()
+	for _, message := range requests[len(requests)-1].Messages {
+		if message.ReasoningContent != "" {
+			t.Errorf("reasoning content re-sent in %+v", message)
+		}
+	}
+}
+
+func TestAIServiceStreamChatFallback(t *testing.T) {
+	primary := NewMockModel()
+	primary.Err = &ProviderError{Provider: "mock-stream-primary", Kind: ErrKindRateLimit, StatusCode: 429}
+	backup := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-stream-primary": primary, "mock-stream-backup": backup})
+
+	backupConfig := models.AIConfig{UserID: 1, Provider: "mock-stream-backup", ModelName: "mock-echo"}
+	if err := s.DB.Create(&backupConfig).Error; err != nil {
+		t.Fatal(err)
+	}
+	primaryConfig := models.AIConfig{UserID: 1, Provider: "mock-stream-primary", ModelName: "mock-echo", FallbackConfigIDs: []uint{backupConfig.ID}}
+
+	reply, _, err := s.StreamChat(context.Background(), 1, 0, "你好", primaryConfig, nil, nil, func(*ChatCompletionChunk) {})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if reply.Content != "echo: 你好" || reply.Provider != "mock-stream-backup" {
+		t.Errorf("reply = %q from %q", reply.Content, reply.Provider)
+	}
+}
+
+func TestMockModelFailTimes(t *testing.T) {
+	model := NewMockModel(ChatMessage{Content: "第一条脚本"})
+	model.Err = errors.New("boom")
+	model.FailTimes = 1
+
+	if _, err := model.ChatCompletion(context.Background(), ChatCompletionRequest{}); err == nil {
+		t.Fatal("first call should fail")
+	}
+	response, err := model.ChatCompletion(context.Background(), ChatCompletionRequest{})
+	if err != nil {
+		t.Fatalf("second call error = %v", err)
+	}
+	if got := response.Choices[0].Message.Content; got != "第一条脚本" {
+		t.Errorf("Content = %q, want the first scripted reply", got)
+	}
+}
+
+// createTestAttachment 在临时目录中写入图片并创建附件记录
+func createTestAttachment(t *testing.T, s *AIService, userID uint) models.ChatAttachment {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "screenshot.png")
+	if err := os.WriteFile(path, []byte("\x89PNG\r\n\x1a\nfake"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	attachment := models.ChatAttachment{UserID: userID, FileName: "screenshot.png", FilePath: path, MimeType: "image/png"}
+	if err := s.DB.Create(&attachment).Error; err != nil {
+		t.Fatal(err)
+	}
+	return attachment
+}
+
+func TestAIServiceChatWithImage(t *testing.T) {
+	model := NewMockModel(ChatMessage{Content: "图里是一个报错"})
+	s := newTestService(t, map[string]*MockModel{"mock-vision": model})
+	attachment := createTestAttachment(t, s, 1)
+	aiConfig := models.AIConfig{Provider: "mock-vision", ModelName: "moonshot-v1-8k-vision-preview", MaxTokens: 1024}
+
+	_, session, err := s.Chat(context.Background(), 1, 0, "看看这个", aiConfig, nil, []uint{attachment.ID})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	request := model.Requests()[0]
+	last := request.Messages[len(request.Messages)-1]
+	if len(last.Parts) != 2 || last.Parts[0].Text != "看看这个" || !strings.HasPrefix(last.Parts[1].ImageURL.URL, "data:image/png;base64,") {
+		t.Fatalf("user message parts = %+v", last.Parts)
+	}
+
+	// 用户消息记录附件，附件关联到消息
+	messages := sessionMessages(t, s, session.ID)
+	if len(messages[0].AttachmentIDs) != 1 || messages[0].AttachmentIDs[0] != attachment.ID {
+		t.Errorf("AttachmentIDs = %v", messages[0].AttachmentIDs)
+	}
+	var saved models.ChatAttachment
+	s.DB.First(&saved, attachment.ID)
+	if saved.MessageID != messages[0].ID {
+		t.Errorf("attachment MessageID = %d, want %d", saved.MessageID, messages[0].ID)
+	}
+
+	// 后续轮次中历史图片以文字说明代替
+	if _, _, err := s.Chat(context.Background(), 1, session.ID, "怎么修复", aiConfig, nil, nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	history := model.Requests()[1].Messages[1]
+	if len(history.Parts) != 0 || !strings.Contains(history.Content, "1张图片") {
+		t.Errorf("history message = %+v", history)
+	}
+}
+
+func TestAIServiceChatRejectsImageForTextModel(t *testing.T) {
+	model := NewMockModel()
+	s := newTestService(t, map[string]*MockModel{"mock-text-only": model})
+	attachment := createTestAttachment(t, s, 1)
+
+	_, _, err := s.Chat(context.Background(), 1, 0, "看看这个", models.AIConfig{Provider: "mock-text-only", ModelName: "deepseek-chat"}, nil, []uint{attachment.ID})
+	if err == nil || !strings.Contains(err.Error(), "不支持图片输入") {
+		t.Fatalf("error = %v, want vision capability error", err)
+	}
+	if len(model.Requests()) != 0 {
+		t.Error("text-only model should not be called")
+	}
+
+	// 其他用户的附件不能使用
+	_, _, err = s.Chat(context.Background(), 2, 0, "看看这个", models.AIConfig{Provider: "mock-text-only", ModelName: "moonshot-v1-8k-vision-preview"}, nil, []uint{attachment.ID})
+	if err == nil {
+		t.Error("using another user's attachment should fail")
+	}
+}
@@ -0,0 +1,392 @@
+package ai
+
+import (
+	"Deepseek-Go/global"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrStreamNotFound 表示会话没有可以续传的流
+var ErrStreamNotFound = errors.New("该会话没有可以续传的流")
+
+// ErrStreamBusy 表示会话已有正在进行的生成或未结束的流式生成
+var ErrStreamBusy = errors.New("会话正在生成回复，请等待完成或先停止生成")
+
+var (
+	// 生成结束后流事件的保留时间，在此期间可以续传
+	streamRetention = 10 * time.Minute
+	// 没有客户端接收流超过该时间时取消生成，避免断开后继续向提供商计费
+	streamDetachTimeout = 30 * time.Second
+	// 接收方上报在线状态的间隔
+	streamHeartbeatInterval = time.Second
+	// 使用Redis存储时查询新事件的间隔
+	streamPollInterval = 100 * time.Millisecond
+)
+
+// StreamEvent 缓存的SSE事件
+type StreamEvent struct {
+	ID    int    `json:"id"`              // 从1开始递增的事件序号，用作SSE的id字段
+	Event string `json:"event,omitempty"` // 事件类型，为空表示默认的message事件
+	Data  string `json:"data"`            // JSON数据
+}
+
+// streamStore 按会话缓存一次生成的SSE事件，每个会话只保留最近一次生成
+type streamStore interface {
+	// reset 清空会话的事件，开始新的生成；上一次生成尚未结束时返回ErrStreamBusy
+	reset(ctx context.Context, sessionID uint) error
+	// append 追加事件，返回分配的事件序号
+	append(ctx context.Context, sessionID uint, event StreamEvent) (int, error)
+	// finish 标记生成结束
+	finish(ctx context.Context, sessionID uint) error
+	// read 返回序号大于afterID的事件以及生成是否已结束，会话没有缓存时返回ErrStreamNotFound
+	read(ctx context.Context, sessionID uint, afterID int) ([]StreamEvent, bool, error)
+	// wait 等待新事件，最多等待timeout
+	wait(ctx context.Context, sessionID uint, afterID int, timeout time.Duration)
+	// touch 上报有客户端正在接收流
+	touch(ctx context.Context, sessionID uint) error
+	// followed 判断最近是否有客户端接收流
+	followed(ctx context.Context, sessionID uint) bool
+	// renew 表明生成仍在进行，写入方异常退出后会话不会一直被占用
+	renew(ctx context.Context, sessionID uint) error
+}
+
+// getStreamStore 配置了Redis时跨实例缓存事件，否则缓存在本实例内存中
+func getStreamStore() streamStore {
+	if global.RedisDB != nil {
+		return redisStreamStore{client: global.RedisDB}
+	}
+	return memoryStreams
+}
+
+// memoryStream 内存中缓存的一次生成
+type memoryStream struct {
+	events   []StreamEvent
+	done     bool
+	changed  chan struct{} // 追加事件或生成结束时关闭并替换
+	lastSeen time.Time
+}
+
+// memoryStreamStore 单实例部署使用的内存事件缓存
+type memoryStreamStore struct {
+	mu      sync.Mutex
+	streams map[uint]*memoryStream
+}
+
+var memoryStreams = &memoryStreamStore{
+	streams: make(map[uint]*memoryStream),
+}
+
+func (m *memoryStreamStore) reset(ctx context.Context, sessionID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.streams[sessionID]; ok {
+		if !old.done {
+			return ErrStreamBusy
+		}
+		close(old.changed)
+	}
+	m.streams[sessionID] = &memoryStream{changed: make(chan struct{}), lastSeen: time.Now()}
+	return nil
+}
+
+func (m *memoryStreamStore) append(ctx context.Context, sessionID uint, event StreamEvent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[sessionID]
+	if !ok {
+		return 0, ErrStreamNotFound
+	}
+	event.ID = len(stream.events) + 1
+	stream.events = append(stream.events, event)
+	close(stream.changed)
+	stream.changed = make(chan struct{})
+	return event.ID, nil
+}
+
+func (m *memoryStreamStore) finish(ctx context.Context, sessionID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[sessionID]
+	if !ok {
+		return ErrStreamNotFound
+	}
+	stream.done = true
+	close(stream.changed)
+	stream.changed = make(chan struct{})
+
+	// 保留一段时间供续传，期间开始了新的生成时不删除
+	time.AfterFunc(streamRetention, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.streams[sessionID] == stream {
+			delete(m.streams, sessionID)
+		}
+	})
+	return nil
+}
+
+func (m *memoryStreamStore) read(ctx context.Context, sessionID uint, afterID int) ([]StreamEvent, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[sessionID]
+	if !ok {
+		return nil, false, ErrStreamNotFound
+	}
+	afterID = max(afterID, 0)
+	if afterID >= len(stream.events) {
+		return nil, stream.done, nil
+	}
+	return append([]StreamEvent(nil), stream.events[afterID:]...), stream.done, nil
+}
+
+func (m *memoryStreamStore) wait(ctx context.Context, sessionID uint, afterID int, timeout time.Duration) {
+	m.mu.Lock()
+	stream, ok := m.streams[sessionID]
+	if !ok || afterID < len(stream.events) || stream.done {
+		m.mu.Unlock()
+		return
+	}
+	changed := stream.changed
+	m.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-changed:
+	case <-timer.C:
+	}
+}
+
+func (m *memoryStreamStore) touch(ctx context.Context, sessionID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stream, ok := m.streams[sessionID]; ok {
+		stream.lastSeen = time.Now()
+	}
+	return nil
+}
+
+func (m *memoryStreamStore) followed(ctx context.Context, sessionID uint) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[sessionID]
+	return ok && time.Since(stream.lastSeen) < streamDetachTimeout
+}
+
+func (m *memoryStreamStore) renew(ctx context.Context, sessionID uint) error {
+	// 内存中的流随进程退出，不需要续期
+	return nil
+}
+
+// redisStreamStore 多实例部署使用的Redis事件缓存，客户端可以在任意实例上续传
+type redisStreamStore struct {
+	client *redis.Client
+}
+
+// redisStreamKey 返回会话流的Redis键，suffix为空时为事件列表
+func redisStreamKey(sessionID uint, suffix string) string {
+	key := "ai:stream:" + strconv.FormatUint(uint64(sessionID), 10)
+	if suffix != "" {
+		key += ":" + suffix
+	}
+	return key
+}
+
+func (r redisStreamStore) reset(ctx context.Context, sessionID uint) error {
+	// writer键表示有实例正在写入，生成过程中定期续期，实例异常退出后自动过期
+	claimed, err := r.client.SetNX(ctx, redisStreamKey(sessionID, "writer"), 1, streamDetachTimeout).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrStreamBusy
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, redisStreamKey(sessionID, ""), redisStreamKey(sessionID, "done"))
+	pipe.Set(ctx, redisStreamKey(sessionID, "follower"), 1, streamDetachTimeout)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r redisStreamStore) append(ctx context.Context, sessionID uint, event StreamEvent) (int, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	pipe := r.client.TxPipeline()
+	length := pipe.RPush(ctx, redisStreamKey(sessionID, ""), data)
+	// 生成过程中保持键不过期，结束后再设置保留时间
+	pipe.Expire(ctx, redisStreamKey(sessionID, ""), streamRetention+streamDetachTimeout+time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(length.Val()), nil
+}
+
+func (r redisStreamStore) finish(ctx context.Context, sessionID uint) error {
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, redisStreamKey(sessionID, "done"), 1, streamRetention)
+	pipe.Expire(ctx, redisStreamKey(sessionID, ""), streamRetention)
+	pipe.Del(ctx, redisStreamKey(sessionID, "writer"))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r redisStreamStore) read(ctx context.Context, sessionID uint, afterID int) ([]StreamEvent, bool, error) {
+	pipe := r.client.Pipeline()
+	exists := pipe.Exists(ctx, redisStreamKey(sessionID, ""), redisStreamKey(sessionID, "follower"), redisStreamKey(sessionID, "done"))
+	items := pipe.LRange(ctx, redisStreamKey(sessionID, ""), int64(max(afterID, 0)), -1)
+	done := pipe.Exists(ctx, redisStreamKey(sessionID, "done"))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, false, err
+	}
+	if exists.Val() == 0 {
+		return nil, false, ErrStreamNotFound
+	}
+
+	events := make([]StreamEvent, 0, len(items.Val()))
+	for i, item := range items.Val() {
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(item), &event); err != nil {
+			return nil, false, fmt.Errorf("解析流事件失败: %v", err)
+		}
+		event.ID = max(afterID, 0) + i + 1
+		events = append(events, event)
+	}
+	return events, done.Val() > 0, nil
+}
+
+func (r redisStreamStore) wait(ctx context.Context, sessionID uint, afterID int, timeout time.Duration) {
+	timer := time.NewTimer(min(timeout, streamPollInterval))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (r redisStreamStore) touch(ctx context.Context, sessionID uint) error {
+	return r.client.Set(ctx, redisStreamKey(sessionID, "follower"), 1, streamDetachTimeout).Err()
+}
+
+func (r redisStreamStore) followed(ctx context.Context, sessionID uint) bool {
+	n, err := r.client.Exists(ctx, redisStreamKey(sessionID, "follower")).Result()
+	// Redis不可用时保守地认为仍有客户端
+	return err != nil || n > 0
+}
+
+func (r redisStreamStore) renew(ctx context.Context, sessionID uint) error {
+	return r.client.Expire(ctx, redisStreamKey(sessionID, "writer"), streamDetachTimeout).Err()
+}
+
+// StreamWriter 向会话的流缓存写入事件
+type StreamWriter struct {
+	store     streamStore
+	sessionID uint
+}
+
+// OpenStream 清空会话之前缓存的事件，开始缓存新的生成
+// 会话已有未结束的流式生成时返回ErrStreamBusy，避免两次生成的事件混在同一个缓存中
+func (s *AIService) OpenStream(ctx context.Context, sessionID uint) (*StreamWriter, error) {
+	store := getStreamStore()
+	if err := store.reset(ctx, sessionID); err != nil {
+		if errors.Is(err, ErrStreamBusy) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("初始化流缓存失败: %v", err)
+	}
+	return &StreamWriter{store: store, sessionID: sessionID}, nil
+}
+
+// Emit 将数据编码为JSON后追加一个事件，event为空表示默认的message事件
+func (w *StreamWriter) Emit(event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("编码会话%d的流事件失败: %v", w.sessionID, err)
+		return
+	}
+	if _, err := w.store.append(context.Background(), w.sessionID, StreamEvent{Event: event, Data: string(payload)}); err != nil {
+		log.Printf("缓存会话%d的流事件失败: %v", w.sessionID, err)
+	}
+}
+
+// Close 标记生成结束，接收方读完剩余事件后结束
+func (w *StreamWriter) Close() {
+	if err := w.store.finish(context.Background(), w.sessionID); err != nil {
+		log.Printf("结束会话%d的流缓存失败: %v", w.sessionID, err)
+	}
+}
+
+// Detach 返回不随单个HTTP请求结束的生成context，最近streamDetachTimeout内没有客户端接收流时取消
+func (w *StreamWriter) Detach() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		ticker := time.NewTicker(min(streamHeartbeatInterval, streamDetachTimeout))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.store.renew(ctx, w.sessionID); err != nil {
+					log.Printf("续期会话%d的流缓存失败: %v", w.sessionID, err)
+				}
+				if !w.store.followed(ctx, w.sessionID) {
+					log.Printf("会话%d的流已无客户端接收，取消生成", w.sessionID)
+					cancel(context.Canceled)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+// FollowStream 从afterID之后回放会话缓存的事件，并持续接收新事件直到生成结束或ctx结束
+func (s *AIService) FollowStream(ctx context.Context, sessionID, userID uint, afterID int, write func(event StreamEvent) error) error {
+	if _, err := s.GetSession(sessionID, userID); err != nil {
+		return err
+	}
+
+	store := getStreamStore()
+	lastTouch := time.Time{}
+	for {
+		if time.Since(lastTouch) >= streamHeartbeatInterval {
+			if err := store.touch(ctx, sessionID); err == nil {
+				lastTouch = time.Now()
+			}
+		}
+
+		events, done, err := store.read(ctx, sessionID, afterID)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := write(event); err != nil {
+				return err
+			}
+			afterID = event.ID
+		}
+		if done && len(events) == 0 {
+			return nil
+		}
+		if len(events) > 0 {
+			continue
+		}
+
+		store.wait(ctx, sessionID, afterID, streamHeartbeatInterval)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package ai
+
+import (
+	"Deepseek-Go/utils/ai/aitest"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// setStreamTimeouts 缩短流缓存的超时时间，测试结束后恢复
+func setStreamTimeouts(t *testing.T, detach, heartbeat time.Duration) {
+	t.Helper()
+	previousDetach, previousHeartbeat, previousPoll := streamDetachTimeout, streamHeartbeatInterval, streamPollInterval
+	streamDetachTimeout, streamHeartbeatInterval, streamPollInterval = detach, heartbeat, heartbeat
+	t.Cleanup(func() {
+		streamDetachTimeout, streamHeartbeatInterval, streamPollInterval = previousDetach, previousHeartbeat, previousPoll
+	})
+}
+
+// collectStream 跟随会话的流直到生成结束，返回收到的事件
+func collectStream(t *testing.T, s *AIService, sessionID uint, afterID int) []StreamEvent {
+	t.Helper()
+	var events []StreamEvent
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.FollowStream(ctx, sessionID, 1, afterID, func(event StreamEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FollowStream() error = %v", err)
+	}
+	return events
+}
+
+func TestStreamReplayAndFollow(t *testing.T) {
+	stores := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"memory", func(t *testing.T) {}},
+		{"redis", func(t *testing.T) { aitest.NewRedis(t) }},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			store.setup(t)
+			setStreamTimeouts(t, time.Second, 10*time.Millisecond)
+			s := NewAIService(aitest.NewDB(t))
+			session, _ := s.GetOrCreateSession(1, 0, "你好")
+
+			writer, err := s.OpenStream(context.Background(), session.ID)
+			if err != nil {
+				t.Fatalf("OpenStream() error = %v", err)
+			}
+			writer.Emit("session", map[string]uint{"session_id": session.ID})
+			writer.Emit("", map[string]string{"content": "你"})
+
+			// 生成过程中开始跟随，先回放已有事件再接收新事件
+			go func() {
+				time.Sleep(30 * time.Millisecond)
+				writer.Emit("", map[string]string{"content": "好"})
+				writer.Emit("", map[string]bool{"done": true})
+				writer.Close()
+			}()
+			events := collectStream(t, s, session.ID, 0)
+			if len(events) != 4 {
+				t.Fatalf("events = %+v, want 4", events)
+			}
+			for i, event := range events {
+				if event.ID != i+1 {
+					t.Errorf("events[%d].ID = %d, want %d", i, event.ID, i+1)
+				}
+			}
+			if events[0].Event != "session" || events[1].Event != "" {
+				t.Errorf("event types = %q, %q", events[0].Event, events[1].Event)
+			}
+			var data map[string]string
+			json.Unmarshal([]byte(events[2].Data), &data)
+			if data["content"] != "好" {
+				t.Errorf("events[2].Data = %s", events[2].Data)
+			}
+
+			// 从Last-Event-ID之后续传
+			resumed := collectStream(t, s, session.ID, 2)
+			if len(resumed) != 2 || resumed[0].ID != 3 || resumed[0].Data != events[2].Data {
+				t.Errorf("resumed = %+v", resumed)
+			}
+			if resumed := collectStream(t, s, session.ID, 4); len(resumed) != 0 {
+				t.Errorf("resumed after last event = %+v", resumed)
+			}
+
+			if err := s.FollowStream(context.Background(), session.ID, 2, 0, func(StreamEvent) error { return nil }); err == nil {
+				t.Error("FollowStream() by another user error = nil")
+			}
+			other, _ := s.GetOrCreateSession(1, 0, "新会话")
+			if err := s.FollowStream(context.Background(), other.ID+1000, 1, 0, func(StreamEvent) error { return nil }); err == nil {
+				t.Error("FollowStream() of a missing session error = nil")
+			}
+		})
+	}
+}
+
+func TestStreamRejectsOverlappingGeneration(t *testing.T) {
+	stores := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"memory", func(t *testing.T) {}},
+		{"redis", func(t *testing.T) { aitest.NewRedis(t) }},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			store.setup(t)
+			s := NewAIService(aitest.NewDB(t))
+			session, _ := s.GetOrCreateSession(1, 0, "你好")
+
+			writer, err := s.OpenStream(context.Background(), session.ID)
+			if err != nil {
+				t.Fatalf("OpenStream() error = %v", err)
+			}
+			writer.Emit("", map[string]string{"content": "第一次"})
+			if _, err := s.OpenStream(context.Background(), session.ID); !errors.Is(err, ErrStreamBusy) {
+				t.Errorf("overlapping OpenStream() error = %v, want ErrStreamBusy", err)
+			}
+
+			// 上一次生成结束后可以开始新的生成
+			writer.Close()
+			next, err := s.OpenStream(context.Background(), session.ID)
+			if err != nil {
+				t.Fatalf("OpenStream() after Close error = %v", err)
+			}
+			defer next.Close()
+			if events, _, _ := getStreamStore().read(context.Background(), session.ID, 0); len(events) != 0 {
+				t.Errorf("events after reset = %+v", events)
+			}
+		})
+	}
+}
+
+func TestStreamDetachCancelsWithoutFollowers(t *testing.T) {
+	setStreamTimeouts(t, 50*time.Millisecond, 10*time.Millisecond)
+	s := NewAIService(aitest.NewDB(t))
+	session, _ := s.GetOrCreateSession(1, 0, "你好")
+
+	writer, _ := s.OpenStream(context.Background(), session.ID)
+	ctx, cancel := writer.Detach()
+	defer cancel()
+
+	// 有客户端跟随时生成继续
+	followCtx, stopFollowing := context.WithCancel(context.Background())
+	followDone := make(chan struct{})
+	go func() {
+		defer close(followDone)
+		s.FollowStream(followCtx, session.ID, 1, 0, func(StreamEvent) error { return nil })
+	}()
+	time.Sleep(150 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("generation cancelled while a client is following")
+	}
+
+	// 客户端断开且没有重连时取消生成
+	stopFollowing()
+	<-followDone
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("generation was not cancelled after all clients disconnected")
+	}
+	if !errors.Is(context.Cause(ctx), context.Canceled) {
+		t.Errorf("Cause = %v", context.Cause(ctx))
+	}
+	writer.Close()
+}
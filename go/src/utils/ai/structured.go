@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 结构化输出校验失败时最多请求模型的次数，包括第一次
+const maxStructuredAttempts = 3
+
+// StructuredOutputError 表示模型多次修正后仍未返回符合Schema的JSON
+type StructuredOutputError struct {
+	Attempts int      // 请求模型的次数
+	Errors   []string // 最后一次回复的校验错误
+	Content  string   // 最后一次回复的原文
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("模型在%d次尝试后仍未返回符合Schema的JSON: %s", e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+// structuredPrompt 构建附加在系统提示词后的结构化输出要求
+// DeepSeek的JSON模式要求提示词中包含json字样
+func structuredPrompt(schema *JSONSchema) string {
+	return "\n\n请只输出一个符合以下JSON Schema的json对象，不要输出任何其他内容：\n" + schema.String()
+}
+
+// StructuredChat 使用JSON模式请求模型，并按Schema校验回复，校验失败时将错误反馈给模型重新生成
+// 返回解析后的JSON、保存的AI回复和会话；ctx结束或会话被StopGeneration停止时取消对提供商的请求
+func (s *AIService) StructuredChat(ctx context.Context, userID uint, sessionID uint, message string, schema *JSONSchema, aiConfig models.AIConfig, knowledgeIDs []uint) (json.RawMessage, *models.ChatMessage, *models.ChatSession, error) {
+	// 只使用支持JSON模式的配置
+	chain := filterChain(s.getFallbackChain(aiConfig), func(c ModelCapabilities) bool { return c.JSONMode })
+	if len(chain) == 0 {
+		return nil, nil, nil, fmt.Errorf("模型%s不支持JSON输出", aiConfig.ModelName)
+	}
+	if err := s.CheckQuota(userID); err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, finish, session, aiMessages, err := s.prepareChat(ctx, userID, sessionID, message, knowledgeIDs, nil, chain)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer finish()
+	aiMessages[0].Content += structuredPrompt(schema)
+
+	var result json.RawMessage
+	var assistantMessage *models.ChatMessage
+	err = s.withFallback(chain, func(cfg models.AIConfig) error {
+		var err error
+		result, assistantMessage, err = s.structuredWithConfig(ctx, userID, session.ID, aiMessages, schema, cfg)
+		if err != nil {
+			return err
+		}
+		s.updateLastMessage(session, assistantMessage.Content)
+		go s.maybeSummarize(session.ID, cfg)
+		return nil
+	})
+	if err != nil {
+		if cause := interruptedCause(ctx); cause != nil {
+			err = cause
+		}
+		return nil, nil, nil, fmt.Errorf("结构化输出失败: %w", err)
+	}
+	return result, assistantMessage, session, nil
+}
+
+// structuredWithConfig 使用指定配置生成结构化输出，修正过程中的消息不保存到会话
+func (s *AIService) structuredWithConfig(ctx context.Context, userID, sessionID uint, aiMessages []ChatMessage, schema *JSONSchema, aiConfig models.AIConfig) (json.RawMessage, *models.ChatMessage, error) {
+	aiModel, err := s.getAIModel(aiConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取AI模型失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	// 结构化输出不调用工具
+	aiConfig.EnableTools = false
+	aiConfig.ResponseFormat = "json_object"
+	messages := append([]ChatMessage(nil), aiMessages...)
+
+	// 修正重试的请求同样计入回复的用量，最终失败时已完成请求的用量只计入每日用量
+	var used tokenUsage
+	fail := func(err error) (json.RawMessage, *models.ChatMessage, error) {
+		if used != (tokenUsage{}) {
+			s.recordUsage(userID, 0, aiConfig.Provider, aiConfig.ModelName, used)
+		}
+		return nil, nil, err
+	}
+
+	var outputErr *StructuredOutputError
+	for attempt := 1; attempt <= maxStructuredAttempts; attempt++ {
+		response, err := aiModel.ChatCompletion(ctx, s.newChatRequest(aiConfig, messages, 0))
+		if err != nil {
+			return fail(err)
+		}
+		if len(response.Choices) == 0 {
+			return fail(fmt.Errorf("AI返回了空回复"))
+		}
+		reply := response.Choices[0].Message
+		used.add(&response.Usage, aiConfig.ModelName, messages, reply)
+
+		result, errs := parseStructuredReply(reply.Content, schema)
+		if len(errs) == 0 {
+			assistantMessage := models.ChatMessage{
+				SessionID: sessionID,
+				Role:      "assistant",
+				Content:   string(result),
+				Provider:  aiConfig.Provider,
+				ModelName: aiConfig.ModelName,
+				CreatedAt: time.Now(),
+			}
+			used.apply(&assistantMessage)
+			if err := s.DB.Create(&assistantMessage).Error; err != nil {
+				return fail(fmt.Errorf("保存AI回复失败: %v", err))
+			}
+			s.recordUsage(userID, sessionID, aiConfig.Provider, aiConfig.ModelName, used)
+			return result, &assistantMessage, nil
+		}
+
+		// 将校验错误反馈给模型，要求重新生成
+		outputErr = &StructuredOutputError{Attempts: attempt, Errors: errs, Content: reply.Content}
+		messages = append(messages,
+			ChatMessage{Role: "assistant", Content: reply.Content},
+			ChatMessage{Role: "user", Content: "你的回复不符合JSON Schema：\n- " + strings.Join(errs, "\n- ") + "\n请修正后重新输出完整的json对象，不要输出任何其他内容。"},
+		)
+	}
+
+	return fail(outputErr)
+}
+
+// parseStructuredReply 解析并校验模型回复，返回压缩后的JSON
+func parseStructuredReply(content string, schema *JSONSchema) (json.RawMessage, []string) {
+	content = strings.TrimSpace(content)
+
+	// 部分模型即使在JSON模式下也会用代码块包裹
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return nil, []string{fmt.Sprintf("回复不是合法的JSON: %v", err)}
+	}
+	if errs := schema.Validate(value); len(errs) > 0 {
+		return nil, errs
+	}
+
+	// 保留模型输出的字段顺序
+	var result bytes.Buffer
+	json.Compact(&result, []byte(content))
+	return result.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用的结构化输出Schema
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"city": {"type": "string"},
+		"temperature": {"type": "number"}
+	},
+	"required": ["city", "temperature"]
+}`
+
+func TestAIServiceStructuredChatCorrectsInvalidReply(t *testing.T) {
+	model := NewMockModel(
+		ChatMessage{Content: "北京今天25度"},
+		ChatMessage{Content: `{"city": "北京"}`},
+		ChatMessage{Content: "```json\n{\"city\": \"北京\", \"temperature\": 25}\n```"},
+	)
+	s := newTestService(t, map[string]*MockModel{"mock-structured": model})
+	schema, err := ParseJSONSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, reply, _, err := s.StructuredChat(context.Background(), 1, 0, "北京天气如何", schema, models.AIConfig{Provider: "mock-structured", ModelName: "mock-echo"}, nil)
+	if err != nil {
+		t.Fatalf("StructuredChat() error = %v", err)
+	}
+	if string(result) != `{"city":"北京","temperature":25}` || reply.Content != string(result) {
+		t.Errorf("result = %s, reply = %q", result, reply.Content)
+	}
+
+	requests := model.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("len(requests) = %d, want 3", len(requests))
+	}
+	first := requests[0]
+	if first.ResponseFormat == nil || first.ResponseFormat.Type != "json_object" {
+		t.Errorf("ResponseFormat = %+v", first.ResponseFormat)
+	}
+	if !strings.Contains(first.Messages[0].Content, "json") || !strings.Contains(first.Messages[0].Content, `"city"`) {
+		t.Errorf("system prompt does not describe the schema: %q", first.Messages[0].Content)
+	}
+	// 第三次请求应包含第二次回复的校验错误
+	last := requests[2].Messages[len(requests[2].Messages)-1]
+	if last.Role != "user" || !strings.Contains(last.Content, `缺少必需字段"temperature"`) {
+		t.Errorf("corrective message = %+v", last)
+	}
+}
+
+func TestAIServiceStructuredChatGivesUp(t *testing.T) {
+	model := NewMockModel(ChatMessage{Content: "a"}, ChatMessage{Content: "b"}, ChatMessage{Content: "c"})
+	s := newTestService(t, map[string]*MockModel{"mock-structured-fail": model})
+	schema, _ := ParseJSONSchema(json.RawMessage(testSchema))
+
+	_, _, _, err := s.StructuredChat(context.Background(), 1, 0, "你好", schema, models.AIConfig{Provider: "mock-structured-fail", ModelName: "mock-echo"}, nil)
+	var outputErr *StructuredOutputError
+	if !errors.As(err, &outputErr) {
+		t.Fatalf("error = %v, want *StructuredOutputError", err)
+	}
+	if outputErr.Attempts != maxStructuredAttempts || outputErr.Content != "c" {
+		t.Errorf("outputErr = %+v", outputErr)
+	}
+}
+
+func TestAIServiceStructuredChatStop(t *testing.T) {
+	model := NewMockModel()
+	model.Latency = time.Second
+	s := newTestService(t, map[string]*MockModel{"mock-structured-stop": model})
+	schema, _ := ParseJSONSchema(json.RawMessage(testSchema))
+	session, _ := s.GetOrCreateSession(1, 0, "你好")
+
+	stopDone := make(chan struct{})
+	defer func() { <-stopDone }()
+	go func() {
+		defer close(stopDone)
+		for {
+			if stopped, _ := s.StopGeneration(context.Background(), session.ID, 1); stopped {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	_, _, _, err := s.StructuredChat(context.Background(), 1, session.ID, "你好", schema, models.AIConfig{Provider: "mock-structured-stop", ModelName: "mock-echo"}, nil)
+	if !errors.Is(err, ErrGenerationStopped) {
+		t.Fatalf("StructuredChat() error = %v, want ErrGenerationStopped", err)
+	}
+	if elapsed := time.Since(start); elapsed >= model.Latency {
+		t.Errorf("StructuredChat() returned after %v, want the upstream request cancelled", elapsed)
+	}
+}
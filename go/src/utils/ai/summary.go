@@ -0,0 +1,207 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 未摘要的历史消息超过上下文预算的该比例时触发摘要
+	summaryTriggerRatio = 0.5
+	// 生成摘要时保留的最新消息数，这些消息仍以原文发送
+	summaryKeepMessages = 6
+	// 摘要回复的最大token数
+	summaryMaxTokens = 1024
+)
+
+// 摘要生成使用的系统提示词
+const summarySystemPrompt = "你是一个对话摘要助手。请将已有摘要和新的对话内容合并为一份简洁的中文摘要，" +
+	"保留用户的目标、偏好、关键事实、已得出的结论和尚未解决的问题，不要编造内容，直接输出摘要正文。"
+
+// 正在生成摘要的会话，避免同一会话并发生成
+var summarizingSessions sync.Map
+
+// summaryMessage 构建插入到系统提示词之后的摘要消息
+func summaryMessage(summary string) ChatMessage {
+	return ChatMessage{
+		Role:    "system",
+		Content: "以下是之前对话的摘要，更早的消息已被省略：\n" + summary,
+	}
+}
+
+// maybeSummarize 未摘要的历史超过阈值时，使用会话的模型更新滚动摘要
+func (s *AIService) maybeSummarize(sessionID uint, aiConfig models.AIConfig) {
+	if err := s.summarizeSession(sessionID, aiConfig, false); err != nil {
+		log.Printf("会话%d生成摘要失败: %v", sessionID, err)
+	}
+}
+
+// recomputeSummary 摘要范围内的消息被修改或删除后重新生成摘要
+func (s *AIService) recomputeSummary(sessionID uint) {
+	var session models.ChatSession
+	if err := s.DB.First(&session, sessionID).Error; err != nil {
+		return
+	}
+
+	aiConfig, err := s.sessionAIConfig(&session)
+	if err != nil {
+		log.Printf("会话%d重新生成摘要失败: %v", sessionID, err)
+		return
+	}
+	if err := s.summarizeSession(sessionID, *aiConfig, true); err != nil {
+		log.Printf("会话%d重新生成摘要失败: %v", sessionID, err)
+	}
+}
+
+// sessionAIConfig 获取会话最近使用的AI配置，不存在时使用用户默认配置
+func (s *AIService) sessionAIConfig(session *models.ChatSession) (*models.AIConfig, error) {
+	if session.AIConfigID > 0 {
+		if config, err := s.GetAIConfig(session.AIConfigID, session.UserID); err == nil {
+			return config, nil
+		}
+	}
+	return s.GetDefaultAIConfig(session.UserID)
+}
+
+// summarizeSession 生成会话摘要
+// rebuild为false时，仅在未摘要的历史超过阈值时把较旧的消息合并进已有摘要；
+// rebuild为true时，丢弃已有摘要并按原有范围从头生成
+func (s *AIService) summarizeSession(sessionID uint, aiConfig models.AIConfig, rebuild bool) error {
+	if _, running := summarizingSessions.LoadOrStore(sessionID, true); running {
+		return nil
+	}
+	defer summarizingSessions.Delete(sessionID)
+
+	var session models.ChatSession
+	if err := s.DB.First(&session, sessionID).Error; err != nil {
+		return fmt.Errorf("会话不存在")
+	}
+	messages, err := s.getSessionMessages(sessionID)
+	if err != nil {
+		return fmt.Errorf("获取历史消息失败: %v", err)
+	}
+
+	previousSummary := session.Summary
+	var toSummarize []models.ChatMessage
+	if rebuild {
+		// 重新摘要原范围内仍然存在的消息
+		previousSummary = ""
+		for _, msg := range messages {
+			if msg.ID <= session.SummaryUntilID {
+				toSummarize = append(toSummarize, msg)
+			}
+		}
+		if len(toSummarize) == 0 {
+			return s.DB.Model(&session).Updates(map[string]interface{}{"summary": "", "summary_until_id": 0}).Error
+		}
+	} else {
+		// 统计未摘要的历史消息
+		var pending []models.ChatMessage
+		for _, msg := range messages {
+			if msg.ID > session.SummaryUntilID {
+				pending = append(pending, msg)
+			}
+		}
+		if len(pending) <= summaryKeepMessages {
+			return nil
+		}
+		_, budget := contextBudget([]models.AIConfig{aiConfig})
+		pendingTokens := 0
+		for _, msg := range pending {
+			pendingTokens += EstimateTokens(aiConfig.ModelName, msg.Content)
+		}
+		if float64(pendingTokens) < float64(budget)*summaryTriggerRatio {
+			return nil
+		}
+
+		// 保留最新的消息，工具结果与发起调用的消息一起摘要
+		cut := len(pending) - summaryKeepMessages
+		for cut < len(pending) && pending[cut].Role == "tool" {
+			cut++
+		}
+		toSummarize = pending[:cut]
+	}
+
+	// 摘要的用量计入用户配额，配额用完时不生成摘要；重新生成时清除旧摘要，避免保留已删除消息的内容
+	if err := s.CheckQuota(session.UserID); err != nil {
+		if rebuild {
+			if err := s.DB.Model(&session).Updates(map[string]interface{}{"summary": "", "summary_until_id": 0}).Error; err != nil {
+				return fmt.Errorf("清除摘要失败: %v", err)
+			}
+		}
+		return fmt.Errorf("跳过摘要: %w", err)
+	}
+
+	summary, err := s.generateSummary(session.UserID, aiConfig, previousSummary, toSummarize)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Model(&session).Updates(map[string]interface{}{
+		"summary":          summary,
+		"summary_until_id": toSummarize[len(toSummarize)-1].ID,
+	}).Error
+}
+
+// generateSummary 调用模型将已有摘要和新消息合并为新的摘要，用量计入会话所属用户的每日用量
+func (s *AIService) generateSummary(userID uint, aiConfig models.AIConfig, previousSummary string, messages []models.ChatMessage) (string, error) {
+	aiModel, err := s.getAIModel(aiConfig)
+	if err != nil {
+		return "", fmt.Errorf("获取AI模型失败: %v", err)
+	}
+
+	// 整理对话记录，思维链不参与摘要
+	var transcript strings.Builder
+	for _, msg := range messages {
+		if msg.Content == "" {
+			continue
+		}
+		transcript.WriteString(msg.Role + ": " + msg.Content + "\n")
+	}
+
+	prompt := "新的对话内容：\n" + transcript.String()
+	if previousSummary != "" {
+		prompt = "已有摘要：\n" + previousSummary + "\n\n" + prompt
+	}
+
+	// 对话过长时截断，保证摘要请求本身不会超出上下文
+	budget := ContextLimit(aiConfig.ModelName) - summaryMaxTokens - EstimateTokens(aiConfig.ModelName, summarySystemPrompt)
+	prompt = truncateToTokens(aiConfig.ModelName, prompt, budget*9/10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	request := ChatCompletionRequest{
+		Model: aiConfig.ModelName,
+		Messages: []ChatMessage{
+			{Role: "system", Content: summarySystemPrompt},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: 0.3,
+		MaxTokens:   summaryMaxTokens,
+	}
+	response, err := aiModel.ChatCompletion(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("AI服务调用失败: %w", err)
+	}
+
+	// 摘要不是会话中的回复，只计入每日用量
+	var used tokenUsage
+	var reply ChatMessage
+	if len(response.Choices) > 0 {
+		reply = response.Choices[0].Message
+	}
+	used.add(&response.Usage, aiConfig.ModelName, request.Messages, reply)
+	s.recordUsage(userID, 0, aiConfig.Provider, aiConfig.ModelName, used)
+
+	if len(response.Choices) == 0 || strings.TrimSpace(response.Choices[0].Message.Content) == "" {
+		return "", fmt.Errorf("AI返回了空摘要")
+	}
+
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
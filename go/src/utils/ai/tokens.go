@@ -0,0 +1,104 @@
+package ai
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 每条消息在role、分隔符等格式上的额外token开销
+const messageOverheadTokens = 4
+
+// 每张图片的估算token数，视觉模型按分辨率计费，这里取常见截图的上限
+const imageTokens = 1024
+
+// ContextLimit 返回模型的上下文长度
+func ContextLimit(model string) int {
+	return Capabilities(model).ContextLength
+}
+
+// tokenizerProfile 按字符类别近似的分词比例，表示每个字符对应的token数
+type tokenizerProfile struct {
+	cjk   float64 // 中日韩文字
+	ascii float64 // 英文字母、数字和ASCII标点
+	other float64 // 其他字符，如emoji、全角标点
+}
+
+var (
+	// DeepSeek官方说明：1个英文字符约0.3个token，1个中文字符约0.6个token
+	deepseekTokenizer = tokenizerProfile{cjk: 0.6, ascii: 0.3, other: 0.6}
+	// Moonshot官方说明：1个token约对应1.5-2个汉字或3-4个英文字符
+	moonshotTokenizer = tokenizerProfile{cjk: 0.65, ascii: 0.28, other: 0.65}
+	// 未知模型按偏保守的比例估算，避免低估
+	defaultTokenizer = tokenizerProfile{cjk: 1.0, ascii: 0.3, other: 1.0}
+)
+
+// tokenizerFor 根据模型名称选择分词比例
+func tokenizerFor(model string) tokenizerProfile {
+	model = strings.ToLower(model)
+	switch {
+	case strings.HasPrefix(model, "deepseek"):
+		return deepseekTokenizer
+	case strings.HasPrefix(model, "moonshot"), strings.HasPrefix(model, "kimi"):
+		return moonshotTokenizer
+	default:
+		return defaultTokenizer
+	}
+}
+
+// runeCost 返回单个字符的token数
+func (t tokenizerProfile) runeCost(r rune) float64 {
+	switch {
+	case r < unicode.MaxASCII:
+		return t.ascii
+	case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+		return t.cjk
+	default:
+		return t.other
+	}
+}
+
+// EstimateTokens 估算文本在指定模型下的token数
+func EstimateTokens(model, text string) int {
+	tokenizer := tokenizerFor(model)
+	var total float64
+	for _, r := range text {
+		total += tokenizer.runeCost(r)
+	}
+	return int(total + 0.999)
+}
+
+// EstimateMessageTokens 估算单条消息的token数，包括工具调用参数
+func EstimateMessageTokens(model string, message ChatMessage) int {
+	tokens := messageOverheadTokens + EstimateTokens(model, message.Content)
+	for _, part := range message.Parts {
+		if part.Type == "image_url" {
+			tokens += imageTokens
+		}
+	}
+	for _, call := range message.ToolCalls {
+		tokens += EstimateTokens(model, call.Function.Name) + EstimateTokens(model, call.Function.Arguments)
+	}
+	return tokens
+}
+
+// EstimateMessagesTokens 估算消息列表的token数
+func EstimateMessagesTokens(model string, messages []ChatMessage) int {
+	total := 0
+	for _, message := range messages {
+		total += EstimateMessageTokens(model, message)
+	}
+	return total
+}
+
+// truncateToTokens 截断文本使其不超过指定的token数
+func truncateToTokens(model, text string, maxTokens int) string {
+	tokenizer := tokenizerFor(model)
+	var total float64
+	for i, r := range text {
+		total += tokenizer.runeCost(r)
+		if total > float64(maxTokens) {
+			return text[:i]
+		}
+	}
+	return text
+}
@@ -0,0 +1,211 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 单轮对话中工具调用的最大轮数，防止模型无限循环调用
+const maxToolIterations = 5
+
+// ToolEnv 工具执行时可用的环境信息
+type ToolEnv struct {
+	DB           *gorm.DB
+	UserID       uint
+	KnowledgeIDs []uint
+}
+
+// ToolHandler 工具处理函数，arguments为模型生成的JSON参数
+type ToolHandler func(ctx context.Context, env ToolEnv, arguments string) (string, error)
+
+// registeredTool 已注册的工具
+type registeredTool struct {
+	definition Tool
+	handler    ToolHandler
+}
+
+// toolRegistry 工具注册表
+var toolRegistry = struct {
+	mu    sync.RWMutex
+	tools map[string]registeredTool
+	order []string
+}{
+	tools: make(map[string]registeredTool),
+}
+
+// RegisterTool 注册一个可供模型调用的Go工具，parameters为参数的JSON Schema
+func RegisterTool(name, description, parameters string, handler ToolHandler) {
+	toolRegistry.mu.Lock()
+	defer toolRegistry.mu.Unlock()
+
+	if _, exists := toolRegistry.tools[name]; !exists {
+		toolRegistry.order = append(toolRegistry.order, name)
+	}
+	toolRegistry.tools[name] = registeredTool{
+		definition: Tool{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        name,
+				Description: description,
+				Parameters:  json.RawMessage(parameters),
+			},
+		},
+		handler: handler,
+	}
+}
+
+// ListTools 返回所有已注册工具的定义
+func ListTools() []Tool {
+	toolRegistry.mu.RLock()
+	defer toolRegistry.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(toolRegistry.order))
+	for _, name := range toolRegistry.order {
+		tools = append(tools, toolRegistry.tools[name].definition)
+	}
+	return tools
+}
+
+// executeToolCall 执行一次工具调用，错误信息同样作为结果返回给模型
+func executeToolCall(ctx context.Context, env ToolEnv, call ToolCall) string {
+	toolRegistry.mu.RLock()
+	tool, ok := toolRegistry.tools[call.Function.Name]
+	toolRegistry.mu.RUnlock()
+
+	if !ok {
+		return fmt.Sprintf("工具不存在: %s", call.Function.Name)
+	}
+
+	result, err := tool.handler(ctx, env, call.Function.Arguments)
+	if err != nil {
+		return fmt.Sprintf("工具执行失败: %v", err)
+	}
+	return result
+}
+
+// mergeToolCallDeltas 将流式增量拼接为完整的工具调用
+func mergeToolCallDeltas(calls []ToolCall, deltas []ToolCallDelta) []ToolCall {
+	for _, delta := range deltas {
+		for len(calls) <= delta.Index {
+			calls = append(calls, ToolCall{Type: "function"})
+		}
+		call := &calls[delta.Index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// 内置工具 ---------------------------------------------------------
+
+func init() {
+	RegisterTool("get_current_time", "获取服务器当前的日期和时间", `{
+		"type": "object",
+		"properties": {
+			"timezone": {"type": "string", "description": "IANA时区名称，如Asia/Shanghai，默认为服务器时区"}
+		}
+	}`, currentTimeTool)
+
+	RegisterTool("calculator", "计算数学表达式，支持 + - * / % ^ 和括号", `{
+		"type": "object",
+		"properties": {
+			"expression": {"type": "string", "description": "要计算的数学表达式，如 (1+2)*3"}
+		},
+		"required": ["expression"]
+	}`, calculatorTool)
+
+	RegisterTool("search_knowledge", "在用户的知识库中按关键词搜索相关内容", `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string", "description": "搜索关键词"}
+		},
+		"required": ["query"]
+	}`, searchKnowledgeTool)
+}
+
+// currentTimeTool 获取当前时间
+func currentTimeTool(ctx context.Context, env ToolEnv, arguments string) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("参数解析失败: %v", err)
+		}
+	}
+
+	now := time.Now()
+	if args.Timezone != "" {
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("无效的时区: %s", args.Timezone)
+		}
+		now = now.In(loc)
+	}
+	return now.Format("2006-01-02 15:04:05 Monday MST"), nil
+}
+
+// calculatorTool 计算数学表达式
+func calculatorTool(ctx context.Context, env ToolEnv, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("参数解析失败: %v", err)
+	}
+
+	result, err := Calculate(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%g", result), nil
+}
+
+// searchKnowledgeTool 在知识库中搜索
+func searchKnowledgeTool(ctx context.Context, env ToolEnv, arguments string) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("参数解析失败: %v", err)
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return "", fmt.Errorf("搜索关键词不能为空")
+	}
+
+	// 只搜索当前用户已处理完成的文件，指定了知识库时限定范围
+	fileQuery := env.DB.WithContext(ctx).Model(&models.KnowledgeFile{}).
+		Select("id").Where("user_id = ? AND status = ?", env.UserID, "completed")
+	if len(env.KnowledgeIDs) > 0 {
+		fileQuery = fileQuery.Where("id IN ?", env.KnowledgeIDs)
+	}
+
+	var vectors []models.KnowledgeVectorStore
+	if err := env.DB.WithContext(ctx).Where("file_id IN (?) AND text LIKE ?", fileQuery, "%"+args.Query+"%").
+		Limit(5).Find(&vectors).Error; err != nil {
+		return "", fmt.Errorf("搜索知识库失败: %v", err)
+	}
+
+	if len(vectors) == 0 {
+		return "未找到相关内容", nil
+	}
+
+	var builder strings.Builder
+	for i, vector := range vectors {
+		builder.WriteString(fmt.Sprintf("[%d] %s\n", i+1, vector.Text))
+	}
+	return builder.String(), nil
+}
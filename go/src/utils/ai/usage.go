@@ -0,0 +1,236 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UsageDateLayout 用量统计使用的日期格式
+const UsageDateLayout = "2006-01-02"
+
+// 用量合计的查询列，没有记录时SUM为NULL，需要转换为0
+const usageSumColumns = "COALESCE(SUM(requests), 0) AS requests, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(cached_tokens), 0) AS cached_tokens, " +
+	"COALESCE(SUM(prompt_tokens + completion_tokens), 0) AS total_tokens, " +
+	"COALESCE(SUM(cost), 0) AS cost"
+
+// tokenUsage 一条回复累计的token用量，工具调用的每轮请求都会累加
+type tokenUsage struct {
+	Prompt     int
+	Completion int
+	Cached     int
+}
+
+// add 累加一次请求的用量，提供商没有返回用量时(如流式生成被中断)按估算的token数累加
+func (u *tokenUsage) add(usage *Usage, model string, request []ChatMessage, reply ChatMessage) {
+	if usage == nil || usage.PromptTokens+usage.CompletionTokens == 0 {
+		u.Prompt += EstimateMessagesTokens(model, request)
+		u.Completion += EstimateMessageTokens(model, reply)
+		return
+	}
+	u.Prompt += usage.PromptTokens
+	u.Completion += usage.CompletionTokens
+	u.Cached += usage.CachedPromptTokens()
+}
+
+// apply 将用量和按消息的提供商、模型计算的费用写入回复消息
+func (u tokenUsage) apply(message *models.ChatMessage) {
+	message.PromptTokens = u.Prompt
+	message.CompletionTokens = u.Completion
+	message.CachedTokens = u.Cached
+	message.Cost = u.cost(message.Provider, message.ModelName)
+}
+
+// recordUsage 将一条回复的用量和费用累加到会话和用户当天的用量汇总，sessionID为0时只累加每日用量
+// 用量达到配额的提醒比例时通知用户；统计失败不影响回复，只记录日志
+func (s *AIService) recordUsage(userID, sessionID uint, provider, modelName string, used tokenUsage) {
+	cost := used.cost(provider, modelName)
+	if sessionID > 0 {
+		err := s.DB.Model(&models.ChatSession{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
+			"prompt_tokens":     gorm.Expr("prompt_tokens + ?", used.Prompt),
+			"completion_tokens": gorm.Expr("completion_tokens + ?", used.Completion),
+			"cached_tokens":     gorm.Expr("cached_tokens + ?", used.Cached),
+			"cost":              gorm.Expr("cost + ?", cost),
+		}).Error
+		if err != nil {
+			log.Printf("累加会话%d的用量失败: %v", sessionID, err)
+		}
+	}
+
+	daily := models.UsageDaily{
+		UserID:           userID,
+		Date:             time.Now().Format(UsageDateLayout),
+		Provider:         provider,
+		ModelName:        modelName,
+		Requests:         1,
+		PromptTokens:     used.Prompt,
+		CompletionTokens: used.Completion,
+		CachedTokens:     used.Cached,
+		Cost:             cost,
+	}
+	err := s.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "date"}, {Name: "provider"}, {Name: "model_name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":          gorm.Expr("requests + ?", 1),
+			"prompt_tokens":     gorm.Expr("prompt_tokens + ?", used.Prompt),
+			"completion_tokens": gorm.Expr("completion_tokens + ?", used.Completion),
+			"cached_tokens":     gorm.Expr("cached_tokens + ?", used.Cached),
+			"cost":              gorm.Expr("cost + ?", cost),
+		}),
+	}).Create(&daily).Error
+	if err != nil {
+		log.Printf("累加用户%d的每日用量失败: %v", userID, err)
+		return
+	}
+
+	s.notifyQuotaWarning(userID, int64(used.Prompt+used.Completion), cost)
+}
+
+// UsageFilter 用量报表的筛选条件，Start和End为包含两端的日期，如2025-03-01
+type UsageFilter struct {
+	Start     string
+	End       string
+	Provider  string // 为空时不筛选
+	ModelName string // 为空时不筛选
+}
+
+// Validate 校验日期格式和范围
+func (f UsageFilter) Validate() error {
+	start, err := time.ParseInLocation(UsageDateLayout, f.Start, time.Local)
+	if err != nil {
+		return fmt.Errorf("无效的开始日期: %s", f.Start)
+	}
+	end, err := time.ParseInLocation(UsageDateLayout, f.End, time.Local)
+	if err != nil {
+		return fmt.Errorf("无效的结束日期: %s", f.End)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("结束日期不能早于开始日期")
+	}
+	return nil
+}
+
+// UsageTotals token用量和费用合计
+type UsageTotals struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// DailyUsage 一天的用量合计
+type DailyUsage struct {
+	Date string `json:"date"`
+	UsageTotals
+}
+
+// ModelUsage 一个模型的用量合计
+type ModelUsage struct {
+	Provider  string `json:"provider"`
+	ModelName string `json:"model_name"`
+	UsageTotals
+}
+
+// UsageReport 用户在日期范围内的用量报表
+type UsageReport struct {
+	Start    string       `json:"start"`
+	End      string       `json:"end"`
+	Currency string       `json:"currency"` // 费用的货币单位
+	Total    UsageTotals  `json:"total"`
+	Daily    []DailyUsage `json:"daily"`  // 按日期升序，没有用量的日期不出现
+	Models   []ModelUsage `json:"models"` // 按总token数降序
+}
+
+// SessionUsage 一个会话在日期范围内的用量合计
+type SessionUsage struct {
+	SessionID uint   `json:"session_id"`
+	Title     string `json:"title"`
+	UsageTotals
+}
+
+// dailyUsageQuery 按筛选条件查询用户的每日用量
+func (s *AIService) dailyUsageQuery(userID uint, filter UsageFilter) *gorm.DB {
+	query := s.DB.Model(&models.UsageDaily{}).Where("user_id = ? AND date BETWEEN ? AND ?", userID, filter.Start, filter.End)
+	if filter.Provider != "" {
+		query = query.Where("provider = ?", filter.Provider)
+	}
+	if filter.ModelName != "" {
+		query = query.Where("model_name = ?", filter.ModelName)
+	}
+	return query
+}
+
+// GetUsageReport 获取用户在日期范围内的用量合计、每日用量和各模型用量
+func (s *AIService) GetUsageReport(userID uint, filter UsageFilter) (*UsageReport, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{Start: filter.Start, End: filter.End, Currency: Currency(), Daily: []DailyUsage{}, Models: []ModelUsage{}}
+	if err := s.dailyUsageQuery(userID, filter).Select(usageSumColumns).Scan(&report.Total).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %v", err)
+	}
+	if err := s.dailyUsageQuery(userID, filter).Select("date, " + usageSumColumns).Group("date").Order("date").Scan(&report.Daily).Error; err != nil {
+		return nil, fmt.Errorf("查询每日用量失败: %v", err)
+	}
+	if err := s.dailyUsageQuery(userID, filter).Select("provider, model_name, " + usageSumColumns).
+		Group("provider, model_name").Order("total_tokens DESC").Scan(&report.Models).Error; err != nil {
+		return nil, fmt.Errorf("查询模型用量失败: %v", err)
+	}
+
+	return report, nil
+}
+
+// GetSessionUsage 按会话分页统计用户在日期范围内的用量，按总token数降序
+// 统计包括已删除的消息和未选中的对比回答，它们同样消耗了token；缓存的回复没有用量，不计入
+func (s *AIService) GetSessionUsage(userID uint, filter UsageFilter, page, pageSize int) ([]SessionUsage, int64, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, 0, err
+	}
+	start, _ := time.ParseInLocation(UsageDateLayout, filter.Start, time.Local)
+	end, _ := time.ParseInLocation(UsageDateLayout, filter.End, time.Local)
+
+	query := s.DB.Table("chat_messages").
+		Joins("JOIN chat_sessions ON chat_sessions.id = chat_messages.session_id").
+		Where("chat_sessions.user_id = ? AND chat_sessions.deleted_at IS NULL", userID).
+		Where("chat_messages.role = ? AND chat_messages.created_at >= ? AND chat_messages.created_at < ?", "assistant", start, end.AddDate(0, 0, 1)).
+		Where("chat_messages.prompt_tokens + chat_messages.completion_tokens > 0")
+	if filter.Provider != "" {
+		query = query.Where("chat_messages.provider = ?", filter.Provider)
+	}
+	if filter.ModelName != "" {
+		query = query.Where("chat_messages.model_name = ?", filter.ModelName)
+	}
+
+	var count int64
+	if err := query.Session(&gorm.Session{}).Distinct("chat_messages.session_id").Count(&count).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询会话用量失败: %v", err)
+	}
+
+	var sessions []SessionUsage
+	offset := (page - 1) * pageSize
+	err := query.Select("chat_messages.session_id AS session_id, chat_sessions.title AS title, " +
+		"COUNT(*) AS requests, " +
+		"COALESCE(SUM(chat_messages.prompt_tokens), 0) AS prompt_tokens, " +
+		"COALESCE(SUM(chat_messages.completion_tokens), 0) AS completion_tokens, " +
+		"COALESCE(SUM(chat_messages.cached_tokens), 0) AS cached_tokens, " +
+		"COALESCE(SUM(chat_messages.prompt_tokens + chat_messages.completion_tokens), 0) AS total_tokens, " +
+		"COALESCE(SUM(chat_messages.cost), 0) AS cost").
+		Group("chat_messages.session_id, chat_sessions.title").
+		Order("total_tokens DESC").Offset(offset).Limit(pageSize).
+		Scan(&sessions).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询会话用量失败: %v", err)
+	}
+
+	return sessions, count, nil
+}
@@ -0,0 +1,219 @@
+package ai
+
+import (
+	"Deepseek-Go/models"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUsageCachedPromptTokens(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{"deepseek", `{"prompt_tokens":100,"completion_tokens":10,"prompt_cache_hit_tokens":64,"prompt_cache_miss_tokens":36}`, 64},
+		{"kimi", `{"prompt_tokens":100,"completion_tokens":10,"cached_tokens":32}`, 32},
+		{"openai", `{"prompt_tokens":100,"completion_tokens":10,"prompt_tokens_details":{"cached_tokens":16}}`, 16},
+		{"none", `{"prompt_tokens":100,"completion_tokens":10}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var usage Usage
+			if err := json.Unmarshal([]byte(tt.body), &usage); err != nil {
+				t.Fatal(err)
+			}
+			if got := usage.CachedPromptTokens(); got != tt.want {
+				t.Errorf("CachedPromptTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatRecordsUsage(t *testing.T) {
+	model := NewMockModel(
+		ChatMessage{ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: ToolCallFunction{Name: "calculator", Arguments: `{"expression":"1+1"}`},
+		}}},
+		ChatMessage{Content: "结果是2"},
+	)
+	s := newTestService(t, map[string]*MockModel{"mock-usage": model})
+	aiConfig := models.AIConfig{Provider: "mock-usage", ModelName: "mock-echo", EnableTools: true}
+
+	reply, session, err := s.Chat(context.Background(), 1, 0, "1+1等于多少", aiConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	// 回复的用量包括工具调用的两轮请求
+	requests := model.Requests()
+	wantPrompt := EstimateMessagesTokens("mock-echo", requests[0].Messages) + EstimateMessagesTokens("mock-echo", requests[1].Messages)
+	if reply.PromptTokens != wantPrompt || reply.CompletionTokens == 0 {
+		t.Errorf("reply usage = %d/%d, want prompt %d", reply.PromptTokens, reply.CompletionTokens, wantPrompt)
+	}
+
+	// 流式回复同样记录用量并累加到会话
+	streamed, _, err := s.StreamChat(context.Background(), 1, session.ID, "再算一次", aiConfig, nil, nil, func(*ChatCompletionChunk) {})
+	if err != nil {
+		t.Fatalf("StreamChat() error = %v", err)
+	}
+	if streamed.PromptTokens == 0 || streamed.CompletionTokens == 0 {
+		t.Errorf("streamed usage = %d/%d", streamed.PromptTokens, streamed.CompletionTokens)
+	}
+
+	var saved models.ChatSession
+	s.DB.First(&saved, session.ID)
+	if saved.PromptTokens != reply.PromptTokens+streamed.PromptTokens || saved.CompletionTokens != reply.CompletionTokens+streamed.CompletionTokens {
+		t.Errorf("session usage = %d/%d", saved.PromptTokens, saved.CompletionTokens)
+	}
+
+	var daily []models.UsageDaily
+	s.DB.Find(&daily)
+	if len(daily) != 1 || daily[0].Date != time.Now().Format(UsageDateLayout) || daily[0].Requests != 2 || daily[0].PromptTokens != saved.PromptTokens {
+		t.Errorf("daily usage = %+v", daily)
+	}
+}
+
+func TestGatewayAndBatchRecordUsage(t *testing.T) {
+	s := newTestService(t, map[string]*MockModel{"mock-usage-other": NewMockModel()})
+	aiConfig := createBatchConfig(t, s, "mock-usage-other")
+
+	request := GatewayRequest{Messages: []ChatMessage{{Role: "user", Content: "你好"}}}
+	if _, err := s.GatewayChat(context.Background(), aiConfig, request, nil); err != nil {
+		t.Fatalf("GatewayChat() error = %v", err)
+	}
+	job, err := s.CreateBatchJob(1, "usage", aiConfig, nil, []BatchPrompt{{Prompt: "一"}, {Prompt: "二"}})
+	if err != nil {
+		t.Fatalf("CreateBatchJob() error = %v", err)
+	}
+	waitBatchJob(t, s, job.ID)
+
+	var daily models.UsageDaily
+	s.DB.Where("user_id = ? AND provider = ?", 1, "mock-usage-other").First(&daily)
+	if daily.Requests != 3 || daily.PromptTokens == 0 {
+		t.Errorf("daily usage = %+v", daily)
+	}
+}
+
+func TestSummaryAndFailedStructuredRecordUsage(t *testing.T) {
+	summaryModel := NewMockModel(ChatMessage{Content: "用户在咨询年假"})
+	structuredModel := NewMockModel(ChatMessage{Content: "a"}, ChatMessage{Content: "b"}, ChatMessage{Content: "c"})
+	s := newTestService(t, map[string]*MockModel{"mock-usage-summary": summaryModel, "mock-usage-structured": structuredModel})
+
+	// 摘要的用量计入会话所属用户
+	session, _ := s.GetOrCreateSession(2, 0, "年假")
+	message := models.ChatMessage{SessionID: session.ID, Role: "user", Content: "公司的年假有多少天？"}
+	s.DB.Create(&message)
+	s.DB.Model(session).Update("summary_until_id", message.ID)
+	if err := s.summarizeSession(session.ID, models.AIConfig{Provider: "mock-usage-summary", ModelName: "mock-echo"}, true); err != nil {
+		t.Fatalf("summarizeSession() error = %v", err)
+	}
+	var daily models.UsageDaily
+	s.DB.Where("user_id = ? AND provider = ?", 2, "mock-usage-summary").First(&daily)
+	if daily.Requests != 1 || daily.PromptTokens == 0 || daily.CompletionTokens == 0 {
+		t.Errorf("summary usage = %+v", daily)
+	}
+
+	// 结构化输出最终失败时，各次尝试的用量同样计入
+	schema, _ := ParseJSONSchema(json.RawMessage(testSchema))
+	if _, _, _, err := s.StructuredChat(context.Background(), 1, 0, "你好", schema, models.AIConfig{Provider: "mock-usage-structured", ModelName: "mock-echo"}, nil); err == nil {
+		t.Fatal("StructuredChat() error = nil")
+	}
+	daily = models.UsageDaily{}
+	s.DB.Where("user_id = ? AND provider = ?", 1, "mock-usage-structured").First(&daily)
+	wantPrompt := 0
+	for _, request := range structuredModel.Requests() {
+		wantPrompt += EstimateMessagesTokens("mock-echo", request.Messages)
+	}
+	if daily.Requests != 1 || daily.PromptTokens != wantPrompt {
+		t.Errorf("failed structured usage = %+v, want prompt %d", daily, wantPrompt)
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	s := newTestService(t, nil)
+	s.DB.Create(&[]models.UsageDaily{
+		{UserID: 1, Date: "2025-03-01", Provider: "deepseek", ModelName: "deepseek-chat", Requests: 2, PromptTokens: 100, CompletionTokens: 50, CachedTokens: 40},
+		{UserID: 1, Date: "2025-03-01", Provider: "kimi", ModelName: "moonshot-v1-8k", Requests: 1, PromptTokens: 10, CompletionTokens: 5},
+		{UserID: 1, Date: "2025-03-02", Provider: "deepseek", ModelName: "deepseek-chat", Requests: 1, PromptTokens: 200, CompletionTokens: 100},
+		{UserID: 1, Date: "2025-04-01", Provider: "deepseek", ModelName: "deepseek-chat", Requests: 1, PromptTokens: 1000, CompletionTokens: 1000},
+		{UserID: 2, Date: "2025-03-01", Provider: "deepseek", ModelName: "deepseek-chat", Requests: 1, PromptTokens: 999, CompletionTokens: 999},
+	})
+
+	report, err := s.GetUsageReport(1, UsageFilter{Start: "2025-03-01", End: "2025-03-31"})
+	if err != nil {
+		t.Fatalf("GetUsageReport() error = %v", err)
+	}
+	want := UsageTotals{Requests: 4, PromptTokens: 310, CompletionTokens: 155, CachedTokens: 40, TotalTokens: 465}
+	if report.Total != want {
+		t.Errorf("total = %+v, want %+v", report.Total, want)
+	}
+	if len(report.Daily) != 2 || report.Daily[0].Date != "2025-03-01" || report.Daily[0].TotalTokens != 165 || report.Daily[1].TotalTokens != 300 {
+		t.Errorf("daily = %+v", report.Daily)
+	}
+	if len(report.Models) != 2 || report.Models[0].ModelName != "deepseek-chat" || report.Models[0].Requests != 3 || report.Models[1].Provider != "kimi" {
+		t.Errorf("models = %+v", report.Models)
+	}
+
+	// 按模型筛选
+	report, err = s.GetUsageReport(1, UsageFilter{Start: "2025-03-01", End: "2025-04-30", ModelName: "moonshot-v1-8k"})
+	if err != nil || report.Total.TotalTokens != 15 || len(report.Daily) != 1 {
+		t.Errorf("filtered report = %+v, err = %v", report, err)
+	}
+
+	// 没有用量时返回0而不是空值
+	report, err = s.GetUsageReport(1, UsageFilter{Start: "2024-01-01", End: "2024-01-31"})
+	if err != nil || report.Total != (UsageTotals{}) || len(report.Daily) != 0 {
+		t.Errorf("empty report = %+v, err = %v", report, err)
+	}
+
+	for _, filter := range []UsageFilter{{Start: "2025-03-31", End: "2025-03-01"}, {Start: "03/01/2025", End: "2025-03-31"}} {
+		if _, err := s.GetUsageReport(1, filter); err == nil {
+			t.Errorf("GetUsageReport(%+v) succeeded", filter)
+		}
+	}
+}
+
+func TestSessionUsage(t *testing.T) {
+	s := newTestService(t, nil)
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+
+	small := models.ChatSession{UserID: 1, Title: "小"}
+	large := models.ChatSession{UserID: 1, Title: "大"}
+	other := models.ChatSession{UserID: 2, Title: "其他用户"}
+	s.DB.Create(&[]*models.ChatSession{&small, &large, &other})
+
+	deleted := models.ChatMessage{SessionID: large.ID, Role: "assistant", ModelName: "deepseek-chat", PromptTokens: 100, CompletionTokens: 100, CreatedAt: day}
+	s.DB.Create(&[]*models.ChatMessage{
+		{SessionID: small.ID, Role: "assistant", ModelName: "deepseek-chat", PromptTokens: 10, CompletionTokens: 5, CreatedAt: day},
+		{SessionID: small.ID, Role: "user", Content: "你好", CreatedAt: day},
+		{SessionID: large.ID, Role: "assistant", ModelName: "moonshot-v1-8k", PromptTokens: 300, CompletionTokens: 200, CachedTokens: 100, CreatedAt: day},
+		&deleted,
+		{SessionID: large.ID, Role: "assistant", ModelName: "deepseek-chat", PromptTokens: 1000, CompletionTokens: 1000, CreatedAt: day.AddDate(0, 1, 0)},
+		{SessionID: other.ID, Role: "assistant", ModelName: "deepseek-chat", PromptTokens: 1, CompletionTokens: 1, CreatedAt: day},
+	})
+	// 已删除的消息同样消耗了token
+	s.DB.Delete(&deleted)
+
+	sessions, count, err := s.GetSessionUsage(1, UsageFilter{Start: "2025-03-01", End: "2025-03-31"}, 1, 10)
+	if err != nil {
+		t.Fatalf("GetSessionUsage() error = %v", err)
+	}
+	if count != 2 || len(sessions) != 2 {
+		t.Fatalf("count = %d, sessions = %+v", count, sessions)
+	}
+	if sessions[0].SessionID != large.ID || sessions[0].Title != "大" || sessions[0].Requests != 2 || sessions[0].TotalTokens != 700 || sessions[0].CachedTokens != 100 {
+		t.Errorf("sessions[0] = %+v", sessions[0])
+	}
+	if sessions[1].SessionID != small.ID || sessions[1].TotalTokens != 15 {
+		t.Errorf("sessions[1] = %+v", sessions[1])
+	}
+
+	sessions, count, err = s.GetSessionUsage(1, UsageFilter{Start: "2025-03-01", End: "2025-03-31", ModelName: "deepseek-chat"}, 1, 1)
+	if err != nil || count != 2 || len(sessions) != 1 || sessions[0].SessionID != large.ID || sessions[0].TotalTokens != 200 {
+		t.Errorf("filtered sessions = %+v, count = %d, err = %v", sessions, count, err)
+	}
+}
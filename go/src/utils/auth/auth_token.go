@@ -9,6 +9,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// 访问令牌的前缀，用于和登录令牌区分
+const AccessTokenPrefix = "dsk-"
+
 // 生成token
 func GenerateToken(username string) (string, error) {
 	// JWT令牌生成函数，传入用户名，生成其JWT令牌.
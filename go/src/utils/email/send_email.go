@@ -3,6 +3,7 @@ package email
 import (
 	"crypto/tls"
 	"fmt"
+	"html"
 	"log"
 	"math/rand"
 	"net/smtp"
@@ -55,6 +56,34 @@ func SendVerificationEmail(toEmail, code string) error {
 	return sendEmail(toEmail, subject, body)
 }
 
+// 发送用量配额提醒邮件，lines为各项达到提醒线的配额说明
+func SendQuotaWarningEmail(toEmail string, lines []string) error {
+	// 如果邮件配置未初始化
+	if config.Config.Email.Host == "" {
+		log.Printf("邮件服务未配置，配额提醒: %s 发送到: %s", strings.Join(lines, "; "), toEmail)
+		return nil
+	}
+
+	items := ""
+	for _, line := range lines {
+		items += fmt.Sprintf("<li>%s</li>", html.EscapeString(line))
+	}
+
+	subject := "用量配额提醒"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>用量配额提醒</h2>
+			<p>您的AI用量即将达到配额：</p>
+			<ul>%s</ul>
+			<p>用完配额后将暂时无法使用聊天功能，直到配额恢复。</p>
+		</body>
+		</html>
+	`, items)
+
+	return sendEmail(toEmail, subject, body)
+}
+
 // 从完整的From字段中提取纯邮箱地址
 func ExtractEmailAddress(from string) string {
 	// 尝试匹配 "Name <email@example.com>" 格式